@@ -21,13 +21,15 @@ cat > /tmp/discovery-policy.json << 'EOF'
                 "dynamodb:PutItem",
                 "dynamodb:GetItem",
                 "dynamodb:UpdateItem",
+                "dynamodb:DeleteItem",
                 "dynamodb:Query",
                 "dynamodb:Scan",
                 "dynamodb:BatchWriteItem"
             ],
             "Resource": [
                 "arn:aws:dynamodb:us-east-1:072006186126:table/aurora-log-tracking",
-                "arn:aws:dynamodb:us-east-1:072006186126:table/aurora-instance-metadata"
+                "arn:aws:dynamodb:us-east-1:072006186126:table/aurora-instance-metadata",
+                "arn:aws:dynamodb:us-east-1:072006186126:table/aurora-log-publish-outbox"
             ]
         }
     ]
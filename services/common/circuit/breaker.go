@@ -0,0 +1,86 @@
+// Package circuit stops calls to a dependency that keeps failing, so that
+// retries do not pile up while it recovers.
+package circuit
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrOpen is returned without calling the function while the breaker is open
+var ErrOpen = errors.New("circuit breaker is open")
+
+const (
+	stateClosed int32 = iota
+	stateOpen
+	stateHalfOpen
+)
+
+// Breaker opens after maxFailures failures. Once resetTimeout has passed
+// since the last failure it lets calls through again, and closes on the
+// first success.
+type Breaker struct {
+	maxFailures     int
+	resetTimeout    time.Duration
+	failures        atomic.Int32
+	lastFailureTime atomic.Int64
+	state           atomic.Int32
+}
+
+func NewBreaker(maxFailures int, resetTimeout time.Duration) *Breaker {
+	return &Breaker{
+		maxFailures:  maxFailures,
+		resetTimeout: resetTimeout,
+	}
+}
+
+// Call runs fn unless the breaker is open and records its outcome
+func (cb *Breaker) Call(fn func() error) error {
+	if !cb.canExecute() {
+		return ErrOpen
+	}
+
+	err := fn()
+	if err != nil {
+		cb.recordFailure()
+	} else {
+		cb.recordSuccess()
+	}
+
+	return err
+}
+
+func (cb *Breaker) canExecute() bool {
+	switch cb.state.Load() {
+	case stateClosed:
+		return true
+	case stateOpen:
+		lastFailure := time.Unix(0, cb.lastFailureTime.Load())
+		if time.Since(lastFailure) > cb.resetTimeout {
+			cb.state.CompareAndSwap(stateOpen, stateHalfOpen)
+			return true
+		}
+		return false
+	case stateHalfOpen:
+		return true
+	default:
+		return false
+	}
+}
+
+func (cb *Breaker) recordFailure() {
+	cb.failures.Add(1)
+	cb.lastFailureTime.Store(time.Now().UnixNano())
+
+	if cb.failures.Load() >= int32(cb.maxFailures) {
+		cb.state.Store(stateOpen)
+	}
+}
+
+func (cb *Breaker) recordSuccess() {
+	if cb.state.Load() == stateHalfOpen {
+		cb.state.Store(stateClosed)
+		cb.failures.Store(0)
+	}
+}
@@ -0,0 +1,52 @@
+package circuit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	cb := NewBreaker(2, 100*time.Millisecond)
+
+	// Test successful calls
+	err := cb.Call(func() error { return nil })
+	assert.NoError(t, err)
+
+	// Test failure tracking
+	calls := 0
+	for i := 0; i < 3; i++ {
+		err = cb.Call(func() error {
+			calls++
+			return assert.AnError
+		})
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 2, calls, "an open breaker does not call the function")
+
+	// Circuit should be open now
+	err = cb.Call(func() error { return nil })
+	assert.ErrorIs(t, err, ErrOpen)
+	assert.EqualError(t, err, "circuit breaker is open")
+
+	// Wait for reset timeout
+	time.Sleep(150 * time.Millisecond)
+
+	// Circuit should be half-open, next call should succeed
+	err = cb.Call(func() error { return nil })
+	assert.NoError(t, err)
+
+	// A success closes it again
+	err = cb.Call(func() error { return assert.AnError })
+	assert.ErrorIs(t, err, assert.AnError)
+}
+
+func BenchmarkBreaker(b *testing.B) {
+	cb := NewBreaker(100, 1*time.Second)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cb.Call(func() error { return nil })
+	}
+}
@@ -0,0 +1,38 @@
+// Package env reads service configuration from environment variables.
+// Unset, empty and unparsable values fall back to the default.
+package env
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Get returns the value of key, or defaultVal if it is unset or empty
+func Get(key, defaultVal string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return defaultVal
+}
+
+// Int returns key as an integer, or defaultVal if it is unset or not a number
+func Int(key string, defaultVal int) int {
+	if val := os.Getenv(key); val != "" {
+		if intVal, err := strconv.Atoi(val); err == nil {
+			return intVal
+		}
+	}
+	return defaultVal
+}
+
+// List splits a comma-separated value, dropping blank items
+func List(key string) []string {
+	var values []string
+	for _, val := range strings.Split(os.Getenv(key), ",") {
+		if val = strings.TrimSpace(val); val != "" {
+			values = append(values, val)
+		}
+	}
+	return values
+}
@@ -0,0 +1,61 @@
+package env
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGet(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		defVal   string
+		envVal   string
+		expected string
+	}{
+		{"returns default when env not set", "TEST_KEY", "default", "", "default"},
+		{"returns env value when set", "TEST_KEY", "default", "value", "value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.envVal != "" {
+				t.Setenv(tt.key, tt.envVal)
+			}
+			result := Get(tt.key, tt.defVal)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestInt(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		defVal   int
+		envVal   string
+		expected int
+	}{
+		{"returns default when env not set", "TEST_KEY", 10, "", 10},
+		{"returns env value as int", "TEST_KEY", 10, "20", 20},
+		{"returns default on invalid int", "TEST_KEY", 10, "invalid", 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.envVal != "" {
+				t.Setenv(tt.key, tt.envVal)
+			}
+			result := Int(tt.key, tt.defVal)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	assert.Nil(t, List("TEST_KEY"))
+
+	t.Setenv("TEST_KEY", " a, b ,,c ")
+	assert.Equal(t, []string{"a", "b", "c"}, List("TEST_KEY"))
+}
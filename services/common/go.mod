@@ -0,0 +1,24 @@
+module github.com/yourorg/aurora-log-system/common
+
+go 1.23.0
+
+toolchain go1.23.4
+
+require (
+	github.com/aws/aws-sdk-go-v2 v1.32.6
+	github.com/segmentio/kafka-go v0.4.48
+	github.com/stretchr/testify v1.9.0
+)
+
+require (
+	github.com/aws/smithy-go v1.22.1 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/klauspost/compress v1.15.9 // indirect
+	github.com/pierrec/lz4/v4 v4.1.15 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/xdg-go/pbkdf2 v1.0.0 // indirect
+	github.com/xdg-go/scram v1.1.2 // indirect
+	github.com/xdg-go/stringprep v1.0.4 // indirect
+	golang.org/x/text v0.13.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
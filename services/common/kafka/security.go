@@ -0,0 +1,261 @@
+// Package kafka holds the Kafka client configuration shared by discovery and
+// the processor: TLS and SASL authentication, and the notification topics
+// they exchange.
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"github.com/yourorg/aurora-log-system/common/env"
+	commontls "github.com/yourorg/aurora-log-system/common/tls"
+)
+
+// SASL mechanisms supported for Kafka clients
+const (
+	saslNone   = ""
+	saslScram  = "SCRAM-SHA-512"
+	saslMSKIAM = "AWS_MSK_IAM"
+)
+
+// SecurityConfig configures encryption and authentication for every Kafka
+// client of a service. Discovery and the processor read the same KAFKA_*
+// variables.
+type SecurityConfig struct {
+	TLS           commontls.FileConfig
+	SASLMechanism string
+	Username      string
+	UsernameFile  string
+	Password      string
+	PasswordFile  string
+	IAMRegion     string // defaults to the AWS config region
+}
+
+// LoadSecurityConfig reads the KAFKA_TLS_* and KAFKA_SASL_* variables
+func LoadSecurityConfig() SecurityConfig {
+	return SecurityConfig{
+		TLS: commontls.FileConfig{
+			Enabled:            env.Get("KAFKA_TLS_ENABLED", "false") == "true",
+			CAFile:             os.Getenv("KAFKA_TLS_CA_FILE"),
+			CertFile:           os.Getenv("KAFKA_TLS_CERT_FILE"),
+			KeyFile:            os.Getenv("KAFKA_TLS_KEY_FILE"),
+			ServerName:         os.Getenv("KAFKA_TLS_SERVER_NAME"),
+			InsecureSkipVerify: env.Get("KAFKA_TLS_INSECURE_SKIP_VERIFY", "false") == "true",
+		},
+		SASLMechanism: strings.ToUpper(strings.TrimSpace(os.Getenv("KAFKA_SASL_MECHANISM"))),
+		Username:      os.Getenv("KAFKA_SASL_USERNAME"),
+		UsernameFile:  os.Getenv("KAFKA_SASL_USERNAME_FILE"),
+		Password:      os.Getenv("KAFKA_SASL_PASSWORD"),
+		PasswordFile:  os.Getenv("KAFKA_SASL_PASSWORD_FILE"),
+		IAMRegion:     os.Getenv("KAFKA_MSK_IAM_REGION"),
+	}
+}
+
+// build returns the TLS configuration and SASL mechanism, either of which
+// may be nil
+func (c SecurityConfig) build(awsCfg aws.Config) (*tls.Config, sasl.Mechanism, error) {
+	tlsConfig := c.TLS
+	var mechanism sasl.Mechanism
+
+	switch c.SASLMechanism {
+	case saslNone, "NONE":
+	case saslScram:
+		username := &fileOrValue{secret: commontls.NewFileSecret(c.UsernameFile), value: c.Username}
+		password := &fileOrValue{secret: commontls.NewFileSecret(c.PasswordFile), value: c.Password}
+		if _, err := username.get(); err != nil {
+			return nil, nil, fmt.Errorf("failed to read Kafka SASL username: %w", err)
+		}
+		if _, err := password.get(); err != nil {
+			return nil, nil, fmt.Errorf("failed to read Kafka SASL password: %w", err)
+		}
+		mechanism = &scramMechanism{username: username, password: password}
+	case saslMSKIAM:
+		if awsCfg.Credentials == nil {
+			return nil, nil, fmt.Errorf("%s requires AWS credentials", saslMSKIAM)
+		}
+		region := c.IAMRegion
+		if region == "" {
+			region = awsCfg.Region
+		}
+		if region == "" {
+			return nil, nil, fmt.Errorf("%s requires an AWS region", saslMSKIAM)
+		}
+		// MSK only accepts IAM authentication on its TLS listener
+		tlsConfig.Enabled = true
+		mechanism = newMSKIAMMechanism(awsCfg.Credentials, region)
+	default:
+		return nil, nil, fmt.Errorf("unsupported KAFKA_SASL_MECHANISM %q", c.SASLMechanism)
+	}
+
+	tlsCfg, err := tlsConfig.Build()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build Kafka TLS config: %w", err)
+	}
+	return tlsCfg, mechanism, nil
+}
+
+// Dialer returns a dialer for readers and consumer groups
+func (c SecurityConfig) Dialer(awsCfg aws.Config) (*kafka.Dialer, error) {
+	tlsCfg, mechanism, err := c.build(awsCfg)
+	if err != nil {
+		return nil, err
+	}
+	return &kafka.Dialer{
+		Timeout:       10 * time.Second,
+		DualStack:     true,
+		TLS:           tlsCfg,
+		SASLMechanism: mechanism,
+	}, nil
+}
+
+// Transport returns a transport for writers and admin clients
+func (c SecurityConfig) Transport(awsCfg aws.Config) (*kafka.Transport, error) {
+	tlsCfg, mechanism, err := c.build(awsCfg)
+	if err != nil {
+		return nil, err
+	}
+	return &kafka.Transport{
+		DialTimeout: 10 * time.Second,
+		TLS:         tlsCfg,
+		SASL:        mechanism,
+	}, nil
+}
+
+// fileOrValue prefers a mounted secret over a fixed value
+type fileOrValue struct {
+	secret *commontls.FileSecret
+	value  string
+}
+
+func (v *fileOrValue) get() (string, error) {
+	if v.secret == nil {
+		return v.value, nil
+	}
+	return v.secret.Value()
+}
+
+// scramMechanism authenticates with SCRAM-SHA-512 using the current
+// credentials, so rotated secrets apply to the next connection
+type scramMechanism struct {
+	username *fileOrValue
+	password *fileOrValue
+}
+
+func (m *scramMechanism) Name() string { return saslScram }
+
+func (m *scramMechanism) Start(ctx context.Context) (sasl.StateMachine, []byte, error) {
+	username, err := m.username.get()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read Kafka SASL username: %w", err)
+	}
+	password, err := m.password.get()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read Kafka SASL password: %w", err)
+	}
+	mechanism, err := scram.Mechanism(scram.SHA512, username, password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create SCRAM mechanism: %w", err)
+	}
+	return mechanism.Start(ctx)
+}
+
+const (
+	mskIAMAction  = "kafka-cluster:Connect"
+	mskIAMService = "kafka-cluster"
+	mskIAMVersion = "2020_10_22"
+	// SHA-256 of an empty payload
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// mskIAMMechanism implements the AWS_MSK_IAM SASL mechanism: the client sends
+// a SigV4-presigned kafka-cluster:Connect request for the broker it dials.
+// Credentials come from the AWS config's provider, which caches and refreshes
+// them, and every connection is signed afresh.
+type mskIAMMechanism struct {
+	credentials aws.CredentialsProvider
+	region      string
+	signer      *v4.Signer
+	expiry      time.Duration
+	now         func() time.Time
+}
+
+func newMSKIAMMechanism(credentials aws.CredentialsProvider, region string) *mskIAMMechanism {
+	return &mskIAMMechanism{
+		credentials: credentials,
+		region:      region,
+		signer:      v4.NewSigner(),
+		expiry:      5 * time.Minute,
+		now:         time.Now,
+	}
+}
+
+func (m *mskIAMMechanism) Name() string { return saslMSKIAM }
+
+func (m *mskIAMMechanism) Start(ctx context.Context) (sasl.StateMachine, []byte, error) {
+	metadata := sasl.MetadataFromContext(ctx)
+	if metadata == nil || metadata.Host == "" {
+		return nil, nil, fmt.Errorf("%s: broker address missing from the dial context", saslMSKIAM)
+	}
+
+	creds, err := m.credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+
+	payload, err := m.payload(ctx, creds, metadata.Host)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mskIAMSession{}, payload, nil
+}
+
+func (m *mskIAMMechanism) payload(ctx context.Context, creds aws.Credentials, host string) ([]byte, error) {
+	query := url.Values{
+		"Action":        {mskIAMAction},
+		"X-Amz-Expires": {strconv.Itoa(int(m.expiry.Seconds()))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "kafka://"+host+"/?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build MSK IAM request: %w", err)
+	}
+
+	signedURL, _, err := m.signer.PresignHTTP(ctx, creds, req, emptyPayloadHash, mskIAMService, m.region, m.now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign MSK IAM request: %w", err)
+	}
+	signed, err := url.Parse(signedURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signed MSK IAM request: %w", err)
+	}
+
+	fields := map[string]string{
+		"version":    mskIAMVersion,
+		"host":       host,
+		"user-agent": "aurora-log-system",
+	}
+	for key, values := range signed.Query() {
+		fields[strings.ToLower(key)] = values[0]
+	}
+	return json.Marshal(fields)
+}
+
+// mskIAMSession accepts the broker's single response to the signed payload;
+// a rejected signature fails the handshake on the broker side
+type mskIAMSession struct{}
+
+func (mskIAMSession) Next(ctx context.Context, challenge []byte) (bool, []byte, error) {
+	return true, nil, nil
+}
@@ -0,0 +1,163 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	commontls "github.com/yourorg/aurora-log-system/common/tls"
+)
+
+func TestSecurityConfigMechanisms(t *testing.T) {
+	awsCfg := aws.Config{
+		Region: "us-east-1",
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+		}),
+	}
+
+	tlsCfg, mechanism, err := SecurityConfig{}.build(awsCfg)
+	require.NoError(t, err)
+	assert.Nil(t, tlsCfg)
+	assert.Nil(t, mechanism)
+
+	// MSK only serves IAM on its TLS listener, so TLS is implied
+	tlsCfg, mechanism, err = SecurityConfig{SASLMechanism: saslMSKIAM}.build(awsCfg)
+	require.NoError(t, err)
+	assert.NotNil(t, tlsCfg)
+	assert.Equal(t, "AWS_MSK_IAM", mechanism.Name())
+
+	_, _, err = SecurityConfig{SASLMechanism: saslMSKIAM}.build(aws.Config{Region: "us-east-1"})
+	assert.Error(t, err)
+
+	_, _, err = SecurityConfig{SASLMechanism: "PLAIN"}.build(awsCfg)
+	assert.ErrorContains(t, err, "unsupported KAFKA_SASL_MECHANISM")
+
+	_, _, err = SecurityConfig{SASLMechanism: saslScram, PasswordFile: filepath.Join(t.TempDir(), "missing")}.build(awsCfg)
+	assert.ErrorContains(t, err, "password")
+}
+
+func TestScramMechanismRereadsRotatedCredentials(t *testing.T) {
+	dir := t.TempDir()
+	usernameFile := filepath.Join(dir, "username")
+	passwordFile := filepath.Join(dir, "password")
+	require.NoError(t, os.WriteFile(usernameFile, []byte("processor\n"), 0600))
+	require.NoError(t, os.WriteFile(passwordFile, []byte("first"), 0600))
+
+	_, mechanism, err := SecurityConfig{
+		SASLMechanism: saslScram,
+		UsernameFile:  usernameFile,
+		PasswordFile:  passwordFile,
+	}.build(aws.Config{})
+	require.NoError(t, err)
+	assert.Equal(t, "SCRAM-SHA-512", mechanism.Name())
+
+	_, initial, err := mechanism.Start(context.Background())
+	require.NoError(t, err)
+	assert.Contains(t, string(initial), "n=processor,")
+
+	require.NoError(t, os.WriteFile(usernameFile, []byte("processor-v2"), 0600))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(usernameFile, future, future))
+
+	_, initial, err = mechanism.Start(context.Background())
+	require.NoError(t, err)
+	assert.Contains(t, string(initial), "n=processor-v2,")
+}
+
+func TestMSKIAMMechanismSignsConnectRequest(t *testing.T) {
+	calls := 0
+	mechanism := newMSKIAMMechanism(aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		calls++
+		return aws.Credentials{
+			AccessKeyID:     fmt.Sprintf("AKID%d", calls),
+			SecretAccessKey: "secret",
+			SessionToken:    "token",
+		}, nil
+	}), "eu-west-1")
+	mechanism.now = func() time.Time { return time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC) }
+
+	_, _, err := mechanism.Start(context.Background())
+	assert.Error(t, err, "the broker address comes from the dial context")
+
+	ctx := sasl.WithMetadata(context.Background(), &sasl.Metadata{Host: "b-1.aurora.kafka.eu-west-1.amazonaws.com", Port: 9098})
+	session, initial, err := mechanism.Start(ctx)
+	require.NoError(t, err)
+
+	var payload map[string]string
+	require.NoError(t, json.Unmarshal(initial, &payload))
+	assert.Equal(t, "2020_10_22", payload["version"])
+	assert.Equal(t, "b-1.aurora.kafka.eu-west-1.amazonaws.com", payload["host"])
+	assert.Equal(t, "kafka-cluster:Connect", payload["action"])
+	assert.Equal(t, "AWS4-HMAC-SHA256", payload["x-amz-algorithm"])
+	assert.Equal(t, "AKID1/20240301/eu-west-1/kafka-cluster/aws4_request", payload["x-amz-credential"])
+	assert.Equal(t, "20240301T120000Z", payload["x-amz-date"])
+	assert.Equal(t, "300", payload["x-amz-expires"])
+	assert.Equal(t, "host", payload["x-amz-signedheaders"])
+	assert.Equal(t, "token", payload["x-amz-security-token"])
+	assert.Len(t, payload["x-amz-signature"], 64)
+
+	done, _, err := session.Next(ctx, []byte(`{"version":"2020_10_22","request-id":"abc"}`))
+	require.NoError(t, err)
+	assert.True(t, done)
+
+	// Every connection asks the provider again, so refreshed keys are used
+	_, initial, err = mechanism.Start(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, string(initial), "AKID2/")
+}
+
+func TestLoadSecurityConfig(t *testing.T) {
+	t.Setenv("KAFKA_TLS_ENABLED", "true")
+	t.Setenv("KAFKA_SASL_MECHANISM", "scram-sha-512")
+	t.Setenv("KAFKA_SASL_USERNAME", "discovery")
+	t.Setenv("KAFKA_SASL_PASSWORD", "secret")
+
+	dialer, err := LoadSecurityConfig().Dialer(aws.Config{})
+	require.NoError(t, err)
+	assert.NotNil(t, dialer.TLS)
+	require.NotNil(t, dialer.SASLMechanism)
+	assert.Equal(t, "SCRAM-SHA-512", dialer.SASLMechanism.Name())
+
+	_, initial, err := dialer.SASLMechanism.Start(context.Background())
+	require.NoError(t, err)
+	assert.Contains(t, string(initial), "n=discovery,")
+}
+
+func TestSecurityConfigPrefersSecretFiles(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	require.NoError(t, os.WriteFile(passwordFile, []byte("from-file\n"), 0600))
+
+	value := &fileOrValue{secret: commontls.NewFileSecret(passwordFile), value: "from-env"}
+	password, err := value.get()
+	require.NoError(t, err)
+	assert.Equal(t, "from-file", password)
+}
+
+func TestMSKIAMTransport(t *testing.T) {
+	transport, err := SecurityConfig{SASLMechanism: saslMSKIAM}.Transport(aws.Config{
+		Region: "us-east-1",
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+		}),
+	})
+	require.NoError(t, err)
+	assert.NotNil(t, transport.TLS)
+
+	ctx := sasl.WithMetadata(context.Background(), &sasl.Metadata{Host: "b-1.aurora.kafka.us-east-1.amazonaws.com", Port: 9098})
+	_, initial, err := transport.SASL.Start(ctx)
+	require.NoError(t, err)
+
+	var payload map[string]string
+	require.NoError(t, json.Unmarshal(initial, &payload))
+	assert.Equal(t, "kafka-cluster:Connect", payload["action"])
+	assert.Contains(t, payload["x-amz-credential"], "/us-east-1/kafka-cluster/aws4_request")
+}
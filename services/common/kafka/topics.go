@@ -0,0 +1,251 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/yourorg/aurora-log-system/common/env"
+)
+
+// Topic provisioning modes (KAFKA_TOPICS_MODE)
+const (
+	topicsCreate = "create" // create missing topics, verify existing ones
+	topicsVerify = "verify" // fail if a topic is missing
+	topicsOff    = "off"
+)
+
+var (
+	// ErrTopicMismatch is returned when a topic is missing or differs from
+	// its configured layout
+	ErrTopicMismatch = errors.New("kafka topics do not match their configuration")
+	// ErrUnknownTopic is returned for log types without a configured topic
+	ErrUnknownTopic = errors.New("no Kafka topic configured for log type")
+)
+
+// TopicSpec is the expected layout of a notification topic
+type TopicSpec struct {
+	Name              string
+	Partitions        int
+	ReplicationFactor int
+	Retention         time.Duration // zero leaves retention unchecked
+}
+
+// TopicsConfig maps each log type to the topic carrying its file
+// notifications. Discovery and the processor read the same KAFKA_* variables.
+type TopicsConfig struct {
+	Mode    string
+	Timeout time.Duration
+	Topics  map[string]TopicSpec // log type -> topic
+}
+
+// LoadTopicsConfig reads the KAFKA_*_TOPIC and topic layout variables
+func LoadTopicsConfig() TopicsConfig {
+	spec := func(name string) TopicSpec {
+		return TopicSpec{
+			Name:              name,
+			Partitions:        env.Int("KAFKA_PARTITION_COUNT", 10),
+			ReplicationFactor: env.Int("KAFKA_REPLICATION_FACTOR", 1),
+			Retention:         time.Duration(env.Int("KAFKA_RETENTION_HOURS", 0)) * time.Hour,
+		}
+	}
+
+	return TopicsConfig{
+		Mode:    strings.ToLower(env.Get("KAFKA_TOPICS_MODE", topicsCreate)),
+		Timeout: time.Duration(env.Int("KAFKA_TOPICS_TIMEOUT_SEC", 30)) * time.Second,
+		Topics: map[string]TopicSpec{
+			"error":     spec(env.Get("KAFKA_ERROR_TOPIC", "aurora-error-logs")),
+			"slowquery": spec(env.Get("KAFKA_SLOWQUERY_TOPIC", "aurora-slowquery-logs")),
+		},
+	}
+}
+
+// TopicFor returns the topic for a log type
+func (c TopicsConfig) TopicFor(logType string) (string, error) {
+	spec, ok := c.Topics[logType]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownTopic, logType)
+	}
+	return spec.Name, nil
+}
+
+// Names returns the configured topic names in a stable order
+func (c TopicsConfig) Names() []string {
+	names := make([]string, 0, len(c.Topics))
+	for _, spec := range c.Topics {
+		names = append(names, spec.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// topicAdmin is the part of kafka.Client used to manage topics
+type topicAdmin interface {
+	Metadata(ctx context.Context, req *kafka.MetadataRequest) (*kafka.MetadataResponse, error)
+	CreateTopics(ctx context.Context, req *kafka.CreateTopicsRequest) (*kafka.CreateTopicsResponse, error)
+	DescribeConfigs(ctx context.Context, req *kafka.DescribeConfigsRequest) (*kafka.DescribeConfigsResponse, error)
+}
+
+// ProvisionTopics checks the configured topics against the brokers
+// before any client starts
+func ProvisionTopics(brokers []string, transport kafka.RoundTripper, config TopicsConfig) error {
+	if config.Mode == topicsOff {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+
+	admin := &kafka.Client{Addr: kafka.TCP(brokers...), Timeout: config.Timeout, Transport: transport}
+	return ensureTopics(ctx, admin, config)
+}
+
+// ensureTopics verifies partition count, replication factor and
+// retention of every configured topic and, in create mode, creates the
+// missing ones. All mismatches are reported together.
+func ensureTopics(ctx context.Context, admin topicAdmin, config TopicsConfig) error {
+	switch config.Mode {
+	case topicsOff:
+		return nil
+	case topicsCreate, topicsVerify:
+	default:
+		return fmt.Errorf("unsupported KAFKA_TOPICS_MODE %q", config.Mode)
+	}
+
+	specs := make(map[string]TopicSpec, len(config.Topics))
+	for _, spec := range config.Topics {
+		specs[spec.Name] = spec
+	}
+	names := config.Names()
+
+	metadata, err := admin.Metadata(ctx, &kafka.MetadataRequest{Topics: names})
+	if err != nil {
+		return fmt.Errorf("failed to describe Kafka topics: %w", err)
+	}
+	existing := make(map[string]kafka.Topic, len(metadata.Topics))
+	for _, topic := range metadata.Topics {
+		if topic.Error != nil {
+			if errors.Is(topic.Error, kafka.UnknownTopicOrPartition) {
+				continue
+			}
+			return fmt.Errorf("failed to describe Kafka topic %s: %w", topic.Name, topic.Error)
+		}
+		existing[topic.Name] = topic
+	}
+
+	var problems []string
+	var missing []kafka.TopicConfig
+	var retained []string
+	for _, name := range names {
+		spec := specs[name]
+		topic, ok := existing[name]
+		if !ok {
+			if config.Mode == topicsVerify {
+				problems = append(problems, fmt.Sprintf("%s does not exist", name))
+				continue
+			}
+			missing = append(missing, spec.topicConfig())
+			continue
+		}
+
+		if len(topic.Partitions) != spec.Partitions {
+			problems = append(problems, fmt.Sprintf("%s has %d partitions, want %d", name, len(topic.Partitions), spec.Partitions))
+		}
+		if len(topic.Partitions) > 0 && len(topic.Partitions[0].Replicas) != spec.ReplicationFactor {
+			problems = append(problems, fmt.Sprintf("%s has replication factor %d, want %d", name, len(topic.Partitions[0].Replicas), spec.ReplicationFactor))
+		}
+		if spec.Retention > 0 {
+			retained = append(retained, name)
+		}
+	}
+
+	if len(retained) > 0 {
+		retentionProblems, err := checkTopicRetention(ctx, admin, specs, retained)
+		if err != nil {
+			return err
+		}
+		problems = append(problems, retentionProblems...)
+	}
+
+	if len(missing) > 0 {
+		if err := createTopics(ctx, admin, missing); err != nil {
+			return err
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrTopicMismatch, strings.Join(problems, "; "))
+	}
+	slog.Info("Kafka topics ready", "topics", names)
+	return nil
+}
+
+func (s TopicSpec) topicConfig() kafka.TopicConfig {
+	topic := kafka.TopicConfig{
+		Topic:             s.Name,
+		NumPartitions:     s.Partitions,
+		ReplicationFactor: s.ReplicationFactor,
+	}
+	if s.Retention > 0 {
+		topic.ConfigEntries = []kafka.ConfigEntry{{
+			ConfigName:  "retention.ms",
+			ConfigValue: strconv.FormatInt(s.Retention.Milliseconds(), 10),
+		}}
+	}
+	return topic
+}
+
+func checkTopicRetention(ctx context.Context, admin topicAdmin, specs map[string]TopicSpec, names []string) ([]string, error) {
+	resources := make([]kafka.DescribeConfigRequestResource, 0, len(names))
+	for _, name := range names {
+		resources = append(resources, kafka.DescribeConfigRequestResource{
+			ResourceType: kafka.ResourceTypeTopic,
+			ResourceName: name,
+			ConfigNames:  []string{"retention.ms"},
+		})
+	}
+
+	resp, err := admin.DescribeConfigs(ctx, &kafka.DescribeConfigsRequest{Resources: resources})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe Kafka topic configs: %w", err)
+	}
+
+	var problems []string
+	for _, resource := range resp.Resources {
+		if resource.Error != nil {
+			return nil, fmt.Errorf("failed to describe config of Kafka topic %s: %w", resource.ResourceName, resource.Error)
+		}
+		want := strconv.FormatInt(specs[resource.ResourceName].Retention.Milliseconds(), 10)
+		for _, entry := range resource.ConfigEntries {
+			if entry.ConfigName == "retention.ms" && entry.ConfigValue != want {
+				problems = append(problems, fmt.Sprintf("%s has retention.ms %s, want %s", resource.ResourceName, entry.ConfigValue, want))
+			}
+		}
+	}
+	return problems, nil
+}
+
+func createTopics(ctx context.Context, admin topicAdmin, topics []kafka.TopicConfig) error {
+	resp, err := admin.CreateTopics(ctx, &kafka.CreateTopicsRequest{Topics: topics})
+	if err != nil {
+		return fmt.Errorf("failed to create Kafka topics: %w", err)
+	}
+	for _, topic := range topics {
+		err := resp.Errors[topic.Topic]
+		switch {
+		case err == nil:
+			slog.Info("Created Kafka topic", "topic", topic.Topic,
+				"partitions", topic.NumPartitions, "replication_factor", topic.ReplicationFactor)
+		case errors.Is(err, kafka.TopicAlreadyExists):
+			// Another replica created it first; the next start verifies it
+		default:
+			return fmt.Errorf("failed to create Kafka topic %s: %w", topic.Topic, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeTopicAdmin struct {
+	topics    map[string]kafka.Topic
+	retention map[string]string
+	created   []kafka.TopicConfig
+	createErr map[string]error
+}
+
+func newFakeTopicAdmin() *fakeTopicAdmin {
+	return &fakeTopicAdmin{topics: make(map[string]kafka.Topic), retention: make(map[string]string)}
+}
+
+func (a *fakeTopicAdmin) addTopic(name string, partitions, replicas int) {
+	topic := kafka.Topic{Name: name}
+	for id := 0; id < partitions; id++ {
+		topic.Partitions = append(topic.Partitions, kafka.Partition{Topic: name, ID: id, Replicas: make([]kafka.Broker, replicas)})
+	}
+	a.topics[name] = topic
+}
+
+func (a *fakeTopicAdmin) Metadata(ctx context.Context, req *kafka.MetadataRequest) (*kafka.MetadataResponse, error) {
+	resp := &kafka.MetadataResponse{}
+	for _, name := range req.Topics {
+		topic, ok := a.topics[name]
+		if !ok {
+			topic = kafka.Topic{Name: name, Error: kafka.UnknownTopicOrPartition}
+		}
+		resp.Topics = append(resp.Topics, topic)
+	}
+	return resp, nil
+}
+
+func (a *fakeTopicAdmin) CreateTopics(ctx context.Context, req *kafka.CreateTopicsRequest) (*kafka.CreateTopicsResponse, error) {
+	a.created = append(a.created, req.Topics...)
+	return &kafka.CreateTopicsResponse{Errors: a.createErr}, nil
+}
+
+func (a *fakeTopicAdmin) DescribeConfigs(ctx context.Context, req *kafka.DescribeConfigsRequest) (*kafka.DescribeConfigsResponse, error) {
+	resp := &kafka.DescribeConfigsResponse{}
+	for _, resource := range req.Resources {
+		resp.Resources = append(resp.Resources, kafka.DescribeConfigResponseResource{
+			ResourceName:  resource.ResourceName,
+			ConfigEntries: []kafka.DescribeConfigResponseConfigEntry{{ConfigName: "retention.ms", ConfigValue: a.retention[resource.ResourceName]}},
+		})
+	}
+	return resp, nil
+}
+
+func testTopicsConfig(mode string) TopicsConfig {
+	return TopicsConfig{
+		Mode: mode,
+		Topics: map[string]TopicSpec{
+			"error":     {Name: "aurora-error-logs", Partitions: 6, ReplicationFactor: 3, Retention: 24 * time.Hour},
+			"slowquery": {Name: "aurora-slowquery-logs", Partitions: 6, ReplicationFactor: 3},
+		},
+	}
+}
+
+func TestLoadTopicsConfig(t *testing.T) {
+	t.Setenv("KAFKA_ERROR_TOPIC", "prod-error-logs")
+	t.Setenv("KAFKA_PARTITION_COUNT", "12")
+	t.Setenv("KAFKA_RETENTION_HOURS", "48")
+
+	config := LoadTopicsConfig()
+	assert.Equal(t, topicsCreate, config.Mode)
+	assert.Equal(t, []string{"aurora-slowquery-logs", "prod-error-logs"}, config.Names())
+	assert.Equal(t, TopicSpec{Name: "prod-error-logs", Partitions: 12, ReplicationFactor: 1, Retention: 48 * time.Hour}, config.Topics["error"])
+
+	topic, err := config.TopicFor("error")
+	require.NoError(t, err)
+	assert.Equal(t, "prod-error-logs", topic)
+	_, err = config.TopicFor("general")
+	assert.ErrorIs(t, err, ErrUnknownTopic)
+}
+
+func TestEnsureTopicsCreatesMissing(t *testing.T) {
+	admin := newFakeTopicAdmin()
+	admin.addTopic("aurora-error-logs", 6, 3)
+	admin.retention["aurora-error-logs"] = "86400000"
+
+	require.NoError(t, ensureTopics(context.Background(), admin, testTopicsConfig(topicsCreate)))
+	require.Len(t, admin.created, 1)
+	assert.Equal(t, kafka.TopicConfig{Topic: "aurora-slowquery-logs", NumPartitions: 6, ReplicationFactor: 3}, admin.created[0])
+
+	// A replica that loses the creation race still starts
+	admin = newFakeTopicAdmin()
+	admin.createErr = map[string]error{"aurora-error-logs": kafka.TopicAlreadyExists, "aurora-slowquery-logs": kafka.TopicAlreadyExists}
+	require.NoError(t, ensureTopics(context.Background(), admin, testTopicsConfig(topicsCreate)))
+	assert.Equal(t, []kafka.ConfigEntry{{ConfigName: "retention.ms", ConfigValue: "86400000"}}, admin.created[0].ConfigEntries)
+
+	admin = newFakeTopicAdmin()
+	admin.createErr = map[string]error{"aurora-error-logs": kafka.PolicyViolation}
+	assert.ErrorIs(t, ensureTopics(context.Background(), admin, testTopicsConfig(topicsCreate)), kafka.PolicyViolation)
+}
+
+func TestEnsureTopicsReportsMismatches(t *testing.T) {
+	admin := newFakeTopicAdmin()
+	admin.addTopic("aurora-error-logs", 3, 1)
+	admin.retention["aurora-error-logs"] = "604800000"
+	admin.addTopic("aurora-slowquery-logs", 6, 3)
+
+	err := ensureTopics(context.Background(), admin, testTopicsConfig(topicsCreate))
+	require.ErrorIs(t, err, ErrTopicMismatch)
+	assert.ErrorContains(t, err, "aurora-error-logs has 3 partitions, want 6")
+	assert.ErrorContains(t, err, "aurora-error-logs has replication factor 1, want 3")
+	assert.ErrorContains(t, err, "aurora-error-logs has retention.ms 604800000, want 86400000")
+	assert.Empty(t, admin.created)
+}
+
+func TestEnsureTopicsVerifyMode(t *testing.T) {
+	admin := newFakeTopicAdmin()
+	admin.addTopic("aurora-error-logs", 6, 3)
+	admin.retention["aurora-error-logs"] = "86400000"
+
+	err := ensureTopics(context.Background(), admin, testTopicsConfig(topicsVerify))
+	assert.ErrorIs(t, err, ErrTopicMismatch)
+	assert.ErrorContains(t, err, "aurora-slowquery-logs does not exist")
+	assert.Empty(t, admin.created)
+
+	assert.NoError(t, ensureTopics(context.Background(), nil, testTopicsConfig(topicsOff)))
+	assert.ErrorContains(t, ensureTopics(context.Background(), admin, testTopicsConfig("sometimes")), "unsupported KAFKA_TOPICS_MODE")
+}
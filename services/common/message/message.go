@@ -0,0 +1,160 @@
+// Package message defines the file notification discovery publishes and
+// the processor consumes. Both services encode and decode it here, so the
+// wire format cannot drift between them.
+//
+// A notification is a JSON LogFileInfo body plus string headers carrying
+// its schema version and provenance. Incompatible changes to the body bump
+// SchemaVersion; the golden files in testdata pin every version that has
+// been published.
+package message
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// SchemaVersion is the version Encode writes and the newest Decode reads.
+// Messages without a schema_version header predate it and are version 1.
+const SchemaVersion = 1
+
+// Headers attached to every notification
+const (
+	HeaderSchemaVersion = "schema_version"
+	HeaderTraceParent   = "traceparent" // W3C trace context
+	HeaderShardID       = "shard_id"
+	HeaderScanID        = "scan_id"
+	HeaderPublishedAt   = "published_at" // Unix milliseconds
+)
+
+// ErrUnsupportedSchemaVersion marks messages from a newer discovery; they
+// must not be decoded with the wrong layout
+var ErrUnsupportedSchemaVersion = errors.New("unsupported message schema version")
+
+var traceParentPattern = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$`)
+
+// LogFileInfo announces one RDS log file that has new data
+type LogFileInfo struct {
+	InstanceID  string    `json:"instance_id"`
+	ClusterID   string    `json:"cluster_id"`
+	Engine      string    `json:"engine"`
+	LogType     string    `json:"log_type"`
+	LogFileName string    `json:"log_file_name"`
+	LastWritten int64     `json:"last_written"`
+	Size        int64     `json:"size"`
+	Timestamp   time.Time `json:"timestamp"`
+	// RDS tags of the cluster, used by the processor for routing
+	ClusterTags map[string]string `json:"cluster_tags,omitempty"`
+	// Sent as headers rather than in the body
+	Provenance Provenance `json:"-"`
+}
+
+// Provenance is what discovery records about a notification
+type Provenance struct {
+	// Set by Decode; Encode always writes SchemaVersion
+	SchemaVersion int
+	ShardID       string
+	ScanID        string
+	PublishedAt   time.Time
+	TraceParent   string
+}
+
+// TraceID returns the trace the file belongs to, or ""
+func (p Provenance) TraceID() string {
+	if match := traceParentPattern.FindStringSubmatch(p.TraceParent); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// Encode returns the body and headers of a notification
+func Encode(info LogFileInfo) ([]byte, map[string]string, error) {
+	body, err := json.Marshal(info)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	p := info.Provenance
+	headers := map[string]string{HeaderSchemaVersion: strconv.Itoa(SchemaVersion)}
+	if p.ShardID != "" {
+		headers[HeaderShardID] = p.ShardID
+	}
+	if !p.PublishedAt.IsZero() {
+		headers[HeaderPublishedAt] = strconv.FormatInt(p.PublishedAt.UnixMilli(), 10)
+	}
+	if p.ScanID != "" {
+		headers[HeaderScanID] = p.ScanID
+	}
+	if p.TraceParent != "" {
+		headers[HeaderTraceParent] = p.TraceParent
+	}
+	return body, headers, nil
+}
+
+// Decode reads a notification according to its schema version. Unknown
+// headers are ignored; malformed provenance headers are dropped.
+func Decode(body []byte, headers map[string]string) (LogFileInfo, error) {
+	p := Provenance{SchemaVersion: 1}
+	if value, ok := headers[HeaderSchemaVersion]; ok {
+		version, err := strconv.Atoi(value)
+		if err != nil {
+			return LogFileInfo{}, fmt.Errorf("%w %q", ErrUnsupportedSchemaVersion, value)
+		}
+		p.SchemaVersion = version
+	}
+	p.ShardID = headers[HeaderShardID]
+	p.ScanID = headers[HeaderScanID]
+	if millis, err := strconv.ParseInt(headers[HeaderPublishedAt], 10, 64); err == nil {
+		p.PublishedAt = time.UnixMilli(millis).UTC()
+	}
+	if traceParentPattern.MatchString(headers[HeaderTraceParent]) {
+		p.TraceParent = headers[HeaderTraceParent]
+	}
+
+	var info LogFileInfo
+	switch p.SchemaVersion {
+	case 1:
+		if err := json.Unmarshal(body, &info); err != nil {
+			return LogFileInfo{}, fmt.Errorf("failed to decode message: %w", err)
+		}
+	default:
+		return LogFileInfo{}, fmt.Errorf("%w %d (newest supported: %d)",
+			ErrUnsupportedSchemaVersion, p.SchemaVersion, SchemaVersion)
+	}
+
+	info.Provenance = p
+	return info, nil
+}
+
+// NewTraceID starts a W3C trace
+func NewTraceID() string {
+	return randomHex(16)
+}
+
+// NewTraceParent starts a sampled span in the given trace
+func NewTraceParent(traceID string) string {
+	return "00-" + traceID + "-" + randomHex(8) + "-01"
+}
+
+// ChildTraceParent continues a trace in a new span. Malformed parents start
+// no trace.
+func ChildTraceParent(parent string) string {
+	match := traceParentPattern.FindStringSubmatch(parent)
+	if match == nil {
+		return ""
+	}
+	return "00-" + match[1] + "-" + randomHex(8) + "-" + match[3]
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,135 @@
+package message
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Regenerates the .golden files. Never regenerate a decode golden file to
+// make a failing test pass: it records what a published message meant.
+var update = flag.Bool("update", false, "rewrite golden files")
+
+// goldenMessage is a notification as it travels: body and headers
+type goldenMessage struct {
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body"`
+}
+
+// decoded is what the processor sees of a notification
+type decoded struct {
+	Info       LogFileInfo `json:"info"`
+	Provenance Provenance  `json:"provenance"`
+}
+
+func checkGolden(t *testing.T, path string, got any) {
+	t.Helper()
+	data, err := json.MarshalIndent(got, "", "  ")
+	require.NoError(t, err)
+	data = append(data, '\n')
+
+	if *update {
+		require.NoError(t, os.WriteFile(path, data, 0o644))
+	}
+	want, err := os.ReadFile(path)
+	require.NoError(t, err, "run go test -update to create golden files")
+	assert.JSONEq(t, string(want), string(data))
+}
+
+// Every message in testdata/decode was published by some discovery version
+// and must keep decoding to the same result
+func TestDecodeGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "decode", "*.json"))
+	require.NoError(t, err)
+	require.NotEmpty(t, inputs)
+
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".json")
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(input)
+			require.NoError(t, err)
+			var msg goldenMessage
+			require.NoError(t, json.Unmarshal(data, &msg))
+
+			info, err := Decode(msg.Body, msg.Headers)
+			require.NoError(t, err)
+			checkGolden(t, strings.TrimSuffix(input, ".json")+".golden", decoded{Info: info, Provenance: info.Provenance})
+		})
+	}
+}
+
+// The current encoder's output; a change here is a wire format change
+func TestEncodeGolden(t *testing.T) {
+	info := LogFileInfo{
+		InstanceID:  "prod-orders-1",
+		ClusterID:   "prod-orders",
+		Engine:      "aurora-mysql",
+		LogType:     "error",
+		LogFileName: "error/mysql-error.log",
+		LastWritten: 1754136000000,
+		Size:        2048,
+		Timestamp:   time.Date(2025, 8, 2, 12, 0, 0, 0, time.UTC),
+		ClusterTags: map[string]string{"team": "payments"},
+		Provenance: Provenance{
+			ShardID:     "3",
+			ScanID:      "9f86d081884c7d65",
+			PublishedAt: time.UnixMilli(1754136000123),
+			TraceParent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		},
+	}
+
+	body, headers, err := Encode(info)
+	require.NoError(t, err)
+	checkGolden(t, filepath.Join("testdata", "encode", "v1.golden"), goldenMessage{Headers: headers, Body: body})
+
+	// What is encoded today decodes unchanged
+	roundTrip, err := Decode(body, headers)
+	require.NoError(t, err)
+	info.Provenance.SchemaVersion = SchemaVersion
+	info.Provenance.PublishedAt = info.Provenance.PublishedAt.UTC()
+	assert.Equal(t, info, roundTrip)
+}
+
+func TestEncodeOmitsEmptyProvenance(t *testing.T) {
+	_, headers, err := Encode(LogFileInfo{InstanceID: "db-1"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{HeaderSchemaVersion: "1"}, headers)
+}
+
+func TestDecodeUnsupportedVersion(t *testing.T) {
+	body := []byte(`{"instance_id":"db-1"}`)
+
+	_, err := Decode(body, map[string]string{HeaderSchemaVersion: "2"})
+	assert.ErrorIs(t, err, ErrUnsupportedSchemaVersion)
+	assert.EqualError(t, err, "unsupported message schema version 2 (newest supported: 1)")
+
+	_, err = Decode(body, map[string]string{HeaderSchemaVersion: "v2"})
+	assert.ErrorIs(t, err, ErrUnsupportedSchemaVersion)
+
+	_, err = Decode([]byte(`not json`), nil)
+	assert.ErrorContains(t, err, "failed to decode message")
+}
+
+func TestTraceParents(t *testing.T) {
+	traceID := NewTraceID()
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{32}$`), traceID)
+
+	parent := NewTraceParent(traceID)
+	assert.Regexp(t, regexp.MustCompile(`^00-`+traceID+`-[0-9a-f]{16}-01$`), parent)
+	assert.NotEqual(t, parent, NewTraceParent(traceID), "every call starts a new span")
+
+	child := ChildTraceParent(parent)
+	assert.Equal(t, traceID, Provenance{TraceParent: child}.TraceID())
+	assert.NotEqual(t, parent, child)
+
+	assert.Empty(t, ChildTraceParent("not-a-trace"))
+	assert.Empty(t, Provenance{TraceParent: "not-a-trace"}.TraceID())
+}
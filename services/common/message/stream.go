@@ -0,0 +1,59 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Fields of a notification sent as a Valkey stream entry. Each header is a
+// field of its own, prefixed with StreamHeaderPrefix.
+const (
+	StreamFieldKey     = "key"
+	StreamFieldBody    = "body"
+	StreamHeaderPrefix = "h:"
+)
+
+var errNoStreamBody = errors.New("stream entry has no body field")
+
+// StreamValues returns the fields of a stream entry as XADD takes them, in a
+// stable order
+func StreamValues(key string, body []byte, headers map[string]string) []any {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := make([]any, 0, 4+2*len(names))
+	values = append(values, StreamFieldKey, key, StreamFieldBody, string(body))
+	for _, name := range names {
+		values = append(values, StreamHeaderPrefix+name, headers[name])
+	}
+	return values
+}
+
+// FromStreamValues splits the fields of a stream entry into key, body and
+// headers. Unknown fields are ignored.
+func FromStreamValues(values map[string]any) (string, []byte, map[string]string, error) {
+	body, ok := values[StreamFieldBody].(string)
+	if !ok {
+		return "", nil, nil, errNoStreamBody
+	}
+	key, _ := values[StreamFieldKey].(string)
+
+	headers := make(map[string]string)
+	for field, value := range values {
+		name, ok := strings.CutPrefix(field, StreamHeaderPrefix)
+		if !ok {
+			continue
+		}
+		text, ok := value.(string)
+		if !ok {
+			return "", nil, nil, fmt.Errorf("stream header %q is not a string", name)
+		}
+		headers[name] = text
+	}
+	return key, []byte(body), headers, nil
+}
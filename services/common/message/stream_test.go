@@ -0,0 +1,41 @@
+package message
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamValues(t *testing.T) {
+	headers := map[string]string{HeaderSchemaVersion: "1", HeaderShardID: "3", HeaderScanID: "scan-1"}
+	values := StreamValues("db-1", []byte(`{"instance_id":"db-1"}`), headers)
+	assert.Equal(t, []any{
+		"key", "db-1",
+		"body", `{"instance_id":"db-1"}`,
+		"h:scan_id", "scan-1",
+		"h:schema_version", "1",
+		"h:shard_id", "3",
+	}, values)
+
+	// Valkey returns the fields as a map of strings
+	fields := map[string]any{}
+	for i := 0; i < len(values); i += 2 {
+		fields[values[i].(string)] = values[i+1]
+	}
+	fields["added_later"] = "ignored"
+
+	key, body, decoded, err := FromStreamValues(fields)
+	require.NoError(t, err)
+	assert.Equal(t, "db-1", key)
+	assert.Equal(t, `{"instance_id":"db-1"}`, string(body))
+	assert.Equal(t, headers, decoded)
+}
+
+func TestFromStreamValuesErrors(t *testing.T) {
+	_, _, _, err := FromStreamValues(map[string]any{"key": "db-1"})
+	assert.ErrorIs(t, err, errNoStreamBody)
+
+	_, _, _, err = FromStreamValues(map[string]any{"body": "{}", "h:shard_id": 3})
+	assert.EqualError(t, err, `stream header "shard_id" is not a string`)
+}
@@ -0,0 +1,24 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExporter(t *testing.T) {
+	exporter := NewExporter("http://localhost:5080", "user", "pass")
+
+	// Test counter increment
+	exporter.IncrementCounter("test_counter", 5)
+	assert.Equal(t, int64(5), exporter.Counter("test_counter"))
+
+	// Test multiple increments
+	exporter.IncrementCounter("test_counter", 3)
+	assert.Equal(t, int64(8), exporter.Counter("test_counter"))
+
+	exporter.RecordError("processor", "decode")
+	exporter.RecordError("processor", "decode")
+	assert.Equal(t, int64(2), exporter.Counter("processor_decode_errors"))
+	assert.Zero(t, exporter.Counter("unknown"))
+}
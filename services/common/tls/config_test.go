@@ -0,0 +1,130 @@
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestCertificate creates a self-signed certificate for 127.0.0.1 and
+// writes it to dir as a CA bundle
+func writeTestCertificate(t *testing.T, dir string) (tls.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fluent-bit"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, certPEM, 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tls.crt"), certPEM, 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tls.key"), keyPEM, 0600))
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+	return cert, caFile
+}
+
+func TestFileConfigBuild(t *testing.T) {
+	dir := t.TempDir()
+	_, caFile := writeTestCertificate(t, dir)
+
+	t.Run("disabled", func(t *testing.T) {
+		cfg, err := FileConfig{}.Build()
+		assert.NoError(t, err)
+		assert.Nil(t, cfg)
+	})
+
+	t.Run("custom CA and client certificate", func(t *testing.T) {
+		cfg, err := FileConfig{
+			Enabled:  true,
+			CAFile:   caFile,
+			CertFile: filepath.Join(dir, "tls.crt"),
+			KeyFile:  filepath.Join(dir, "tls.key"),
+		}.Build()
+		require.NoError(t, err)
+		assert.NotNil(t, cfg.VerifyConnection)
+
+		cert, err := cfg.GetClientCertificate(&tls.CertificateRequestInfo{})
+		require.NoError(t, err)
+		assert.NotEmpty(t, cert.Certificate)
+	})
+
+	t.Run("missing CA file", func(t *testing.T) {
+		_, err := FileConfig{Enabled: true, CAFile: filepath.Join(dir, "missing.pem")}.Build()
+		assert.Error(t, err)
+	})
+}
+
+func TestFileConfigReloadsCA(t *testing.T) {
+	serverDir, clientDir := t.TempDir(), t.TempDir()
+	serverCert, serverCA := writeTestCertificate(t, serverDir)
+	_, otherCA := writeTestCertificate(t, clientDir)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{serverCert}})
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+
+	// The client trusts a different CA until the bundle is rotated
+	cfg, err := FileConfig{Enabled: true, CAFile: otherCA, ServerName: "127.0.0.1"}.Build()
+	require.NoError(t, err)
+	_, err = tls.Dial("tcp", listener.Addr().String(), cfg)
+	assert.Error(t, err)
+
+	pem, err := os.ReadFile(serverCA)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(otherCA, pem, 0600))
+	require.NoError(t, os.Chtimes(otherCA, time.Now().Add(time.Minute), time.Now().Add(time.Minute)))
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), cfg)
+	require.NoError(t, err)
+	conn.Close()
+
+	// The server name is still checked
+	cfg, err = FileConfig{Enabled: true, CAFile: serverCA, ServerName: "fluent-bit.example"}.Build()
+	require.NoError(t, err)
+	_, err = tls.Dial("tcp", listener.Addr().String(), cfg)
+	assert.ErrorContains(t, err, "fluent-bit.example")
+}
@@ -0,0 +1,51 @@
+package tls
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileSecret is a credential stored in a file (e.g. a mounted Kubernetes
+// secret). The file is re-read whenever its modification time changes.
+type FileSecret struct {
+	path    string
+	mu      sync.Mutex
+	value   string
+	modTime time.Time
+}
+
+func NewFileSecret(path string) *FileSecret {
+	if path == "" {
+		return nil
+	}
+	return &FileSecret{path: path}
+}
+
+// Value returns the current secret, reloading it after rotation
+func (s *FileSecret) Value() (string, error) {
+	if s == nil {
+		return "", nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat secret file %s: %w", s.path, err)
+	}
+
+	if !info.ModTime().Equal(s.modTime) || s.value == "" {
+		data, err := os.ReadFile(s.path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file %s: %w", s.path, err)
+		}
+		s.value = strings.TrimSpace(string(data))
+		s.modTime = info.ModTime()
+	}
+
+	return s.value, nil
+}
@@ -0,0 +1,34 @@
+package tls
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSecretRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(path, []byte("first\n"), 0600))
+
+	secret := NewFileSecret(path)
+	value, err := secret.Value()
+	require.NoError(t, err)
+	assert.Equal(t, "first", value)
+
+	require.NoError(t, os.WriteFile(path, []byte("second"), 0600))
+	// Make the rotation visible even on filesystems with coarse mtimes
+	require.NoError(t, os.Chtimes(path, time.Now().Add(time.Minute), time.Now().Add(time.Minute)))
+
+	value, err = secret.Value()
+	require.NoError(t, err)
+	assert.Equal(t, "second", value)
+
+	var unset *FileSecret
+	value, err = unset.Value()
+	assert.NoError(t, err)
+	assert.Empty(t, value)
+}
@@ -6,14 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
-	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
 	"strconv"
 	"strings"
-	"sync"
-	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -25,30 +22,14 @@ import (
 	rdsTypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
 	"github.com/redis/go-redis/v9"
 	"github.com/segmentio/kafka-go"
+	"github.com/yourorg/aurora-log-system/common/circuit"
+	"github.com/yourorg/aurora-log-system/common/env"
+	commonkafka "github.com/yourorg/aurora-log-system/common/kafka"
+	"github.com/yourorg/aurora-log-system/common/metrics"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/time/rate"
 )
 
-// ============================================================================
-// Helper Functions
-// ============================================================================
-
-func getEnvOrDefault(key, defaultVal string) string {
-	if val := os.Getenv(key); val != "" {
-		return val
-	}
-	return defaultVal
-}
-
-func getEnvAsInt(key string, defaultVal int) int {
-	if val := os.Getenv(key); val != "" {
-		if intVal, err := strconv.Atoi(val); err == nil {
-			return intVal
-		}
-	}
-	return defaultVal
-}
-
 // ============================================================================
 // RDS Cache Client - Caches RDS API responses in Redis
 // ============================================================================
@@ -66,6 +47,14 @@ type DynamoDBClientInterface interface {
 	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
 	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
 	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+}
+
+// notificationWriter publishes file notifications. kafka.Writer and
+// ValkeyStreamWriter implement it.
+type notificationWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
 }
 
 type RDSCacheClient struct {
@@ -221,109 +210,6 @@ func (c *RDSCacheClient) setCache(ctx context.Context, key string, value interfa
 	}
 }
 
-// ============================================================================
-// Circuit Breaker - Protects against cascading failures
-// ============================================================================
-
-type CircuitBreaker struct {
-	maxFailures     int
-	resetTimeout    time.Duration
-	failures        atomic.Int32
-	lastFailureTime atomic.Int64
-	state           atomic.Int32 // 0=closed, 1=open, 2=half-open
-}
-
-func NewCircuitBreaker(maxFailures int, resetTimeout time.Duration) *CircuitBreaker {
-	return &CircuitBreaker{
-		maxFailures:  maxFailures,
-		resetTimeout: resetTimeout,
-	}
-}
-
-func (cb *CircuitBreaker) Call(fn func() error) error {
-	if !cb.canExecute() {
-		return errors.New("circuit breaker is open")
-	}
-
-	err := fn()
-	if err != nil {
-		cb.recordFailure()
-	} else {
-		cb.recordSuccess()
-	}
-
-	return err
-}
-
-func (cb *CircuitBreaker) canExecute() bool {
-	state := cb.state.Load()
-	
-	switch state {
-	case 0: // closed
-		return true
-	case 1: // open
-		lastFailure := time.Unix(0, cb.lastFailureTime.Load())
-		if time.Since(lastFailure) > cb.resetTimeout {
-			cb.state.CompareAndSwap(1, 2) // transition to half-open
-			return true
-		}
-		return false
-	case 2: // half-open
-		return true
-	default:
-		return false
-	}
-}
-
-func (cb *CircuitBreaker) recordFailure() {
-	cb.failures.Add(1)
-	cb.lastFailureTime.Store(time.Now().UnixNano())
-	
-	if cb.failures.Load() >= int32(cb.maxFailures) {
-		cb.state.Store(1) // open
-	}
-}
-
-func (cb *CircuitBreaker) recordSuccess() {
-	if cb.state.Load() == 2 { // half-open
-		cb.state.Store(0) // closed
-		cb.failures.Store(0)
-	}
-}
-
-// ============================================================================
-// Metrics Exporter - Sends metrics to OpenObserve
-// ============================================================================
-
-type MetricsExporter struct {
-	url      string
-	user     string
-	pass     string
-	client   *http.Client
-	counters map[string]int64
-	mu       sync.RWMutex
-}
-
-func NewMetricsExporter(url, user, pass string) *MetricsExporter {
-	return &MetricsExporter{
-		url:      url,
-		user:     user,
-		pass:     pass,
-		client:   &http.Client{Timeout: 5 * time.Second},
-		counters: make(map[string]int64),
-	}
-}
-
-func (m *MetricsExporter) IncrementCounter(name string, value int64) {
-	m.mu.Lock()
-	m.counters[name] += value
-	m.mu.Unlock()
-}
-
-func (m *MetricsExporter) RecordHistogram(name string, value float64) {
-	slog.Debug("Metric recorded", "name", name, "value", value)
-}
-
 // ============================================================================
 // Main Types
 // ============================================================================
@@ -339,17 +225,13 @@ type Config struct {
 	DiscoveryInterval time.Duration
 	RateLimitPerSec   int
 	Region            string
-}
-
-type LogFileInfo struct {
-	InstanceID   string    `json:"instance_id"`
-	ClusterID    string    `json:"cluster_id"`
-	Engine       string    `json:"engine"`
-	LogType      string    `json:"log_type"`
-	LogFileName  string    `json:"log_file_name"`
-	LastWritten  int64     `json:"last_written"`
-	Size         int64     `json:"size"`
-	Timestamp    time.Time `json:"timestamp"`
+	OutboxTable       string
+	OutboxInterval    time.Duration
+	// Files left "discovered" this long were never published, e.g. after
+	// a crash, and are queued in the outbox
+	PublishStaleAfter time.Duration
+	// kafka or valkey
+	Transport         string
 }
 
 type Discovery struct {
@@ -357,11 +239,13 @@ type Discovery struct {
 	rdsClient        *rds.Client
 	rdsCacheClient   *RDSCacheClient
 	dynamoClient     DynamoDBClientInterface
-	kafkaWriter      *kafka.Writer
+	writer           notificationWriter
+	topics           commonkafka.TopicsConfig
+	outbox           *PublishOutbox
 	redisClient      *redis.Client
 	limiter          *rate.Limiter
-	metricsExporter  *MetricsExporter
-	circuitBreaker   *CircuitBreaker
+	metricsExporter  *metrics.Exporter
+	circuitBreaker   *circuit.Breaker
 	shutdownChan     chan struct{}
 }
 
@@ -395,12 +279,16 @@ func main() {
 		InstanceTable:     os.Getenv("INSTANCE_TABLE"),
 		TrackingTable:     os.Getenv("TRACKING_TABLE"),
 		ValkeyURL:         os.Getenv("VALKEY_URL"),
-		LogLevel:          getEnvOrDefault("LOG_LEVEL", "INFO"),
-		ShardID:           getEnvAsInt("SHARD_ID", 0),
-		TotalShards:       getEnvAsInt("TOTAL_SHARDS", 1),
-		DiscoveryInterval: time.Duration(getEnvAsInt("DISCOVERY_INTERVAL_MIN", 5)) * time.Minute,
-		RateLimitPerSec:   getEnvAsInt("RDS_API_RATE_LIMIT", 10),
+		LogLevel:          env.Get("LOG_LEVEL", "INFO"),
+		ShardID:           env.Int("SHARD_ID", 0),
+		TotalShards:       env.Int("TOTAL_SHARDS", 1),
+		DiscoveryInterval: time.Duration(env.Int("DISCOVERY_INTERVAL_MIN", 5)) * time.Minute,
+		RateLimitPerSec:   env.Int("RDS_API_RATE_LIMIT", 10),
 		Region:            os.Getenv("AWS_REGION"),
+		OutboxTable:       env.Get("OUTBOX_TABLE", "aurora-log-publish-outbox"),
+		OutboxInterval:    time.Duration(env.Int("DISCOVERY_OUTBOX_INTERVAL_SEC", 30)) * time.Second,
+		PublishStaleAfter: time.Duration(env.Int("DISCOVERY_PUBLISH_STALE_MIN", 15)) * time.Minute,
+		Transport:         env.Get("NOTIFICATION_TRANSPORT", transportKafka),
 	}
 
 	// Configure AWS SDK
@@ -416,17 +304,60 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Initialize Kafka writer
-	kafkaWriter := kafka.NewWriter(kafka.WriterConfig{
-		Brokers:      cfg.KafkaBrokers,
-		Async:        true,
-		BatchSize:    100,
-		BatchTimeout: 1 * time.Second,
-		ErrorLogger: kafka.LoggerFunc(func(msg string, args ...interface{}) {
-			slog.Error("Kafka error", "msg", fmt.Sprintf(msg, args...))
-		}),
-	})
-	defer kafkaWriter.Close()
+	// Streams and topics share their names, so both transports route by
+	// the same configuration
+	kafkaTopics := commonkafka.LoadTopicsConfig()
+	var writer notificationWriter
+	switch cfg.Transport {
+	case transportKafka:
+		kafkaTransport, err := commonkafka.LoadSecurityConfig().Transport(awsCfg)
+		if err != nil {
+			slog.Error("Failed to configure Kafka security", "error", err)
+			os.Exit(1)
+		}
+
+		// Publishing to a missing or misconfigured topic fails late and quietly
+		if err := commonkafka.ProvisionTopics(cfg.KafkaBrokers, kafkaTransport, kafkaTopics); err != nil {
+			slog.Error("Kafka topics are not ready", "error", err)
+			os.Exit(1)
+		}
+
+		// Initialize Kafka writer. Writes are synchronous so a file is marked
+		// published only once every in-sync replica has it.
+		writer = &kafka.Writer{
+			Addr:         kafka.TCP(cfg.KafkaBrokers...),
+			Transport:    kafkaTransport,
+			RequiredAcks: kafka.RequireAll,
+			MaxAttempts:  3,
+			BatchSize:    100,
+			BatchTimeout: 10 * time.Millisecond,
+			WriteTimeout: 10 * time.Second,
+			ErrorLogger: kafka.LoggerFunc(func(msg string, args ...interface{}) {
+				slog.Error("Kafka error", "msg", fmt.Sprintf(msg, args...))
+			}),
+		}
+	case transportValkey:
+		streams := loadValkeyStreamsConfig()
+		streamsClient, err := newValkeyClient(streams.URL)
+		if err != nil {
+			slog.Error("Failed to configure Valkey streams", "error", err)
+			os.Exit(1)
+		}
+		// Unlike the cache, the streams are required
+		pingCtx, pingCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = streamsClient.Ping(pingCtx).Err()
+		pingCancel()
+		if err != nil {
+			slog.Error("Valkey streams are not reachable", "error", err)
+			os.Exit(1)
+		}
+		writer = NewValkeyStreamWriter(streamsClient, streams.MaxLen)
+	default:
+		slog.Error("Unknown notification transport", "transport", cfg.Transport)
+		os.Exit(1)
+	}
+	defer writer.Close()
+	slog.Info("Publishing notifications", "transport", cfg.Transport, "topics", kafkaTopics.Names())
 
 	// Initialize Redis client
 	rdsClient := rds.NewFromConfig(awsCfg)
@@ -450,7 +381,7 @@ func main() {
 	}
 	
 	// Initialize services
-	metricsExporter := NewMetricsExporter(
+	metricsExporter := metrics.NewExporter(
 		os.Getenv("OPENOBSERVE_URL"),
 		os.Getenv("OPENOBSERVE_USER"),
 		os.Getenv("OPENOBSERVE_PASS"),
@@ -463,11 +394,13 @@ func main() {
 		rdsClient:       rdsClient,
 		rdsCacheClient:  rdsCacheClient,
 		dynamoClient:    dynamoClient,
-		kafkaWriter:     kafkaWriter,
+		writer:          writer,
+		topics:          kafkaTopics,
+		outbox:          NewPublishOutbox(dynamoClient, cfg.OutboxTable, cfg.ShardID, cfg.OutboxInterval),
 		redisClient:     redisClient,
 		limiter:         rate.NewLimiter(rate.Limit(cfg.RateLimitPerSec), cfg.RateLimitPerSec),
 		metricsExporter: metricsExporter,
-		circuitBreaker:  NewCircuitBreaker(5, 30*time.Second),
+		circuitBreaker:  circuit.NewBreaker(5, 30*time.Second),
 		shutdownChan:    make(chan struct{}),
 	}
 
@@ -502,6 +435,8 @@ func (d *Discovery) Start(ctx context.Context) error {
 		"total_shards", d.config.TotalShards,
 		"discovery_interval", d.config.DiscoveryInterval)
 
+	go d.runOutbox(ctx)
+
 	// Run discovery immediately
 	d.discoverClusters(ctx)
 
@@ -529,6 +464,10 @@ func (d *Discovery) discoverClusters(ctx context.Context) {
 		d.metricsExporter.RecordHistogram("discovery_duration_seconds", time.Since(startTime).Seconds())
 	}()
 
+	scan := newDiscoveryScan()
+	ctx = withDiscoveryScan(ctx, scan)
+	slog.Debug("Starting discovery scan", "scan_id", scan.ID, "trace_id", scan.TraceID)
+
 	// Rate limit RDS API calls
 	if err := d.limiter.Wait(ctx); err != nil {
 		slog.Error("Rate limiter error", "error", err)
@@ -588,9 +527,11 @@ func (d *Discovery) processCluster(ctx context.Context, cluster rdsTypes.DBClust
 		slog.Error("Failed to save cluster details", "error", err, "cluster_id", clusterID)
 	}
 	
+	tags := clusterTags(cluster)
+	
 	// Process each cluster member
 	for _, member := range cluster.DBClusterMembers {
-		if err := d.processInstance(ctx, aws.ToString(member.DBInstanceIdentifier), clusterID, member); err != nil {
+		if err := d.processInstance(ctx, aws.ToString(member.DBInstanceIdentifier), clusterID, tags, member); err != nil {
 			slog.Error("Failed to process instance", 
 				"error", err, 
 				"instance_id", aws.ToString(member.DBInstanceIdentifier))
@@ -600,7 +541,19 @@ func (d *Discovery) processCluster(ctx context.Context, cluster rdsTypes.DBClust
 	return nil
 }
 
-func (d *Discovery) processInstance(ctx context.Context, instanceID, clusterID string, member rdsTypes.DBClusterMember) error {
+// clusterTags returns the cluster's RDS tags as a map
+func clusterTags(cluster rdsTypes.DBCluster) map[string]string {
+	if len(cluster.TagList) == 0 {
+		return nil
+	}
+	tags := make(map[string]string, len(cluster.TagList))
+	for _, tag := range cluster.TagList {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return tags
+}
+
+func (d *Discovery) processInstance(ctx context.Context, instanceID, clusterID string, tags map[string]string, member rdsTypes.DBClusterMember) error {
 	// Rate limit
 	if err := d.limiter.Wait(ctx); err != nil {
 		return err
@@ -615,6 +568,7 @@ func (d *Discovery) processInstance(ctx context.Context, instanceID, clusterID s
 	slog.Debug("Found log files", "instance_id", instanceID, "count", len(logFiles))
 
 	// Process each log file
+	var pending []LogFileInfo
 	for _, logFile := range logFiles {
 		logType := d.getLogType(aws.ToString(logFile.LogFileName))
 		if logType == "" {
@@ -630,15 +584,19 @@ func (d *Discovery) processInstance(ctx context.Context, instanceID, clusterID s
 			LastWritten: aws.ToInt64(logFile.LastWritten),
 			Size:        aws.ToInt64(logFile.Size),
 			Timestamp:   time.Now(),
+			ClusterTags: tags,
+		}
+		if scan, ok := scanFromContext(ctx); ok {
+			logInfo.Provenance.ScanID = scan.ID
+			logInfo.Provenance.TraceParent = scan.newTraceParent()
 		}
 
 		// Check if we should process this log file
 		if d.shouldProcessLog(ctx, logInfo) {
-			if err := d.publishLogInfo(ctx, logInfo); err != nil {
-				slog.Error("Failed to publish log info", "error", err, "file", logInfo.LogFileName)
-			}
+			pending = append(pending, logInfo)
 		}
 	}
+	d.publishLogInfos(ctx, pending)
 
 	// Save instance details
 	return d.saveInstanceDetails(ctx, instanceID, clusterID, member)
@@ -691,7 +649,10 @@ func (d *Discovery) shouldProcessLog(ctx context.Context, logInfo LogFileInfo) b
 						return false // File not modified
 					}
 				}
-			} else if statusVal.Value == "processing" || statusVal.Value == "discovered" {
+			} else if statusVal.Value == "discovered" {
+				d.requeueIfStale(ctx, logInfo, result.Item)
+				return false // Publish pending
+			} else if statusVal.Value == "published" || statusVal.Value == "processing" {
 				return false // Already being processed
 			}
 		}
@@ -746,19 +707,125 @@ func (d *Discovery) updateTrackingStatus(ctx context.Context, logInfo LogFileInf
 	}
 }
 
-func (d *Discovery) publishLogInfo(ctx context.Context, logInfo LogFileInfo) error {
-	data, err := json.Marshal(logInfo)
-	if err != nil {
-		return err
+// requeueIfStale queues a file whose publish never completed. Files whose
+// publish failed are already in the outbox and keep their entry.
+func (d *Discovery) requeueIfStale(ctx context.Context, logInfo LogFileInfo, item map[string]dynamoTypes.AttributeValue) {
+	discoveredAttr, ok := item["discovered_at"].(*dynamoTypes.AttributeValueMemberN)
+	if !ok {
+		return
+	}
+	discoveredAt, err := strconv.ParseInt(discoveredAttr.Value, 10, 64)
+	if err != nil || time.Since(time.Unix(discoveredAt, 0)) < d.config.PublishStaleAfter {
+		return
+	}
+
+	slog.Warn("Requeueing unpublished log file", "instance_id", logInfo.InstanceID, "file", logInfo.LogFileName)
+	if err := d.outbox.Add(ctx, logInfo, errors.New("not published after discovery")); err != nil {
+		slog.Error("Failed to requeue unpublished log file", "error", err)
+	}
+}
+
+// writeLogInfos publishes file notifications and waits for the broker to
+// acknowledge them. It returns one result per notification.
+func (d *Discovery) writeLogInfos(ctx context.Context, infos []LogFileInfo) []error {
+	errs := make([]error, len(infos))
+	msgs := make([]kafka.Message, 0, len(infos))
+	sent := make([]int, 0, len(infos))
+	for i, info := range infos {
+		// Only the known topics exist; never produce to a guessed name
+		topic, err := d.topics.TopicFor(info.LogType)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		data, headers, err := encodeLogInfo(info, d.config.ShardID, time.Now())
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		msgs = append(msgs, kafka.Message{
+			Topic:   topic,
+			Key:     []byte(info.InstanceID),
+			Value:   data,
+			Headers: headers,
+		})
+		sent = append(sent, i)
+	}
+	if len(msgs) == 0 {
+		return errs
+	}
+
+	err := d.writer.WriteMessages(ctx, msgs...)
+	var writeErrs kafka.WriteErrors
+	switch {
+	case err == nil:
+	case errors.As(err, &writeErrs) && len(writeErrs) == len(msgs):
+		for j, writeErr := range writeErrs {
+			errs[sent[j]] = writeErr
+		}
+	default:
+		for _, i := range sent {
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
+// publishLogInfos publishes newly discovered files. Acknowledged files move
+// to "published"; the others are queued in the outbox for retry.
+func (d *Discovery) publishLogInfos(ctx context.Context, infos []LogFileInfo) {
+	if len(infos) == 0 {
+		return
 	}
 
-	// Use proper topic names per architecture
-	topic := fmt.Sprintf("aurora-%s-logs", logInfo.LogType)
-	return d.kafkaWriter.WriteMessages(ctx, kafka.Message{
-		Topic: topic,
-		Key:   []byte(logInfo.InstanceID),
-		Value: data,
+	for i, err := range d.writeLogInfos(ctx, infos) {
+		info := infos[i]
+		if err == nil {
+			d.markPublished(ctx, info)
+			continue
+		}
+
+		d.metricsExporter.IncrementCounter("discovery_publish_failures", 1)
+		if errors.Is(err, commonkafka.ErrUnknownTopic) {
+			// Retrying cannot help
+			slog.Error("Failed to publish log info", "error", err, "file", info.LogFileName)
+			continue
+		}
+		slog.Warn("Failed to publish log info, queued for retry", "error", err, "file", info.LogFileName)
+		if err := d.outbox.Add(ctx, info, err); err != nil {
+			slog.Error("Failed to queue log info for retry", "error", err, "file", info.LogFileName)
+		}
+	}
+}
+
+// markPublished records the broker's ack. The processor may already have
+// moved the file on, so only a "discovered" status is replaced.
+func (d *Discovery) markPublished(ctx context.Context, logInfo LogFileInfo) {
+	updateExpr := "SET #status = :published, published_at = :published_at"
+	condition := "#status = :discovered"
+
+	_, err := d.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: &d.config.TrackingTable,
+		Key: map[string]dynamoTypes.AttributeValue{
+			"instance_id":   &dynamoTypes.AttributeValueMemberS{Value: logInfo.InstanceID},
+			"log_file_name": &dynamoTypes.AttributeValueMemberS{Value: logInfo.LogFileName},
+		},
+		UpdateExpression:    &updateExpr,
+		ConditionExpression: &condition,
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
+			":published":    &dynamoTypes.AttributeValueMemberS{Value: "published"},
+			":discovered":   &dynamoTypes.AttributeValueMemberS{Value: "discovered"},
+			":published_at": &dynamoTypes.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+		},
 	})
+
+	var moved *dynamoTypes.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &moved) {
+		slog.Error("Failed to mark log file published", "error", err)
+	}
 }
 
 func (d *Discovery) saveClusterDetails(ctx context.Context, cluster rdsTypes.DBCluster) error {
@@ -13,6 +13,8 @@ import (
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	commonkafka "github.com/yourorg/aurora-log-system/common/kafka"
 )
 
 // Mock clients
@@ -54,89 +56,14 @@ func (m *mockDynamoClient) UpdateItem(ctx context.Context, params *dynamodb.Upda
 	return args.Get(0).(*dynamodb.UpdateItemOutput), args.Error(1)
 }
 
-func (m *mockDynamoClient) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+func (m *mockDynamoClient) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
 	args := m.Called(ctx, params)
-	return args.Get(0).(*dynamodb.QueryOutput), args.Error(1)
-}
-
-// Test helper functions
-func TestGetEnvOrDefault(t *testing.T) {
-	tests := []struct {
-		name     string
-		key      string
-		defVal   string
-		envVal   string
-		expected string
-	}{
-		{"returns default when env not set", "TEST_KEY", "default", "", "default"},
-		{"returns env value when set", "TEST_KEY", "default", "value", "value"},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if tt.envVal != "" {
-				t.Setenv(tt.key, tt.envVal)
-			}
-			result := getEnvOrDefault(tt.key, tt.defVal)
-			assert.Equal(t, tt.expected, result)
-		})
-	}
-}
-
-func TestGetEnvAsInt(t *testing.T) {
-	tests := []struct {
-		name     string
-		key      string
-		defVal   int
-		envVal   string
-		expected int
-	}{
-		{"returns default when env not set", "TEST_KEY", 10, "", 10},
-		{"returns env value as int", "TEST_KEY", 10, "20", 20},
-		{"returns default on invalid int", "TEST_KEY", 10, "invalid", 10},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if tt.envVal != "" {
-				t.Setenv(tt.key, tt.envVal)
-			}
-			result := getEnvAsInt(tt.key, tt.defVal)
-			assert.Equal(t, tt.expected, result)
-		})
-	}
+	return args.Get(0).(*dynamodb.DeleteItemOutput), args.Error(1)
 }
 
-// Test Circuit Breaker
-func TestCircuitBreaker(t *testing.T) {
-	cb := NewCircuitBreaker(2, 100*time.Millisecond)
-
-	// Test successful calls
-	err := cb.Call(func() error { return nil })
-	assert.NoError(t, err)
-
-	// Test failure tracking
-	failCount := 0
-	for i := 0; i < 3; i++ {
-		err = cb.Call(func() error {
-			failCount++
-			return assert.AnError
-		})
-		if i < 2 {
-			assert.Error(t, err)
-		}
-	}
-
-	// Circuit should be open now
-	err = cb.Call(func() error { return nil })
-	assert.EqualError(t, err, "circuit breaker is open")
-
-	// Wait for reset timeout
-	time.Sleep(150 * time.Millisecond)
-
-	// Circuit should be half-open, next call should succeed
-	err = cb.Call(func() error { return nil })
-	assert.NoError(t, err)
+func (m *mockDynamoClient) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	args := m.Called(ctx, params)
+	return args.Get(0).(*dynamodb.QueryOutput), args.Error(1)
 }
 
 // Test RDS Cache Client
@@ -275,35 +202,7 @@ func TestShouldProcessLog(t *testing.T) {
 	})
 }
 
-// Test Metrics Exporter
-func TestMetricsExporter(t *testing.T) {
-	exporter := NewMetricsExporter("http://localhost:5080", "user", "pass")
-
-	// Test counter increment
-	exporter.IncrementCounter("test_counter", 5)
-	exporter.mu.RLock()
-	value := exporter.counters["test_counter"]
-	exporter.mu.RUnlock()
-	assert.Equal(t, int64(5), value)
-
-	// Test multiple increments
-	exporter.IncrementCounter("test_counter", 3)
-	exporter.mu.RLock()
-	value = exporter.counters["test_counter"]
-	exporter.mu.RUnlock()
-	assert.Equal(t, int64(8), value)
-}
-
 // Benchmark tests
-func BenchmarkCircuitBreaker(b *testing.B) {
-	cb := NewCircuitBreaker(100, 1*time.Second)
-	
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		cb.Call(func() error { return nil })
-	}
-}
-
 func BenchmarkGetLogType(b *testing.B) {
 	d := &Discovery{}
 	fileName := "error/mysql-error-2024-01-01.log"
@@ -312,4 +211,14 @@ func BenchmarkGetLogType(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		d.getLogType(fileName)
 	}
-}
\ No newline at end of file
+}
+
+func TestPublishLogInfoRefusesUnknownTopics(t *testing.T) {
+	// The writer is nil; reaching it would panic
+	d := &Discovery{topics: commonkafka.LoadTopicsConfig()}
+
+	errs := d.writeLogInfos(context.Background(), []LogFileInfo{{InstanceID: "db-1", LogType: "audit"}})
+	require.Len(t, errs, 1)
+	require.ErrorIs(t, errs[0], commonkafka.ErrUnknownTopic)
+	assert.ErrorContains(t, errs[0], `"audit"`)
+}
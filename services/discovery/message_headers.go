@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/yourorg/aurora-log-system/common/message"
+)
+
+// LogFileInfo is the file notification sent to the processor. The wire
+// format is defined in the common message package.
+type LogFileInfo = message.LogFileInfo
+
+// discoveryScan identifies one discovery pass. All files found in a pass
+// share its trace.
+type discoveryScan struct {
+	ID      string
+	TraceID string
+}
+
+type scanContextKey struct{}
+
+func newDiscoveryScan() discoveryScan {
+	return discoveryScan{ID: randomHex(8), TraceID: message.NewTraceID()}
+}
+
+func withDiscoveryScan(ctx context.Context, scan discoveryScan) context.Context {
+	return context.WithValue(ctx, scanContextKey{}, scan)
+}
+
+func scanFromContext(ctx context.Context) (discoveryScan, bool) {
+	scan, ok := ctx.Value(scanContextKey{}).(discoveryScan)
+	return scan, ok
+}
+
+// newTraceParent starts a span for one file within the scan's trace
+func (s discoveryScan) newTraceParent() string {
+	return message.NewTraceParent(s.TraceID)
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// encodeLogInfo returns the body and Kafka headers of a notification
+// published by this shard now
+func encodeLogInfo(info LogFileInfo, shardID int, publishedAt time.Time) ([]byte, []kafka.Header, error) {
+	info.Provenance.ShardID = strconv.Itoa(shardID)
+	info.Provenance.PublishedAt = publishedAt
+	body, values, err := message.Encode(info)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	headers := make([]kafka.Header, 0, len(values))
+	for key, value := range values {
+		headers = append(headers, kafka.Header{Key: key, Value: []byte(value)})
+	}
+	sort.Slice(headers, func(i, j int) bool { return headers[i].Key < headers[j].Key })
+	return body, headers, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamoTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageHeaders(t *testing.T) {
+	scan := newDiscoveryScan()
+	ctx := withDiscoveryScan(context.Background(), scan)
+	fromCtx, ok := scanFromContext(ctx)
+	require.True(t, ok)
+	assert.Equal(t, scan, fromCtx)
+
+	traceParent := scan.newTraceParent()
+	assert.Regexp(t, regexp.MustCompile(`^00-`+scan.TraceID+`-[0-9a-f]{16}-01$`), traceParent)
+	assert.NotEqual(t, traceParent, scan.newTraceParent(), "every file gets its own span")
+
+	info := LogFileInfo{InstanceID: "db-1"}
+	info.Provenance.ScanID = scan.ID
+	info.Provenance.TraceParent = traceParent
+	body, headers, err := encodeLogInfo(info, 3, time.UnixMilli(1714564800123))
+	require.NoError(t, err)
+	assert.NotContains(t, string(body), scan.ID, "provenance travels in headers only")
+
+	keys := []string{}
+	values := map[string]string{}
+	for _, header := range headers {
+		keys = append(keys, header.Key)
+		values[header.Key] = string(header.Value)
+	}
+	assert.IsIncreasing(t, keys, "headers are written in a stable order")
+	assert.Equal(t, map[string]string{
+		"schema_version": "1",
+		"shard_id":       "3",
+		"published_at":   "1714564800123",
+		"scan_id":        scan.ID,
+		"traceparent":    traceParent,
+	}, values)
+}
+
+func TestWriteLogInfosSendsHeaders(t *testing.T) {
+	mockDynamo := new(mockDynamoClient)
+	writer := &fakeKafkaWriter{}
+	d := newOutboxTestDiscovery(mockDynamo, writer)
+	d.config.ShardID = 2
+
+	info := LogFileInfo{InstanceID: "db-1", LogType: "error"}
+	info.Provenance.ScanID = "scan-1"
+	errs := d.writeLogInfos(context.Background(), []LogFileInfo{info})
+	require.NoError(t, errs[0])
+	require.Len(t, writer.messages, 1)
+	assert.NotContains(t, string(writer.messages[0].Value), "scan-1", "provenance travels in headers only")
+
+	keys := []string{}
+	for _, header := range writer.messages[0].Headers {
+		keys = append(keys, header.Key)
+	}
+	assert.ElementsMatch(t, []string{"schema_version", "shard_id", "published_at", "scan_id"}, keys)
+}
+
+func TestOutboxKeepsProvenance(t *testing.T) {
+	mockDynamo := new(mockDynamoClient)
+	outbox := NewPublishOutbox(mockDynamo, "outbox", 0, time.Minute)
+	mockDynamo.On("PutItem", mock.Anything, mock.Anything).Return(&dynamodb.PutItemOutput{}, nil).Once()
+
+	info := LogFileInfo{InstanceID: "db-1", LogFileName: "error.log"}
+	info.Provenance.ScanID = "scan-1"
+	info.Provenance.TraceParent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	require.NoError(t, outbox.Add(context.Background(), info, assert.AnError))
+
+	item := mockDynamo.Calls[0].Arguments.Get(1).(*dynamodb.PutItemInput).Item
+	entry, err := decodeOutboxEntry(item)
+	require.NoError(t, err)
+	assert.Equal(t, info, entry.LogInfo)
+	assert.Equal(t, &dynamoTypes.AttributeValueMemberS{Value: "scan-1"}, item["scan_id"])
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamoTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Longest wait between two publish attempts of an outbox entry
+const maxOutboxBackoff = 15 * time.Minute
+
+// PublishOutbox holds file notifications that Kafka did not acknowledge.
+// Entries are keyed by shard, so each discovery replica drains its own.
+type PublishOutbox struct {
+	client  DynamoDBClientInterface
+	table   string
+	shardID int
+	backoff time.Duration // first retry delay, doubled per attempt
+	now     func() time.Time
+}
+
+type outboxEntry struct {
+	LogInfo  LogFileInfo
+	Attempts int
+}
+
+func NewPublishOutbox(client DynamoDBClientInterface, table string, shardID int, backoff time.Duration) *PublishOutbox {
+	return &PublishOutbox{
+		client:  client,
+		table:   table,
+		shardID: shardID,
+		backoff: backoff,
+		now:     time.Now,
+	}
+}
+
+func (o *PublishOutbox) key(info LogFileInfo) map[string]dynamoTypes.AttributeValue {
+	return map[string]dynamoTypes.AttributeValue{
+		"shard_id": &dynamoTypes.AttributeValueMemberN{Value: strconv.Itoa(o.shardID)},
+		"file_key": &dynamoTypes.AttributeValueMemberS{Value: info.InstanceID + "/" + info.LogFileName},
+	}
+}
+
+// retryAt returns when an entry that failed attempts times is due again
+func (o *PublishOutbox) retryAt(attempts int) time.Time {
+	delay := o.backoff
+	for i := 1; i < attempts && delay < maxOutboxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxOutboxBackoff {
+		delay = maxOutboxBackoff
+	}
+	return o.now().Add(delay)
+}
+
+// Add queues a notification for retry. A file that is already queued keeps
+// its entry.
+func (o *PublishOutbox) Add(ctx context.Context, info LogFileInfo, cause error) error {
+	message, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("failed to encode outbox entry: %w", err)
+	}
+
+	item := o.key(info)
+	item["message"] = &dynamoTypes.AttributeValueMemberS{Value: string(message)}
+	item["attempts"] = &dynamoTypes.AttributeValueMemberN{Value: "1"}
+	item["next_attempt_at"] = &dynamoTypes.AttributeValueMemberN{Value: strconv.FormatInt(o.retryAt(1).Unix(), 10)}
+	item["last_error"] = &dynamoTypes.AttributeValueMemberS{Value: cause.Error()}
+	item["created_at"] = &dynamoTypes.AttributeValueMemberN{Value: strconv.FormatInt(o.now().Unix(), 10)}
+	// Provenance is not part of the message body; keep it for the retry
+	if info.Provenance.ScanID != "" {
+		item["scan_id"] = &dynamoTypes.AttributeValueMemberS{Value: info.Provenance.ScanID}
+	}
+	if info.Provenance.TraceParent != "" {
+		item["traceparent"] = &dynamoTypes.AttributeValueMemberS{Value: info.Provenance.TraceParent}
+	}
+
+	condition := "attribute_not_exists(file_key)"
+	_, err = o.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           &o.table,
+		Item:                item,
+		ConditionExpression: &condition,
+	})
+	var exists *dynamoTypes.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &exists) {
+		return fmt.Errorf("failed to write outbox entry: %w", err)
+	}
+	return nil
+}
+
+// Due returns up to limit entries of this shard whose retry time has come
+func (o *PublishOutbox) Due(ctx context.Context, limit int) ([]outboxEntry, error) {
+	keyCondition := "shard_id = :shard"
+	filter := "next_attempt_at <= :now"
+	input := &dynamodb.QueryInput{
+		TableName:              &o.table,
+		KeyConditionExpression: &keyCondition,
+		FilterExpression:       &filter,
+		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
+			":shard": &dynamoTypes.AttributeValueMemberN{Value: strconv.Itoa(o.shardID)},
+			":now":   &dynamoTypes.AttributeValueMemberN{Value: strconv.FormatInt(o.now().Unix(), 10)},
+		},
+	}
+
+	var entries []outboxEntry
+	for len(entries) < limit {
+		result, err := o.client.Query(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query outbox: %w", err)
+		}
+		for _, item := range result.Items {
+			entry, err := decodeOutboxEntry(item)
+			if err != nil {
+				slog.Error("Skipping unreadable outbox entry", "error", err)
+				continue
+			}
+			entries = append(entries, entry)
+			if len(entries) == limit {
+				break
+			}
+		}
+		if len(result.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+	return entries, nil
+}
+
+func decodeOutboxEntry(item map[string]dynamoTypes.AttributeValue) (outboxEntry, error) {
+	var entry outboxEntry
+	message, ok := item["message"].(*dynamoTypes.AttributeValueMemberS)
+	if !ok {
+		return entry, errors.New("outbox entry has no message")
+	}
+	if err := json.Unmarshal([]byte(message.Value), &entry.LogInfo); err != nil {
+		return entry, fmt.Errorf("failed to decode outbox message: %w", err)
+	}
+	if attempts, ok := item["attempts"].(*dynamoTypes.AttributeValueMemberN); ok {
+		entry.Attempts, _ = strconv.Atoi(attempts.Value)
+	}
+	if scanID, ok := item["scan_id"].(*dynamoTypes.AttributeValueMemberS); ok {
+		entry.LogInfo.Provenance.ScanID = scanID.Value
+	}
+	if traceParent, ok := item["traceparent"].(*dynamoTypes.AttributeValueMemberS); ok {
+		entry.LogInfo.Provenance.TraceParent = traceParent.Value
+	}
+	return entry, nil
+}
+
+// Remove deletes the entry of a published file
+func (o *PublishOutbox) Remove(ctx context.Context, info LogFileInfo) error {
+	if _, err := o.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: &o.table,
+		Key:       o.key(info),
+	}); err != nil {
+		return fmt.Errorf("failed to delete outbox entry: %w", err)
+	}
+	return nil
+}
+
+// Reschedule records another failed attempt and backs the entry off
+func (o *PublishOutbox) Reschedule(ctx context.Context, entry outboxEntry, cause error) error {
+	attempts := entry.Attempts + 1
+	update := "SET attempts = :attempts, next_attempt_at = :next, last_error = :error"
+	if _, err := o.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        &o.table,
+		Key:              o.key(entry.LogInfo),
+		UpdateExpression: &update,
+		ExpressionAttributeValues: map[string]dynamoTypes.AttributeValue{
+			":attempts": &dynamoTypes.AttributeValueMemberN{Value: strconv.Itoa(attempts)},
+			":next":     &dynamoTypes.AttributeValueMemberN{Value: strconv.FormatInt(o.retryAt(attempts).Unix(), 10)},
+			":error":    &dynamoTypes.AttributeValueMemberS{Value: cause.Error()},
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to update outbox entry: %w", err)
+	}
+	return nil
+}
+
+// runOutbox retries queued notifications until discovery stops
+func (d *Discovery) runOutbox(ctx context.Context) {
+	ticker := time.NewTicker(d.config.OutboxInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.shutdownChan:
+			return
+		case <-ticker.C:
+			d.drainOutbox(ctx)
+		}
+	}
+}
+
+// drainOutbox publishes the due outbox entries once
+func (d *Discovery) drainOutbox(ctx context.Context) {
+	entries, err := d.outbox.Due(ctx, 100)
+	if err != nil {
+		slog.Error("Failed to read publish outbox", "error", err)
+		return
+	}
+	if len(entries) == 0 {
+		return
+	}
+
+	infos := make([]LogFileInfo, len(entries))
+	for i, entry := range entries {
+		infos[i] = entry.LogInfo
+	}
+
+	published := 0
+	for i, err := range d.writeLogInfos(ctx, infos) {
+		entry := entries[i]
+		if err != nil {
+			slog.Warn("Outbox publish failed", "error", err,
+				"instance_id", entry.LogInfo.InstanceID, "file", entry.LogInfo.LogFileName, "attempts", entry.Attempts+1)
+			if err := d.outbox.Reschedule(ctx, entry, err); err != nil {
+				slog.Error("Failed to reschedule outbox entry", "error", err)
+			}
+			continue
+		}
+
+		d.markPublished(ctx, entry.LogInfo)
+		if err := d.outbox.Remove(ctx, entry.LogInfo); err != nil {
+			// The entry is published again on the next pass
+			slog.Error("Failed to remove published outbox entry", "error", err)
+		}
+		published++
+	}
+
+	d.metricsExporter.IncrementCounter("discovery_outbox_published", int64(published))
+	slog.Info("Drained publish outbox", "published", published, "remaining", len(entries)-published)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamoTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	commonkafka "github.com/yourorg/aurora-log-system/common/kafka"
+	"github.com/yourorg/aurora-log-system/common/metrics"
+)
+
+type fakeKafkaWriter struct {
+	mu       sync.Mutex
+	messages []kafka.Message
+	err      error
+}
+
+func (w *fakeKafkaWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.messages = append(w.messages, msgs...)
+	return w.err
+}
+
+func (w *fakeKafkaWriter) Close() error { return nil }
+
+var outboxTestNow = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func newOutboxTestDiscovery(dynamo *mockDynamoClient, writer notificationWriter) *Discovery {
+	outbox := NewPublishOutbox(dynamo, "outbox", 2, 30*time.Second)
+	outbox.now = func() time.Time { return outboxTestNow }
+	return &Discovery{
+		config:          Config{TrackingTable: "tracking", PublishStaleAfter: 15 * time.Minute},
+		dynamoClient:    dynamo,
+		writer:          writer,
+		topics:          commonkafka.LoadTopicsConfig(),
+		outbox:          outbox,
+		metricsExporter: metrics.NewExporter("", "", ""),
+	}
+}
+
+func onTable(method, table string) func(input interface{}) bool {
+	return func(input interface{}) bool {
+		switch in := input.(type) {
+		case *dynamodb.PutItemInput:
+			return method == "PutItem" && *in.TableName == table
+		case *dynamodb.UpdateItemInput:
+			return method == "UpdateItem" && *in.TableName == table
+		case *dynamodb.DeleteItemInput:
+			return method == "DeleteItem" && *in.TableName == table
+		case *dynamodb.QueryInput:
+			return method == "Query" && *in.TableName == table
+		}
+		return false
+	}
+}
+
+func outboxItem(info LogFileInfo, attempts int) map[string]dynamoTypes.AttributeValue {
+	outbox := &PublishOutbox{shardID: 2}
+	item := outbox.key(info)
+	item["message"] = &dynamoTypes.AttributeValueMemberS{Value: `{"instance_id":"` + info.InstanceID + `","log_type":"` + info.LogType + `","log_file_name":"` + info.LogFileName + `"}`}
+	item["attempts"] = &dynamoTypes.AttributeValueMemberN{Value: strconv.Itoa(attempts)}
+	return item
+}
+
+func TestPublishLogInfosQueuesUnacknowledgedFiles(t *testing.T) {
+	mockDynamo := new(mockDynamoClient)
+	writer := &fakeKafkaWriter{err: kafka.WriteErrors{nil, kafka.LeaderNotAvailable}}
+	d := newOutboxTestDiscovery(mockDynamo, writer)
+
+	mockDynamo.On("UpdateItem", mock.Anything, mock.MatchedBy(onTable("UpdateItem", "tracking"))).
+		Return(&dynamodb.UpdateItemOutput{}, nil).Once()
+	mockDynamo.On("PutItem", mock.Anything, mock.MatchedBy(onTable("PutItem", "outbox"))).
+		Return(&dynamodb.PutItemOutput{}, nil).Once()
+
+	d.publishLogInfos(context.Background(), []LogFileInfo{
+		{InstanceID: "db-1", LogType: "error", LogFileName: "error/mysql-error.log"},
+		{InstanceID: "db-1", LogType: "slowquery", LogFileName: "slowquery/mysql-slowquery.log"},
+	})
+	mockDynamo.AssertExpectations(t)
+
+	require.Len(t, writer.messages, 2)
+	assert.Equal(t, "aurora-error-logs", writer.messages[0].Topic)
+	assert.Equal(t, "aurora-slowquery-logs", writer.messages[1].Topic)
+
+	// Only the acknowledged file moves on, and only from "discovered"
+	published := mockDynamo.Calls[0].Arguments.Get(1).(*dynamodb.UpdateItemInput)
+	assert.Equal(t, &dynamoTypes.AttributeValueMemberS{Value: "error/mysql-error.log"}, published.Key["log_file_name"])
+	assert.Equal(t, "#status = :discovered", *published.ConditionExpression)
+
+	queued := mockDynamo.Calls[1].Arguments.Get(1).(*dynamodb.PutItemInput)
+	assert.Equal(t, &dynamoTypes.AttributeValueMemberN{Value: "2"}, queued.Item["shard_id"])
+	assert.Equal(t, &dynamoTypes.AttributeValueMemberS{Value: "db-1/slowquery/mysql-slowquery.log"}, queued.Item["file_key"])
+	assert.Equal(t, &dynamoTypes.AttributeValueMemberN{Value: strconv.FormatInt(outboxTestNow.Add(30*time.Second).Unix(), 10)}, queued.Item["next_attempt_at"])
+	assert.Equal(t, int64(1), d.metricsExporter.Counter("discovery_publish_failures"))
+}
+
+func TestDrainOutboxRetriesDueEntries(t *testing.T) {
+	mockDynamo := new(mockDynamoClient)
+	writer := &fakeKafkaWriter{err: kafka.WriteErrors{nil, kafka.RequestTimedOut}}
+	d := newOutboxTestDiscovery(mockDynamo, writer)
+
+	first := LogFileInfo{InstanceID: "db-1", LogType: "error", LogFileName: "error/mysql-error.log"}
+	second := LogFileInfo{InstanceID: "db-2", LogType: "error", LogFileName: "error/mysql-error.log"}
+	mockDynamo.On("Query", mock.Anything, mock.MatchedBy(onTable("Query", "outbox"))).
+		Return(&dynamodb.QueryOutput{Items: []map[string]dynamoTypes.AttributeValue{outboxItem(first, 1), outboxItem(second, 2)}}, nil).Once()
+	mockDynamo.On("UpdateItem", mock.Anything, mock.MatchedBy(onTable("UpdateItem", "tracking"))).
+		Return(&dynamodb.UpdateItemOutput{}, &dynamoTypes.ConditionalCheckFailedException{}).Once()
+	mockDynamo.On("DeleteItem", mock.Anything, mock.MatchedBy(onTable("DeleteItem", "outbox"))).
+		Return(&dynamodb.DeleteItemOutput{}, nil).Once()
+	mockDynamo.On("UpdateItem", mock.Anything, mock.MatchedBy(onTable("UpdateItem", "outbox"))).
+		Return(&dynamodb.UpdateItemOutput{}, nil).Once()
+
+	d.drainOutbox(context.Background())
+	mockDynamo.AssertExpectations(t)
+
+	query := mockDynamo.Calls[0].Arguments.Get(1).(*dynamodb.QueryInput)
+	assert.Equal(t, &dynamoTypes.AttributeValueMemberN{Value: "2"}, query.ExpressionAttributeValues[":shard"])
+
+	// The failed entry backs off: 30s doubled for each earlier attempt
+	var rescheduled *dynamodb.UpdateItemInput
+	for _, call := range mockDynamo.Calls {
+		if input, ok := call.Arguments.Get(1).(*dynamodb.UpdateItemInput); ok && *input.TableName == "outbox" {
+			rescheduled = input
+		}
+	}
+	require.NotNil(t, rescheduled)
+	assert.Equal(t, &dynamoTypes.AttributeValueMemberS{Value: "db-2/error/mysql-error.log"}, rescheduled.Key["file_key"])
+	assert.Equal(t, &dynamoTypes.AttributeValueMemberN{Value: "3"}, rescheduled.ExpressionAttributeValues[":attempts"])
+	assert.Equal(t, &dynamoTypes.AttributeValueMemberN{Value: strconv.FormatInt(outboxTestNow.Add(2*time.Minute).Unix(), 10)}, rescheduled.ExpressionAttributeValues[":next"])
+	assert.Equal(t, int64(1), d.metricsExporter.Counter("discovery_outbox_published"))
+}
+
+func TestPublishOutboxAdd(t *testing.T) {
+	mockDynamo := new(mockDynamoClient)
+	outbox := NewPublishOutbox(mockDynamo, "outbox", 0, 30*time.Second)
+
+	// An already queued file keeps its entry
+	mockDynamo.On("PutItem", mock.Anything, mock.Anything).
+		Return(&dynamodb.PutItemOutput{}, &dynamoTypes.ConditionalCheckFailedException{}).Once()
+	require.NoError(t, outbox.Add(context.Background(), LogFileInfo{InstanceID: "db-1"}, errors.New("broker down")))
+
+	mockDynamo.On("PutItem", mock.Anything, mock.Anything).
+		Return(&dynamodb.PutItemOutput{}, errors.New("throttled")).Once()
+	assert.Error(t, outbox.Add(context.Background(), LogFileInfo{InstanceID: "db-1"}, errors.New("broker down")))
+
+	outbox.now = func() time.Time { return outboxTestNow }
+	assert.Equal(t, outboxTestNow.Add(30*time.Second), outbox.retryAt(1))
+	assert.Equal(t, outboxTestNow.Add(4*time.Minute), outbox.retryAt(4))
+	assert.Equal(t, outboxTestNow.Add(maxOutboxBackoff), outbox.retryAt(20))
+}
+
+func TestShouldProcessLogRequeuesStaleDiscoveries(t *testing.T) {
+	mockDynamo := new(mockDynamoClient)
+	d := newOutboxTestDiscovery(mockDynamo, &fakeKafkaWriter{})
+	logInfo := LogFileInfo{InstanceID: "db-1", LogType: "error", LogFileName: "error/mysql-error.log"}
+
+	tracked := func(status string, discoveredAt time.Time) *dynamodb.GetItemOutput {
+		return &dynamodb.GetItemOutput{Item: map[string]dynamoTypes.AttributeValue{
+			"status":        &dynamoTypes.AttributeValueMemberS{Value: status},
+			"discovered_at": &dynamoTypes.AttributeValueMemberN{Value: strconv.FormatInt(discoveredAt.Unix(), 10)},
+		}}
+	}
+
+	mockDynamo.On("GetItem", mock.Anything, mock.Anything).Return(tracked("discovered", time.Now().Add(-time.Minute)), nil).Once()
+	assert.False(t, d.shouldProcessLog(context.Background(), logInfo))
+
+	mockDynamo.On("GetItem", mock.Anything, mock.Anything).Return(tracked("published", time.Now().Add(-time.Hour)), nil).Once()
+	assert.False(t, d.shouldProcessLog(context.Background(), logInfo))
+
+	mockDynamo.On("GetItem", mock.Anything, mock.Anything).Return(tracked("discovered", time.Now().Add(-time.Hour)), nil).Once()
+	mockDynamo.On("PutItem", mock.Anything, mock.MatchedBy(onTable("PutItem", "outbox"))).Return(&dynamodb.PutItemOutput{}, nil).Once()
+	assert.False(t, d.shouldProcessLog(context.Background(), logInfo))
+	mockDynamo.AssertExpectations(t)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+	"github.com/yourorg/aurora-log-system/common/env"
+	"github.com/yourorg/aurora-log-system/common/message"
+)
+
+// Transports that carry file notifications to the processor
+// (NOTIFICATION_TRANSPORT)
+const (
+	transportKafka  = "kafka"
+	transportValkey = "valkey"
+)
+
+// ValkeyStreamsConfig configures notifications over Valkey Streams. Streams
+// are named after the notification topics, so switching transports keeps
+// KAFKA_ERROR_TOPIC and KAFKA_SLOWQUERY_TOPIC.
+type ValkeyStreamsConfig struct {
+	URL string
+	// Approximate cap on entries per stream; 0 keeps every entry. Entries
+	// trimmed before the processor acknowledges them are lost.
+	MaxLen int64
+}
+
+func loadValkeyStreamsConfig() ValkeyStreamsConfig {
+	return ValkeyStreamsConfig{
+		URL:    env.Get("VALKEY_STREAMS_URL", os.Getenv("VALKEY_URL")),
+		MaxLen: int64(env.Int("VALKEY_STREAM_MAXLEN", 100000)),
+	}
+}
+
+// newValkeyClient connects to a redis:// or rediss:// URL; a bare host:port
+// is accepted too
+func newValkeyClient(url string) (*redis.Client, error) {
+	if url == "" {
+		return nil, errors.New("VALKEY_STREAMS_URL or VALKEY_URL is required")
+	}
+	if !strings.Contains(url, "://") {
+		url = "redis://" + url
+	}
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Valkey URL: %w", err)
+	}
+	return redis.NewClient(opts), nil
+}
+
+// valkeyStreamClient is the subset of redis.Client used for publishing
+type valkeyStreamClient interface {
+	XAdd(ctx context.Context, a *redis.XAddArgs) *redis.StringCmd
+	Close() error
+}
+
+// ValkeyStreamWriter publishes notifications with XADD to the stream named
+// by each message's topic. It takes kafka.Message so the publish path is the
+// same for both transports.
+type ValkeyStreamWriter struct {
+	client valkeyStreamClient
+	maxLen int64
+}
+
+func NewValkeyStreamWriter(client valkeyStreamClient, maxLen int64) *ValkeyStreamWriter {
+	return &ValkeyStreamWriter{client: client, maxLen: maxLen}
+}
+
+// WriteMessages adds each message to its stream. Like kafka.Writer, it
+// reports partial failures as kafka.WriteErrors, one entry per message.
+func (w *ValkeyStreamWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	errs := make(kafka.WriteErrors, len(msgs))
+	failed := 0
+	for i, msg := range msgs {
+		headers := make(map[string]string, len(msg.Headers))
+		for _, header := range msg.Headers {
+			headers[header.Key] = string(header.Value)
+		}
+		err := w.client.XAdd(ctx, &redis.XAddArgs{
+			Stream: msg.Topic,
+			MaxLen: w.maxLen,
+			Approx: true,
+			Values: message.StreamValues(string(msg.Key), msg.Value, headers),
+		}).Err()
+		if err != nil {
+			errs[i] = fmt.Errorf("failed to add to stream %s: %w", msg.Topic, err)
+			failed++
+		}
+	}
+	if failed == 0 {
+		return nil
+	}
+	return errs
+}
+
+func (w *ValkeyStreamWriter) Close() error {
+	return w.client.Close()
+}
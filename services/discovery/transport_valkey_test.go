@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeStreamClient struct {
+	added   []*redis.XAddArgs
+	failFor string // stream whose XADDs fail
+}
+
+func (c *fakeStreamClient) XAdd(ctx context.Context, a *redis.XAddArgs) *redis.StringCmd {
+	cmd := redis.NewStringCmd(ctx)
+	if a.Stream == c.failFor {
+		cmd.SetErr(assert.AnError)
+		return cmd
+	}
+	c.added = append(c.added, a)
+	cmd.SetVal("1754136000000-0")
+	return cmd
+}
+
+func (c *fakeStreamClient) Close() error { return nil }
+
+func TestValkeyStreamWriter(t *testing.T) {
+	client := &fakeStreamClient{failFor: "aurora-slowquery-logs"}
+	d := newOutboxTestDiscovery(new(mockDynamoClient), NewValkeyStreamWriter(client, 5000))
+
+	info := LogFileInfo{InstanceID: "db-1", LogType: "error"}
+	info.Provenance.ScanID = "scan-1"
+	errs := d.writeLogInfos(context.Background(), []LogFileInfo{
+		info,
+		{InstanceID: "db-2", LogType: "slowquery"},
+	})
+	require.NoError(t, errs[0])
+	assert.ErrorIs(t, errs[1], assert.AnError, "only the failed message is reported")
+
+	require.Len(t, client.added, 1)
+	added := client.added[0]
+	assert.Equal(t, "aurora-error-logs", added.Stream)
+	assert.Equal(t, int64(5000), added.MaxLen)
+	assert.True(t, added.Approx)
+
+	values := added.Values.([]any)
+	assert.Equal(t, []any{"key", "db-1"}, values[:2])
+	assert.Contains(t, values, "h:scan_id")
+	assert.Contains(t, values, "scan-1")
+}
+
+func TestNewValkeyClient(t *testing.T) {
+	_, err := newValkeyClient("")
+	assert.Error(t, err)
+
+	client, err := newValkeyClient("valkey.local:6379")
+	require.NoError(t, err)
+	assert.Equal(t, "valkey.local:6379", client.Options().Addr)
+
+	client, err = newValkeyClient("rediss://:secret@valkey.local:6380/2")
+	require.NoError(t, err)
+	assert.Equal(t, "secret", client.Options().Password)
+	assert.Equal(t, 2, client.Options().DB)
+	assert.NotNil(t, client.Options().TLSConfig)
+}
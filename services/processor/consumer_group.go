@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// errPartitionRevoked is returned when committing a partition this consumer
+// no longer owns
+var errPartitionRevoked = errors.New("partition revoked")
+
+// messageSource is where the processor reads file notifications from.
+// kafka.Reader satisfies it; PartitionConsumer adds rebalance awareness.
+type messageSource interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// leasedSource is implemented by sources that tie each message to the
+// ownership of its partition. The returned lease is cancelled when the
+// partition is revoked.
+type leasedSource interface {
+	FetchLeasedMessage(ctx context.Context) (kafka.Message, context.Context, error)
+}
+
+// offsetCommitTarget commits offsets within one group generation
+type offsetCommitTarget interface {
+	CommitOffsets(offsets map[string]map[int]int64) error
+}
+
+type partitionLease struct {
+	ctx    context.Context
+	commit offsetCommitTarget
+}
+
+type leasedMessage struct {
+	msg   kafka.Message
+	lease context.Context
+}
+
+// PartitionConsumer consumes the notification topics as a member of a Kafka
+// consumer group, reading each assigned partition directly. Every message
+// carries the lease of the generation it was fetched in. When a rebalance
+// ends the generation, OnRevoked runs for each partition before the consumer
+// rejoins, so in-flight work can checkpoint and commit while this member
+// still owns the partition and before its next owner starts.
+type PartitionConsumer struct {
+	group        *kafka.ConsumerGroup
+	readerConfig kafka.ReaderConfig
+	messages     chan leasedMessage
+
+	// OnAssigned and OnRevoked are called from the partition's goroutine
+	OnAssigned func(topic string, partition int)
+	OnRevoked  func(topic string, partition int)
+
+	mu    sync.Mutex
+	owned map[topicPartition]*partitionLease
+}
+
+// NewPartitionConsumer joins the group described by groupConfig. Partition
+// readers are built from readerConfig with the topic and partition filled in.
+func NewPartitionConsumer(groupConfig kafka.ConsumerGroupConfig, readerConfig kafka.ReaderConfig) (*PartitionConsumer, error) {
+	group, err := kafka.NewConsumerGroup(groupConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer group: %w", err)
+	}
+	readerConfig.GroupID = ""
+	readerConfig.GroupTopics = nil
+	return &PartitionConsumer{
+		group:        group,
+		readerConfig: readerConfig,
+		messages:     make(chan leasedMessage),
+		owned:        make(map[topicPartition]*partitionLease),
+	}, nil
+}
+
+// Run follows the group's generations until ctx is cancelled or the
+// consumer is closed
+func (c *PartitionConsumer) Run(ctx context.Context) {
+	for {
+		gen, err := c.group.Next(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, kafka.ErrGroupClosed) {
+				return
+			}
+			slog.Warn("Consumer group error", "error", err)
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		assigned := 0
+		for topic, partitions := range gen.Assignments {
+			for _, assignment := range partitions {
+				topic, assignment := topic, assignment
+				gen.Start(func(genCtx context.Context) {
+					c.consumePartition(genCtx, gen, topic, assignment)
+				})
+				assigned++
+			}
+		}
+		slog.Info("Joined consumer group generation", "generation", gen.ID, "member", gen.MemberID, "partitions", assigned)
+	}
+}
+
+func (c *PartitionConsumer) consumePartition(genCtx context.Context, gen offsetCommitTarget, topic string, assignment kafka.PartitionAssignment) {
+	tp := topicPartition{topic, assignment.ID}
+	lease := &partitionLease{ctx: genCtx, commit: gen}
+	c.mu.Lock()
+	c.owned[tp] = lease
+	c.mu.Unlock()
+
+	slog.Info("Partition assigned", "topic", topic, "partition", assignment.ID, "offset", assignment.Offset)
+	if c.OnAssigned != nil {
+		c.OnAssigned(topic, assignment.ID)
+	}
+
+	readerConfig := c.readerConfig
+	readerConfig.Topic = topic
+	readerConfig.Partition = assignment.ID
+	reader := kafka.NewReader(readerConfig)
+	if err := reader.SetOffset(assignment.Offset); err != nil {
+		slog.Error("Failed to set partition offset", "topic", topic, "partition", assignment.ID, "error", err)
+	}
+
+	for genCtx.Err() == nil {
+		msg, err := reader.FetchMessage(genCtx)
+		if err != nil {
+			if genCtx.Err() == nil {
+				slog.Error("Kafka fetch failed", "topic", topic, "partition", assignment.ID, "error", err)
+				select {
+				case <-time.After(time.Second):
+				case <-genCtx.Done():
+				}
+			}
+			continue
+		}
+		select {
+		case c.messages <- leasedMessage{msg: msg, lease: genCtx}:
+		case <-genCtx.Done():
+		}
+	}
+	if err := reader.Close(); err != nil {
+		slog.Warn("Failed to close partition reader", "topic", topic, "partition", assignment.ID, "error", err)
+	}
+
+	// The generation has ended but this member has not rejoined yet, so
+	// commits for the partition are still accepted
+	slog.Info("Partition revoked", "topic", topic, "partition", assignment.ID)
+	if c.OnRevoked != nil {
+		c.OnRevoked(topic, assignment.ID)
+	}
+
+	c.mu.Lock()
+	if c.owned[tp] == lease {
+		delete(c.owned, tp)
+	}
+	c.mu.Unlock()
+}
+
+// FetchLeasedMessage returns the next message of an owned partition and the
+// lease it was fetched under. Messages from revoked partitions are dropped;
+// the partition's next owner reads them again.
+func (c *PartitionConsumer) FetchLeasedMessage(ctx context.Context) (kafka.Message, context.Context, error) {
+	for {
+		select {
+		case leased := <-c.messages:
+			if leased.lease.Err() != nil {
+				continue
+			}
+			return leased.msg, leased.lease, nil
+		case <-ctx.Done():
+			return kafka.Message{}, nil, ctx.Err()
+		}
+	}
+}
+
+func (c *PartitionConsumer) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	msg, _, err := c.FetchLeasedMessage(ctx)
+	return msg, err
+}
+
+// CommitMessages commits each message's partition past the message, within
+// the generation that owns the partition
+func (c *PartitionConsumer) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	byLease := make(map[*partitionLease]map[string]map[int]int64)
+
+	c.mu.Lock()
+	for _, msg := range msgs {
+		lease, ok := c.owned[topicPartition{msg.Topic, msg.Partition}]
+		if !ok {
+			c.mu.Unlock()
+			return fmt.Errorf("%w: %s/%d", errPartitionRevoked, msg.Topic, msg.Partition)
+		}
+		offsets := byLease[lease]
+		if offsets == nil {
+			offsets = make(map[string]map[int]int64)
+			byLease[lease] = offsets
+		}
+		if offsets[msg.Topic] == nil {
+			offsets[msg.Topic] = make(map[int]int64)
+		}
+		if next := msg.Offset + 1; next > offsets[msg.Topic][msg.Partition] {
+			offsets[msg.Topic][msg.Partition] = next
+		}
+	}
+	c.mu.Unlock()
+
+	for lease, offsets := range byLease {
+		if err := lease.commit.CommitOffsets(offsets); err != nil {
+			return fmt.Errorf("failed to commit offsets: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close leaves the consumer group
+func (c *PartitionConsumer) Close() error {
+	return c.group.Close()
+}
+
+// partitionWork counts the files being processed per partition, so a
+// revoke can wait for them to hand off
+type partitionWork struct {
+	mu      sync.Mutex
+	active  map[topicPartition]int
+	changed chan struct{}
+}
+
+func newPartitionWork() *partitionWork {
+	return &partitionWork{active: make(map[topicPartition]int), changed: make(chan struct{})}
+}
+
+func (w *partitionWork) begin(topic string, partition int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.active[topicPartition{topic, partition}]++
+}
+
+func (w *partitionWork) end(topic string, partition int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	tp := topicPartition{topic, partition}
+	if w.active[tp]--; w.active[tp] <= 0 {
+		delete(w.active, tp)
+	}
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+// wait blocks until no file of the partition is being processed
+func (w *partitionWork) wait(ctx context.Context, topic string, partition int) error {
+	for {
+		w.mu.Lock()
+		active := w.active[topicPartition{topic, partition}]
+		changed := w.changed
+		w.mu.Unlock()
+		if active == 0 {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
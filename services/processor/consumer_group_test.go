@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamoTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/yourorg/aurora-log-system/common/metrics"
+)
+
+type fakeGeneration struct {
+	mu      sync.Mutex
+	commits []map[string]map[int]int64
+}
+
+func (g *fakeGeneration) CommitOffsets(offsets map[string]map[int]int64) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.commits = append(g.commits, offsets)
+	return nil
+}
+
+func newTestPartitionConsumer() *PartitionConsumer {
+	return &PartitionConsumer{
+		messages: make(chan leasedMessage, 10),
+		owned:    make(map[topicPartition]*partitionLease),
+	}
+}
+
+func TestPartitionConsumerDropsRevokedMessages(t *testing.T) {
+	consumer := newTestPartitionConsumer()
+
+	revoked, revoke := context.WithCancel(context.Background())
+	revoke()
+	consumer.messages <- leasedMessage{msg: offsetMessage(0, 1), lease: revoked}
+	consumer.messages <- leasedMessage{msg: offsetMessage(1, 5), lease: context.Background()}
+
+	msg, lease, err := consumer.FetchLeasedMessage(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, msg.Partition)
+	assert.NoError(t, lease.Err())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = consumer.FetchMessage(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestPartitionConsumerCommitsThroughOwningGeneration(t *testing.T) {
+	consumer := newTestPartitionConsumer()
+	gen := &fakeGeneration{}
+	consumer.owned[topicPartition{"aurora-error-logs", 0}] = &partitionLease{ctx: context.Background(), commit: gen}
+
+	require.NoError(t, consumer.CommitMessages(context.Background(), offsetMessage(0, 7), offsetMessage(0, 9)))
+	assert.Equal(t, []map[string]map[int]int64{{"aurora-error-logs": {0: 10}}}, gen.commits)
+
+	err := consumer.CommitMessages(context.Background(), offsetMessage(3, 1))
+	assert.ErrorIs(t, err, errPartitionRevoked)
+}
+
+func TestPartitionWorkWait(t *testing.T) {
+	work := newPartitionWork()
+	require.NoError(t, work.wait(context.Background(), "aurora-error-logs", 0))
+
+	work.begin("aurora-error-logs", 0)
+	work.begin("aurora-error-logs", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, work.wait(ctx, "aurora-error-logs", 0), context.DeadlineExceeded)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		work.end("aurora-error-logs", 0)
+	}()
+	assert.NoError(t, work.wait(context.Background(), "aurora-error-logs", 0))
+}
+
+func TestProcessItemSkipsRevokedPartition(t *testing.T) {
+	committer := &fakeCommitter{}
+	bp := &BatchProcessor{
+		config:  Config{MaxRetries: 3},
+		offsets: NewOffsetTracker(committer, 10),
+		work:    newPartitionWork(),
+	}
+
+	msg := offsetMessage(0, 1)
+	require.True(t, bp.offsets.TryTrack(msg))
+	lease, revoke := context.WithCancel(context.Background())
+	revoke()
+
+	// Neither processed, sent to the DLQ nor committed: the dynamo client
+	// and circuit breaker are nil and would panic if used
+	bp.processItem(context.Background(), 0, BatchItem{Message: msg, LogMsg: LogMessage{LogFileName: "error.log"}, lease: lease})
+	assert.Empty(t, committer.offsets())
+}
+
+func TestPartitionRevokedWaitsForHandoff(t *testing.T) {
+	committer := &fakeCommitter{}
+	bp := &BatchProcessor{
+		config:  Config{HandoffTimeout: time.Second},
+		offsets: NewOffsetTracker(committer, 10),
+		work:    newPartitionWork(),
+	}
+
+	require.True(t, bp.offsets.TryTrack(offsetMessage(0, 1)))
+	require.True(t, bp.offsets.TryTrack(offsetMessage(0, 2)))
+	bp.work.begin("aurora-error-logs", 0)
+
+	revoked := make(chan struct{})
+	go func() {
+		bp.partitionRevoked("aurora-error-logs", 0)
+		close(revoked)
+	}()
+
+	// A file that finishes during the handoff is still committed
+	require.NoError(t, bp.offsets.Complete(context.Background(), offsetMessage(0, 1)))
+	select {
+	case <-revoked:
+		t.Fatal("revoke finished while a file was still running")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	bp.work.end("aurora-error-logs", 0)
+	select {
+	case <-revoked:
+	case <-time.After(time.Second):
+		t.Fatal("revoke did not finish after the file stopped")
+	}
+	assert.Equal(t, []int64{1}, committer.offsets())
+	assert.Zero(t, bp.offsets.InFlight("aurora-error-logs", 0))
+}
+
+func TestHandOffDeliversAndCheckpoints(t *testing.T) {
+	sink := &recordingSink{name: "recording"}
+	fanOut := NewSinkFanOut(metrics.NewExporter("", "", ""))
+	require.NoError(t, fanOut.Add(sink, SinkOptions{BatchSize: 100, FlushInterval: time.Hour}))
+	fanOut.Start()
+	defer fanOut.Close(context.Background())
+
+	mockDynamo := new(mockDynamoClient)
+	mockDynamo.On("PutItem", mock.Anything, mock.MatchedBy(func(input *dynamodb.PutItemInput) bool {
+		return *input.TableName == "checkpoints"
+	})).Return(&dynamodb.PutItemOutput{}, nil).Once()
+
+	bp := &BatchProcessor{
+		config:       Config{CheckpointTable: "checkpoints", HandoffTimeout: 5 * time.Second},
+		dynamoClient: mockDynamo,
+		sinks:        fanOut,
+	}
+
+	// The item's context is already cancelled, as after a revoke
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	logMsg := LogMessage{InstanceID: "db-1", LogFileName: "error/mysql-error.log", LogType: "error"}
+	err := bp.handOff(ctx, logMsg, makeEntries("ERROR", "INFO"), NewSinkDelivery(), downloadMarker{marker: "marker-42", lines: 2500})
+
+	assert.ErrorIs(t, err, errHandedOff)
+	assert.Equal(t, 2, sink.entries())
+	mockDynamo.AssertExpectations(t)
+	item := mockDynamo.Calls[0].Arguments.Get(1).(*dynamodb.PutItemInput).Item
+	assert.Equal(t, &dynamoTypes.AttributeValueMemberS{Value: "marker-42"}, item["marker"])
+	assert.Equal(t, &dynamoTypes.AttributeValueMemberN{Value: "2500"}, item["line_count"])
+}
+
+// recordingForwarder is a LogForwarder that acknowledges every batch
+type recordingForwarder struct {
+	entries []ForwardEntry
+}
+
+func (f *recordingForwarder) ForwardBatch(ctx context.Context, tag string, entries []ForwardEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.entries = append(f.entries, entries...)
+	return nil
+}
+
+func (f *recordingForwarder) Close() error { return nil }
+
+func TestHandOffForwardingForwardsAndCheckpoints(t *testing.T) {
+	mockDynamo := new(mockDynamoClient)
+	mockDynamo.On("PutItem", mock.Anything, mock.MatchedBy(func(input *dynamodb.PutItemInput) bool {
+		return *input.TableName == "checkpoints"
+	})).Return(&dynamodb.PutItemOutput{}, nil).Once()
+
+	forwarder := &recordingForwarder{}
+	bp := &BatchProcessor{
+		config:             Config{CheckpointTable: "checkpoints", HandoffTimeout: 5 * time.Second},
+		dynamoClient:       mockDynamo,
+		fluentBitForwarder: forwarder,
+	}
+
+	// The item's context is already cancelled, as after a revoke
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	logMsg := LogMessage{InstanceID: "db-1", LogFileName: "error/mysql-error.log", LogType: "error"}
+	batch := []ForwardEntry{
+		{Time: time.Now(), Record: map[string]interface{}{"message": "a"}},
+		{Time: time.Now(), Record: map[string]interface{}{"message": "b"}},
+	}
+	err := bp.handOffForwarding(ctx, logMsg, "aurora.error", batch, downloadMarker{marker: "marker-42", lines: 2500})
+
+	assert.ErrorIs(t, err, errHandedOff)
+	assert.Len(t, forwarder.entries, 2)
+	mockDynamo.AssertExpectations(t)
+	item := mockDynamo.Calls[0].Arguments.Get(1).(*dynamodb.PutItemInput).Item
+	assert.Equal(t, &dynamoTypes.AttributeValueMemberS{Value: "marker-42"}, item["marker"])
+	assert.Equal(t, &dynamoTypes.AttributeValueMemberN{Value: "2500"}, item["line_count"])
+}
+
+var _ messageSource = (*kafka.Reader)(nil)
+var _ messageSource = (*PartitionConsumer)(nil)
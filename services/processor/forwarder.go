@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	commontls "github.com/yourorg/aurora-log-system/common/tls"
+)
+
+// EventTime is the Fluent Forward EventTime extension (ext type 0). It carries
+// seconds and nanoseconds as two big-endian uint32 values.
+type EventTime time.Time
+
+func init() {
+	msgpack.RegisterExt(0, (*EventTime)(nil))
+}
+
+func (t *EventTime) MarshalMsgpack() ([]byte, error) {
+	tm := time.Time(*t)
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint32(b, uint32(tm.Unix()))
+	binary.BigEndian.PutUint32(b[4:], uint32(tm.Nanosecond()))
+	return b, nil
+}
+
+func (t *EventTime) UnmarshalMsgpack(b []byte) error {
+	if len(b) != 8 {
+		return fmt.Errorf("invalid EventTime length: %d", len(b))
+	}
+	sec := binary.BigEndian.Uint32(b)
+	nsec := binary.BigEndian.Uint32(b[4:])
+	*t = EventTime(time.Unix(int64(sec), int64(nsec)))
+	return nil
+}
+
+// ForwardEntry is a single record sent to Fluent Bit
+type ForwardEntry struct {
+	Time   time.Time
+	Record map[string]interface{}
+}
+
+// FluentForwardOptions configures delivery, transport security and
+// authentication of a FluentBitForwarder
+type FluentForwardOptions struct {
+	// AckTimeout of zero disables acknowledgements (fire-and-forget)
+	AckTimeout time.Duration
+	MaxRetries int
+	// TLS enables an encrypted transport when set
+	TLS *tls.Config
+	// SharedKey enables the HELO/PING/PONG handshake when set
+	SharedKey *commontls.FileSecret
+	// Username and Password are optional user authentication for the handshake
+	Username     *commontls.FileSecret
+	Password     *commontls.FileSecret
+	SelfHostname string
+}
+
+// FluentBitForwarder sends records to Fluent Bit using the Fluent Forward
+// protocol in PackedForward mode. When an ack timeout is set every chunk
+// carries a `chunk` option and is only considered delivered once Fluent Bit
+// answers with the matching `ack`; unacknowledged chunks are retransmitted.
+type FluentBitForwarder struct {
+	address   string
+	opts      FluentForwardOptions
+	conn      net.Conn
+	decoder   *msgpack.Decoder
+	mu        sync.Mutex
+	connected bool
+}
+
+// NewFluentBitForwarder creates a new Fluent Bit forwarder
+func NewFluentBitForwarder(host, port string, opts FluentForwardOptions) *FluentBitForwarder {
+	if opts.SelfHostname == "" {
+		opts.SelfHostname, _ = os.Hostname()
+	}
+	return &FluentBitForwarder{
+		address: net.JoinHostPort(host, port),
+		opts:    opts,
+	}
+}
+
+// Connect establishes connection to Fluent Bit
+func (f *FluentBitForwarder) Connect() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.connectLocked()
+}
+
+func (f *FluentBitForwarder) connectLocked() error {
+	if f.connected && f.conn != nil {
+		return nil
+	}
+
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	var conn net.Conn
+	var err error
+	if f.opts.TLS != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", f.address, f.opts.TLS)
+	} else {
+		conn, err = dialer.Dial("tcp", f.address)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to Fluent Bit at %s: %w", f.address, err)
+	}
+
+	f.conn = conn
+	f.decoder = msgpack.NewDecoder(conn)
+
+	if f.opts.SharedKey != nil {
+		if err := f.handshakeLocked(); err != nil {
+			f.disconnectLocked()
+			return fmt.Errorf("handshake with Fluent Bit at %s failed: %w", f.address, err)
+		}
+	}
+
+	f.connected = true
+	slog.Info("Connected to Fluent Bit", "address", f.address, "tls", f.opts.TLS != nil, "auth", f.opts.SharedKey != nil)
+	return nil
+}
+
+// handshakeLocked performs the forward protocol shared-key authentication:
+// the server sends HELO, we answer with PING and verify the server's PONG.
+// Credentials are read per connection so rotated secrets take effect on reconnect.
+func (f *FluentBitForwarder) handshakeLocked() error {
+	sharedKey, err := f.opts.SharedKey.Value()
+	if err != nil {
+		return err
+	}
+	username, err := f.opts.Username.Value()
+	if err != nil {
+		return err
+	}
+	password, err := f.opts.Password.Value()
+	if err != nil {
+		return err
+	}
+
+	f.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	defer f.conn.SetDeadline(time.Time{})
+
+	// HELO: ["HELO", {"nonce": bin, "auth": bin, "keepalive": bool}]
+	var helo []interface{}
+	if err := f.decoder.Decode(&helo); err != nil {
+		return fmt.Errorf("failed to read HELO: %w", err)
+	}
+	if len(helo) != 2 || helo[0] != "HELO" {
+		return fmt.Errorf("unexpected HELO message: %v", helo)
+	}
+	heloOpts, _ := helo[1].(map[string]interface{})
+	nonce := msgpackBytes(heloOpts["nonce"])
+	authSalt := msgpackBytes(heloOpts["auth"])
+
+	sharedKeySalt := make([]byte, 16)
+	if _, err := rand.Read(sharedKeySalt); err != nil {
+		return fmt.Errorf("failed to generate shared key salt: %w", err)
+	}
+
+	passwordDigest := ""
+	if len(authSalt) > 0 {
+		passwordDigest = sha512Hex(authSalt, []byte(username), []byte(password))
+	}
+
+	// PING: ["PING", hostname, salt, sha512_hex(salt+hostname+nonce+key), username, sha512_hex(auth_salt+username+password)]
+	ping, err := msgpack.Marshal([]interface{}{
+		"PING",
+		f.opts.SelfHostname,
+		sharedKeySalt,
+		sha512Hex(sharedKeySalt, []byte(f.opts.SelfHostname), nonce, []byte(sharedKey)),
+		username,
+		passwordDigest,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode PING: %w", err)
+	}
+	if _, err := f.conn.Write(ping); err != nil {
+		return fmt.Errorf("failed to send PING: %w", err)
+	}
+
+	// PONG: ["PONG", auth_result, reason, server_hostname, sha512_hex(salt+server_hostname+nonce+key)]
+	var pong []interface{}
+	if err := f.decoder.Decode(&pong); err != nil {
+		return fmt.Errorf("failed to read PONG: %w", err)
+	}
+	if len(pong) != 5 || pong[0] != "PONG" {
+		return fmt.Errorf("unexpected PONG message: %v", pong)
+	}
+	if ok, _ := pong[1].(bool); !ok {
+		return fmt.Errorf("authentication rejected: %v", pong[2])
+	}
+	serverHostname, _ := pong[3].(string)
+	expected := sha512Hex(sharedKeySalt, []byte(serverHostname), nonce, []byte(sharedKey))
+	if digest, _ := pong[4].(string); digest != expected {
+		return fmt.Errorf("server %s failed shared key verification", serverHostname)
+	}
+
+	return nil
+}
+
+// Probe checks that the connection is still open and reconnects if it is
+// not. A connection busy with a chunk is left alone, as the chunk's ack
+// proves it alive.
+func (f *FluentBitForwarder) Probe() error {
+	if !f.mu.TryLock() {
+		return nil
+	}
+	defer f.mu.Unlock()
+
+	if f.connected && f.conn != nil {
+		f.conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+		var b [1]byte
+		_, err := f.conn.Read(b[:])
+		f.conn.SetReadDeadline(time.Time{})
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil
+		}
+		// Fluent Bit only sends acks, which send reads; EOF, a reset or
+		// stray data all leave the connection unusable
+		f.disconnectLocked()
+	}
+	return f.connectLocked()
+}
+
+func (f *FluentBitForwarder) disconnectLocked() {
+	if f.conn != nil {
+		f.conn.Close()
+	}
+	f.conn = nil
+	f.decoder = nil
+	f.connected = false
+}
+
+// Forward sends a single log entry to Fluent Bit
+func (f *FluentBitForwarder) Forward(tag string, timestamp time.Time, record map[string]interface{}) error {
+	return f.ForwardBatch(context.Background(), tag, []ForwardEntry{{Time: timestamp, Record: record}})
+}
+
+// ForwardBatch sends entries as one PackedForward chunk and waits for its
+// acknowledgement, retransmitting the chunk on failure.
+func (f *FluentBitForwarder) ForwardBatch(ctx context.Context, tag string, entries []ForwardEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	chunkID := ""
+	if f.opts.AckTimeout > 0 {
+		id, err := newChunkID()
+		if err != nil {
+			return err
+		}
+		chunkID = id
+	}
+
+	payload, err := encodePackedForward(tag, entries, chunkID)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= f.opts.MaxRetries; attempt++ {
+		if attempt > 0 {
+			backoff := time.Duration(attempt) * 500 * time.Millisecond
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			slog.Warn("Retransmitting chunk to Fluent Bit",
+				"chunk", chunkID,
+				"attempt", attempt,
+				"entries", len(entries),
+				"error", lastErr)
+		}
+
+		if lastErr = f.send(payload, chunkID); lastErr == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("chunk %s not delivered to Fluent Bit after %d attempts: %w", chunkID, f.opts.MaxRetries+1, lastErr)
+}
+
+// send writes one encoded chunk and, when acknowledgements are enabled,
+// waits for the matching ack on the same connection.
+func (f *FluentBitForwarder) send(payload []byte, chunkID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.connectLocked(); err != nil {
+		return err
+	}
+
+	f.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := f.conn.Write(payload); err != nil {
+		f.disconnectLocked()
+		return fmt.Errorf("failed to write to Fluent Bit: %w", err)
+	}
+
+	if chunkID == "" {
+		return nil
+	}
+
+	f.conn.SetReadDeadline(time.Now().Add(f.opts.AckTimeout))
+	var resp map[string]interface{}
+	if err := f.decoder.Decode(&resp); err != nil {
+		// The connection state is unknown after a missed ack, so start over
+		f.disconnectLocked()
+		return fmt.Errorf("failed to read ack from Fluent Bit: %w", err)
+	}
+
+	if ack, _ := resp["ack"].(string); ack != chunkID {
+		f.disconnectLocked()
+		return fmt.Errorf("unexpected ack from Fluent Bit: got %q, want %q", ack, chunkID)
+	}
+
+	return nil
+}
+
+// Close closes the connection to Fluent Bit
+func (f *FluentBitForwarder) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.conn != nil {
+		err := f.conn.Close()
+		f.conn = nil
+		f.decoder = nil
+		f.connected = false
+		return err
+	}
+	return nil
+}
+
+// encodePackedForward builds a PackedForward message:
+// [tag, <msgpack stream of [EventTime, record]>, {"size": n, "chunk": id}]
+func encodePackedForward(tag string, entries []ForwardEntry, chunkID string) ([]byte, error) {
+	var stream bytes.Buffer
+	enc := msgpack.NewEncoder(&stream)
+	for _, entry := range entries {
+		eventTime := EventTime(entry.Time)
+		if err := enc.Encode([]interface{}{&eventTime, entry.Record}); err != nil {
+			return nil, fmt.Errorf("failed to encode entry: %w", err)
+		}
+	}
+
+	option := map[string]interface{}{
+		"size": len(entries),
+	}
+	if chunkID != "" {
+		option["chunk"] = chunkID
+	}
+
+	data, err := msgpack.Marshal([]interface{}{tag, stream.Bytes(), option})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode forward message: %w", err)
+	}
+	return data, nil
+}
+
+func newChunkID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate chunk id: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+func sha512Hex(parts ...[]byte) string {
+	h := sha512.New()
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// msgpackBytes accepts both bin and str encodings used by forward servers
+func msgpackBytes(v interface{}) []byte {
+	switch val := v.(type) {
+	case []byte:
+		return val
+	case string:
+		return []byte(val)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LogForwarder delivers batches of raw log records to Fluent Bit
+type LogForwarder interface {
+	ForwardBatch(ctx context.Context, tag string, entries []ForwardEntry) error
+	Close() error
+}
+
+// Balancing strategies for ForwarderPool
+const (
+	BalanceRoundRobin    = "round_robin"
+	BalanceLeastInflight = "least_inflight"
+)
+
+// Upstream discovery modes for ForwarderPool
+const (
+	DiscoveryStatic = "static" // fixed host:port list
+	DiscoveryDNS    = "dns"    // A/AAAA records of a headless service
+	DiscoverySRV    = "srv"    // DNS SRV records
+)
+
+// ForwarderPoolConfig configures upstream discovery and balancing
+type ForwarderPoolConfig struct {
+	Discovery              string
+	Hosts                  []string // static: host:port entries
+	ServiceName            string   // dns: headless service name, srv: SRV record name
+	Port                   string   // dns: port used for every resolved address
+	Balancing              string
+	ConnectionsPerUpstream int
+	HealthCheckInterval    time.Duration
+	DiscoveryInterval      time.Duration
+	MinBackoff             time.Duration
+	MaxBackoff             time.Duration
+}
+
+type forwardUpstream struct {
+	host       string
+	port       string
+	serverName string // TLS name to verify when host is a resolved IP
+}
+
+func (u forwardUpstream) address() string {
+	return net.JoinHostPort(u.host, u.port)
+}
+
+type poolMember struct {
+	upstream  forwardUpstream
+	forwarder *FluentBitForwarder
+	inflight  atomic.Int32
+	healthy   atomic.Bool
+	failures  atomic.Int32
+	retryAt   atomic.Int64
+	removed   atomic.Bool
+}
+
+// ForwarderPool spreads chunks across several Fluent Bit upstreams, each with
+// its own connections, and fails over to the remaining upstreams when one of
+// them stops acknowledging. Failed upstreams are reconnected with exponential
+// backoff by a background health checker.
+type ForwarderPool struct {
+	config  ForwarderPoolConfig
+	opts    FluentForwardOptions
+	resolve func(ctx context.Context) ([]forwardUpstream, error)
+
+	mu      sync.RWMutex
+	members []*poolMember
+	next    atomic.Uint64
+
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewForwarderPool creates a pool; call Start to discover upstreams. Unknown
+// discovery modes and balancing strategies are configuration errors.
+func NewForwarderPool(config ForwarderPoolConfig, opts FluentForwardOptions) (*ForwarderPool, error) {
+	switch config.Discovery {
+	case "":
+		config.Discovery = DiscoveryStatic
+	case DiscoveryStatic, DiscoveryDNS, DiscoverySRV:
+	default:
+		return nil, fmt.Errorf("unknown Fluent Bit discovery mode %q (want %s, %s or %s)", config.Discovery, DiscoveryStatic, DiscoveryDNS, DiscoverySRV)
+	}
+	switch config.Balancing {
+	case "":
+		config.Balancing = BalanceRoundRobin
+	case BalanceRoundRobin, BalanceLeastInflight:
+	default:
+		return nil, fmt.Errorf("unknown Fluent Bit balancing strategy %q (want %s or %s)", config.Balancing, BalanceRoundRobin, BalanceLeastInflight)
+	}
+	if config.ConnectionsPerUpstream <= 0 {
+		config.ConnectionsPerUpstream = 1
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = time.Second
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 30 * time.Second
+	}
+
+	pool := &ForwarderPool{
+		config:   config,
+		opts:     opts,
+		stopChan: make(chan struct{}),
+	}
+	pool.resolve = pool.resolveUpstreams
+	return pool, nil
+}
+
+// Start resolves the initial upstream set and launches health checking and
+// periodic re-discovery
+func (p *ForwarderPool) Start(ctx context.Context) error {
+	if err := p.refresh(ctx); err != nil {
+		return err
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.maintain(ctx)
+	}()
+	return nil
+}
+
+func (p *ForwarderPool) maintain(ctx context.Context) {
+	healthInterval := p.config.HealthCheckInterval
+	if healthInterval <= 0 {
+		healthInterval = 10 * time.Second
+	}
+	healthTicker := time.NewTicker(healthInterval)
+	defer healthTicker.Stop()
+
+	var discoveryC <-chan time.Time
+	if p.config.Discovery != DiscoveryStatic && p.config.DiscoveryInterval > 0 {
+		discoveryTicker := time.NewTicker(p.config.DiscoveryInterval)
+		defer discoveryTicker.Stop()
+		discoveryC = discoveryTicker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.stopChan:
+			return
+		case <-healthTicker.C:
+			p.healthCheck()
+		case <-discoveryC:
+			if err := p.refresh(ctx); err != nil {
+				slog.Warn("Failed to refresh Fluent Bit upstreams", "error", err)
+			}
+		}
+	}
+}
+
+// resolveUpstreams returns the current upstream set for the configured discovery mode
+func (p *ForwarderPool) resolveUpstreams(ctx context.Context) ([]forwardUpstream, error) {
+	switch p.config.Discovery {
+	case DiscoveryDNS:
+		addrs, err := net.DefaultResolver.LookupHost(ctx, p.config.ServiceName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", p.config.ServiceName, err)
+		}
+		upstreams := make([]forwardUpstream, 0, len(addrs))
+		for _, addr := range addrs {
+			upstreams = append(upstreams, forwardUpstream{host: addr, port: p.config.Port, serverName: p.config.ServiceName})
+		}
+		return upstreams, nil
+
+	case DiscoverySRV:
+		_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", p.config.ServiceName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve SRV %s: %w", p.config.ServiceName, err)
+		}
+		upstreams := make([]forwardUpstream, 0, len(records))
+		for _, record := range records {
+			target := strings.TrimSuffix(record.Target, ".")
+			upstreams = append(upstreams, forwardUpstream{
+				host:       target,
+				port:       strconv.Itoa(int(record.Port)),
+				serverName: target,
+			})
+		}
+		return upstreams, nil
+
+	default:
+		upstreams := make([]forwardUpstream, 0, len(p.config.Hosts))
+		for _, hostPort := range p.config.Hosts {
+			host, port, err := net.SplitHostPort(strings.TrimSpace(hostPort))
+			if err != nil {
+				return nil, fmt.Errorf("invalid Fluent Bit upstream %q: %w", hostPort, err)
+			}
+			upstreams = append(upstreams, forwardUpstream{host: host, port: port})
+		}
+		return upstreams, nil
+	}
+}
+
+// refresh reconciles pool members with the resolved upstream set
+func (p *ForwarderPool) refresh(ctx context.Context) error {
+	upstreams, err := p.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	if len(upstreams) == 0 {
+		return fmt.Errorf("no Fluent Bit upstreams discovered")
+	}
+
+	wanted := make(map[string]forwardUpstream, len(upstreams))
+	for _, upstream := range upstreams {
+		wanted[upstream.address()] = upstream
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	kept := make([]*poolMember, 0, len(p.members))
+	existing := make(map[string]int)
+	for _, member := range p.members {
+		addr := member.upstream.address()
+		if _, ok := wanted[addr]; !ok {
+			member.removed.Store(true)
+			member.forwarder.Close()
+			slog.Info("Removed Fluent Bit upstream", "address", addr)
+			continue
+		}
+		kept = append(kept, member)
+		existing[addr]++
+	}
+
+	for addr, upstream := range wanted {
+		for i := existing[addr]; i < p.config.ConnectionsPerUpstream; i++ {
+			kept = append(kept, p.newMember(upstream))
+		}
+		if existing[addr] == 0 {
+			slog.Info("Added Fluent Bit upstream", "address", addr)
+		}
+	}
+
+	p.members = kept
+	return nil
+}
+
+func (p *ForwarderPool) newMember(upstream forwardUpstream) *poolMember {
+	opts := p.opts
+	if opts.TLS != nil && opts.TLS.ServerName == "" && upstream.serverName != "" {
+		opts.TLS = opts.TLS.Clone()
+		opts.TLS.ServerName = upstream.serverName
+	}
+	member := &poolMember{
+		upstream:  upstream,
+		forwarder: NewFluentBitForwarder(upstream.host, upstream.port, opts),
+	}
+	member.healthy.Store(true)
+	return member
+}
+
+// pick selects the next member to use, skipping members already tried for
+// the current chunk
+func (p *ForwarderPool) pick(tried map[*poolMember]bool) *poolMember {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	now := time.Now().UnixNano()
+	candidates := make([]*poolMember, 0, len(p.members))
+	for _, member := range p.members {
+		if !tried[member] && member.healthy.Load() {
+			candidates = append(candidates, member)
+		}
+	}
+	if len(candidates) == 0 {
+		// Every upstream is down: probe the ones whose backoff has expired
+		for _, member := range p.members {
+			if !tried[member] && member.retryAt.Load() <= now {
+				candidates = append(candidates, member)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	start := int(p.next.Add(1) % uint64(len(candidates)))
+	if p.config.Balancing != BalanceLeastInflight {
+		return candidates[start]
+	}
+
+	best := candidates[start]
+	for i := 1; i < len(candidates); i++ {
+		member := candidates[(start+i)%len(candidates)]
+		if member.inflight.Load() < best.inflight.Load() {
+			best = member
+		}
+	}
+	return best
+}
+
+// ForwardBatch encodes entries once and sends them to one upstream, failing
+// over to other upstreams until the chunk is acknowledged
+func (p *ForwarderPool) ForwardBatch(ctx context.Context, tag string, entries []ForwardEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	chunkID := ""
+	if p.opts.AckTimeout > 0 {
+		id, err := newChunkID()
+		if err != nil {
+			return err
+		}
+		chunkID = id
+	}
+
+	payload, err := encodePackedForward(tag, entries, chunkID)
+	if err != nil {
+		return err
+	}
+
+	tried := make(map[*poolMember]bool)
+	var lastErr error
+	for attempt := 0; attempt <= p.opts.MaxRetries; attempt++ {
+		member := p.pick(tried)
+		if member == nil {
+			// Every upstream has been tried once; back off and start over
+			tried = make(map[*poolMember]bool)
+			backoff := time.Duration(attempt+1) * 500 * time.Millisecond
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if member = p.pick(tried); member == nil {
+				lastErr = fmt.Errorf("no healthy Fluent Bit upstreams")
+				continue
+			}
+		}
+		tried[member] = true
+
+		member.inflight.Add(1)
+		err := member.forwarder.send(payload, chunkID)
+		member.inflight.Add(-1)
+		if member.removed.Load() {
+			member.forwarder.Close()
+		}
+
+		if err == nil {
+			p.markHealthy(member)
+			return nil
+		}
+
+		lastErr = err
+		p.markFailed(member)
+		slog.Warn("Fluent Bit upstream failed, failing over",
+			"address", member.upstream.address(),
+			"chunk", chunkID,
+			"attempt", attempt+1,
+			"error", err)
+	}
+
+	return fmt.Errorf("chunk %s not delivered to any Fluent Bit upstream after %d attempts: %w", chunkID, p.opts.MaxRetries+1, lastErr)
+}
+
+func (p *ForwarderPool) markHealthy(member *poolMember) {
+	if !member.healthy.Swap(true) {
+		slog.Info("Fluent Bit upstream recovered", "address", member.upstream.address())
+	}
+	member.failures.Store(0)
+}
+
+// markFailed takes a member out of rotation with exponential, jittered backoff
+func (p *ForwarderPool) markFailed(member *poolMember) {
+	member.healthy.Store(false)
+	failures := member.failures.Add(1)
+
+	backoff := p.config.MinBackoff
+	for i := int32(1); i < failures && backoff < p.config.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.config.MaxBackoff {
+		backoff = p.config.MaxBackoff
+	}
+	backoff += time.Duration(rand.Int63n(int64(backoff)/4 + 1))
+	member.retryAt.Store(time.Now().Add(backoff).UnixNano())
+}
+
+// healthCheck probes healthy members, so a dead upstream leaves rotation
+// before traffic fails on it, and reconnects unhealthy members whose
+// backoff has expired
+func (p *ForwarderPool) healthCheck() {
+	p.mu.RLock()
+	members := append([]*poolMember(nil), p.members...)
+	p.mu.RUnlock()
+
+	now := time.Now().UnixNano()
+	for _, member := range members {
+		if member.healthy.Load() {
+			if err := member.forwarder.Probe(); err != nil {
+				p.markFailed(member)
+				slog.Warn("Fluent Bit upstream failed health check", "address", member.upstream.address(), "error", err)
+			}
+			continue
+		}
+		if member.retryAt.Load() > now {
+			continue
+		}
+		if err := member.forwarder.Connect(); err != nil {
+			p.markFailed(member)
+			slog.Debug("Fluent Bit upstream still unavailable", "address", member.upstream.address(), "error", err)
+			continue
+		}
+		p.markHealthy(member)
+	}
+}
+
+// HealthyUpstreams returns the number of members currently in rotation
+func (p *ForwarderPool) HealthyUpstreams() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	healthy := 0
+	for _, member := range p.members {
+		if member.healthy.Load() {
+			healthy++
+		}
+	}
+	return healthy
+}
+
+// Close stops background maintenance and closes every connection
+func (p *ForwarderPool) Close() error {
+	select {
+	case <-p.stopChan:
+	default:
+		close(p.stopChan)
+	}
+	p.wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, member := range p.members {
+		member.forwarder.Close()
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// startAckServer runs a fake Fluent Bit that acknowledges every chunk and
+// counts the chunks it received
+func startAckServer(t *testing.T) (string, *atomic.Int32) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	var chunks atomic.Int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				dec := msgpack.NewDecoder(conn)
+				for {
+					msg, err := readForwardMessage(dec)
+					if err != nil {
+						return
+					}
+					chunks.Add(1)
+					data, _ := msgpack.Marshal(map[string]interface{}{"ack": msg.Option["chunk"]})
+					conn.Write(data)
+				}
+			}(conn)
+		}
+	}()
+
+	return listener.Addr().String(), &chunks
+}
+
+// deadAddress returns an address nothing is listening on
+func deadAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+	return addr
+}
+
+func testEntries() []ForwardEntry {
+	return []ForwardEntry{{Time: time.Now(), Record: map[string]interface{}{"message": "line"}}}
+}
+
+func TestForwarderPoolRoundRobin(t *testing.T) {
+	addrA, chunksA := startAckServer(t)
+	addrB, chunksB := startAckServer(t)
+
+	pool, err := NewForwarderPool(ForwarderPoolConfig{
+		Discovery: DiscoveryStatic,
+		Hosts:     []string{addrA, addrB},
+		Balancing: BalanceRoundRobin,
+	}, FluentForwardOptions{AckTimeout: time.Second})
+	require.NoError(t, err)
+	require.NoError(t, pool.Start(context.Background()))
+	defer pool.Close()
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, pool.ForwardBatch(context.Background(), "aurora.error", testEntries()))
+	}
+
+	assert.Equal(t, int32(2), chunksA.Load())
+	assert.Equal(t, int32(2), chunksB.Load())
+}
+
+func TestForwarderPoolFailover(t *testing.T) {
+	addr, chunks := startAckServer(t)
+	dead := deadAddress(t)
+
+	pool, err := NewForwarderPool(ForwarderPoolConfig{
+		Discovery:  DiscoveryStatic,
+		Hosts:      []string{dead, addr},
+		MinBackoff: time.Minute,
+	}, FluentForwardOptions{AckTimeout: time.Second, MaxRetries: 2})
+	require.NoError(t, err)
+	require.NoError(t, pool.Start(context.Background()))
+	defer pool.Close()
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, pool.ForwardBatch(context.Background(), "aurora.error", testEntries()))
+	}
+
+	assert.Equal(t, int32(3), chunks.Load())
+	assert.Equal(t, 1, pool.HealthyUpstreams(), "dead upstream should be out of rotation")
+}
+
+func TestForwarderPoolAllUpstreamsDown(t *testing.T) {
+	pool, err := NewForwarderPool(ForwarderPoolConfig{
+		Discovery:  DiscoveryStatic,
+		Hosts:      []string{deadAddress(t)},
+		MinBackoff: time.Minute,
+	}, FluentForwardOptions{AckTimeout: 100 * time.Millisecond, MaxRetries: 1})
+	require.NoError(t, err)
+	require.NoError(t, pool.Start(context.Background()))
+	defer pool.Close()
+
+	err = pool.ForwardBatch(context.Background(), "aurora.error", testEntries())
+	assert.Error(t, err)
+}
+
+func TestForwarderPoolLeastInflight(t *testing.T) {
+	pool, err := NewForwarderPool(ForwarderPoolConfig{Balancing: BalanceLeastInflight}, FluentForwardOptions{})
+	require.NoError(t, err)
+	busy := pool.newMember(forwardUpstream{host: "10.0.0.1", port: "24224"})
+	idle := pool.newMember(forwardUpstream{host: "10.0.0.2", port: "24224"})
+	busy.inflight.Store(5)
+	idle.inflight.Store(1)
+	pool.members = []*poolMember{busy, idle}
+
+	for i := 0; i < 4; i++ {
+		assert.Same(t, idle, pool.pick(nil))
+	}
+
+	// Members already tried for a chunk are skipped
+	assert.Same(t, busy, pool.pick(map[*poolMember]bool{idle: true}))
+}
+
+func TestNewForwarderPoolRejectsUnknownSettings(t *testing.T) {
+	pool, err := NewForwarderPool(ForwarderPoolConfig{}, FluentForwardOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, DiscoveryStatic, pool.config.Discovery)
+	assert.Equal(t, BalanceRoundRobin, pool.config.Balancing)
+
+	_, err = NewForwarderPool(ForwarderPoolConfig{Balancing: "least-inflight"}, FluentForwardOptions{})
+	assert.ErrorContains(t, err, `unknown Fluent Bit balancing strategy "least-inflight"`)
+
+	_, err = NewForwarderPool(ForwarderPoolConfig{Discovery: "consul"}, FluentForwardOptions{})
+	assert.ErrorContains(t, err, `unknown Fluent Bit discovery mode "consul"`)
+}
+
+func TestForwarderPoolRefresh(t *testing.T) {
+	upstreams := []forwardUpstream{
+		{host: "10.0.0.1", port: "24224", serverName: "fluent-bit.logging.svc"},
+		{host: "10.0.0.2", port: "24224", serverName: "fluent-bit.logging.svc"},
+	}
+
+	pool, err := NewForwarderPool(ForwarderPoolConfig{Discovery: DiscoveryDNS, ConnectionsPerUpstream: 2}, FluentForwardOptions{})
+	require.NoError(t, err)
+	pool.resolve = func(ctx context.Context) ([]forwardUpstream, error) {
+		return upstreams, nil
+	}
+
+	require.NoError(t, pool.refresh(context.Background()))
+	assert.Len(t, pool.members, 4)
+
+	// One aggregator pod goes away and a new one appears
+	upstreams = []forwardUpstream{
+		{host: "10.0.0.2", port: "24224", serverName: "fluent-bit.logging.svc"},
+		{host: "10.0.0.3", port: "24224", serverName: "fluent-bit.logging.svc"},
+	}
+	require.NoError(t, pool.refresh(context.Background()))
+	require.Len(t, pool.members, 4)
+
+	hosts := map[string]int{}
+	for _, member := range pool.members {
+		hosts[member.upstream.host]++
+	}
+	assert.Equal(t, map[string]int{"10.0.0.2": 2, "10.0.0.3": 2}, hosts)
+
+	upstreams = nil
+	assert.Error(t, pool.refresh(context.Background()))
+}
+
+func TestForwarderPoolHealthCheckRecovers(t *testing.T) {
+	addr, _ := startAckServer(t)
+
+	pool, err := NewForwarderPool(ForwarderPoolConfig{Discovery: DiscoveryStatic, Hosts: []string{addr}}, FluentForwardOptions{})
+	require.NoError(t, err)
+	require.NoError(t, pool.refresh(context.Background()))
+
+	member := pool.members[0]
+	pool.markFailed(member)
+	assert.Equal(t, 0, pool.HealthyUpstreams())
+
+	// Backoff has not expired yet
+	pool.healthCheck()
+	assert.Equal(t, 0, pool.HealthyUpstreams())
+
+	member.retryAt.Store(time.Now().Add(-time.Second).UnixNano())
+	pool.healthCheck()
+	assert.Equal(t, 1, pool.HealthyUpstreams())
+	pool.Close()
+}
+
+func TestForwarderPoolHealthCheckProbesHealthyMembers(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	pool, err := NewForwarderPool(ForwarderPoolConfig{Discovery: DiscoveryStatic, Hosts: []string{listener.Addr().String()}}, FluentForwardOptions{})
+	require.NoError(t, err)
+	require.NoError(t, pool.refresh(context.Background()))
+	defer pool.Close()
+
+	// An open connection passes the probe
+	pool.healthCheck()
+	assert.Equal(t, 1, pool.HealthyUpstreams())
+
+	// The upstream dies without any chunk being sent
+	(<-accepted).Close()
+	listener.Close()
+	pool.healthCheck()
+	assert.Equal(t, 0, pool.HealthyUpstreams())
+}
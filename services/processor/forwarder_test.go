@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+	commontls "github.com/yourorg/aurora-log-system/common/tls"
+)
+
+// writeTestCertificate creates a self-signed certificate for 127.0.0.1 and
+// writes it to dir as a CA bundle
+func writeTestCertificate(t *testing.T, dir string) (tls.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fluent-bit"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, certPEM, 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tls.crt"), certPEM, 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tls.key"), keyPEM, 0600))
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+	return cert, caFile
+}
+
+type forwardMessage struct {
+	Tag     string
+	Entries []forwardEntryWire
+	Option  map[string]interface{}
+}
+
+type forwardEntryWire struct {
+	_msgpack struct{} `msgpack:",as_array"`
+	Time     EventTime
+	Record   map[string]interface{}
+}
+
+// readForwardMessage decodes one PackedForward message from a fake Fluent Bit
+func readForwardMessage(dec *msgpack.Decoder) (forwardMessage, error) {
+	var raw []interface{}
+	if err := dec.Decode(&raw); err != nil {
+		return forwardMessage{}, err
+	}
+	if len(raw) != 3 {
+		return forwardMessage{}, fmt.Errorf("expected PackedForward with 3 elements, got %d", len(raw))
+	}
+
+	msg := forwardMessage{
+		Tag:    raw[0].(string),
+		Option: raw[2].(map[string]interface{}),
+	}
+
+	entryDec := msgpack.NewDecoder(bytes.NewReader(raw[1].([]byte)))
+	for {
+		var entry forwardEntryWire
+		if err := entryDec.Decode(&entry); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return forwardMessage{}, err
+		}
+		msg.Entries = append(msg.Entries, entry)
+	}
+	return msg, nil
+}
+
+func TestEventTimeRoundTrip(t *testing.T) {
+	ts := time.Date(2025, 8, 2, 12, 34, 56, 123456789, time.UTC)
+
+	eventTime := EventTime(ts)
+	data, err := msgpack.Marshal(&eventTime)
+	require.NoError(t, err)
+	// fixext8 (0xd7) with ext type 0
+	assert.Equal(t, byte(0xd7), data[0])
+	assert.Equal(t, byte(0x00), data[1])
+
+	var decoded EventTime
+	require.NoError(t, msgpack.Unmarshal(data, &decoded))
+	assert.True(t, ts.Equal(time.Time(decoded)))
+}
+
+func TestFluentBitForwarderAck(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	received := make(chan forwardMessage, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		msg, err := readForwardMessage(msgpack.NewDecoder(conn))
+		if err != nil {
+			return
+		}
+		received <- msg
+		data, _ := msgpack.Marshal(map[string]interface{}{"ack": msg.Option["chunk"]})
+		conn.Write(data)
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	forwarder := NewFluentBitForwarder(host, port, FluentForwardOptions{AckTimeout: time.Second})
+	defer forwarder.Close()
+
+	ts := time.Date(2025, 8, 2, 12, 34, 56, 500, time.UTC)
+	err = forwarder.ForwardBatch(context.Background(), "aurora.error", []ForwardEntry{
+		{Time: ts, Record: map[string]interface{}{"message": "first"}},
+		{Time: ts.Add(time.Second), Record: map[string]interface{}{"message": "second"}},
+	})
+	require.NoError(t, err)
+
+	msg := <-received
+	assert.Equal(t, "aurora.error", msg.Tag)
+	assert.EqualValues(t, 2, msg.Option["size"])
+	assert.NotEmpty(t, msg.Option["chunk"])
+	require.Len(t, msg.Entries, 2)
+	assert.True(t, ts.Equal(time.Time(msg.Entries[0].Time)))
+	assert.Equal(t, "second", msg.Entries[1].Record["message"])
+}
+
+func TestFluentBitForwarderRetransmitsUnacknowledgedChunk(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	chunks := make(chan string, 2)
+	go func() {
+		for attempt := 0; attempt < 2; attempt++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			msg, err := readForwardMessage(msgpack.NewDecoder(conn))
+			if err != nil {
+				conn.Close()
+				return
+			}
+			chunk := msg.Option["chunk"].(string)
+			chunks <- chunk
+			if attempt == 1 {
+				// Only acknowledge the retransmission
+				data, _ := msgpack.Marshal(map[string]interface{}{"ack": chunk})
+				conn.Write(data)
+			}
+			conn.Close()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	forwarder := NewFluentBitForwarder(host, port, FluentForwardOptions{AckTimeout: 200 * time.Millisecond, MaxRetries: 1})
+	defer forwarder.Close()
+
+	err = forwarder.Forward("aurora.slowquery", time.Now(), map[string]interface{}{"message": "retry me"})
+	require.NoError(t, err)
+
+	first, second := <-chunks, <-chunks
+	assert.Equal(t, first, second, "retransmission should reuse the chunk id")
+}
+
+func TestFluentBitForwarderFailsWithoutAck(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			// Swallow data and never acknowledge
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	forwarder := NewFluentBitForwarder(host, port, FluentForwardOptions{AckTimeout: 50 * time.Millisecond, MaxRetries: 1})
+	defer forwarder.Close()
+
+	err = forwarder.Forward("aurora.error", time.Now(), map[string]interface{}{"message": "lost"})
+	assert.Error(t, err)
+}
+
+// fakeSecureFluentBit accepts one connection, performs the server side of the
+// shared-key handshake and acknowledges a single chunk
+func fakeSecureFluentBit(t *testing.T, listener net.Listener, sharedKey string, received chan<- forwardMessage) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	nonce := []byte("0123456789abcdef")
+	helo, _ := msgpack.Marshal([]interface{}{"HELO", map[string]interface{}{"nonce": nonce, "auth": []byte{}, "keepalive": true}})
+	conn.Write(helo)
+
+	dec := msgpack.NewDecoder(conn)
+	var ping []interface{}
+	if err := dec.Decode(&ping); err != nil || len(ping) != 6 {
+		return
+	}
+	hostname := ping[1].(string)
+	salt := msgpackBytes(ping[2])
+	authenticated := ping[3].(string) == sha512Hex(salt, []byte(hostname), nonce, []byte(sharedKey))
+
+	pong, _ := msgpack.Marshal([]interface{}{
+		"PONG", authenticated, "", "fluent-bit-aggregator",
+		sha512Hex(salt, []byte("fluent-bit-aggregator"), nonce, []byte(sharedKey)),
+	})
+	conn.Write(pong)
+	if !authenticated {
+		return
+	}
+
+	msg, err := readForwardMessage(dec)
+	if err != nil {
+		return
+	}
+	received <- msg
+	data, _ := msgpack.Marshal(map[string]interface{}{"ack": msg.Option["chunk"]})
+	conn.Write(data)
+}
+
+func TestFluentBitForwarderSharedKeyHandshake(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "shared_key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("s3cret\n"), 0600))
+
+	t.Run("accepted", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		received := make(chan forwardMessage, 1)
+		go fakeSecureFluentBit(t, listener, "s3cret", received)
+
+		host, port, _ := net.SplitHostPort(listener.Addr().String())
+		forwarder := NewFluentBitForwarder(host, port, FluentForwardOptions{
+			AckTimeout:   time.Second,
+			SharedKey:    commontls.NewFileSecret(keyFile),
+			SelfHostname: "processor-0",
+		})
+		defer forwarder.Close()
+
+		require.NoError(t, forwarder.Forward("aurora.error", time.Now(), map[string]interface{}{"message": "authenticated"}))
+		msg := <-received
+		assert.Equal(t, "authenticated", msg.Entries[0].Record["message"])
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		go fakeSecureFluentBit(t, listener, "other-key", make(chan forwardMessage, 1))
+
+		host, port, _ := net.SplitHostPort(listener.Addr().String())
+		forwarder := NewFluentBitForwarder(host, port, FluentForwardOptions{
+			AckTimeout: time.Second,
+			SharedKey:  commontls.NewFileSecret(keyFile),
+		})
+		defer forwarder.Close()
+
+		err = forwarder.Connect()
+		assert.ErrorContains(t, err, "authentication rejected")
+	})
+}
+
+func TestFluentBitForwarderTLS(t *testing.T) {
+	dir := t.TempDir()
+	serverCert, caFile := writeTestCertificate(t, dir)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{serverCert}})
+	require.NoError(t, err)
+	defer listener.Close()
+
+	received := make(chan forwardMessage, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		msg, err := readForwardMessage(msgpack.NewDecoder(conn))
+		if err != nil {
+			return
+		}
+		received <- msg
+		data, _ := msgpack.Marshal(map[string]interface{}{"ack": msg.Option["chunk"]})
+		conn.Write(data)
+	}()
+
+	tlsConfig, err := commontls.FileConfig{Enabled: true, CAFile: caFile}.Build()
+	require.NoError(t, err)
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	forwarder := NewFluentBitForwarder(host, port, FluentForwardOptions{AckTimeout: time.Second, TLS: tlsConfig})
+	defer forwarder.Close()
+
+	require.NoError(t, forwarder.Forward("aurora.error", time.Now(), map[string]interface{}{"message": "encrypted"}))
+	msg := <-received
+	assert.Equal(t, "encrypted", msg.Entries[0].Record["message"])
+}
@@ -2,9 +2,7 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -17,7 +15,6 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -27,133 +24,20 @@ import (
 	dynamoTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/aws-sdk-go-v2/service/rds"
 	"github.com/segmentio/kafka-go"
+	"github.com/yourorg/aurora-log-system/common/circuit"
+	"github.com/yourorg/aurora-log-system/common/env"
+	commonkafka "github.com/yourorg/aurora-log-system/common/kafka"
+	"github.com/yourorg/aurora-log-system/common/message"
+	"github.com/yourorg/aurora-log-system/common/metrics"
+	commontls "github.com/yourorg/aurora-log-system/common/tls"
 )
 
-// Configuration helpers
-func getEnvOrDefault(key, defaultVal string) string {
-	if val := os.Getenv(key); val != "" {
-		return val
-	}
-	return defaultVal
-}
-
-func getEnvAsInt(key string, defaultVal int) int {
-	if val := os.Getenv(key); val != "" {
-		if intVal, err := strconv.Atoi(val); err == nil {
-			return intVal
-		}
-	}
-	return defaultVal
-}
-
-
-// Circuit Breaker implementation
-type CircuitBreaker struct {
-	maxFailures     int
-	resetTimeout    time.Duration
-	failures        atomic.Int32
-	lastFailureTime atomic.Int64
-	state           atomic.Int32 // 0=closed, 1=open, 2=half-open
-}
-
-func NewCircuitBreaker(maxFailures int, resetTimeout time.Duration) *CircuitBreaker {
-	return &CircuitBreaker{
-		maxFailures:  maxFailures,
-		resetTimeout: resetTimeout,
-	}
-}
-
-func (cb *CircuitBreaker) Call(fn func() error) error {
-	if !cb.canExecute() {
-		return errors.New("circuit breaker is open")
-	}
-
-	err := fn()
-	if err != nil {
-		cb.recordFailure()
-	} else {
-		cb.recordSuccess()
-	}
-
-	return err
-}
-
-func (cb *CircuitBreaker) canExecute() bool {
-	state := cb.state.Load()
-	
-	switch state {
-	case 0: // closed
-		return true
-	case 1: // open
-		lastFailure := time.Unix(0, cb.lastFailureTime.Load())
-		if time.Since(lastFailure) > cb.resetTimeout {
-			cb.state.CompareAndSwap(1, 2) // transition to half-open
-			return true
-		}
-		return false
-	case 2: // half-open
-		return true
-	default:
-		return false
-	}
-}
-
-func (cb *CircuitBreaker) recordFailure() {
-	cb.failures.Add(1)
-	cb.lastFailureTime.Store(time.Now().UnixNano())
-	
-	if cb.failures.Load() >= int32(cb.maxFailures) {
-		cb.state.Store(1) // open
-	}
-}
-
-func (cb *CircuitBreaker) recordSuccess() {
-	if cb.state.Load() == 2 { // half-open
-		cb.state.Store(0) // closed
-		cb.failures.Store(0)
-	}
-}
-
-// Metrics Exporter
-type MetricsExporter struct {
-	url      string
-	user     string
-	pass     string
-	client   *http.Client
-	counters map[string]int64
-	mu       sync.RWMutex
-}
-
-func NewMetricsExporter(url, user, pass string) *MetricsExporter {
-	return &MetricsExporter{
-		url:      url,
-		user:     user,
-		pass:     pass,
-		client:   &http.Client{Timeout: 5 * time.Second},
-		counters: make(map[string]int64),
-	}
-}
-
-func (m *MetricsExporter) IncrementCounter(name string, value int64) {
-	m.mu.Lock()
-	m.counters[name] += value
-	m.mu.Unlock()
-}
-
-func (m *MetricsExporter) RecordError(service, errorType string) {
-	m.IncrementCounter(fmt.Sprintf("%s_%s_errors", service, errorType), 1)
-}
-
-func (m *MetricsExporter) RecordDuration(name string, duration time.Duration) {
-	slog.Debug("Metric recorded", "name", name, "duration_ms", duration.Milliseconds())
-}
-
 // Data Integrity Checker
 type DataIntegrityChecker struct {
-	metricsExporter *MetricsExporter
+	metricsExporter *metrics.Exporter
 }
 
-func NewDataIntegrityChecker(metrics *MetricsExporter) *DataIntegrityChecker {
+func NewDataIntegrityChecker(metrics *metrics.Exporter) *DataIntegrityChecker {
 	return &DataIntegrityChecker{metricsExporter: metrics}
 }
 
@@ -230,109 +114,26 @@ type Config struct {
 	LogForwardEnabled    bool
 	LogForwardHost       string
 	LogForwardPort       string
+	LogForwardAckTimeout time.Duration
+	LogForwardRetries    int
+	LogForwardTLS        commontls.FileConfig
+	LogForwardSharedKeyFile string
+	LogForwardUsernameFile  string
+	LogForwardPasswordFile  string
+	LogForwardPool          ForwarderPoolConfig
 	ParsingMode          string // passthrough, minimal, full
-}
-
-type LogMessage struct {
-	InstanceID   string    `json:"instance_id"`
-	ClusterID    string    `json:"cluster_id"`
-	Engine       string    `json:"engine"`
-	LogType      string    `json:"log_type"`
-	LogFileName  string    `json:"log_file_name"`
-	LastWritten  int64     `json:"last_written"`
-	Size         int64     `json:"size"`
-	Timestamp    time.Time `json:"timestamp"`
+	// Output sinks for parsed entries
+	Sinks                []string
+	// Uncommitted Kafka messages allowed per partition
+	MaxInFlightPerPartition int
+	// Time a revoked partition's files get to checkpoint and stop
+	HandoffTimeout time.Duration
+	// kafka or valkey
+	Transport string
 }
 
 type ParsedLogEntry map[string]interface{}
 
-// FluentBitForwarder handles TCP forwarding to Fluent Bit
-type FluentBitForwarder struct {
-	address      string
-	conn         net.Conn
-	mu           sync.Mutex
-	connected    bool
-	reconnectCh  chan struct{}
-}
-
-// NewFluentBitForwarder creates a new Fluent Bit forwarder
-func NewFluentBitForwarder(host, port string) *FluentBitForwarder {
-	return &FluentBitForwarder{
-		address:     net.JoinHostPort(host, port),
-		reconnectCh: make(chan struct{}, 1),
-	}
-}
-
-// Connect establishes connection to Fluent Bit
-func (f *FluentBitForwarder) Connect() error {
-	f.mu.Lock()
-	defer f.mu.Unlock()
-
-	if f.connected && f.conn != nil {
-		return nil
-	}
-
-	conn, err := net.DialTimeout("tcp", f.address, 5*time.Second)
-	if err != nil {
-		return fmt.Errorf("failed to connect to Fluent Bit at %s: %w", f.address, err)
-	}
-
-	f.conn = conn
-	f.connected = true
-	slog.Info("Connected to Fluent Bit", "address", f.address)
-	return nil
-}
-
-// Forward sends a log entry to Fluent Bit
-func (f *FluentBitForwarder) Forward(tag string, timestamp time.Time, record map[string]interface{}) error {
-	f.mu.Lock()
-	defer f.mu.Unlock()
-
-	if !f.connected || f.conn == nil {
-		if err := f.Connect(); err != nil {
-			return err
-		}
-	}
-
-	// Fluent Bit forward protocol format: [tag, [[timestamp, record]]]
-	entry := []interface{}{
-		tag,
-		[]interface{}{
-			[]interface{}{timestamp.Unix(), record},
-		},
-	}
-
-	data, err := json.Marshal(entry)
-	if err != nil {
-		return fmt.Errorf("failed to marshal entry: %w", err)
-	}
-
-	// Set write deadline
-	f.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
-	
-	_, err = f.conn.Write(append(data, '\n'))
-	if err != nil {
-		f.connected = false
-		f.conn.Close()
-		return fmt.Errorf("failed to write to Fluent Bit: %w", err)
-	}
-
-	return nil
-}
-
-// Close closes the connection to Fluent Bit
-func (f *FluentBitForwarder) Close() error {
-	f.mu.Lock()
-	defer f.mu.Unlock()
-
-	if f.conn != nil {
-		err := f.conn.Close()
-		f.connected = false
-		return err
-	}
-	return nil
-}
-
 // DynamoDBClientInterface defines the interface for DynamoDB operations
 type DynamoDBClientInterface interface {
 	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
@@ -341,24 +142,34 @@ type DynamoDBClientInterface interface {
 	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
 }
 
+// RDSClientInterface defines the interface for RDS log downloads
+type RDSClientInterface interface {
+	DownloadDBLogFilePortion(ctx context.Context, params *rds.DownloadDBLogFilePortionInput, optFns ...func(*rds.Options)) (*rds.DownloadDBLogFilePortionOutput, error)
+}
+
 // Batch Processor with optimizations
 type BatchProcessor struct {
 	config           Config
-	rdsClient        *rds.Client
+	rdsClient        RDSClientInterface
 	dynamoClient     DynamoDBClientInterface
-	kafkaReader      *kafka.Reader
-	httpPool         *HTTPConnectionPool
-	metricsExporter  *MetricsExporter
+	source           messageSource
+	offsets          *OffsetTracker
+	work             *partitionWork
+	sinks            *SinkFanOut
+	metricsExporter  *metrics.Exporter
 	integrityChecker *DataIntegrityChecker
-	circuitBreaker   *CircuitBreaker
+	circuitBreaker   *circuit.Breaker
 	shutdownChan     chan struct{}
 	workerCount      int
-	fluentBitForwarder *FluentBitForwarder
+	fluentBitForwarder LogForwarder
 }
 
 type BatchItem struct {
 	Message kafka.Message
 	LogMsg  LogMessage
+	// Cancelled when the message's partition is revoked; nil for sources
+	// without rebalance tracking
+	lease context.Context
 }
 
 func main() {
@@ -372,6 +183,11 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Companion commands that reconcile OpenObserve streams and exit
+	if len(os.Args) > 1 && (os.Args[1] == "-provision-streams" || os.Args[1] == "-check-streams") {
+		os.Exit(runStreamProvisioningCommand(os.Args[1] == "-provision-streams"))
+	}
+
 	cfg := Config{
 		KafkaBrokers:     strings.Split(os.Getenv("KAFKA_BROKERS"), ","),
 		TrackingTable:    os.Getenv("TRACKING_TABLE"),
@@ -379,26 +195,53 @@ func main() {
 		OpenObserveURL:   os.Getenv("OPENOBSERVE_URL"),
 		OpenObserveUser:  os.Getenv("OPENOBSERVE_USER"),
 		OpenObservePass:  os.Getenv("OPENOBSERVE_PASS"),
-		OpenObserveStream: getEnvOrDefault("OPENOBSERVE_STREAM", "aurora_logs"),
-		ConsumerGroup:    getEnvOrDefault("CONSUMER_GROUP", "aurora-processor-group"),
-		MaxConcurrency:   getEnvAsInt("MAX_CONCURRENCY", 10),
-		BatchSize:        getEnvAsInt("BATCH_SIZE", 100),
-		BatchTimeout:     time.Duration(getEnvAsInt("BATCH_TIMEOUT_SEC", 5)) * time.Second,
+		OpenObserveStream: env.Get("OPENOBSERVE_STREAM", "aurora_logs"),
+		ConsumerGroup:    env.Get("CONSUMER_GROUP", "aurora-processor-group"),
+		MaxConcurrency:   env.Int("MAX_CONCURRENCY", 10),
+		BatchSize:        env.Int("BATCH_SIZE", 100),
+		BatchTimeout:     time.Duration(env.Int("BATCH_TIMEOUT_SEC", 5)) * time.Second,
 		Region:           os.Getenv("AWS_REGION"),
 		// Checkpoint and retry configuration
-		CheckpointTable:      getEnvOrDefault("CHECKPOINT_TABLE", "aurora-log-checkpoints"),
-		DLQTable:             getEnvOrDefault("DLQ_TABLE", "aurora-log-dlq"),
-		MaxRetries:           getEnvAsInt("MAX_RETRIES", 3),
-		RetryBackoff:         time.Duration(getEnvAsInt("RETRY_BACKOFF_SEC", 5)) * time.Second,
-		CircuitBreakerMax:    getEnvAsInt("CIRCUIT_BREAKER_MAX_FAILURES", 5),
-		CircuitBreakerTimeout: time.Duration(getEnvAsInt("CIRCUIT_BREAKER_TIMEOUT_SEC", 30)) * time.Second,
-		ConnectionPoolSize:   getEnvAsInt("CONNECTION_POOL_SIZE", 100),
-		ConnectionTimeout:    time.Duration(getEnvAsInt("CONNECTION_TIMEOUT_SEC", 30)) * time.Second,
+		CheckpointTable:      env.Get("CHECKPOINT_TABLE", "aurora-log-checkpoints"),
+		DLQTable:             env.Get("DLQ_TABLE", "aurora-log-dlq"),
+		MaxRetries:           env.Int("MAX_RETRIES", 3),
+		RetryBackoff:         time.Duration(env.Int("RETRY_BACKOFF_SEC", 5)) * time.Second,
+		CircuitBreakerMax:    env.Int("CIRCUIT_BREAKER_MAX_FAILURES", 5),
+		CircuitBreakerTimeout: time.Duration(env.Int("CIRCUIT_BREAKER_TIMEOUT_SEC", 30)) * time.Second,
+		ConnectionPoolSize:   env.Int("CONNECTION_POOL_SIZE", 100),
+		ConnectionTimeout:    time.Duration(env.Int("CONNECTION_TIMEOUT_SEC", 30)) * time.Second,
 		// Fluent Bit forwarding configuration
 		LogForwardEnabled:    os.Getenv("LOG_FORWARD_ENABLED") == "true",
-		LogForwardHost:       getEnvOrDefault("LOG_FORWARD_HOST", "localhost"),
-		LogForwardPort:       getEnvOrDefault("LOG_FORWARD_PORT", "24224"),
-		ParsingMode:          getEnvOrDefault("PARSING_MODE", "full"),
+		LogForwardHost:       env.Get("LOG_FORWARD_HOST", "localhost"),
+		LogForwardPort:       env.Get("LOG_FORWARD_PORT", "24224"),
+		LogForwardAckTimeout: time.Duration(env.Int("LOG_FORWARD_ACK_TIMEOUT_SEC", 10)) * time.Second,
+		LogForwardRetries:    env.Int("LOG_FORWARD_RETRIES", 3),
+		LogForwardTLS: commontls.FileConfig{
+			Enabled:            os.Getenv("LOG_FORWARD_TLS_ENABLED") == "true",
+			CAFile:             os.Getenv("LOG_FORWARD_TLS_CA_FILE"),
+			CertFile:           os.Getenv("LOG_FORWARD_TLS_CERT_FILE"),
+			KeyFile:            os.Getenv("LOG_FORWARD_TLS_KEY_FILE"),
+			ServerName:         os.Getenv("LOG_FORWARD_TLS_SERVER_NAME"),
+			InsecureSkipVerify: os.Getenv("LOG_FORWARD_TLS_INSECURE_SKIP_VERIFY") == "true",
+		},
+		LogForwardSharedKeyFile: os.Getenv("LOG_FORWARD_SHARED_KEY_FILE"),
+		LogForwardUsernameFile:  os.Getenv("LOG_FORWARD_USERNAME_FILE"),
+		LogForwardPasswordFile:  os.Getenv("LOG_FORWARD_PASSWORD_FILE"),
+		LogForwardPool: ForwarderPoolConfig{
+			Discovery:              env.Get("LOG_FORWARD_DISCOVERY", DiscoveryStatic),
+			ServiceName:            os.Getenv("LOG_FORWARD_SERVICE_NAME"),
+			Balancing:              env.Get("LOG_FORWARD_BALANCING", BalanceRoundRobin),
+			ConnectionsPerUpstream: env.Int("LOG_FORWARD_CONNECTIONS_PER_UPSTREAM", 2),
+			HealthCheckInterval:    time.Duration(env.Int("LOG_FORWARD_HEALTH_CHECK_SEC", 10)) * time.Second,
+			DiscoveryInterval:      time.Duration(env.Int("LOG_FORWARD_DISCOVERY_INTERVAL_SEC", 30)) * time.Second,
+			MinBackoff:             time.Second,
+			MaxBackoff:             time.Duration(env.Int("LOG_FORWARD_MAX_BACKOFF_SEC", 30)) * time.Second,
+		},
+		ParsingMode:          env.Get("PARSING_MODE", "full"),
+		Sinks:                strings.Split(env.Get("SINKS", "openobserve"), ","),
+		MaxInFlightPerPartition: env.Int("KAFKA_MAX_IN_FLIGHT_PER_PARTITION", 100),
+		HandoffTimeout:          time.Duration(env.Int("KAFKA_REVOKE_TIMEOUT_SEC", 20)) * time.Second,
+		Transport:               env.Get("NOTIFICATION_TRANSPORT", transportKafka),
 	}
 	
 	// Log configuration mode
@@ -406,6 +249,10 @@ func main() {
 		slog.Info("Fluent Bit forwarding enabled", 
 			"host", cfg.LogForwardHost, 
 			"port", cfg.LogForwardPort,
+			"tls", cfg.LogForwardTLS.Enabled,
+			"shared_key_auth", cfg.LogForwardSharedKeyFile != "",
+			"discovery", cfg.LogForwardPool.Discovery,
+			"balancing", cfg.LogForwardPool.Balancing,
 			"parsing_mode", cfg.ParsingMode)
 	} else {
 		slog.Info("Using direct OpenObserve integration", "parsing_mode", cfg.ParsingMode)
@@ -425,48 +272,145 @@ func main() {
 		os.Exit(1)
 	}
 
-	kafkaReader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:        cfg.KafkaBrokers,
-		GroupTopics:    []string{"aurora-error-logs", "aurora-slowquery-logs"},
-		GroupID:        cfg.ConsumerGroup,
-		MinBytes:       10e3, // 10KB
-		MaxBytes:       10e6, // 10MB
-		CommitInterval: time.Second,
-		StartOffset:    kafka.FirstOffset,
-		ErrorLogger:    kafka.LoggerFunc(func(msg string, args ...interface{}) {
+	// Streams and topics share their names, so both transports route by
+	// the same configuration
+	kafkaTopics := commonkafka.LoadTopicsConfig()
+	var source messageSource
+	switch cfg.Transport {
+	case transportKafka:
+		kafkaSecurity := commonkafka.LoadSecurityConfig()
+		kafkaDialer, err := kafkaSecurity.Dialer(awsCfg)
+		if err != nil {
+			slog.Error("Failed to configure Kafka security", "error", err)
+			os.Exit(1)
+		}
+		kafkaTransport, err := kafkaSecurity.Transport(awsCfg)
+		if err != nil {
+			slog.Error("Failed to configure Kafka security", "error", err)
+			os.Exit(1)
+		}
+
+		if err := commonkafka.ProvisionTopics(cfg.KafkaBrokers, kafkaTransport, kafkaTopics); err != nil {
+			slog.Error("Kafka topics are not ready", "error", err)
+			os.Exit(1)
+		}
+
+		kafkaErrorLogger := kafka.LoggerFunc(func(msg string, args ...interface{}) {
 			slog.Error("Kafka error", "msg", fmt.Sprintf(msg, args...))
-		}),
-	})
+		})
+		// Partitions are read through the consumer group directly so the
+		// processor sees assignments and revocations
+		consumer, err := NewPartitionConsumer(kafka.ConsumerGroupConfig{
+			ID:               cfg.ConsumerGroup,
+			Brokers:          cfg.KafkaBrokers,
+			Topics:           kafkaTopics.Names(),
+			StartOffset:      kafka.FirstOffset,
+			RebalanceTimeout: cfg.HandoffTimeout + 10*time.Second,
+			Dialer:           kafkaDialer,
+			ErrorLogger:      kafkaErrorLogger,
+		}, kafka.ReaderConfig{
+			Brokers:     cfg.KafkaBrokers,
+			Dialer:      kafkaDialer,
+			MinBytes:    10e3, // 10KB
+			MaxBytes:    10e6, // 10MB
+			ErrorLogger: kafkaErrorLogger,
+		})
+		if err != nil {
+			slog.Error("Failed to create Kafka consumer", "error", err)
+			os.Exit(1)
+		}
+		source = consumer
+	case transportValkey:
+		streamsConfig := loadValkeyStreamsConfig(cfg.ConsumerGroup)
+		streamsClient, err := newValkeyClient(streamsConfig.URL)
+		if err != nil {
+			slog.Error("Failed to configure Valkey streams", "error", err)
+			os.Exit(1)
+		}
+		joinCtx, joinCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		streamSource, err := NewValkeyStreamSource(joinCtx, streamsClient, streamsConfig, kafkaTopics.Names())
+		joinCancel()
+		if err != nil {
+			slog.Error("Failed to join Valkey consumer group", "error", err)
+			os.Exit(1)
+		}
+		source = streamSource
+	default:
+		slog.Error("Unknown notification transport", "transport", cfg.Transport)
+		os.Exit(1)
+	}
+	slog.Info("Consuming notifications", "transport", cfg.Transport, "topics", kafkaTopics.Names())
 	defer func() {
-		if err := kafkaReader.Close(); err != nil {
-			slog.Error("Failed to close kafka reader", "error", err)
+		if err := source.Close(); err != nil {
+			slog.Error("Failed to close notification consumer", "error", err)
 		}
 	}()
 
-	metricsExporter := NewMetricsExporter(
+	metricsExporter := metrics.NewExporter(
 		cfg.OpenObserveURL,
 		cfg.OpenObserveUser,
 		cfg.OpenObservePass,
 	)
 
-	// Create Fluent Bit forwarder if enabled
-	var fluentBitForwarder *FluentBitForwarder
+	// Create Fluent Bit forwarder pool if enabled
+	var fluentBitForwarder LogForwarder
 	if cfg.LogForwardEnabled {
-		fluentBitForwarder = NewFluentBitForwarder(cfg.LogForwardHost, cfg.LogForwardPort)
-		if err := fluentBitForwarder.Connect(); err != nil {
-			slog.Warn("Failed to connect to Fluent Bit, will retry", "error", err)
+		tlsConfig, err := cfg.LogForwardTLS.Build()
+		if err != nil {
+			slog.Error("Invalid Fluent Bit TLS configuration", "error", err)
+			os.Exit(1)
 		}
-		defer fluentBitForwarder.Close()
+
+		poolCfg := cfg.LogForwardPool
+		poolCfg.Port = cfg.LogForwardPort
+		if hosts := os.Getenv("LOG_FORWARD_HOSTS"); hosts != "" {
+			poolCfg.Hosts = strings.Split(hosts, ",")
+		} else {
+			poolCfg.Hosts = []string{net.JoinHostPort(cfg.LogForwardHost, cfg.LogForwardPort)}
+		}
+		if poolCfg.ServiceName == "" {
+			poolCfg.ServiceName = cfg.LogForwardHost
+		}
+
+		pool, err := NewForwarderPool(poolCfg, FluentForwardOptions{
+			AckTimeout: cfg.LogForwardAckTimeout,
+			MaxRetries: cfg.LogForwardRetries,
+			TLS:        tlsConfig,
+			SharedKey:  commontls.NewFileSecret(cfg.LogForwardSharedKeyFile),
+			Username:   commontls.NewFileSecret(cfg.LogForwardUsernameFile),
+			Password:   commontls.NewFileSecret(cfg.LogForwardPasswordFile),
+		})
+		if err != nil {
+			slog.Error("Invalid Fluent Bit upstream configuration", "error", err)
+			os.Exit(1)
+		}
+		if err := pool.Start(context.Background()); err != nil {
+			slog.Error("Failed to discover Fluent Bit upstreams", "error", err)
+			os.Exit(1)
+		}
+		defer pool.Close()
+		fluentBitForwarder = pool
+	}
+
+	// Create output sinks; each gets its own queue, batching and circuit breaker
+	sinks, err := buildSinks(cfg, awsCfg, NewHTTPConnectionPool(cfg.ConnectionPoolSize, cfg.ConnectionTimeout), fluentBitForwarder, metricsExporter)
+	if err != nil {
+		slog.Error("Failed to configure sinks", "error", err)
+		os.Exit(1)
 	}
+	sinks.Start()
+	slog.Info("Output sinks configured", "sinks", sinks.Names())
 
 	processor := &BatchProcessor{
 		config:           cfg,
 		rdsClient:        rds.NewFromConfig(awsCfg),
 		dynamoClient:     dynamodb.NewFromConfig(awsCfg),
-		kafkaReader:      kafkaReader,
-		httpPool:         NewHTTPConnectionPool(cfg.ConnectionPoolSize, cfg.ConnectionTimeout),
+		source:           source,
+		offsets:          NewOffsetTracker(source, cfg.MaxInFlightPerPartition),
+		work:             newPartitionWork(),
+		sinks:            sinks,
 		metricsExporter:  metricsExporter,
-		circuitBreaker:   NewCircuitBreaker(cfg.CircuitBreakerMax, cfg.CircuitBreakerTimeout),
+		circuitBreaker:   circuit.NewBreaker(cfg.CircuitBreakerMax, cfg.CircuitBreakerTimeout),
 		shutdownChan:     make(chan struct{}),
 		workerCount:      cfg.MaxConcurrency,
 		fluentBitForwarder: fluentBitForwarder,
@@ -478,6 +422,14 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	switch source := source.(type) {
+	case *PartitionConsumer:
+		source.OnRevoked = processor.partitionRevoked
+		go source.Run(ctx)
+	case *ValkeyStreamSource:
+		go source.Run(ctx)
+	}
+
 	go func() {
 		sig := <-sigChan
 		slog.Info("Received shutdown signal", "signal", sig)
@@ -489,6 +441,13 @@ func main() {
 		slog.Error("Processor failed", "error", err)
 		os.Exit(1)
 	}
+
+	// Drain sink queues before exiting
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer closeCancel()
+	if err := sinks.Close(closeCtx); err != nil {
+		slog.Error("Failed to close sinks", "error", err)
+	}
 }
 
 func (bp *BatchProcessor) Start(ctx context.Context) error {
@@ -548,7 +507,7 @@ func (bp *BatchProcessor) batchCollector(ctx context.Context, itemsChan chan<- B
 			
 		default:
 			// Fetch message
-			msg, err := bp.kafkaReader.FetchMessage(ctx)
+			msg, lease, err := bp.fetchMessage(ctx)
 			if err != nil {
 				if ctx.Err() != nil || errors.Is(err, io.EOF) {
 					return
@@ -556,19 +515,48 @@ func (bp *BatchProcessor) batchCollector(ctx context.Context, itemsChan chan<- B
 				continue
 			}
 			
+			// Cap uncommitted messages per partition; when the partition is
+			// full, hand the queued batch to workers before waiting for one
+			// of them to finish
+			if !bp.offsets.TryTrack(msg) {
+				if len(batch) > 0 {
+					bp.processBatch(ctx, batch, itemsChan)
+					batch = make([]BatchItem, 0, bp.config.BatchSize)
+				}
+				if err := bp.offsets.Track(ctx, msg); err != nil {
+					return
+				}
+			}
+			
+			// The partition was revoked while this message waited; its next
+			// owner reads it again
+			if lease != nil && lease.Err() != nil {
+				bp.offsets.Forget(msg)
+				continue
+			}
+			
 			// Parse message
-			var logMsg LogMessage
-			if err := json.Unmarshal(msg.Value, &logMsg); err != nil {
-				slog.Error("Failed to unmarshal message", "error", err)
-				if err := bp.kafkaReader.CommitMessages(ctx, msg); err != nil {
-					slog.Error("Failed to commit message", "error", err)
+			logMsg, err := decodeLogMessage(msg)
+			if errors.Is(err, message.ErrUnsupportedSchemaVersion) {
+				slog.Error("Sending message with unsupported schema to DLQ", "error", err,
+					"partition", msg.Partition, "offset", msg.Offset)
+				bp.metricsExporter.RecordError("processor", "unsupported_schema_version")
+				if dlqErr := bp.sendToDLQ(ctx, BatchItem{Message: msg}, err); dlqErr != nil {
+					slog.Error("Failed to send to DLQ", "error", dlqErr)
 				}
+				bp.completeMessage(ctx, msg)
+				continue
+			}
+			if err != nil {
+				slog.Error("Failed to unmarshal message", "error", err)
+				bp.completeMessage(ctx, msg)
 				continue
 			}
 			
 			batch = append(batch, BatchItem{
 				Message: msg,
 				LogMsg:  logMsg,
+				lease:   lease,
 			})
 			
 			if len(batch) >= bp.config.BatchSize {
@@ -579,6 +567,16 @@ func (bp *BatchProcessor) batchCollector(ctx context.Context, itemsChan chan<- B
 	}
 }
 
+// fetchMessage returns the next message and, for rebalance-aware sources,
+// the lease of its partition
+func (bp *BatchProcessor) fetchMessage(ctx context.Context) (kafka.Message, context.Context, error) {
+	if leased, ok := bp.source.(leasedSource); ok {
+		return leased.FetchLeasedMessage(ctx)
+	}
+	msg, err := bp.source.FetchMessage(ctx)
+	return msg, nil, err
+}
+
 func (bp *BatchProcessor) processBatch(ctx context.Context, batch []BatchItem, itemsChan chan<- BatchItem) {
 	// Group by instance for efficient processing
 	grouped := make(map[string][]BatchItem)
@@ -608,70 +606,153 @@ func (bp *BatchProcessor) worker(ctx context.Context, workerID int, itemsChan <-
 			if !ok {
 				return
 			}
+			bp.processItem(ctx, workerID, item)
 			
-			// Process with retry logic
-			var err error
-			retryCount := 0
-			
-			for retryCount <= bp.config.MaxRetries {
-				err = bp.circuitBreaker.Call(func() error {
-					return bp.processLogOptimized(ctx, item.LogMsg)
-				})
-				
-				if err == nil {
-					// Success - commit message
-					if commitErr := bp.kafkaReader.CommitMessages(ctx, item.Message); commitErr != nil {
-						slog.Error("Failed to commit message", "error", commitErr)
-						// Don't retry on commit errors
-					}
-					break
-				}
-				
-				if retryCount < bp.config.MaxRetries {
-					slog.Warn("Retrying failed log processing",
-						"worker", workerID,
-						"instance", item.LogMsg.InstanceID,
-						"file", item.LogMsg.LogFileName,
-						"retry", retryCount+1,
-						"error", err)
-					
-					// Exponential backoff
-					backoff := time.Duration(retryCount+1) * bp.config.RetryBackoff
-					select {
-					case <-time.After(backoff):
-					case <-ctx.Done():
-						return
-					}
-				}
-				
-				retryCount++
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// processItem processes one file with retries. Work stops when the item's
+// partition is revoked or the processor shuts down; the file is then
+// checkpointed and neither retried, sent to the DLQ nor committed, so the
+// partition's next owner resumes it.
+func (bp *BatchProcessor) processItem(ctx context.Context, workerID int, item BatchItem) {
+	itemCtx, release := bp.leaseContext(ctx, item)
+	defer release()
+	
+	if itemCtx.Err() != nil {
+		slog.Info("Skipping file from revoked partition",
+			"instance", item.LogMsg.InstanceID,
+			"file", item.LogMsg.LogFileName,
+			"partition", item.Message.Partition)
+		return
+	}
+	
+	bp.work.begin(item.Message.Topic, item.Message.Partition)
+	defer bp.work.end(item.Message.Topic, item.Message.Partition)
+	
+	provenance := item.LogMsg.Provenance
+	if !provenance.PublishedAt.IsZero() {
+		bp.metricsExporter.RecordDuration("publish_to_processing_lag", time.Since(provenance.PublishedAt))
+	}
+	slog.Debug("Processing log file",
+		"worker", workerID,
+		"instance", item.LogMsg.InstanceID,
+		"file", item.LogMsg.LogFileName,
+		"scan_id", provenance.ScanID,
+		"trace_id", provenance.TraceID())
+	
+	// Process with retry logic
+	var err error
+	retryCount := 0
+	
+	for retryCount <= bp.config.MaxRetries {
+		handedOff := false
+		err = bp.circuitBreaker.Call(func() error {
+			err := bp.processLogOptimized(itemCtx, item.LogMsg)
+			// A handoff is not a failure of the file or its dependencies
+			if errors.Is(err, errHandedOff) {
+				handedOff = true
+				return nil
 			}
+			return err
+		})
+		
+		if err == nil && !handedOff {
+			// Success - commit once every earlier offset is done too
+			bp.completeMessage(ctx, item.Message)
+			return
+		}
+		
+		if handedOff || itemCtx.Err() != nil {
+			slog.Info("Stopped processing file for handoff",
+				"worker", workerID,
+				"instance", item.LogMsg.InstanceID,
+				"file", item.LogMsg.LogFileName,
+				"partition", item.Message.Partition,
+				"error", err)
+			return
+		}
+		
+		if retryCount < bp.config.MaxRetries {
+			slog.Warn("Retrying failed log processing",
+				"worker", workerID,
+				"instance", item.LogMsg.InstanceID,
+				"file", item.LogMsg.LogFileName,
+				"retry", retryCount+1,
+				"error", err)
 			
-			if err != nil {
-				// All retries failed - send to DLQ
-				slog.Error("Failed to process log after retries", 
-					"worker", workerID,
-					"instance", item.LogMsg.InstanceID,
-					"file", item.LogMsg.LogFileName,
-					"retries", bp.config.MaxRetries,
-					"error", err)
-				
-				bp.metricsExporter.RecordError("processor", "processing_failed_all_retries")
-				
-				// Send to DLQ
-				if dlqErr := bp.sendToDLQ(ctx, item, err); dlqErr != nil {
-					slog.Error("Failed to send to DLQ", "error", dlqErr)
-				}
-				
-				// Commit message anyway to avoid reprocessing
-				if commitErr := bp.kafkaReader.CommitMessages(ctx, item.Message); commitErr != nil {
-					slog.Error("Failed to commit failed message", "error", commitErr)
-				}
+			// Exponential backoff
+			backoff := time.Duration(retryCount+1) * bp.config.RetryBackoff
+			select {
+			case <-time.After(backoff):
+			case <-itemCtx.Done():
+				return
 			}
-			
-		case <-ctx.Done():
-			return
 		}
+		
+		retryCount++
+	}
+	
+	// All retries failed - send to DLQ
+	slog.Error("Failed to process log after retries", 
+		"worker", workerID,
+		"instance", item.LogMsg.InstanceID,
+		"file", item.LogMsg.LogFileName,
+		"retries", bp.config.MaxRetries,
+		"trace_id", provenance.TraceID(),
+		"error", err)
+	
+	bp.metricsExporter.RecordError("processor", "processing_failed_all_retries")
+	
+	// Send to DLQ
+	if dlqErr := bp.sendToDLQ(ctx, item, err); dlqErr != nil {
+		slog.Error("Failed to send to DLQ", "error", dlqErr)
+	}
+	
+	// Commit message anyway to avoid reprocessing
+	bp.completeMessage(ctx, item.Message)
+}
+
+// leaseContext derives the context an item is processed under; it is
+// cancelled with ctx or when the item's partition is revoked
+func (bp *BatchProcessor) leaseContext(ctx context.Context, item BatchItem) (context.Context, context.CancelFunc) {
+	itemCtx, cancel := context.WithCancel(ctx)
+	if item.lease == nil {
+		return itemCtx, cancel
+	}
+	if item.lease.Err() != nil {
+		cancel()
+		return itemCtx, cancel
+	}
+	stop := context.AfterFunc(item.lease, cancel)
+	return itemCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// partitionRevoked runs before the consumer gives up a partition. Files
+// being processed were cancelled with the partition's lease; once they have
+// checkpointed (or the handoff timeout passes) the partition's offset state
+// is dropped.
+func (bp *BatchProcessor) partitionRevoked(topic string, partition int) {
+	ctx, cancel := context.WithTimeout(context.Background(), bp.config.HandoffTimeout)
+	defer cancel()
+	if err := bp.work.wait(ctx, topic, partition); err != nil {
+		slog.Warn("Files of revoked partition did not stop in time", "topic", topic, "partition", partition, "timeout", bp.config.HandoffTimeout)
+	}
+	bp.offsets.Revoke(topic, partition)
+}
+
+// completeMessage marks a message processed; the tracker commits its
+// partition up to the highest contiguous processed offset
+func (bp *BatchProcessor) completeMessage(ctx context.Context, msg kafka.Message) {
+	if err := bp.offsets.Complete(ctx, msg); err != nil {
+		// Don't retry on commit errors; a later commit covers this offset
+		slog.Error("Failed to commit message", "topic", msg.Topic, "partition", msg.Partition, "offset", msg.Offset, "error", err)
 	}
 }
 
@@ -679,6 +760,16 @@ func (bp *BatchProcessor) worker(ctx context.Context, workerID int, itemsChan <-
 func extractTimestampFromLine(line string, logType string) time.Time {
 	switch logType {
 	case "error":
+		// Aurora MySQL error log: "2025-08-04T05:30:23.573848Z 58699 [Note] ..."
+		if len(line) > 20 && line[10] == 'T' {
+			field := line
+			if idx := strings.IndexByte(line, ' '); idx > 0 {
+				field = line[:idx]
+			}
+			if ts, err := time.Parse(time.RFC3339Nano, field); err == nil {
+				return ts
+			}
+		}
 		// MySQL error log: "2025-08-02 12:34:56"
 		if len(line) >= 19 {
 			if ts, err := time.Parse("2006-01-02 15:04:05", line[:19]); err == nil {
@@ -720,6 +811,25 @@ func extractTimestampFromLine(line string, logType string) time.Time {
 	return time.Time{}
 }
 
+// lineTimestamp returns the timestamp of a log line. Lines without their own
+// timestamp (stack traces, SQL text, wrapped messages) continue the previous
+// entry and inherit its timestamp.
+func lineTimestamp(line, logType string, previous time.Time) time.Time {
+	if ts := extractTimestampFromLine(line, logType); !ts.IsZero() {
+		return ts
+	}
+	return previous
+}
+
+// fileTimestamp is the fallback for lines before the first timestamped line:
+// the time RDS reports the file was last written, in milliseconds
+func fileTimestamp(logMsg LogMessage) time.Time {
+	if logMsg.LastWritten > 0 {
+		return time.UnixMilli(logMsg.LastWritten)
+	}
+	return time.Now()
+}
+
 // forwardLogToFluentBit sends raw logs to Fluent Bit via TCP forward protocol
 func (bp *BatchProcessor) forwardLogToFluentBit(ctx context.Context, logMsg LogMessage) error {
 	if bp.fluentBitForwarder == nil {
@@ -728,14 +838,27 @@ func (bp *BatchProcessor) forwardLogToFluentBit(ctx context.Context, logMsg LogM
 
 	slog.Info("Forwarding log to Fluent Bit", "instance_id", logMsg.InstanceID, "file", logMsg.LogFileName)
 	
+	// Every line before the checkpoint was acknowledged by Fluent Bit
+	checkpointMarker, checkpointLines, err := bp.getCheckpoint(ctx, logMsg)
+	if err != nil {
+		slog.Warn("Failed to get checkpoint", "error", err)
+	}
+	if checkpointMarker != "" {
+		slog.Info("Resuming from checkpoint", "marker", checkpointMarker, "lines", checkpointLines)
+	}
+	
 	// Update status to 'processing'
 	if err := bp.updateLogStatus(ctx, logMsg, "processing", "", 0); err != nil {
 		slog.Error("Failed to update status to processing", "error", err)
 	}
 	
-	// Download log with streaming
-	reader, err := bp.downloadLogStreaming(ctx, logMsg, "")
+	// Download log with streaming from checkpoint
+	checkpoint := downloadMarker{marker: checkpointMarker, lines: checkpointLines}
+	reader, err := bp.downloadLogStreaming(ctx, logMsg, checkpoint)
 	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w: %v", errHandedOff, err)
+		}
 		// Update status to 'failed'
 		if statusErr := bp.updateLogStatus(ctx, logMsg, "failed", fmt.Sprintf("Download failed: %v", err), 0); statusErr != nil {
 			slog.Error("Failed to update status to failed", "error", statusErr)
@@ -744,40 +867,49 @@ func (bp *BatchProcessor) forwardLogToFluentBit(ctx context.Context, logMsg LogM
 	}
 	defer reader.Close()
 	
+	markerChan := make(chan downloadMarker, 100)
+	doneChan := make(chan struct{})
+	if mtr, ok := reader.(*markerTrackingReader); ok {
+		go bp.trackDownloadMarkers(ctx, logMsg, mtr, markerChan, doneChan)
+	}
+	
 	// Determine tag based on log type
 	tag := fmt.Sprintf("aurora.%s", logMsg.LogType)
 	
 	scanner := bufio.NewScanner(reader)
 	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024) // 64KB initial, 1MB max
 	
-	lineCount := 0
+	lineCount := checkpointLines
+	lastCheckpointLines := checkpointLines
+	var downloaded []downloadMarker
 	batchCount := 0
-	batch := make([]map[string]interface{}, 0, 100)
+	batch := make([]ForwardEntry, 0, 100)
 	
-	// For passthrough mode, we still need to extract timestamp from first line
-	var logTimestamp time.Time
-	timestampExtracted := false
+	// Every line carries its own timestamp; continuation lines inherit the
+	// last one seen and leading lines fall back to the file's LastWritten
+	entryTime := fileTimestamp(logMsg)
 	
 	for scanner.Scan() {
+		// Partition revoked or shutting down
+		if ctx.Err() != nil {
+			break
+		}
+		
 		line := scanner.Text()
 		lineCount++
 		
-		// Try to extract timestamp from first few lines
-		if !timestampExtracted && lineCount <= 10 {
-			ts := extractTimestampFromLine(line, logMsg.LogType)
-			if !ts.IsZero() {
-				logTimestamp = ts
-				timestampExtracted = true
-				slog.Debug("Extracted timestamp from log", "timestamp", logTimestamp)
-			}
+		select {
+		case newMarker := <-markerChan:
+			downloaded = append(downloaded, newMarker)
+		default:
 		}
-		
-		// Use extracted timestamp or current time
-		entryTime := logTimestamp
-		if entryTime.IsZero() {
-			entryTime = time.Now()
+		for len(downloaded) > 0 && downloaded[0].lines <= lineCount {
+			checkpoint = downloaded[0]
+			downloaded = downloaded[1:]
 		}
 		
+		entryTime = lineTimestamp(line, logMsg.LogType, entryTime)
+		
 		// Create minimal record with raw log line
 		record := map[string]interface{}{
 			"message":       line,
@@ -788,32 +920,34 @@ func (bp *BatchProcessor) forwardLogToFluentBit(ctx context.Context, logMsg LogM
 			"line_number":   lineCount,
 		}
 		
-		batch = append(batch, record)
+		batch = append(batch, ForwardEntry{Time: entryTime, Record: record})
 		
-		// Send batch when full
+		// Send batch when full; a chunk that is never acknowledged fails the file
 		if len(batch) >= 100 {
-			for _, entry := range batch {
-				if err := bp.fluentBitForwarder.Forward(tag, entryTime, entry); err != nil {
-					slog.Warn("Failed to forward to Fluent Bit", "error", err)
+			if err := bp.fluentBitForwarder.ForwardBatch(ctx, tag, batch); err != nil {
+				if ctx.Err() != nil {
+					break
 				}
+				close(doneChan)
+				return bp.failForwarding(ctx, logMsg, err, lineCount)
 			}
 			batchCount++
 			batch = batch[:0] // Reset batch
+			
+			// Every line so far is acknowledged, so the checkpoint may move
+			if lineCount-lastCheckpointLines >= 10000 && checkpoint.marker != "" {
+				if err := bp.saveCheckpoint(ctx, logMsg, checkpoint.marker, checkpoint.lines); err != nil {
+					slog.Warn("Failed to save checkpoint", "error", err)
+				}
+				lastCheckpointLines = lineCount
+			}
 		}
 	}
 	
-	// Send remaining batch
-	if len(batch) > 0 {
-		entryTime := logTimestamp
-		if entryTime.IsZero() {
-			entryTime = time.Now()
-		}
-		for _, entry := range batch {
-			if err := bp.fluentBitForwarder.Forward(tag, entryTime, entry); err != nil {
-				slog.Warn("Failed to forward to Fluent Bit", "error", err)
-			}
-		}
-		batchCount++
+	close(doneChan)
+	
+	if ctx.Err() != nil {
+		return bp.handOffForwarding(ctx, logMsg, tag, batch, checkpoint)
 	}
 	
 	if err := scanner.Err(); err != nil {
@@ -824,6 +958,21 @@ func (bp *BatchProcessor) forwardLogToFluentBit(ctx context.Context, logMsg LogM
 		return fmt.Errorf("error reading log file: %w", err)
 	}
 	
+	// Send remaining batch
+	if len(batch) > 0 {
+		if err := bp.fluentBitForwarder.ForwardBatch(ctx, tag, batch); err != nil {
+			if ctx.Err() != nil {
+				return bp.handOffForwarding(ctx, logMsg, tag, batch, checkpoint)
+			}
+			return bp.failForwarding(ctx, logMsg, err, lineCount)
+		}
+		batchCount++
+	}
+	
+	if err := bp.deleteCheckpoint(ctx, logMsg); err != nil {
+		slog.Warn("Failed to delete checkpoint", "error", err)
+	}
+	
 	// Update status to 'completed'
 	if err := bp.updateLogStatus(ctx, logMsg, "completed", "", lineCount); err != nil {
 		slog.Error("Failed to update status to completed", "error", err)
@@ -838,6 +987,89 @@ func (bp *BatchProcessor) forwardLogToFluentBit(ctx context.Context, logMsg LogM
 	return nil
 }
 
+// failForwarding marks a file as failed after Fluent Bit did not acknowledge a chunk
+func (bp *BatchProcessor) failForwarding(ctx context.Context, logMsg LogMessage, err error, lineCount int) error {
+	if statusErr := bp.updateLogStatus(ctx, logMsg, "failed", fmt.Sprintf("Forwarding failed: %v", err), lineCount); statusErr != nil {
+		slog.Error("Failed to update status to failed", "error", statusErr)
+	}
+	return fmt.Errorf("forwarding to Fluent Bit failed: %w", err)
+}
+
+// awaitDelivery flushes the sinks' pending batches for logMsg's file and
+// waits until everything handed to them so far is written, uploaded or
+// spooled. final is set once the whole file has been read.
+func (bp *BatchProcessor) awaitDelivery(ctx context.Context, logMsg LogMessage, delivery *SinkDelivery, final bool) error {
+	if err := bp.sinks.Flush(ctx, logMsg, delivery, final); err != nil {
+		slog.Warn("Failed to flush sinks", "error", err)
+	}
+	return delivery.Wait(ctx)
+}
+
+// failDelivery marks a file as failed after a sink neither wrote nor spooled
+// some of its entries. The checkpoint is kept, so a retry resumes from the
+// last delivered position.
+func (bp *BatchProcessor) failDelivery(ctx context.Context, logMsg LogMessage, err error, lineCount int) error {
+	if statusErr := bp.updateLogStatus(ctx, logMsg, "failed", fmt.Sprintf("Sink delivery failed: %v", err), lineCount); statusErr != nil {
+		slog.Error("Failed to update status to failed", "error", statusErr)
+	}
+	return fmt.Errorf("sink delivery failed: %w", err)
+}
+
+// errHandedOff is returned when a file stops because its partition was
+// revoked or the processor is shutting down
+var errHandedOff = errors.New("processing handed off")
+
+// handOff stops a file whose context was cancelled. The entries read so far
+// are delivered and the position is checkpointed, so whoever processes the
+// file next resumes there instead of starting over. checkpoint must not be
+// ahead of the lines parsed so far.
+func (bp *BatchProcessor) handOff(ctx context.Context, logMsg LogMessage, batch []ParsedLogEntry, delivery *SinkDelivery, checkpoint downloadMarker) error {
+	handoffCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), bp.config.HandoffTimeout)
+	defer cancel()
+	
+	if len(batch) > 0 {
+		if err := bp.sinks.WriteBatch(handoffCtx, logMsg, batch, delivery); err != nil {
+			return fmt.Errorf("%w: delivery failed: %v", errHandedOff, err)
+		}
+	}
+	if err := bp.awaitDelivery(handoffCtx, logMsg, delivery, false); err != nil {
+		// Without delivery the old checkpoint stays; the next owner repeats
+		// the entries since then
+		return fmt.Errorf("%w: delivery failed: %v", errHandedOff, err)
+	}
+	return bp.saveHandOffCheckpoint(handoffCtx, logMsg, checkpoint)
+}
+
+// handOffForwarding is handOff for passthrough mode: the lines read so far
+// are forwarded and acknowledged before the position is checkpointed
+func (bp *BatchProcessor) handOffForwarding(ctx context.Context, logMsg LogMessage, tag string, batch []ForwardEntry, checkpoint downloadMarker) error {
+	handoffCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), bp.config.HandoffTimeout)
+	defer cancel()
+	
+	if len(batch) > 0 {
+		if err := bp.fluentBitForwarder.ForwardBatch(handoffCtx, tag, batch); err != nil {
+			// The old checkpoint stays; the next owner repeats the lines since then
+			return fmt.Errorf("%w: forwarding failed: %v", errHandedOff, err)
+		}
+	}
+	return bp.saveHandOffCheckpoint(handoffCtx, logMsg, checkpoint)
+}
+
+func (bp *BatchProcessor) saveHandOffCheckpoint(ctx context.Context, logMsg LogMessage, checkpoint downloadMarker) error {
+	if checkpoint.marker != "" {
+		if err := bp.saveCheckpoint(ctx, logMsg, checkpoint.marker, checkpoint.lines); err != nil {
+			return fmt.Errorf("%w: checkpoint failed: %v", errHandedOff, err)
+		}
+	}
+	
+	slog.Info("Handed off file at checkpoint",
+		"instance_id", logMsg.InstanceID,
+		"file", logMsg.LogFileName,
+		"marker", checkpoint.marker,
+		"lines", checkpoint.lines)
+	return errHandedOff
+}
+
 func (bp *BatchProcessor) processLogOptimized(ctx context.Context, logMsg LogMessage) error {
 	startTime := time.Now()
 	defer func() {
@@ -852,14 +1084,14 @@ func (bp *BatchProcessor) processLogOptimized(ctx context.Context, logMsg LogMes
 	}
 	
 	// Check for existing checkpoint
-	checkpointMarker, err := bp.getCheckpoint(ctx, logMsg)
+	checkpointMarker, checkpointLines, err := bp.getCheckpoint(ctx, logMsg)
 	if err != nil {
 		slog.Warn("Failed to get checkpoint", "error", err)
 		// Continue without checkpoint
 	}
 	
 	if checkpointMarker != "" {
-		slog.Info("Resuming from checkpoint", "marker", checkpointMarker)
+		slog.Info("Resuming from checkpoint", "marker", checkpointMarker, "lines", checkpointLines)
 	}
 	
 	// Update status to 'processing'
@@ -868,8 +1100,12 @@ func (bp *BatchProcessor) processLogOptimized(ctx context.Context, logMsg LogMes
 	}
 	
 	// Download log with streaming from checkpoint
-	reader, err := bp.downloadLogStreaming(ctx, logMsg, checkpointMarker)
+	checkpoint := downloadMarker{marker: checkpointMarker, lines: checkpointLines}
+	reader, err := bp.downloadLogStreaming(ctx, logMsg, checkpoint)
 	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w: %v", errHandedOff, err)
+		}
 		// Update status to 'failed'
 		if statusErr := bp.updateLogStatus(ctx, logMsg, "failed", fmt.Sprintf("Download failed: %v", err), 0); statusErr != nil {
 			slog.Error("Failed to update status to failed", "error", statusErr)
@@ -883,7 +1119,7 @@ func (bp *BatchProcessor) processLogOptimized(ctx context.Context, logMsg LogMes
 	}()
 	
 	// Create a channel to receive markers from download goroutine
-	markerChan := make(chan string, 100)
+	markerChan := make(chan downloadMarker, 100)
 	doneChan := make(chan struct{})
 	
 	// Start marker tracking goroutine if reader supports it
@@ -897,21 +1133,41 @@ func (bp *BatchProcessor) processLogOptimized(ctx context.Context, logMsg LogMes
 	scanner := bufio.NewScanner(reader)
 	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024) // 10MB max line
 	
-	lineCount := 0
+	// Line numbers count from the start of the file, so a resumed file
+	// numbers its lines as the first attempt did
+	lineCount := checkpointLines
 	parsedCount := 0
-	lastCheckpointLines := 0
-	currentMarker := checkpointMarker
+	lastCheckpointLines := checkpointLines
+	
+	// Markers arrive as soon as their portion is downloaded, while its lines
+	// may still wait in the scanner; one only becomes the checkpoint once
+	// every line before it is parsed
+	var downloaded []downloadMarker
+	
+	// Tracks every batch until the sinks wrote or spooled it; checkpoints and
+	// the completed status only move past delivered data
+	delivery := NewSinkDelivery()
+	var deliveryErr error
 	
 	for scanner.Scan() {
+		// Partition revoked or shutting down
+		if ctx.Err() != nil {
+			break
+		}
+		
 		line := scanner.Text()
 		lineCount++
 		
 		// Check for new marker
 		select {
 		case newMarker := <-markerChan:
-			currentMarker = newMarker
+			downloaded = append(downloaded, newMarker)
 		default:
 		}
+		for len(downloaded) > 0 && downloaded[0].lines <= lineCount {
+			checkpoint = downloaded[0]
+			downloaded = downloaded[1:]
+		}
 		
 		// Parse line
 		entry := parser(line)
@@ -921,6 +1177,7 @@ func (bp *BatchProcessor) processLogOptimized(ctx context.Context, logMsg LogMes
 			entry["instance_id"] = logMsg.InstanceID
 			entry["cluster_id"] = logMsg.ClusterID
 			entry["log_file_name"] = logMsg.LogFileName
+			entry["line_number"] = lineCount
 			
 			// Use the log's original timestamp if available, otherwise use current time
 			if ts, ok := entry["timestamp"].(string); ok && ts != "" {
@@ -942,14 +1199,19 @@ func (bp *BatchProcessor) processLogOptimized(ctx context.Context, logMsg LogMes
 			
 			// Send batch when full
 			if len(batch) >= 1000 {
-				if err := bp.sendBatch(ctx, logMsg, batch); err != nil {
-					slog.Warn("Failed to send batch", "error", err)
+				if err := bp.sinks.WriteBatch(ctx, logMsg, batch, delivery); err != nil {
+					deliveryErr = err
+					break
 				}
 				batch = make([]ParsedLogEntry, 0, 1000)
 				
-				// Save checkpoint every 10000 lines
-				if lineCount-lastCheckpointLines >= 10000 && currentMarker != "" {
-					if err := bp.saveCheckpoint(ctx, logMsg, currentMarker, lineCount); err != nil {
+				// Save checkpoint every 10000 lines, once everything before it is delivered
+				if lineCount-lastCheckpointLines >= 10000 && checkpoint.marker != "" {
+					if err := bp.awaitDelivery(ctx, logMsg, delivery, false); err != nil {
+						deliveryErr = err
+						break
+					}
+					if err := bp.saveCheckpoint(ctx, logMsg, checkpoint.marker, checkpoint.lines); err != nil {
 						slog.Warn("Failed to save checkpoint", "error", err)
 					}
 					lastCheckpointLines = lineCount
@@ -960,6 +1222,14 @@ func (bp *BatchProcessor) processLogOptimized(ctx context.Context, logMsg LogMes
 	
 	close(doneChan)
 	
+	if ctx.Err() != nil {
+		return bp.handOff(ctx, logMsg, batch, delivery, checkpoint)
+	}
+	
+	if deliveryErr != nil {
+		return bp.failDelivery(ctx, logMsg, deliveryErr, lineCount)
+	}
+	
 	if err := scanner.Err(); err != nil {
 		// Update status to 'failed'
 		if statusErr := bp.updateLogStatus(ctx, logMsg, "failed", fmt.Sprintf("Scanner error: %v", err), lineCount); statusErr != nil {
@@ -970,11 +1240,19 @@ func (bp *BatchProcessor) processLogOptimized(ctx context.Context, logMsg LogMes
 	
 	// Send final batch
 	if len(batch) > 0 {
-		if err := bp.sendBatch(ctx, logMsg, batch); err != nil {
-			slog.Warn("Failed to send final batch", "error", err)
+		if err := bp.sinks.WriteBatch(ctx, logMsg, batch, delivery); err != nil {
+			return bp.failDelivery(ctx, logMsg, err, lineCount)
 		}
 	}
 	
+	// Only complete the file once every sink wrote or spooled its entries
+	if err := bp.awaitDelivery(ctx, logMsg, delivery, true); err != nil {
+		if ctx.Err() != nil {
+			return bp.handOff(ctx, logMsg, nil, delivery, checkpoint)
+		}
+		return bp.failDelivery(ctx, logMsg, err, lineCount)
+	}
+	
 	// Delete checkpoint on successful completion
 	if err := bp.deleteCheckpoint(ctx, logMsg); err != nil {
 		slog.Warn("Failed to delete checkpoint", "error", err)
@@ -996,13 +1274,20 @@ func (bp *BatchProcessor) processLogOptimized(ctx context.Context, logMsg LogMes
 	return nil
 }
 
+// downloadMarker is the RDS marker after a downloaded portion and the number
+// of lines of the file before it
+type downloadMarker struct {
+	marker string
+	lines  int
+}
+
 // Custom reader that tracks markers
 type markerTrackingReader struct {
 	*io.PipeReader
-	markerChan chan string
+	markerChan chan downloadMarker
 }
 
-func (r *markerTrackingReader) SendMarker(marker string) {
+func (r *markerTrackingReader) SendMarker(marker downloadMarker) {
 	select {
 	case r.markerChan <- marker:
 	default:
@@ -1010,14 +1295,15 @@ func (r *markerTrackingReader) SendMarker(marker string) {
 	}
 }
 
-// Streaming download implementation with marker tracking
-func (bp *BatchProcessor) downloadLogStreaming(ctx context.Context, logMsg LogMessage, startMarker string) (io.ReadCloser, error) {
+// Streaming download implementation with marker tracking. Lines are counted
+// from start, which the caller resumes from.
+func (bp *BatchProcessor) downloadLogStreaming(ctx context.Context, logMsg LogMessage, start downloadMarker) (io.ReadCloser, error) {
 	pr, pw := io.Pipe()
 	
 	// Create custom reader that exposes marker channel
 	mtr := &markerTrackingReader{
 		PipeReader: pr,
-		markerChan: make(chan string, 100),
+		markerChan: make(chan downloadMarker, 100),
 	}
 	
 	go func() {
@@ -1028,10 +1314,12 @@ func (bp *BatchProcessor) downloadLogStreaming(ctx context.Context, logMsg LogMe
 			close(mtr.markerChan)
 		}()
 		
-		marker := startMarker
+		marker := start.marker
 		if marker == "" || marker == "end" {
 			marker = "0"
 		}
+		lines := start.lines
+		partialLine := false
 		
 		for {
 			select {
@@ -1054,20 +1342,26 @@ func (bp *BatchProcessor) downloadLogStreaming(ctx context.Context, logMsg LogMe
 			}
 			
 			if output.LogFileData != nil && len(*output.LogFileData) > 0 {
-				if _, err := pw.Write([]byte(*output.LogFileData)); err != nil {
+				data := *output.LogFileData
+				if _, err := pw.Write([]byte(data)); err != nil {
 					pw.CloseWithError(err)
 					return
 				}
+				lines += strings.Count(data, "\n")
+				partialLine = !strings.HasSuffix(data, "\n")
 			}
 			
 			// Update marker and send to tracking channel
 			if output.Marker != nil {
 				marker = *output.Marker
-				// Send marker for checkpoint tracking
-				select {
-				case mtr.markerChan <- marker:
-				default:
-					// Channel full, skip
+				// Send marker for checkpoint tracking, unless resuming there
+				// would split the last line
+				if !partialLine {
+					select {
+					case mtr.markerChan <- downloadMarker{marker: marker, lines: lines}:
+					default:
+						// Channel full, skip
+					}
 				}
 			}
 			
@@ -1082,7 +1376,7 @@ func (bp *BatchProcessor) downloadLogStreaming(ctx context.Context, logMsg LogMe
 }
 
 // Track download markers for checkpointing
-func (bp *BatchProcessor) trackDownloadMarkers(ctx context.Context, logMsg LogMessage, mtr *markerTrackingReader, markerChan chan<- string, doneChan <-chan struct{}) {
+func (bp *BatchProcessor) trackDownloadMarkers(ctx context.Context, logMsg LogMessage, mtr *markerTrackingReader, markerChan chan<- downloadMarker, doneChan <-chan struct{}) {
 	for {
 		select {
 		case <-ctx.Done():
@@ -1143,68 +1437,6 @@ func (bp *BatchProcessor) updateLogStatus(ctx context.Context, logMsg LogMessage
 	return err
 }
 
-func (bp *BatchProcessor) sendBatch(ctx context.Context, logMsg LogMessage, batch []ParsedLogEntry) error {
-	httpClient := bp.httpPool.Get()
-	defer bp.httpPool.Put(httpClient)
-	
-	// Convert batch to JSON array
-	jsonData, err := json.Marshal(batch)
-	if err != nil {
-		return fmt.Errorf("failed to marshal batch: %w", err)
-	}
-	
-	// Use different streams based on log type
-	streamName := bp.config.OpenObserveStream
-	switch logMsg.LogType {
-	case "error":
-		streamName = "aurora_error_logs"
-	case "slowquery":
-		streamName = "aurora_slowquery_logs"
-	}
-	
-	url := fmt.Sprintf("%s/api/default/%s/_json", bp.config.OpenObserveURL, streamName)
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonData))
-	if err != nil {
-		return err
-	}
-	
-	req.SetBasicAuth(bp.config.OpenObserveUser, bp.config.OpenObservePass)
-	req.Header.Set("Content-Type", "application/json")
-	
-	slog.Info("Sending batch to OpenObserve", 
-		"url", url, 
-		"stream", streamName, 
-		"batch_size", len(batch),
-		"data_size", len(jsonData))
-	
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			slog.Error("Failed to close response body", "error", err)
-		}
-	}()
-	
-	if resp.StatusCode >= 400 {
-		// Read error body for debugging
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		slog.Error("Failed to send batch to OpenObserve", 
-			"status", resp.StatusCode,
-			"body", string(bodyBytes),
-			"stream", streamName)
-		return fmt.Errorf("HTTP error: %d", resp.StatusCode)
-	}
-	
-	slog.Info("Successfully sent batch to OpenObserve", 
-		"status", resp.StatusCode,
-		"stream", streamName,
-		"entries", len(batch))
-	
-	return nil
-}
-
 func (bp *BatchProcessor) getParser(logType string) func(string) ParsedLogEntry {
 	switch logType {
 	case "error":
@@ -1492,7 +1724,9 @@ func parseGenericLog(line string) ParsedLogEntry {
 // Checkpoint and Recovery Functions
 // ============================================================================
 
-func (bp *BatchProcessor) getCheckpoint(ctx context.Context, logMsg LogMessage) (string, error) {
+// getCheckpoint returns the marker to resume a file from and the number of
+// lines before it
+func (bp *BatchProcessor) getCheckpoint(ctx context.Context, logMsg LogMessage) (string, int, error) {
 	result, err := bp.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
 		TableName: &bp.config.CheckpointTable,
 		Key: map[string]dynamoTypes.AttributeValue{
@@ -1502,20 +1736,25 @@ func (bp *BatchProcessor) getCheckpoint(ctx context.Context, logMsg LogMessage)
 	})
 	
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 	
 	if result.Item == nil {
-		return "", nil
+		return "", 0, nil
+	}
+	
+	lineCount := 0
+	if lines, ok := result.Item["line_count"].(*dynamoTypes.AttributeValueMemberN); ok {
+		lineCount, _ = strconv.Atoi(lines.Value)
 	}
 	
 	if marker, ok := result.Item["marker"]; ok {
 		if markerVal, ok := marker.(*dynamoTypes.AttributeValueMemberS); ok {
-			return markerVal.Value, nil
+			return markerVal.Value, lineCount, nil
 		}
 	}
 	
-	return "", nil
+	return "", 0, nil
 }
 
 func (bp *BatchProcessor) saveCheckpoint(ctx context.Context, logMsg LogMessage, marker string, lineCount int) error {
@@ -1562,6 +1801,7 @@ func (bp *BatchProcessor) sendToDLQ(ctx context.Context, item BatchItem, process
 			"kafka_partition": &dynamoTypes.AttributeValueMemberN{Value: strconv.Itoa(item.Message.Partition)},
 			"kafka_offset":   &dynamoTypes.AttributeValueMemberN{Value: strconv.FormatInt(item.Message.Offset, 10)},
 			"original_message": &dynamoTypes.AttributeValueMemberS{Value: string(item.Message.Value)},
+			"kafka_headers":  &dynamoTypes.AttributeValueMemberS{Value: formatHeaders(item.Message.Headers)},
 		},
 	})
 	return err
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/yourorg/aurora-log-system/common/message"
+)
+
+// LogMessage is a file notification from discovery. The wire format is
+// defined in the common message package.
+type LogMessage = message.LogFileInfo
+
+// decodeLogMessage decodes a file notification according to its schema
+// version. The processor works in its own span of the file's trace.
+func decodeLogMessage(msg kafka.Message) (LogMessage, error) {
+	headers := make(map[string]string, len(msg.Headers))
+	for _, header := range msg.Headers {
+		headers[header.Key] = string(header.Value)
+	}
+
+	logMsg, err := message.Decode(msg.Value, headers)
+	if err != nil {
+		return LogMessage{}, err
+	}
+	logMsg.Provenance.TraceParent = message.ChildTraceParent(logMsg.Provenance.TraceParent)
+	return logMsg, nil
+}
+
+// formatHeaders renders message headers as a JSON object for the DLQ
+func formatHeaders(headers []kafka.Header) string {
+	values := make(map[string]string, len(headers))
+	for _, header := range headers {
+		values[header.Key] = string(header.Value)
+	}
+	data, _ := json.Marshal(values)
+	return string(data)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamoTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/yourorg/aurora-log-system/common/message"
+	"github.com/yourorg/aurora-log-system/common/metrics"
+)
+
+const testTraceParent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+
+func notification(offset int64, headers ...kafka.Header) kafka.Message {
+	msg := offsetMessage(0, offset)
+	msg.Value = []byte(`{"instance_id":"db-1","log_type":"error","log_file_name":"error/mysql-error.log"}`)
+	msg.Headers = headers
+	return msg
+}
+
+func header(key, value string) kafka.Header {
+	return kafka.Header{Key: key, Value: []byte(value)}
+}
+
+func TestDecodeLogMessage(t *testing.T) {
+	// Messages from before headers existed are version 1
+	logMsg, err := decodeLogMessage(notification(1))
+	require.NoError(t, err)
+	assert.Equal(t, "db-1", logMsg.InstanceID)
+	assert.Equal(t, message.Provenance{SchemaVersion: 1}, logMsg.Provenance)
+
+	logMsg, err = decodeLogMessage(notification(2,
+		header("schema_version", "1"),
+		header("shard_id", "3"),
+		header("scan_id", "9f86d081884c7d65"),
+		header("published_at", "1714564800123"),
+		header("traceparent", testTraceParent),
+	))
+	require.NoError(t, err)
+	provenance := logMsg.Provenance
+	assert.Equal(t, "3", provenance.ShardID)
+	assert.Equal(t, "9f86d081884c7d65", provenance.ScanID)
+	assert.Equal(t, time.UnixMilli(1714564800123).UTC(), provenance.PublishedAt)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", provenance.TraceID())
+	assert.NotEqual(t, testTraceParent, provenance.TraceParent, "the processor works in its own span")
+
+	_, err = decodeLogMessage(notification(3, header("schema_version", "2")))
+	assert.ErrorIs(t, err, message.ErrUnsupportedSchemaVersion)
+	_, err = decodeLogMessage(notification(4, header("schema_version", "v2")))
+	assert.ErrorIs(t, err, message.ErrUnsupportedSchemaVersion)
+
+	logMsg, err = decodeLogMessage(notification(5, header("traceparent", "not-a-trace")))
+	require.NoError(t, err)
+	assert.Empty(t, logMsg.Provenance.TraceID())
+}
+
+type sliceSource struct {
+	messages chan kafka.Message
+}
+
+func (s *sliceSource) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case msg := <-s.messages:
+		return msg, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (s *sliceSource) CommitMessages(ctx context.Context, msgs ...kafka.Message) error { return nil }
+func (s *sliceSource) Close() error                                                    { return nil }
+
+func TestBatchCollectorSendsUnknownSchemaToDLQ(t *testing.T) {
+	source := &sliceSource{messages: make(chan kafka.Message, 2)}
+	source.messages <- notification(1, header("schema_version", "2"))
+	source.messages <- notification(2, header("schema_version", "1"))
+
+	mockDynamo := new(mockDynamoClient)
+	mockDynamo.On("PutItem", mock.Anything, mock.MatchedBy(func(input *dynamodb.PutItemInput) bool {
+		return *input.TableName == "dlq"
+	})).Return(&dynamodb.PutItemOutput{}, nil).Once()
+
+	committer := &fakeCommitter{}
+	bp := &BatchProcessor{
+		config:          Config{BatchSize: 1, BatchTimeout: time.Hour, DLQTable: "dlq"},
+		dynamoClient:    mockDynamo,
+		source:          source,
+		offsets:         NewOffsetTracker(committer, 10),
+		metricsExporter: metrics.NewExporter("", "", ""),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	items := make(chan BatchItem, 2)
+	go bp.batchCollector(ctx, items)
+
+	select {
+	case item := <-items:
+		assert.Equal(t, int64(2), item.Message.Offset)
+	case <-time.After(time.Second):
+		t.Fatal("supported message was not dispatched")
+	}
+	cancel()
+
+	mockDynamo.AssertExpectations(t)
+	dlq := mockDynamo.Calls[0].Arguments.Get(1).(*dynamodb.PutItemInput)
+	require.IsType(t, &dynamoTypes.AttributeValueMemberS{}, dlq.Item["error"])
+	assert.Contains(t, dlq.Item["error"].(*dynamoTypes.AttributeValueMemberS).Value, "unsupported message schema version 2")
+	assert.Equal(t, []int64{1}, committer.offsets(), "the unsupported message is not read again")
+}
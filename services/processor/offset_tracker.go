@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// offsetCommitter is the part of kafka.Reader the tracker commits through
+type offsetCommitter interface {
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+type topicPartition struct {
+	topic     string
+	partition int
+}
+
+type trackedOffset struct {
+	msg  kafka.Message
+	done bool
+}
+
+// OffsetTracker records which fetched messages have finished and commits,
+// per partition, only the highest offset below which everything is done.
+// Workers finish files out of order; committing each message as it finishes
+// would let a crash skip earlier files that were still running. The number of
+// uncommitted messages per partition is capped, so one slow file cannot leave
+// an unbounded gap behind it.
+type OffsetTracker struct {
+	committer   offsetCommitter
+	maxInFlight int
+
+	mu         sync.Mutex
+	partitions map[topicPartition][]*trackedOffset // fetch (offset) order
+	released   chan struct{}                       // closed and replaced when a slot frees
+
+	commitMu  sync.Mutex
+	committed map[topicPartition]int64
+}
+
+func NewOffsetTracker(committer offsetCommitter, maxInFlight int) *OffsetTracker {
+	if maxInFlight <= 0 {
+		maxInFlight = 1
+	}
+	return &OffsetTracker{
+		committer:   committer,
+		maxInFlight: maxInFlight,
+		partitions:  make(map[topicPartition][]*trackedOffset),
+		released:    make(chan struct{}),
+		committed:   make(map[topicPartition]int64),
+	}
+}
+
+// TryTrack registers a fetched message if its partition has room
+func (t *OffsetTracker) TryTrack(msg kafka.Message) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.trackLocked(msg)
+}
+
+// Track registers a fetched message, waiting for its partition to have room.
+// Callers holding undispatched messages must hand them to workers first, or
+// the wait can never end.
+func (t *OffsetTracker) Track(ctx context.Context, msg kafka.Message) error {
+	for {
+		t.mu.Lock()
+		if t.trackLocked(msg) {
+			t.mu.Unlock()
+			return nil
+		}
+		released := t.released
+		t.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (t *OffsetTracker) trackLocked(msg kafka.Message) bool {
+	tp := topicPartition{msg.Topic, msg.Partition}
+	pending := t.partitions[tp]
+
+	// The reader went back, e.g. after a rebalance: entries at or after this
+	// offset will be fetched again, so forget them
+	if n := len(pending); n > 0 && msg.Offset <= pending[n-1].msg.Offset {
+		keep := pending[:0]
+		for _, entry := range pending {
+			if entry.msg.Offset < msg.Offset {
+				keep = append(keep, entry)
+			}
+		}
+		pending = keep
+		t.releaseLocked()
+	}
+
+	if len(pending) >= t.maxInFlight {
+		t.partitions[tp] = pending
+		return false
+	}
+	t.partitions[tp] = append(pending, &trackedOffset{msg: msg})
+	return true
+}
+
+func (t *OffsetTracker) releaseLocked() {
+	close(t.released)
+	t.released = make(chan struct{})
+}
+
+// Complete marks a message done and commits its partition up to the highest
+// contiguous done offset. Messages the tracker no longer knows (dropped by a
+// rewind) are ignored.
+func (t *OffsetTracker) Complete(ctx context.Context, msg kafka.Message) error {
+	tp := topicPartition{msg.Topic, msg.Partition}
+
+	t.mu.Lock()
+	pending := t.partitions[tp]
+	for _, entry := range pending {
+		if entry.msg.Offset == msg.Offset {
+			entry.done = true
+			break
+		}
+	}
+
+	contiguous := 0
+	for contiguous < len(pending) && pending[contiguous].done {
+		contiguous++
+	}
+	if contiguous == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	commit := pending[contiguous-1].msg
+	t.partitions[tp] = pending[contiguous:]
+	t.releaseLocked()
+	t.mu.Unlock()
+
+	// Workers can reach this point out of order; never move a commit back
+	t.commitMu.Lock()
+	defer t.commitMu.Unlock()
+	if last, ok := t.committed[tp]; ok && commit.Offset <= last {
+		return nil
+	}
+	if err := t.committer.CommitMessages(ctx, commit); err != nil {
+		return err
+	}
+	t.committed[tp] = commit.Offset
+	return nil
+}
+
+// InFlight returns the number of uncommitted messages held for a partition
+func (t *OffsetTracker) InFlight(topic string, partition int) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.partitions[topicPartition{topic, partition}])
+}
+
+// Forget drops a tracked message that will not be processed
+func (t *OffsetTracker) Forget(msg kafka.Message) {
+	tp := topicPartition{msg.Topic, msg.Partition}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pending := t.partitions[tp]
+	for i, entry := range pending {
+		if entry.msg.Offset == msg.Offset {
+			t.partitions[tp] = append(pending[:i:i], pending[i+1:]...)
+			t.releaseLocked()
+			return
+		}
+	}
+}
+
+// Revoke drops all state for a partition this consumer no longer owns
+func (t *OffsetTracker) Revoke(topic string, partition int) {
+	tp := topicPartition{topic, partition}
+
+	t.mu.Lock()
+	delete(t.partitions, tp)
+	t.releaseLocked()
+	t.mu.Unlock()
+
+	t.commitMu.Lock()
+	delete(t.committed, tp)
+	t.commitMu.Unlock()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCommitter struct {
+	mu      sync.Mutex
+	commits []kafka.Message
+	err     error
+}
+
+func (c *fakeCommitter) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	c.commits = append(c.commits, msgs...)
+	return nil
+}
+
+func (c *fakeCommitter) offsets() []int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var offsets []int64
+	for _, msg := range c.commits {
+		offsets = append(offsets, msg.Offset)
+	}
+	return offsets
+}
+
+func offsetMessage(partition int, offset int64) kafka.Message {
+	return kafka.Message{Topic: "aurora-error-logs", Partition: partition, Offset: offset}
+}
+
+func TestOffsetTrackerCommitsContiguousOffsets(t *testing.T) {
+	committer := &fakeCommitter{}
+	tracker := NewOffsetTracker(committer, 10)
+	ctx := context.Background()
+
+	for offset := int64(10); offset < 14; offset++ {
+		require.True(t, tracker.TryTrack(offsetMessage(0, offset)))
+	}
+
+	// Later offsets finish first; nothing is committed past the gap at 10
+	require.NoError(t, tracker.Complete(ctx, offsetMessage(0, 12)))
+	require.NoError(t, tracker.Complete(ctx, offsetMessage(0, 11)))
+	assert.Empty(t, committer.offsets())
+	assert.Equal(t, 4, tracker.InFlight("aurora-error-logs", 0))
+
+	require.NoError(t, tracker.Complete(ctx, offsetMessage(0, 10)))
+	assert.Equal(t, []int64{12}, committer.offsets())
+	assert.Equal(t, 1, tracker.InFlight("aurora-error-logs", 0))
+
+	require.NoError(t, tracker.Complete(ctx, offsetMessage(0, 13)))
+	assert.Equal(t, []int64{12, 13}, committer.offsets())
+}
+
+func TestOffsetTrackerPartitionsAreIndependent(t *testing.T) {
+	committer := &fakeCommitter{}
+	tracker := NewOffsetTracker(committer, 10)
+	ctx := context.Background()
+
+	require.True(t, tracker.TryTrack(offsetMessage(0, 5)))
+	require.True(t, tracker.TryTrack(offsetMessage(1, 7)))
+
+	require.NoError(t, tracker.Complete(ctx, offsetMessage(1, 7)))
+	require.Len(t, committer.commits, 1)
+	assert.Equal(t, 1, committer.commits[0].Partition)
+	assert.Equal(t, 1, tracker.InFlight("aurora-error-logs", 0))
+}
+
+func TestOffsetTrackerBoundsInFlight(t *testing.T) {
+	committer := &fakeCommitter{}
+	tracker := NewOffsetTracker(committer, 2)
+
+	require.True(t, tracker.TryTrack(offsetMessage(0, 1)))
+	require.True(t, tracker.TryTrack(offsetMessage(0, 2)))
+	assert.False(t, tracker.TryTrack(offsetMessage(0, 3)))
+	assert.True(t, tracker.TryTrack(offsetMessage(1, 1)), "other partitions have their own limit")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, tracker.Track(ctx, offsetMessage(0, 3)), context.DeadlineExceeded)
+
+	tracked := make(chan error, 1)
+	go func() {
+		tracked <- tracker.Track(context.Background(), offsetMessage(0, 3))
+	}()
+
+	select {
+	case <-tracked:
+		t.Fatal("Track returned while the partition was full")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	require.NoError(t, tracker.Complete(context.Background(), offsetMessage(0, 1)))
+	select {
+	case err := <-tracked:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Track did not resume after a slot freed")
+	}
+	assert.Equal(t, 2, tracker.InFlight("aurora-error-logs", 0))
+}
+
+func TestOffsetTrackerRewind(t *testing.T) {
+	committer := &fakeCommitter{}
+	tracker := NewOffsetTracker(committer, 10)
+	ctx := context.Background()
+
+	for offset := int64(1); offset <= 4; offset++ {
+		require.True(t, tracker.TryTrack(offsetMessage(0, offset)))
+	}
+
+	// The reader restarts from offset 3; the old 3 and 4 are dropped
+	require.True(t, tracker.TryTrack(offsetMessage(0, 3)))
+	assert.Equal(t, 3, tracker.InFlight("aurora-error-logs", 0))
+
+	require.NoError(t, tracker.Complete(ctx, offsetMessage(0, 1)))
+	require.NoError(t, tracker.Complete(ctx, offsetMessage(0, 2)))
+	require.NoError(t, tracker.Complete(ctx, offsetMessage(0, 3)))
+	assert.Equal(t, []int64{1, 2, 3}, committer.offsets())
+}
+
+func TestOffsetTrackerNeverCommitsBackwards(t *testing.T) {
+	committer := &fakeCommitter{err: errors.New("coordinator unavailable")}
+	tracker := NewOffsetTracker(committer, 10)
+	ctx := context.Background()
+
+	require.True(t, tracker.TryTrack(offsetMessage(0, 1)))
+	require.True(t, tracker.TryTrack(offsetMessage(0, 2)))
+
+	assert.Error(t, tracker.Complete(ctx, offsetMessage(0, 1)))
+
+	// A later commit covers the failed one
+	committer.err = nil
+	require.NoError(t, tracker.Complete(ctx, offsetMessage(0, 2)))
+	assert.Equal(t, []int64{2}, committer.offsets())
+
+	// Completing an offset the tracker no longer holds is a no-op
+	require.NoError(t, tracker.Complete(ctx, offsetMessage(0, 1)))
+	assert.Equal(t, []int64{2}, committer.offsets())
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yourorg/aurora-log-system/common/env"
+	"github.com/yourorg/aurora-log-system/common/metrics"
+	"gopkg.in/yaml.v3"
+)
+
+// OpenObserveStreamSpec is the declared state of one stream
+type OpenObserveStreamSpec struct {
+	Name               string   `yaml:"name"`
+	Org                string   `yaml:"org"`
+	Type               string   `yaml:"type"`           // logs (default), metrics or traces
+	RetentionDays      int      `yaml:"retention_days"` // 0 keeps the server default
+	PartitionKeys      []string `yaml:"partition_keys"`
+	FullTextSearchKeys []string `yaml:"full_text_search_keys"`
+}
+
+type openObserveStreamsFile struct {
+	Streams []OpenObserveStreamSpec `yaml:"streams"`
+}
+
+// loadOpenObserveStreamSpecs reads the declared streams; streams without an
+// org belong to defaultOrg
+func loadOpenObserveStreamSpecs(file, defaultOrg string) ([]OpenObserveStreamSpec, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OpenObserve streams: %w", err)
+	}
+
+	var parsed openObserveStreamsFile
+	if err := yaml.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenObserve streams %s: %w", file, err)
+	}
+
+	for i := range parsed.Streams {
+		spec := &parsed.Streams[i]
+		if spec.Name == "" {
+			return nil, fmt.Errorf("OpenObserve stream %d has no name", i+1)
+		}
+		if spec.Org == "" {
+			spec.Org = defaultOrg
+		}
+		if spec.Type == "" {
+			spec.Type = "logs"
+		}
+		if spec.RetentionDays < 0 {
+			return nil, fmt.Errorf("OpenObserve stream %s: negative retention_days", spec.Name)
+		}
+	}
+	return parsed.Streams, nil
+}
+
+// StreamDrift is one difference between a declared stream and OpenObserve
+type StreamDrift struct {
+	Org    string
+	Stream string
+	Field  string // stream, retention_days, partition_keys or full_text_search_keys
+	Want   string
+	Have   string
+	Fixed  bool
+}
+
+func (d StreamDrift) String() string {
+	return fmt.Sprintf("%s/%s %s: want %q, have %q", d.Org, d.Stream, d.Field, d.Want, d.Have)
+}
+
+type openObservePartitionKey struct {
+	Field    string `json:"field"`
+	Types    string `json:"types"`
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+// openObservePartitionKeys accepts both the list form of current OpenObserve
+// releases and the index-keyed object of older ones
+type openObservePartitionKeys []openObservePartitionKey
+
+func (k *openObservePartitionKeys) UnmarshalJSON(data []byte) error {
+	var list []openObservePartitionKey
+	if err := json.Unmarshal(data, &list); err == nil {
+		*k = list
+		return nil
+	}
+
+	var indexed map[string]openObservePartitionKey
+	if err := json.Unmarshal(data, &indexed); err != nil {
+		return err
+	}
+	indexes := make([]string, 0, len(indexed))
+	for index := range indexed {
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		a, _ := strconv.Atoi(indexes[i])
+		b, _ := strconv.Atoi(indexes[j])
+		return a < b
+	})
+	*k = make(openObservePartitionKeys, 0, len(indexes))
+	for _, index := range indexes {
+		*k = append(*k, indexed[index])
+	}
+	return nil
+}
+
+type openObserveStreamSettings struct {
+	PartitionKeys      openObservePartitionKeys `json:"partition_keys"`
+	FullTextSearchKeys []string                 `json:"full_text_search_keys"`
+	DataRetention      int                      `json:"data_retention"`
+}
+
+type openObserveStreamList struct {
+	List []struct {
+		Name     string                    `json:"name"`
+		Settings openObserveStreamSettings `json:"settings"`
+	} `json:"list"`
+}
+
+// openObserveSettingsChange is a list delta of the settings update API
+type openObserveSettingsChange[T any] struct {
+	Add    []T `json:"add"`
+	Remove []T `json:"remove"`
+}
+
+type openObserveSettingsUpdate struct {
+	PartitionKeys      openObserveSettingsChange[openObservePartitionKey] `json:"partition_keys"`
+	FullTextSearchKeys openObserveSettingsChange[string]                  `json:"full_text_search_keys"`
+	DataRetention      *int                                               `json:"data_retention,omitempty"`
+}
+
+// OpenObserveStreamProvisioner reconciles declared streams with an
+// OpenObserve instance. Missing streams are created with their settings;
+// existing streams get missing partition and full-text search keys added and
+// their retention set. Keys that exist in OpenObserve but are not declared
+// are reported, never removed: dropping a partition key changes the layout of
+// new data, and keys may have been added from the UI on purpose.
+type OpenObserveStreamProvisioner struct {
+	url    string
+	user   string
+	pass   string
+	orgs   map[string]openObserveTarget // orgs that routes write with their own credentials
+	client *http.Client
+}
+
+func NewOpenObserveStreamProvisioner(baseURL, user, pass string, client *http.Client) *OpenObserveStreamProvisioner {
+	return &OpenObserveStreamProvisioner{url: strings.TrimSuffix(baseURL, "/"), user: user, pass: pass, client: client}
+}
+
+// UseRouteCredentials authenticates to each routed org with the credentials
+// its route writes with; the first such route of an org wins, like routing.
+// Other orgs use the default credentials.
+func (p *OpenObserveStreamProvisioner) UseRouteCredentials(routes []OpenObserveRoute) {
+	p.orgs = make(map[string]openObserveTarget)
+	for _, route := range routes {
+		if route.Org == "" || route.User == "" {
+			continue
+		}
+		if _, ok := p.orgs[route.Org]; !ok {
+			p.orgs[route.Org] = openObserveTarget{route: route.Name, org: route.Org, user: route.User, passFile: route.password}
+		}
+	}
+}
+
+func (p *OpenObserveStreamProvisioner) credentials(org string) (string, string, error) {
+	target, ok := p.orgs[org]
+	if !ok {
+		return p.user, p.pass, nil
+	}
+	pass, err := target.password()
+	return target.user, pass, err
+}
+
+// Reconcile compares the declared streams with OpenObserve. With apply set it
+// fixes what it can; the returned drift marks those entries Fixed. A stream
+// that fails does not stop the others; all failures are returned together.
+func (p *OpenObserveStreamProvisioner) Reconcile(ctx context.Context, specs []OpenObserveStreamSpec, apply bool) ([]StreamDrift, error) {
+	type listKey struct{ org, streamType string }
+	existing := make(map[listKey]map[string]openObserveStreamSettings)
+	listErrs := make(map[listKey]error)
+
+	var drift []StreamDrift
+	var errs []error
+	for _, spec := range specs {
+		key := listKey{spec.Org, spec.Type}
+		if _, failed := listErrs[key]; failed {
+			continue
+		}
+		streams, ok := existing[key]
+		if !ok {
+			var err error
+			if streams, err = p.listStreams(ctx, spec.Org, spec.Type); err != nil {
+				listErrs[key] = err
+				errs = append(errs, err)
+				continue
+			}
+			existing[key] = streams
+		}
+
+		settings, found := streams[spec.Name]
+		if !found {
+			entry := StreamDrift{Org: spec.Org, Stream: spec.Name, Field: "stream", Want: "present", Have: "missing"}
+			if apply {
+				if err := p.createStream(ctx, spec); err != nil {
+					errs = append(errs, err)
+				} else {
+					entry.Fixed = true
+				}
+			}
+			drift = append(drift, entry)
+			continue
+		}
+
+		streamDrift, update := diffStreamSettings(spec, settings)
+		if apply && update != nil {
+			if err := p.updateStream(ctx, spec, *update); err != nil {
+				errs = append(errs, err)
+			} else {
+				for i := range streamDrift {
+					streamDrift[i].Fixed = streamDrift[i].Want != ""
+				}
+			}
+		}
+		drift = append(drift, streamDrift...)
+	}
+	return drift, errors.Join(errs...)
+}
+
+// diffStreamSettings reports drift for one existing stream and builds the
+// update that adds what is missing. Entries with an empty Want are keys that
+// are present but not declared.
+func diffStreamSettings(spec OpenObserveStreamSpec, settings openObserveStreamSettings) ([]StreamDrift, *openObserveSettingsUpdate) {
+	var drift []StreamDrift
+	var update openObserveSettingsUpdate
+	changed := false
+	report := func(field, want, have string) {
+		drift = append(drift, StreamDrift{Org: spec.Org, Stream: spec.Name, Field: field, Want: want, Have: have})
+	}
+
+	if spec.RetentionDays > 0 && spec.RetentionDays != settings.DataRetention {
+		report("retention_days", strconv.Itoa(spec.RetentionDays), strconv.Itoa(settings.DataRetention))
+		retention := spec.RetentionDays
+		update.DataRetention = &retention
+		changed = true
+	}
+
+	var partitions []string
+	for _, key := range settings.PartitionKeys {
+		if !key.Disabled {
+			partitions = append(partitions, key.Field)
+		}
+	}
+	missing, extra := diffKeys(spec.PartitionKeys, partitions)
+	for _, field := range missing {
+		report("partition_keys", field, "")
+		update.PartitionKeys.Add = append(update.PartitionKeys.Add, openObservePartitionKey{Field: field, Types: "value"})
+		changed = true
+	}
+	for _, field := range extra {
+		report("partition_keys", "", field)
+	}
+
+	missing, extra = diffKeys(spec.FullTextSearchKeys, settings.FullTextSearchKeys)
+	for _, field := range missing {
+		report("full_text_search_keys", field, "")
+		update.FullTextSearchKeys.Add = append(update.FullTextSearchKeys.Add, field)
+		changed = true
+	}
+	for _, field := range extra {
+		report("full_text_search_keys", "", field)
+	}
+
+	if !changed {
+		return drift, nil
+	}
+	return drift, &update
+}
+
+// diffKeys returns the declared keys that are missing and the present keys
+// that are not declared
+func diffKeys(want, have []string) (missing, extra []string) {
+	for _, key := range want {
+		if !containsFold(have, key) {
+			missing = append(missing, key)
+		}
+	}
+	for _, key := range have {
+		if !containsFold(want, key) {
+			extra = append(extra, key)
+		}
+	}
+	return missing, extra
+}
+
+func (p *OpenObserveStreamProvisioner) listStreams(ctx context.Context, org, streamType string) (map[string]openObserveStreamSettings, error) {
+	body, err := p.do(ctx, org, http.MethodGet, fmt.Sprintf("/api/%s/streams?type=%s", url.PathEscape(org), url.QueryEscape(streamType)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list OpenObserve streams in %s: %w", org, err)
+	}
+
+	var list openObserveStreamList
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, fmt.Errorf("failed to decode OpenObserve streams in %s: %w", org, err)
+	}
+	streams := make(map[string]openObserveStreamSettings, len(list.List))
+	for _, stream := range list.List {
+		streams[stream.Name] = stream.Settings
+	}
+	return streams, nil
+}
+
+func (p *OpenObserveStreamProvisioner) createStream(ctx context.Context, spec OpenObserveStreamSpec) error {
+	settings := openObserveStreamSettings{
+		PartitionKeys:      openObservePartitionKeys{},
+		FullTextSearchKeys: spec.FullTextSearchKeys,
+		DataRetention:      spec.RetentionDays,
+	}
+	for _, field := range spec.PartitionKeys {
+		settings.PartitionKeys = append(settings.PartitionKeys, openObservePartitionKey{Field: field, Types: "value"})
+	}
+	if settings.FullTextSearchKeys == nil {
+		settings.FullTextSearchKeys = []string{}
+	}
+
+	path := fmt.Sprintf("/api/%s/streams/%s?type=%s", url.PathEscape(spec.Org), url.PathEscape(spec.Name), url.QueryEscape(spec.Type))
+	if _, err := p.do(ctx, spec.Org, http.MethodPost, path, settings); err != nil {
+		return fmt.Errorf("failed to create OpenObserve stream %s/%s: %w", spec.Org, spec.Name, err)
+	}
+	slog.Info("Created OpenObserve stream", "org", spec.Org, "stream", spec.Name, "retention_days", spec.RetentionDays)
+	return nil
+}
+
+func (p *OpenObserveStreamProvisioner) updateStream(ctx context.Context, spec OpenObserveStreamSpec, update openObserveSettingsUpdate) error {
+	if update.PartitionKeys.Add == nil {
+		update.PartitionKeys.Add = []openObservePartitionKey{}
+	}
+	if update.FullTextSearchKeys.Add == nil {
+		update.FullTextSearchKeys.Add = []string{}
+	}
+	update.PartitionKeys.Remove = []openObservePartitionKey{}
+	update.FullTextSearchKeys.Remove = []string{}
+
+	path := fmt.Sprintf("/api/%s/streams/%s/settings?type=%s", url.PathEscape(spec.Org), url.PathEscape(spec.Name), url.QueryEscape(spec.Type))
+	if _, err := p.do(ctx, spec.Org, http.MethodPut, path, update); err != nil {
+		return fmt.Errorf("failed to update OpenObserve stream %s/%s: %w", spec.Org, spec.Name, err)
+	}
+	slog.Info("Updated OpenObserve stream settings", "org", spec.Org, "stream", spec.Name)
+	return nil
+}
+
+func (p *OpenObserveStreamProvisioner) do(ctx context.Context, org, method, path string, payload interface{}) ([]byte, error) {
+	user, pass, err := p.credentials(org)
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(user, pass)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("OpenObserve returned %d: %s", resp.StatusCode, truncate(string(respBody), 500))
+	}
+	return respBody, nil
+}
+
+// provisionOpenObserveStreams reconciles the streams declared in
+// OPENOBSERVE_STREAMS_FILE, logging each drift entry
+func provisionOpenObserveStreams(ctx context.Context, config OpenObserveSinkConfig, client *http.Client, metrics *metrics.Exporter, apply bool) ([]StreamDrift, error) {
+	file := env.Get("OPENOBSERVE_STREAMS_FILE", "")
+	if file == "" {
+		return nil, nil
+	}
+	specs, err := loadOpenObserveStreamSpecs(file, config.Org)
+	if err != nil {
+		return nil, err
+	}
+
+	provisioner := NewOpenObserveStreamProvisioner(config.URL, config.User, config.Pass, client)
+	provisioner.UseRouteCredentials(config.Routes)
+	drift, err := provisioner.Reconcile(ctx, specs, apply)
+
+	unfixed := 0
+	for _, entry := range drift {
+		if entry.Fixed {
+			slog.Info("Reconciled OpenObserve stream drift", "org", entry.Org, "stream", entry.Stream, "field", entry.Field, "want", entry.Want, "have", entry.Have)
+			continue
+		}
+		unfixed++
+		slog.Warn("OpenObserve stream drift", "org", entry.Org, "stream", entry.Stream, "field", entry.Field, "want", entry.Want, "have", entry.Have)
+	}
+	if metrics != nil {
+		metrics.IncrementCounter("openobserve_stream_drift", int64(unfixed))
+	}
+	if err != nil {
+		return drift, err
+	}
+
+	slog.Info("OpenObserve streams reconciled", "streams", len(specs), "drift", len(drift), "unresolved", unfixed, "apply", apply)
+	return drift, nil
+}
+
+// startupStreamProvisioning runs provisioning before the sink starts writing.
+// Failures are logged rather than fatal: OpenObserve creates streams on first
+// ingest, so the processor can still deliver without the declared settings.
+func startupStreamProvisioning(config OpenObserveSinkConfig, client *http.Client, metrics *metrics.Exporter) {
+	mode := env.Get("OPENOBSERVE_STREAMS_MODE", "apply")
+	if mode == "off" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(env.Int("OPENOBSERVE_STREAMS_TIMEOUT_SEC", 30))*time.Second)
+	defer cancel()
+	if _, err := provisionOpenObserveStreams(ctx, config, client, metrics, mode == "apply"); err != nil {
+		slog.Warn("OpenObserve stream provisioning failed", "error", err)
+	}
+}
+
+// runStreamProvisioningCommand implements `processor -provision-streams` and
+// `processor -check-streams`. Check exits 1 on any drift; both exit 1 on
+// errors.
+func runStreamProvisioningCommand(apply bool) int {
+	config, err := loadOpenObserveSinkConfig(Config{
+		OpenObserveURL:  os.Getenv("OPENOBSERVE_URL"),
+		OpenObserveUser: os.Getenv("OPENOBSERVE_USER"),
+		OpenObservePass: os.Getenv("OPENOBSERVE_PASS"),
+	})
+	if err != nil {
+		slog.Error("Invalid OpenObserve configuration", "error", err)
+		return 1
+	}
+	if env.Get("OPENOBSERVE_STREAMS_FILE", "") == "" {
+		slog.Error("OPENOBSERVE_STREAMS_FILE is not set")
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(env.Int("OPENOBSERVE_STREAMS_TIMEOUT_SEC", 30))*time.Second)
+	defer cancel()
+	drift, err := provisionOpenObserveStreams(ctx, config, &http.Client{Timeout: 30 * time.Second}, nil, apply)
+	if err != nil {
+		slog.Error("OpenObserve stream provisioning failed", "error", err)
+		return 1
+	}
+	if !apply && len(drift) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourorg/aurora-log-system/common/metrics"
+)
+
+// fakeOpenObserve implements the stream list, create and settings APIs. Orgs
+// accept admin/secret unless they are given their own credentials.
+type fakeOpenObserve struct {
+	mu      sync.Mutex
+	streams map[string]map[string]openObserveStreamSettings // org -> stream
+	users   map[string]string                               // org -> "user:pass"
+	writes  int
+}
+
+func newFakeOpenObserve(t *testing.T) (*fakeOpenObserve, *httptest.Server) {
+	fake := &fakeOpenObserve{
+		streams: make(map[string]map[string]openObserveStreamSettings),
+		users:   make(map[string]string),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "logs", r.URL.Query().Get("type"))
+
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		want := "admin:secret"
+		if creds, ok := fake.users[parts[1]]; ok {
+			want = creds
+		}
+		if user, pass, _ := r.BasicAuth(); user+":"+pass != want {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodGet && len(parts) == 3:
+			list := map[string][]map[string]interface{}{"list": {}}
+			for name, settings := range fake.streams[parts[1]] {
+				list["list"] = append(list["list"], map[string]interface{}{"name": name, "settings": settings})
+			}
+			json.NewEncoder(w).Encode(list)
+		case r.Method == http.MethodPost && len(parts) == 4:
+			var settings openObserveStreamSettings
+			if !assert.NoError(t, json.NewDecoder(r.Body).Decode(&settings)) {
+				return
+			}
+			if fake.streams[parts[1]] == nil {
+				fake.streams[parts[1]] = make(map[string]openObserveStreamSettings)
+			}
+			fake.streams[parts[1]][parts[3]] = settings
+			fake.writes++
+		case r.Method == http.MethodPut && len(parts) == 5:
+			var update openObserveSettingsUpdate
+			if !assert.NoError(t, json.NewDecoder(r.Body).Decode(&update)) {
+				return
+			}
+			assert.Empty(t, update.PartitionKeys.Remove)
+			assert.Empty(t, update.FullTextSearchKeys.Remove)
+			settings := fake.streams[parts[1]][parts[3]]
+			settings.PartitionKeys = append(settings.PartitionKeys, update.PartitionKeys.Add...)
+			settings.FullTextSearchKeys = append(settings.FullTextSearchKeys, update.FullTextSearchKeys.Add...)
+			if update.DataRetention != nil {
+				settings.DataRetention = *update.DataRetention
+			}
+			fake.streams[parts[1]][parts[3]] = settings
+			fake.writes++
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return fake, server
+}
+
+var testStreamSpecs = []OpenObserveStreamSpec{
+	{Name: "aurora_error_logs", Org: "default", Type: "logs", RetentionDays: 30, PartitionKeys: []string{"cluster_id"}, FullTextSearchKeys: []string{"message"}},
+	{Name: "aurora_slowquery_logs", Org: "default", Type: "logs", RetentionDays: 14, PartitionKeys: []string{"cluster_id"}, FullTextSearchKeys: []string{"query"}},
+}
+
+func TestOpenObserveStreamProvisionerCreatesAndUpdates(t *testing.T) {
+	fake, server := newFakeOpenObserve(t)
+	fake.streams["default"] = map[string]openObserveStreamSettings{
+		"aurora_slowquery_logs": {
+			PartitionKeys:      openObservePartitionKeys{{Field: "instance_id", Types: "value"}},
+			FullTextSearchKeys: []string{"query"},
+			DataRetention:      7,
+		},
+	}
+
+	provisioner := NewOpenObserveStreamProvisioner(server.URL, "admin", "secret", server.Client())
+	drift, err := provisioner.Reconcile(context.Background(), testStreamSpecs, true)
+	require.NoError(t, err)
+
+	assert.Equal(t, []StreamDrift{
+		{Org: "default", Stream: "aurora_error_logs", Field: "stream", Want: "present", Have: "missing", Fixed: true},
+		{Org: "default", Stream: "aurora_slowquery_logs", Field: "retention_days", Want: "14", Have: "7", Fixed: true},
+		{Org: "default", Stream: "aurora_slowquery_logs", Field: "partition_keys", Want: "cluster_id", Fixed: true},
+		{Org: "default", Stream: "aurora_slowquery_logs", Field: "partition_keys", Have: "instance_id"},
+	}, drift)
+
+	created := fake.streams["default"]["aurora_error_logs"]
+	assert.Equal(t, 30, created.DataRetention)
+	assert.Equal(t, openObservePartitionKeys{{Field: "cluster_id", Types: "value"}}, created.PartitionKeys)
+	assert.Equal(t, []string{"message"}, created.FullTextSearchKeys)
+
+	updated := fake.streams["default"]["aurora_slowquery_logs"]
+	assert.Equal(t, 14, updated.DataRetention)
+	assert.Len(t, updated.PartitionKeys, 2, "undeclared keys are kept")
+
+	// A second pass only reports the undeclared key and writes nothing
+	writes := fake.writes
+	drift, err = provisioner.Reconcile(context.Background(), testStreamSpecs, true)
+	require.NoError(t, err)
+	require.Len(t, drift, 1)
+	assert.Equal(t, "instance_id", drift[0].Have)
+	assert.Equal(t, writes, fake.writes)
+}
+
+func TestOpenObserveStreamProvisionerCheckOnly(t *testing.T) {
+	fake, server := newFakeOpenObserve(t)
+
+	provisioner := NewOpenObserveStreamProvisioner(server.URL, "admin", "secret", server.Client())
+	drift, err := provisioner.Reconcile(context.Background(), testStreamSpecs, false)
+	require.NoError(t, err)
+	require.Len(t, drift, 2)
+	for _, entry := range drift {
+		assert.Equal(t, "stream", entry.Field)
+		assert.False(t, entry.Fixed)
+	}
+	assert.Zero(t, fake.writes)
+}
+
+func TestOpenObserveStreamProvisionerErrors(t *testing.T) {
+	_, server := newFakeOpenObserve(t)
+
+	provisioner := NewOpenObserveStreamProvisioner(server.URL, "admin", "wrong", server.Client())
+	_, err := provisioner.Reconcile(context.Background(), testStreamSpecs, true)
+	assert.ErrorContains(t, err, "401")
+}
+
+func TestOpenObserveStreamProvisionerRouteCredentials(t *testing.T) {
+	fake, server := newFakeOpenObserve(t)
+	fake.users["payments"] = "payments-admin:p4y"
+	fake.users["search"] = "search-admin:s34rch"
+
+	secret := filepath.Join(t.TempDir(), "payments-password")
+	require.NoError(t, os.WriteFile(secret, []byte("p4y\n"), 0o600))
+	routes, err := loadOpenObserveRoutes(writeRoutesFile(t, `
+routes:
+  - name: payments
+    org: payments
+    user: payments-admin
+    password_file: `+secret+`
+`))
+	require.NoError(t, err)
+
+	specs := []OpenObserveStreamSpec{
+		{Name: "payments_error", Org: "payments", Type: "logs"},
+		{Name: "search_error", Org: "search", Type: "logs"}, // no route credentials for this org
+		{Name: "aurora_logs", Org: "default", Type: "logs"},
+	}
+	provisioner := NewOpenObserveStreamProvisioner(server.URL, "admin", "secret", server.Client())
+	provisioner.UseRouteCredentials(routes)
+	drift, err := provisioner.Reconcile(context.Background(), specs, true)
+
+	assert.ErrorContains(t, err, "failed to list OpenObserve streams in search")
+	assert.NotContains(t, err.Error(), "payments")
+	require.Len(t, drift, 2, "the failing org does not stop the others")
+	assert.Contains(t, fake.streams["payments"], "payments_error")
+	assert.Contains(t, fake.streams["default"], "aurora_logs")
+}
+
+func TestOpenObservePartitionKeysFormats(t *testing.T) {
+	var settings openObserveStreamSettings
+	require.NoError(t, json.Unmarshal([]byte(`{"partition_keys":{"1":{"field":"b","types":"value"},"0":{"field":"a","types":"value","disabled":true}}}`), &settings))
+	assert.Equal(t, openObservePartitionKeys{{Field: "a", Types: "value", Disabled: true}, {Field: "b", Types: "value"}}, settings.PartitionKeys)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"partition_keys":[{"field":"c","types":"value"}]}`), &settings))
+	assert.Equal(t, openObservePartitionKeys{{Field: "c", Types: "value"}}, settings.PartitionKeys)
+}
+
+func TestLoadOpenObserveStreamSpecs(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "streams.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(`
+streams:
+  - name: aurora_logs
+    retention_days: 7
+    full_text_search_keys: [message]
+  - name: payments_error
+    org: payments
+    partition_keys: [cluster_id, level]
+`), 0o600))
+
+	specs, err := loadOpenObserveStreamSpecs(file, "default")
+	require.NoError(t, err)
+	require.Len(t, specs, 2)
+	assert.Equal(t, OpenObserveStreamSpec{Name: "aurora_logs", Org: "default", Type: "logs", RetentionDays: 7, FullTextSearchKeys: []string{"message"}}, specs[0])
+	assert.Equal(t, "payments", specs[1].Org)
+
+	require.NoError(t, os.WriteFile(file, []byte("streams:\n  - retention_days: 7\n"), 0o600))
+	_, err = loadOpenObserveStreamSpecs(file, "default")
+	assert.ErrorContains(t, err, "no name")
+}
+
+func TestProvisionOpenObserveStreamsFromEnv(t *testing.T) {
+	fake, server := newFakeOpenObserve(t)
+	file := filepath.Join(t.TempDir(), "streams.yaml")
+	require.NoError(t, os.WriteFile(file, []byte("streams:\n  - name: aurora_logs\n    retention_days: 7\n"), 0o600))
+	t.Setenv("OPENOBSERVE_STREAMS_FILE", file)
+
+	metrics := metrics.NewExporter("", "", "")
+	config := OpenObserveSinkConfig{URL: server.URL, Org: "default", User: "admin", Pass: "secret"}
+	drift, err := provisionOpenObserveStreams(context.Background(), config, server.Client(), metrics, false)
+	require.NoError(t, err)
+	require.Len(t, drift, 1)
+	assert.Equal(t, int64(1), metrics.Counter("openobserve_stream_drift"))
+
+	t.Setenv("OPENOBSERVE_STREAMS_MODE", "apply")
+	startupStreamProvisioning(config, server.Client(), metrics)
+	assert.Equal(t, 7, fake.streams["default"]["aurora_logs"].DataRetention)
+}
@@ -0,0 +1,852 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/yourorg/aurora-log-system/common/circuit"
+	"github.com/yourorg/aurora-log-system/common/env"
+	commonkafka "github.com/yourorg/aurora-log-system/common/kafka"
+	"github.com/yourorg/aurora-log-system/common/metrics"
+)
+
+// Sink is an output destination for parsed log entries. Every entry in a
+// batch belongs to the same log type, cluster and instance as logMsg.
+// Sinks must not modify the entries they receive; they are shared between sinks.
+type Sink interface {
+	Name() string
+	WriteBatch(ctx context.Context, logMsg LogMessage, batch []ParsedLogEntry) error
+	Flush(ctx context.Context) error
+	Close() error
+	Health(ctx context.Context) error
+}
+
+// rollingSink is implemented by sinks that buffer output across batches
+// (e.g. archive objects) and close it on their own size or age limits.
+// The runner calls Roll on every flush tick. WriteBatch only buffers, so a
+// file's flush request calls FlushFile before its delivery completes; final
+// is set once the file has been read to its end.
+type rollingSink interface {
+	Roll(ctx context.Context) error
+	FlushFile(ctx context.Context, logMsg LogMessage, final bool) error
+}
+
+// SinkFilter selects the files and entries a sink receives. Empty lists
+// match everything; entries without a level are never filtered by Levels.
+type SinkFilter struct {
+	LogTypes []string
+	Clusters []string // path.Match patterns, e.g. "prod-*"
+	Levels   []string
+}
+
+func (f SinkFilter) matchesFile(logMsg LogMessage) bool {
+	if len(f.LogTypes) > 0 && !containsFold(f.LogTypes, logMsg.LogType) {
+		return false
+	}
+	if len(f.Clusters) == 0 {
+		return true
+	}
+	for _, pattern := range f.Clusters {
+		if ok, _ := path.Match(pattern, logMsg.ClusterID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (f SinkFilter) matchesEntry(entry ParsedLogEntry) bool {
+	if len(f.Levels) == 0 {
+		return true
+	}
+	level, ok := entry["level"].(string)
+	if !ok {
+		return true
+	}
+	return containsFold(f.Levels, level)
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// SinkOptions configure filtering, batching and failure handling for one sink
+type SinkOptions struct {
+	Filter                SinkFilter
+	BatchSize             int
+	FlushInterval         time.Duration
+	QueueSize             int
+	EnqueueTimeout        time.Duration
+	MaxRetries            int
+	RetryBackoff          time.Duration
+	CircuitBreakerMax     int
+	CircuitBreakerTimeout time.Duration
+	HealthInterval        time.Duration
+	// Disk spool for batches the sink cannot accept; disabled when SpoolDir is empty
+	SpoolDir           string
+	SpoolMaxBytes      int64
+	SpoolSegmentBytes  int64
+	SpoolDrainInterval time.Duration
+}
+
+// loadSinkOptions reads SINK_<NAME>_* environment variables on top of defaults
+func loadSinkOptions(name string, defaults SinkOptions) SinkOptions {
+	prefix := "SINK_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+	opts := defaults
+	opts.Filter = SinkFilter{
+		LogTypes: env.List(prefix + "LOG_TYPES"),
+		Clusters: env.List(prefix + "CLUSTERS"),
+		Levels:   env.List(prefix + "LEVELS"),
+	}
+	opts.BatchSize = env.Int(prefix+"BATCH_SIZE", defaults.BatchSize)
+	opts.FlushInterval = time.Duration(env.Int(prefix+"FLUSH_SEC", int(defaults.FlushInterval.Seconds()))) * time.Second
+	opts.QueueSize = env.Int(prefix+"QUEUE_SIZE", defaults.QueueSize)
+	opts.EnqueueTimeout = time.Duration(env.Int(prefix+"ENQUEUE_TIMEOUT_SEC", int(defaults.EnqueueTimeout.Seconds()))) * time.Second
+	opts.MaxRetries = env.Int(prefix+"MAX_RETRIES", defaults.MaxRetries)
+	if defaults.SpoolDir != "" {
+		opts.SpoolDir = filepath.Join(defaults.SpoolDir, name)
+	}
+	opts.SpoolDir = env.Get(prefix+"SPOOL_DIR", opts.SpoolDir)
+	opts.SpoolMaxBytes = int64(env.Int(prefix+"SPOOL_MAX_MB", int(defaults.SpoolMaxBytes>>20))) << 20
+	return opts
+}
+
+// archiveSpoolDir is where an archive sink keeps objects it could not upload
+// by shutdown, beside the sink's batch spool
+func archiveSpoolDir(opts SinkOptions) string {
+	if opts.SpoolDir == "" {
+		return ""
+	}
+	return filepath.Join(opts.SpoolDir, "objects")
+}
+
+// buildSinks creates the sinks listed in cfg.Sinks with their per-sink options
+func buildSinks(cfg Config, awsCfg aws.Config, httpPool *HTTPConnectionPool, forwarder LogForwarder, metrics *metrics.Exporter) (*SinkFanOut, error) {
+	defaults := SinkOptions{
+		BatchSize:             1000,
+		FlushInterval:         cfg.BatchTimeout,
+		QueueSize:             100,
+		EnqueueTimeout:        30 * time.Second,
+		MaxRetries:            cfg.MaxRetries,
+		RetryBackoff:          time.Second,
+		CircuitBreakerMax:     cfg.CircuitBreakerMax,
+		CircuitBreakerTimeout: cfg.CircuitBreakerTimeout,
+		SpoolDir:              env.Get("SINK_SPOOL_DIR", ""),
+		SpoolMaxBytes:         int64(env.Int("SINK_SPOOL_MAX_MB", 1024)) << 20,
+		SpoolSegmentBytes:     int64(env.Int("SINK_SPOOL_SEGMENT_MB", 16)) << 20,
+		SpoolDrainInterval:    time.Duration(env.Int("SINK_SPOOL_DRAIN_SEC", 10)) * time.Second,
+	}
+
+	fanOut := NewSinkFanOut(metrics)
+	for _, name := range cfg.Sinks {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		opts := loadSinkOptions(name, defaults)
+		var sink Sink
+		switch name {
+		case "openobserve":
+			openObserveConfig, err := loadOpenObserveSinkConfig(cfg)
+			if err != nil {
+				return nil, err
+			}
+			httpClient := httpPool.Get()
+			startupStreamProvisioning(openObserveConfig, httpClient, metrics)
+			httpPool.Put(httpClient)
+			sink = NewOpenObserveSink(openObserveConfig, httpPool, metrics)
+		case "fluentbit":
+			if forwarder == nil {
+				return nil, fmt.Errorf("sink fluentbit requires LOG_FORWARD_ENABLED=true")
+			}
+			sink = NewFluentBitSink(forwarder)
+		case "loki":
+			lokiSink, err := NewLokiSink(loadLokiSinkConfig(), httpPool, metrics)
+			if err != nil {
+				return nil, err
+			}
+			sink = lokiSink
+		case "otlp":
+			otlpSink, err := NewOTLPSink(loadOTLPSinkConfig(cfg.Region), httpPool)
+			if err != nil {
+				return nil, err
+			}
+			sink = otlpSink
+		case "syslog":
+			syslogConfig, err := loadSyslogSinkConfig()
+			if err != nil {
+				return nil, err
+			}
+			syslogSink, err := NewSyslogSink(syslogConfig)
+			if err != nil {
+				return nil, err
+			}
+			sink = syslogSink
+		case "kafka":
+			kafkaConfig := loadKafkaRepublishSinkConfig(cfg.KafkaBrokers)
+			transport, err := commonkafka.LoadSecurityConfig().Transport(awsCfg)
+			if err != nil {
+				return nil, fmt.Errorf("failed to configure Kafka security: %w", err)
+			}
+			kafkaConfig.Transport = transport
+			kafkaSink, err := NewKafkaRepublishSink(kafkaConfig)
+			if err != nil {
+				return nil, err
+			}
+			sink = kafkaSink
+		case "opensearch":
+			sink = NewOpenSearchSink(loadOpenSearchSinkConfig(), httpPool)
+		case "splunk":
+			splunkSink, err := NewSplunkHECSink(loadSplunkHECSinkConfig(), httpPool)
+			if err != nil {
+				return nil, err
+			}
+			sink = splunkSink
+		case "s3":
+			s3Config := loadS3ArchiveSinkConfig()
+			s3Config.SpoolDir = archiveSpoolDir(opts)
+			s3Sink, err := NewS3ArchiveSink(s3Config, awsCfg)
+			if err != nil {
+				return nil, err
+			}
+			sink = s3Sink
+		case "parquet":
+			parquetConfig := loadParquetArchiveSinkConfig()
+			parquetConfig.S3.SpoolDir = archiveSpoolDir(opts)
+			parquetSink, err := NewParquetArchiveSink(parquetConfig, awsCfg)
+			if err != nil {
+				return nil, err
+			}
+			sink = parquetSink
+		default:
+			return nil, fmt.Errorf("unknown sink %q", name)
+		}
+
+		if err := fanOut.Add(sink, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(fanOut.runners) == 0 {
+		return nil, fmt.Errorf("no sinks configured")
+	}
+	return fanOut, nil
+}
+
+// entryTimestamp returns the event time OpenObserve indexes (`_timestamp`, ms)
+func entryTimestamp(entry ParsedLogEntry) time.Time {
+	switch ts := entry["_timestamp"].(type) {
+	case int64:
+		return time.UnixMilli(ts)
+	case float64:
+		return time.UnixMilli(int64(ts))
+	case int:
+		return time.UnixMilli(int64(ts))
+	}
+	return time.Now()
+}
+
+// entryLineNumber returns the line of its file an entry was parsed from.
+// Numbers decoded from the spool arrive as float64.
+func entryLineNumber(entry ParsedLogEntry) (int64, bool) {
+	switch line := entry["line_number"].(type) {
+	case int:
+		return int64(line), true
+	case int64:
+		return line, true
+	case float64:
+		return int64(line), true
+	}
+	return 0, false
+}
+
+// entryID identifies an entry by the file line it was parsed from, so
+// identical lines in one file stay separate and the ID survives retries and
+// reprocessing from a checkpoint, whatever the batch position or fields
+// such as a fallback timestamp. Entries without a line number fall back to
+// their source and position in the batch.
+func entryID(logMsg LogMessage, entry ParsedLogEntry, position int, source []byte) string {
+	hash := sha256.New()
+	if line, ok := entryLineNumber(entry); ok {
+		fmt.Fprintf(hash, "%s\x00%s\x00line:%d", logMsg.InstanceID, logMsg.LogFileName, line)
+		return hex.EncodeToString(hash.Sum(nil))[:40]
+	}
+	fmt.Fprintf(hash, "%s\x00%s\x00%d\x00", logMsg.InstanceID, logMsg.LogFileName, position)
+	hash.Write(source)
+	return hex.EncodeToString(hash.Sum(nil))[:40]
+}
+
+type sinkBatch struct {
+	logMsg     LogMessage
+	entries    []ParsedLogEntry
+	deliveries []*SinkDelivery
+	flush      bool // write the pending batch for logMsg now
+	final      bool // with flush: logMsg's file was read to its end
+}
+
+// SinkDelivery tracks the batches of one file through every sink. Each
+// batch counts as delivered once a sink wrote it or spooled it to disk, and
+// for buffering sinks once the flush after it uploaded the buffer.
+type SinkDelivery struct {
+	mu      sync.Mutex
+	pending int
+	idle    chan struct{}
+	errs    []error
+}
+
+func NewSinkDelivery() *SinkDelivery {
+	idle := make(chan struct{})
+	close(idle)
+	return &SinkDelivery{idle: idle}
+}
+
+func (d *SinkDelivery) add() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pending == 0 {
+		d.idle = make(chan struct{})
+	}
+	d.pending++
+}
+
+func (d *SinkDelivery) done(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err != nil {
+		d.errs = append(d.errs, err)
+	}
+	d.pending--
+	if d.pending == 0 {
+		close(d.idle)
+	}
+}
+
+// Wait blocks until every batch handed out so far is delivered and returns
+// the errors of batches that were lost
+func (d *SinkDelivery) Wait(ctx context.Context) error {
+	d.mu.Lock()
+	idle := d.idle
+	d.mu.Unlock()
+
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return errors.Join(d.errs...)
+}
+
+// sinkFileKey identifies the source file of a batch
+func sinkFileKey(logMsg LogMessage) string {
+	return logMsg.InstanceID + "/" + logMsg.LogFileName
+}
+
+func sinkBatchKey(logMsg LogMessage) string {
+	return logMsg.LogType + "/" + logMsg.ClusterID + "/" + logMsg.InstanceID
+}
+
+// sinkRunner owns one sink: its queue, batching, retries and circuit
+// breaker. Runners never share state, so a slow or failing sink only ever
+// fills its own queue.
+type sinkRunner struct {
+	sink    Sink
+	opts    SinkOptions
+	queue   chan sinkBatch
+	breaker *circuit.Breaker
+	metrics *metrics.Exporter
+	spool   *SinkSpool
+	pending map[string]*sinkBatch
+	done    chan struct{}
+	cancel  context.CancelFunc
+}
+
+func newSinkRunner(sink Sink, opts SinkOptions, metrics *metrics.Exporter) *sinkRunner {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 5 * time.Second
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 100
+	}
+	if opts.EnqueueTimeout <= 0 {
+		opts.EnqueueTimeout = 30 * time.Second
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = time.Second
+	}
+	if opts.CircuitBreakerMax <= 0 {
+		opts.CircuitBreakerMax = 5
+	}
+	if opts.CircuitBreakerTimeout <= 0 {
+		opts.CircuitBreakerTimeout = 30 * time.Second
+	}
+	if opts.HealthInterval <= 0 {
+		opts.HealthInterval = time.Minute
+	}
+	if opts.SpoolDrainInterval <= 0 {
+		opts.SpoolDrainInterval = 10 * time.Second
+	}
+
+	return &sinkRunner{
+		sink:    sink,
+		opts:    opts,
+		queue:   make(chan sinkBatch, opts.QueueSize),
+		breaker: circuit.NewBreaker(opts.CircuitBreakerMax, opts.CircuitBreakerTimeout),
+		metrics: metrics,
+		pending: make(map[string]*sinkBatch),
+		done:    make(chan struct{}),
+	}
+}
+
+func (r *sinkRunner) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	go r.run(ctx)
+}
+
+func (r *sinkRunner) run(ctx context.Context) {
+	defer close(r.done)
+
+	flushTicker := time.NewTicker(r.opts.FlushInterval)
+	defer flushTicker.Stop()
+	healthTicker := time.NewTicker(r.opts.HealthInterval)
+	defer healthTicker.Stop()
+	var drain <-chan time.Time
+	if r.spool != nil {
+		drainTicker := time.NewTicker(r.opts.SpoolDrainInterval)
+		defer drainTicker.Stop()
+		drain = drainTicker.C
+	}
+
+	for {
+		select {
+		case batch, ok := <-r.queue:
+			if !ok {
+				r.flushPending(ctx)
+				if err := r.sink.Flush(ctx); err != nil {
+					slog.Error("Failed to flush sink", "sink", r.sink.Name(), "error", err)
+				}
+				return
+			}
+			r.add(ctx, batch)
+		case <-flushTicker.C:
+			r.flushPending(ctx)
+			if rolling, ok := r.sink.(rollingSink); ok {
+				if err := rolling.Roll(ctx); err != nil {
+					slog.Error("Failed to roll sink output", "sink", r.sink.Name(), "error", err)
+					r.metrics.RecordError("sink_"+r.sink.Name(), "roll")
+				}
+			}
+		case <-healthTicker.C:
+			if err := r.sink.Health(ctx); err != nil {
+				slog.Warn("Sink unhealthy", "sink", r.sink.Name(), "error", err)
+				r.metrics.RecordError("sink_"+r.sink.Name(), "health_check")
+			}
+		case <-drain:
+			r.drainSpool(ctx)
+		}
+	}
+}
+
+// enqueue hands a batch to the runner, waiting at most EnqueueTimeout for
+// queue space. When the queue stays full the batch goes to the spool, if
+// the sink has one. The batch's deliveries are completed here unless it
+// was queued.
+func (r *sinkRunner) enqueue(ctx context.Context, batch sinkBatch) error {
+	select {
+	case r.queue <- batch:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(r.opts.EnqueueTimeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case r.queue <- batch:
+		return nil
+	case <-timer.C:
+		err = fmt.Errorf("sink %s queue is full", r.sink.Name())
+		if r.spool != nil && !batch.flush {
+			if spoolErr := r.spoolBatch(batch); spoolErr == nil {
+				err = nil
+			} else {
+				err = errors.Join(err, spoolErr)
+			}
+		}
+		if err != nil && !batch.flush {
+			r.metrics.IncrementCounter(fmt.Sprintf("sink_%s_dropped_entries", r.sink.Name()), int64(len(batch.entries)))
+		}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	for _, delivery := range batch.deliveries {
+		delivery.done(err)
+	}
+	return err
+}
+
+func (r *sinkRunner) add(ctx context.Context, batch sinkBatch) {
+	key := sinkBatchKey(batch.logMsg)
+	if batch.flush {
+		if pending, ok := r.pending[key]; ok {
+			r.writePending(ctx, *pending)
+			delete(r.pending, key)
+		}
+		err := r.flushSink(ctx, batch)
+		for _, delivery := range batch.deliveries {
+			delivery.done(err)
+		}
+		return
+	}
+
+	pending, ok := r.pending[key]
+	if !ok {
+		pending = &sinkBatch{logMsg: batch.logMsg, entries: make([]ParsedLogEntry, 0, r.opts.BatchSize)}
+		r.pending[key] = pending
+	}
+
+	if len(batch.entries) == 0 {
+		for _, delivery := range batch.deliveries {
+			delivery.done(nil)
+		}
+	}
+
+	// The batch's deliveries follow its entries into every pending batch
+	// they land in; enqueue took the reference for the first one
+	attached, first := false, true
+	for _, entry := range batch.entries {
+		if !attached {
+			for _, delivery := range batch.deliveries {
+				if !first {
+					delivery.add()
+				}
+				pending.deliveries = append(pending.deliveries, delivery)
+			}
+			attached, first = true, false
+		}
+		pending.entries = append(pending.entries, entry)
+		if len(pending.entries) >= r.opts.BatchSize {
+			r.writePending(ctx, *pending)
+			pending.entries = make([]ParsedLogEntry, 0, r.opts.BatchSize)
+			pending.deliveries = nil
+			attached = false
+		}
+	}
+
+	if len(pending.entries) == 0 {
+		delete(r.pending, key)
+	}
+}
+
+func (r *sinkRunner) flushPending(ctx context.Context) {
+	for key, pending := range r.pending {
+		if len(pending.entries) > 0 {
+			r.writePending(ctx, *pending)
+		}
+		delete(r.pending, key)
+	}
+}
+
+// flushSink flushes what a buffering sink holds for the flush batch's
+// file. Other sinks have nothing left to flush once their pending batch is
+// written. While the spool holds data, the flush is spooled behind it, so
+// it only runs once the file's spooled batches are replayed.
+func (r *sinkRunner) flushSink(ctx context.Context, flush sinkBatch) error {
+	rolling, ok := r.sink.(rollingSink)
+	if !ok {
+		return nil
+	}
+	if r.spool != nil && r.spool.Len() > 0 {
+		return r.spoolBatch(flush)
+	}
+	err := r.breaker.Call(func() error {
+		return rolling.FlushFile(ctx, flush.logMsg, flush.final)
+	})
+	if err != nil {
+		slog.Error("Failed to flush sink", "sink", r.sink.Name(), "error", err)
+		r.metrics.RecordError("sink_"+r.sink.Name(), "flush")
+		return fmt.Errorf("sink %s flush: %w", r.sink.Name(), err)
+	}
+	return nil
+}
+
+// writePending writes a batch and reports the outcome to its deliveries
+func (r *sinkRunner) writePending(ctx context.Context, batch sinkBatch) {
+	err := r.write(ctx, batch)
+	for _, delivery := range batch.deliveries {
+		delivery.done(err)
+	}
+}
+
+// write delivers one batch with retries behind the sink's circuit breaker.
+// With a spool, batches the sink does not accept are spooled instead, and
+// while the spool holds data new batches queue up behind it to keep order.
+func (r *sinkRunner) write(ctx context.Context, batch sinkBatch) error {
+	name := r.sink.Name()
+	if r.spool != nil && r.spool.Len() > 0 {
+		return r.spoolBatch(batch)
+	}
+
+	var err error
+	for attempt := 0; attempt <= r.opts.MaxRetries; attempt++ {
+		if attempt > 0 {
+			backoff := time.Duration(attempt) * r.opts.RetryBackoff
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+
+		err = r.breaker.Call(func() error {
+			return r.sink.WriteBatch(ctx, batch.logMsg, batch.entries)
+		})
+		if err == nil {
+			r.metrics.IncrementCounter(fmt.Sprintf("sink_%s_written_entries", name), int64(len(batch.entries)))
+			return nil
+		}
+
+		slog.Warn("Sink write failed",
+			"sink", name,
+			"attempt", attempt+1,
+			"entries", len(batch.entries),
+			"log_type", batch.logMsg.LogType,
+			"error", err)
+	}
+
+	if r.spool != nil {
+		spoolErr := r.spoolBatch(batch)
+		if spoolErr == nil {
+			return nil
+		}
+		err = errors.Join(err, spoolErr)
+	}
+
+	slog.Error("Sink write failed after retries",
+		"sink", name,
+		"entries", len(batch.entries),
+		"instance_id", batch.logMsg.InstanceID,
+		"error", err)
+	r.metrics.IncrementCounter(fmt.Sprintf("sink_%s_failed_entries", name), int64(len(batch.entries)))
+	return err
+}
+
+// spoolBatch appends a batch to the sink's spool for later replay
+func (r *sinkRunner) spoolBatch(batch sinkBatch) error {
+	if err := r.spool.Append(batch); err != nil {
+		r.metrics.RecordError("sink_"+r.sink.Name(), "spool")
+		return fmt.Errorf("sink %s spool: %w", r.sink.Name(), err)
+	}
+	r.metrics.IncrementCounter(fmt.Sprintf("sink_%s_spooled_entries", r.sink.Name()), int64(len(batch.entries)))
+	return nil
+}
+
+// drainSpool replays spooled batches until the spool is empty or the sink
+// fails again. Writes go through the circuit breaker without retries; the
+// next drain tick is the retry.
+func (r *sinkRunner) drainSpool(ctx context.Context) {
+	if r.spool.Len() == 0 {
+		return
+	}
+
+	name := r.sink.Name()
+	replayed, err := r.spool.Drain(ctx, func(batch sinkBatch) error {
+		return r.breaker.Call(func() error {
+			return r.replay(ctx, batch)
+		})
+	})
+	if replayed > 0 {
+		r.metrics.IncrementCounter(fmt.Sprintf("sink_%s_replayed_entries", name), int64(replayed))
+		r.metrics.IncrementCounter(fmt.Sprintf("sink_%s_written_entries", name), int64(replayed))
+	}
+	if err != nil {
+		slog.Warn("Spool replay stopped", "sink", name, "replayed_entries", replayed, "remaining_batches", r.spool.Len(), "error", err)
+		return
+	}
+	slog.Info("Spool drained", "sink", name, "replayed_entries", replayed)
+}
+
+// replay writes one spooled batch. A buffering sink only holds the entries
+// in memory after WriteBatch, so the file is flushed before the record
+// counts as replayed; otherwise the segment could be deleted while its
+// entries are still unwritten. A failed flush replays the record again.
+func (r *sinkRunner) replay(ctx context.Context, batch sinkBatch) error {
+	rolling, ok := r.sink.(rollingSink)
+	if batch.flush {
+		if !ok {
+			return nil
+		}
+		return rolling.FlushFile(ctx, batch.logMsg, batch.final)
+	}
+	if err := r.sink.WriteBatch(ctx, batch.logMsg, batch.entries); err != nil {
+		return err
+	}
+	if ok {
+		return rolling.FlushFile(ctx, batch.logMsg, false)
+	}
+	return nil
+}
+
+// close drains the queue and closes the sink, giving up when ctx expires
+func (r *sinkRunner) close(ctx context.Context) error {
+	close(r.queue)
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		r.cancel()
+		<-r.done
+	}
+	r.cancel()
+	if r.spool != nil {
+		if err := r.spool.Close(); err != nil {
+			slog.Error("Failed to close spool", "sink", r.sink.Name(), "error", err)
+		}
+	}
+	return r.sink.Close()
+}
+
+// SinkFanOut delivers every batch to all configured sinks independently
+type SinkFanOut struct {
+	runners []*sinkRunner
+	metrics *metrics.Exporter
+}
+
+func NewSinkFanOut(metrics *metrics.Exporter) *SinkFanOut {
+	return &SinkFanOut{metrics: metrics}
+}
+
+// Add registers a sink and opens its spool; must be called before Start
+func (f *SinkFanOut) Add(sink Sink, opts SinkOptions) error {
+	runner := newSinkRunner(sink, opts, f.metrics)
+	if opts.SpoolDir != "" {
+		spool, err := OpenSinkSpool(opts.SpoolDir, opts.SpoolMaxBytes, opts.SpoolSegmentBytes)
+		if err != nil {
+			return fmt.Errorf("sink %s: %w", sink.Name(), err)
+		}
+		runner.spool = spool
+	}
+	f.runners = append(f.runners, runner)
+	return nil
+}
+
+// Start launches one goroutine per sink
+func (f *SinkFanOut) Start() {
+	for _, runner := range f.runners {
+		runner.start()
+	}
+}
+
+// Names returns the configured sink names
+func (f *SinkFanOut) Names() []string {
+	names := make([]string, 0, len(f.runners))
+	for _, runner := range f.runners {
+		names = append(names, runner.sink.Name())
+	}
+	return names
+}
+
+// WriteBatch applies each sink's filters and enqueues the batch to every
+// matching sink in parallel. An error means at least one sink rejected it.
+// If delivery is not nil it tracks the batch until every sink has written
+// or spooled it.
+func (f *SinkFanOut) WriteBatch(ctx context.Context, logMsg LogMessage, batch []ParsedLogEntry, delivery *SinkDelivery) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(f.runners))
+
+	for i, runner := range f.runners {
+		if !runner.opts.Filter.matchesFile(logMsg) {
+			continue
+		}
+
+		entries := make([]ParsedLogEntry, 0, len(batch))
+		for _, entry := range batch {
+			if runner.opts.Filter.matchesEntry(entry) {
+				entries = append(entries, entry)
+			}
+		}
+		if len(entries) == 0 {
+			continue
+		}
+
+		queued := sinkBatch{logMsg: logMsg, entries: entries}
+		if delivery != nil {
+			delivery.add()
+			queued.deliveries = []*SinkDelivery{delivery}
+		}
+
+		wg.Add(1)
+		go func(i int, runner *sinkRunner, queued sinkBatch) {
+			defer wg.Done()
+			errs[i] = runner.enqueue(ctx, queued)
+		}(i, runner, queued)
+	}
+
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Flush asks every sink that accepts logMsg's file to write its pending
+// batch for that file without waiting for the flush interval. Buffering
+// sinks also flush what they hold for the file; if delivery is not nil it
+// tracks those flushes. final marks the flush after the file's last line.
+func (f *SinkFanOut) Flush(ctx context.Context, logMsg LogMessage, delivery *SinkDelivery, final bool) error {
+	var errs []error
+	for _, runner := range f.runners {
+		if !runner.opts.Filter.matchesFile(logMsg) {
+			continue
+		}
+		flush := sinkBatch{logMsg: logMsg, flush: true, final: final}
+		if _, ok := runner.sink.(rollingSink); ok && delivery != nil {
+			delivery.add()
+			flush.deliveries = []*SinkDelivery{delivery}
+		}
+		errs = append(errs, runner.enqueue(ctx, flush))
+	}
+	return errors.Join(errs...)
+}
+
+// Health reports the health of every sink by name
+func (f *SinkFanOut) Health(ctx context.Context) map[string]error {
+	health := make(map[string]error, len(f.runners))
+	for _, runner := range f.runners {
+		health[runner.sink.Name()] = runner.sink.Health(ctx)
+	}
+	return health
+}
+
+// Close flushes and closes all sinks
+func (f *SinkFanOut) Close(ctx context.Context) error {
+	var errs []error
+	for _, runner := range f.runners {
+		if err := runner.close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("sink %s: %w", runner.sink.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
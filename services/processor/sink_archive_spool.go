@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// archiveSpool keeps the output of an archive sink on local disk. Finished
+// objects that were not uploaded by shutdown are stored one file per
+// object, named after their escaped key, until the next start uploads
+// them. Objects still being filled are mirrored by a journal under open/,
+// so flushed entries survive a crash before their object is uploaded.
+type archiveSpool struct {
+	dir     string
+	pending []string // finished object keys, oldest first
+	next    int
+
+	// Journals left by a previous run; the sink rebuilds their objects
+	recovered []*archiveJournal
+}
+
+func openArchiveSpool(dir string) (*archiveSpool, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "open"), 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create archive spool directory: %w", err)
+	}
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list archive spool directory: %w", err)
+	}
+
+	spool := &archiveSpool{dir: dir}
+	for _, name := range names {
+		if name.IsDir() {
+			continue
+		}
+		// Left over from a crash while saving
+		if strings.HasSuffix(name.Name(), ".tmp") {
+			os.Remove(filepath.Join(dir, name.Name()))
+			continue
+		}
+		if key, err := url.PathUnescape(name.Name()); err == nil {
+			spool.pending = append(spool.pending, key)
+		}
+	}
+	sort.Strings(spool.pending)
+
+	journals, err := os.ReadDir(filepath.Join(dir, "open"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list archive journals: %w", err)
+	}
+	for _, name := range journals {
+		if name.IsDir() || !strings.HasSuffix(name.Name(), ".ndjson") {
+			continue
+		}
+		journal, err := readArchiveJournal(filepath.Join(dir, "open", name.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if journal != nil {
+			spool.recovered = append(spool.recovered, journal)
+		}
+	}
+
+	if len(spool.pending) > 0 || len(spool.recovered) > 0 {
+		slog.Info("Recovered archive objects not uploaded before shutdown",
+			"dir", dir,
+			"objects", len(spool.pending),
+			"journals", len(spool.recovered))
+	}
+	return spool, nil
+}
+
+func (a *archiveSpool) path(key string) string {
+	return filepath.Join(a.dir, url.PathEscape(key))
+}
+
+// save writes an object to a temporary file, fsyncs it and renames it into
+// place, so a crash never leaves a truncated object behind
+func (a *archiveSpool) save(key string, body []byte) error {
+	tmp := a.path(key) + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to spool archive object %s: %w", key, err)
+	}
+	_, err = f.Write(body)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, a.path(key))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to spool archive object %s: %w", key, err)
+	}
+	a.pending = append(a.pending, key)
+	return nil
+}
+
+// upload puts every spooled object and deletes each one once it is stored.
+// Objects that fail stay for the next call.
+func (a *archiveSpool) upload(ctx context.Context, put func(ctx context.Context, key string, body []byte) error) error {
+	var errs []error
+	var remaining []string
+	for _, key := range a.pending {
+		body, err := os.ReadFile(a.path(key))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read spooled archive object %s: %w", key, err))
+			remaining = append(remaining, key)
+			continue
+		}
+		if err := put(ctx, key, body); err != nil {
+			errs = append(errs, err)
+			remaining = append(remaining, key)
+			continue
+		}
+		if err := os.Remove(a.path(key)); err != nil {
+			slog.Warn("Failed to remove uploaded archive object from spool", "key", key, "error", err)
+		}
+		slog.Info("Uploaded spooled archive object", "key", key, "bytes", len(body))
+	}
+	a.pending = remaining
+	return errors.Join(errs...)
+}
+
+// archiveJournalHeader is the first line of a journal and says which
+// object its lines belong to
+type archiveJournalHeader struct {
+	Partition string    `json:"partition"`
+	Table     string    `json:"table,omitempty"` // Parquet: error or slowquery
+	Opened    time.Time `json:"opened"`
+}
+
+// archiveJournal mirrors the lines of one open archive object as NDJSON.
+// Lines are appended and fsynced when a file's entries must become
+// durable, and the journal is removed once its object is uploaded or
+// spooled.
+type archiveJournal struct {
+	path   string
+	header archiveJournalHeader
+	size   int64
+	lines  [][]byte // lines read back from a recovered journal
+}
+
+func (a *archiveSpool) newJournal(header archiveJournalHeader) *archiveJournal {
+	a.next++
+	name := fmt.Sprintf("%d-%d.ndjson", time.Now().UnixNano(), a.next)
+	return &archiveJournal{path: filepath.Join(a.dir, "open", name), header: header}
+}
+
+// readArchiveJournal reads a journal left by a previous run. A crash during
+// an append leaves a torn last line, which is truncated away; a journal
+// without a complete header or without lines is removed.
+func readArchiveJournal(path string) (*archiveJournal, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive journal: %w", err)
+	}
+	end := bytes.LastIndexByte(data, '\n') + 1
+	if end < len(data) {
+		if err := os.Truncate(path, int64(end)); err != nil {
+			return nil, fmt.Errorf("failed to truncate archive journal: %w", err)
+		}
+	}
+
+	lines := bytes.Split(data[:end], []byte("\n"))
+	journal := &archiveJournal{path: path, size: int64(end)}
+	if end == 0 || json.Unmarshal(lines[0], &journal.header) != nil {
+		os.Remove(path)
+		return nil, nil
+	}
+	journal.lines = lines[1 : len(lines)-1]
+	if len(journal.lines) == 0 {
+		os.Remove(path)
+		return nil, nil
+	}
+	return journal, nil
+}
+
+// append writes lines to the journal and fsyncs it. A failed append is cut
+// off again, so a retry does not repeat lines.
+func (j *archiveJournal) append(lines [][]byte) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if j.size == 0 {
+		header, err := json.Marshal(j.header)
+		if err != nil {
+			return fmt.Errorf("failed to encode archive journal header: %w", err)
+		}
+		buf.Write(header)
+		buf.WriteByte('\n')
+	}
+	for _, line := range lines {
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	f, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open archive journal: %w", err)
+	}
+	_, err = f.WriteAt(buf.Bytes(), j.size)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Truncate(j.size)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to journal archive entries: %w", err)
+	}
+	j.size += int64(buf.Len())
+	return nil
+}
+
+// remove deletes the journal once its object no longer needs it
+func (j *archiveJournal) remove() {
+	if err := os.Remove(j.path); err != nil && !os.IsNotExist(err) {
+		slog.Warn("Failed to remove archive journal", "path", j.path, "error", err)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// FluentBitSink forwards parsed entries to Fluent Bit over the forward
+// protocol, tagged `aurora.<log_type>`
+type FluentBitSink struct {
+	forwarder LogForwarder
+}
+
+// NewFluentBitSink wraps a forwarder shared with passthrough mode; the sink
+// does not own it and leaves closing it to the caller
+func NewFluentBitSink(forwarder LogForwarder) *FluentBitSink {
+	return &FluentBitSink{forwarder: forwarder}
+}
+
+func (s *FluentBitSink) Name() string { return "fluentbit" }
+
+func (s *FluentBitSink) WriteBatch(ctx context.Context, logMsg LogMessage, batch []ParsedLogEntry) error {
+	entries := make([]ForwardEntry, 0, len(batch))
+	for _, entry := range batch {
+		entries = append(entries, ForwardEntry{
+			Time:   entryTimestamp(entry),
+			Record: entry,
+		})
+	}
+	return s.forwarder.ForwardBatch(ctx, fmt.Sprintf("aurora.%s", logMsg.LogType), entries)
+}
+
+// Flush is a no-op; ForwardBatch returns once the chunk is acknowledged
+func (s *FluentBitSink) Flush(ctx context.Context) error { return nil }
+
+func (s *FluentBitSink) Close() error { return nil }
+
+func (s *FluentBitSink) Health(ctx context.Context) error {
+	if pool, ok := s.forwarder.(*ForwarderPool); ok && pool.HealthyUpstreams() == 0 {
+		return errors.New("no healthy Fluent Bit upstreams")
+	}
+	return nil
+}
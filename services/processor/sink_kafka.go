@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/yourorg/aurora-log-system/common/env"
+	"github.com/yourorg/aurora-log-system/common/message"
+)
+
+// Version of the parsed record envelope; bump on incompatible changes and
+// keep docs/schemas/aurora-parsed-log.v<N>.json in step
+const parsedLogSchemaVersion = 1
+
+// KafkaRepublishSinkConfig configures the sink that republishes parsed
+// entries to Kafka
+type KafkaRepublishSinkConfig struct {
+	Brokers      []string
+	Topic        string            // default topic
+	TopicRoutes  map[string]string // log type -> topic
+	Compression  string
+	BatchTimeout time.Duration
+	WriteTimeout time.Duration
+	Transport    *kafka.Transport // TLS and SASL; nil for plaintext
+}
+
+func loadKafkaRepublishSinkConfig(defaultBrokers []string) KafkaRepublishSinkConfig {
+	brokers := env.List("KAFKA_REPUBLISH_BROKERS")
+	if len(brokers) == 0 {
+		brokers = defaultBrokers
+	}
+
+	// KAFKA_REPUBLISH_TOPICS=error=aurora-parsed-error-logs,slowquery=aurora-parsed-slowquery-logs
+	routes := make(map[string]string)
+	for _, route := range env.List("KAFKA_REPUBLISH_TOPICS") {
+		if logType, topic, ok := strings.Cut(route, "="); ok {
+			routes[strings.TrimSpace(logType)] = strings.TrimSpace(topic)
+		}
+	}
+
+	return KafkaRepublishSinkConfig{
+		Brokers:      brokers,
+		Topic:        env.Get("KAFKA_REPUBLISH_TOPIC", "aurora-parsed-logs"),
+		TopicRoutes:  routes,
+		Compression:  env.Get("KAFKA_REPUBLISH_COMPRESSION", "snappy"),
+		BatchTimeout: time.Duration(env.Int("KAFKA_REPUBLISH_BATCH_TIMEOUT_MS", 100)) * time.Millisecond,
+		WriteTimeout: time.Duration(env.Int("KAFKA_REPUBLISH_WRITE_TIMEOUT_SEC", 10)) * time.Second,
+	}
+}
+
+// kafkaMessageWriter is the subset of kafka.Writer used by the sink
+type kafkaMessageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// ParsedLogRecord is the versioned envelope written to the republish topics
+type ParsedLogRecord struct {
+	SchemaVersion int            `json:"schema_version"`
+	RecordID      string         `json:"record_id"`
+	ClusterID     string         `json:"cluster_id"`
+	InstanceID    string         `json:"instance_id"`
+	Engine        string         `json:"engine,omitempty"`
+	LogType       string         `json:"log_type"`
+	LogFileName   string         `json:"log_file_name"`
+	Timestamp     time.Time      `json:"timestamp"`
+	Fields        ParsedLogEntry `json:"fields"`
+}
+
+// KafkaRepublishSink writes parsed entries back to Kafka so other consumers
+// can reuse the processor's parsing. Records are keyed by cluster ID and
+// written synchronously with acks from all in-sync replicas.
+//
+// kafka-go has no idempotent producer (no producer IDs or sequence
+// numbers), so a retried batch, or a file reprocessed from its checkpoint,
+// can be written twice. Consumers should deduplicate on record_id, which
+// is derived from the instance, file and line an entry was parsed from.
+type KafkaRepublishSink struct {
+	config  KafkaRepublishSinkConfig
+	writer  kafkaMessageWriter
+	brokers *kafka.Client
+}
+
+func NewKafkaRepublishSink(config KafkaRepublishSinkConfig) (*KafkaRepublishSink, error) {
+	if len(config.Brokers) == 0 || config.Brokers[0] == "" {
+		return nil, fmt.Errorf("no Kafka brokers configured for the kafka sink")
+	}
+
+	var compression kafka.Compression
+	switch config.Compression {
+	case "none", "":
+	case "gzip":
+		compression = kafka.Gzip
+	case "snappy":
+		compression = kafka.Snappy
+	case "lz4":
+		compression = kafka.Lz4
+	case "zstd":
+		compression = kafka.Zstd
+	default:
+		return nil, fmt.Errorf("unsupported Kafka compression %q", config.Compression)
+	}
+
+	writer := &kafka.Writer{
+		Addr: kafka.TCP(config.Brokers...),
+		// Hash keeps every record of a cluster on one partition, in order
+		Balancer:               &kafka.Hash{},
+		RequiredAcks:           kafka.RequireAll,
+		MaxAttempts:            3,
+		BatchTimeout:           config.BatchTimeout,
+		WriteTimeout:           config.WriteTimeout,
+		Compression:            compression,
+		AllowAutoTopicCreation: false,
+	}
+	if config.Transport != nil {
+		writer.Transport = config.Transport
+	}
+
+	return newKafkaRepublishSink(config, writer), nil
+}
+
+func newKafkaRepublishSink(config KafkaRepublishSinkConfig, writer kafkaMessageWriter) *KafkaRepublishSink {
+	var transport kafka.RoundTripper
+	if config.Transport != nil {
+		transport = config.Transport
+	}
+	return &KafkaRepublishSink{
+		config:  config,
+		writer:  writer,
+		brokers: &kafka.Client{Addr: kafka.TCP(config.Brokers...), Timeout: 10 * time.Second, Transport: transport},
+	}
+}
+
+func (s *KafkaRepublishSink) Name() string { return "kafka" }
+
+func (s *KafkaRepublishSink) topicFor(logType string) string {
+	if topic, ok := s.config.TopicRoutes[logType]; ok && topic != "" {
+		return topic
+	}
+	return s.config.Topic
+}
+
+// topics lists every topic the sink may write to
+func (s *KafkaRepublishSink) topics() []string {
+	seen := map[string]bool{s.config.Topic: true}
+	topics := []string{s.config.Topic}
+	for _, topic := range s.config.TopicRoutes {
+		if !seen[topic] {
+			seen[topic] = true
+			topics = append(topics, topic)
+		}
+	}
+	return topics
+}
+
+func (s *KafkaRepublishSink) WriteBatch(ctx context.Context, logMsg LogMessage, batch []ParsedLogEntry) error {
+	topic := s.topicFor(logMsg.LogType)
+	headers := []kafka.Header{
+		{Key: "schema_version", Value: []byte(strconv.Itoa(parsedLogSchemaVersion))},
+		{Key: "content_type", Value: []byte("application/json")},
+		{Key: "log_type", Value: []byte(logMsg.LogType)},
+	}
+	// Continue the file's trace downstream
+	if logMsg.Provenance.TraceParent != "" {
+		headers = append(headers, kafka.Header{Key: message.HeaderTraceParent, Value: []byte(logMsg.Provenance.TraceParent)})
+	}
+
+	messages := make([]kafka.Message, 0, len(batch))
+	for i, entry := range batch {
+		fields, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to marshal entry: %w", err)
+		}
+
+		record := ParsedLogRecord{
+			SchemaVersion: parsedLogSchemaVersion,
+			RecordID:      entryID(logMsg, entry, i, fields),
+			ClusterID:     logMsg.ClusterID,
+			InstanceID:    logMsg.InstanceID,
+			Engine:        logMsg.Engine,
+			LogType:       logMsg.LogType,
+			LogFileName:   logMsg.LogFileName,
+			Timestamp:     entryTimestamp(entry).UTC(),
+			Fields:        entry,
+		}
+		value, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to marshal record: %w", err)
+		}
+
+		messages = append(messages, kafka.Message{
+			Topic:   topic,
+			Key:     []byte(logMsg.ClusterID),
+			Value:   value,
+			Headers: headers,
+			Time:    record.Timestamp,
+		})
+	}
+
+	if err := s.writer.WriteMessages(ctx, messages...); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", topic, err)
+	}
+	return nil
+}
+
+// Flush is a no-op; WriteMessages returns once the batch is acknowledged
+func (s *KafkaRepublishSink) Flush(ctx context.Context) error { return nil }
+
+func (s *KafkaRepublishSink) Close() error {
+	return s.writer.Close()
+}
+
+// Health checks that the brokers answer and that every target topic exists
+func (s *KafkaRepublishSink) Health(ctx context.Context) error {
+	resp, err := s.brokers.Metadata(ctx, &kafka.MetadataRequest{Topics: s.topics()})
+	if err != nil {
+		return err
+	}
+	for _, topic := range resp.Topics {
+		if topic.Error != nil {
+			return fmt.Errorf("topic %s: %w", topic.Name, topic.Error)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeKafkaWriter struct {
+	messages []kafka.Message
+	fail     bool
+	closed   bool
+}
+
+func (w *fakeKafkaWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if w.fail {
+		return errors.New("leader not available")
+	}
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func (w *fakeKafkaWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func newTestKafkaSink(writer *fakeKafkaWriter) *KafkaRepublishSink {
+	return newKafkaRepublishSink(KafkaRepublishSinkConfig{
+		Brokers:     []string{"localhost:9092"},
+		Topic:       "aurora-parsed-logs",
+		TopicRoutes: map[string]string{"slowquery": "aurora-parsed-slowquery-logs"},
+	}, writer)
+}
+
+func TestKafkaRepublishSinkRecords(t *testing.T) {
+	writer := &fakeKafkaWriter{}
+	sink := newTestKafkaSink(writer)
+
+	ts := time.Date(2025, 8, 2, 12, 0, 0, 0, time.UTC)
+	logMsg := LogMessage{ClusterID: "prod-orders", InstanceID: "db-1", Engine: "aurora-mysql", LogType: "error", LogFileName: "error/mysql-error.log"}
+	batch := []ParsedLogEntry{
+		{"_timestamp": ts.UnixMilli(), "level": "ERROR", "message": "boom", "line_number": 41},
+		{"_timestamp": ts.UnixMilli(), "level": "ERROR", "message": "boom", "line_number": 42},
+	}
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, batch))
+	require.Len(t, writer.messages, 2)
+
+	msg := writer.messages[0]
+	assert.Equal(t, "aurora-parsed-logs", msg.Topic)
+	assert.Equal(t, "prod-orders", string(msg.Key))
+	assert.Contains(t, msg.Headers, kafka.Header{Key: "schema_version", Value: []byte("1")})
+
+	var record ParsedLogRecord
+	require.NoError(t, json.Unmarshal(msg.Value, &record))
+	assert.Equal(t, 1, record.SchemaVersion)
+	assert.Equal(t, "db-1", record.InstanceID)
+	assert.Equal(t, "aurora-mysql", record.Engine)
+	assert.True(t, ts.Equal(record.Timestamp))
+	assert.Equal(t, "boom", record.Fields["message"])
+
+	var second ParsedLogRecord
+	require.NoError(t, json.Unmarshal(writer.messages[1].Value, &second))
+	assert.NotEqual(t, record.RecordID, second.RecordID, "identical lines stay distinct records")
+
+	// A retry of the same batch produces the same record IDs
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, batch))
+	var retried ParsedLogRecord
+	require.NoError(t, json.Unmarshal(writer.messages[2].Value, &retried))
+	assert.Equal(t, record.RecordID, retried.RecordID)
+
+	// Reprocessed from a checkpoint, the line lands elsewhere in its batch
+	// and a fallback timestamp may differ; the record ID stays the same
+	reprocessed := ParsedLogEntry{"_timestamp": ts.Add(time.Minute).UnixMilli(), "level": "ERROR", "message": "boom", "line_number": 42.0}
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, []ParsedLogEntry{reprocessed}))
+	require.NoError(t, json.Unmarshal(writer.messages[4].Value, &retried))
+	assert.Equal(t, second.RecordID, retried.RecordID)
+}
+
+func TestKafkaRepublishSinkTopicRouting(t *testing.T) {
+	writer := &fakeKafkaWriter{}
+	sink := newTestKafkaSink(writer)
+
+	require.NoError(t, sink.WriteBatch(context.Background(), LogMessage{LogType: "slowquery"}, makeEntries("INFO")))
+	require.NoError(t, sink.WriteBatch(context.Background(), LogMessage{LogType: "general"}, makeEntries("INFO")))
+
+	assert.Equal(t, "aurora-parsed-slowquery-logs", writer.messages[0].Topic)
+	assert.Equal(t, "aurora-parsed-logs", writer.messages[1].Topic)
+	assert.ElementsMatch(t, []string{"aurora-parsed-logs", "aurora-parsed-slowquery-logs"}, sink.topics())
+}
+
+func TestKafkaRepublishSinkErrors(t *testing.T) {
+	writer := &fakeKafkaWriter{fail: true}
+	sink := newTestKafkaSink(writer)
+
+	err := sink.WriteBatch(context.Background(), LogMessage{LogType: "error"}, makeEntries("ERROR"))
+	assert.ErrorContains(t, err, "aurora-parsed-logs")
+
+	require.NoError(t, sink.Close())
+	assert.True(t, writer.closed)
+
+	_, err = NewKafkaRepublishSink(KafkaRepublishSinkConfig{Brokers: []string{""}})
+	assert.Error(t, err)
+	_, err = NewKafkaRepublishSink(KafkaRepublishSinkConfig{Brokers: []string{"localhost:9092"}, Compression: "brotli"})
+	assert.Error(t, err)
+}
+
+// The published JSON schema must describe exactly what the sink writes
+func TestParsedLogRecordMatchesSchema(t *testing.T) {
+	data, err := os.ReadFile("../../docs/schemas/aurora-parsed-log.v1.json")
+	require.NoError(t, err)
+
+	var schema struct {
+		Required   []string                          `json:"required"`
+		Properties map[string]map[string]interface{} `json:"properties"`
+	}
+	require.NoError(t, json.Unmarshal(data, &schema))
+	assert.Equal(t, float64(parsedLogSchemaVersion), schema.Properties["schema_version"]["const"])
+
+	value, err := json.Marshal(ParsedLogRecord{Engine: "aurora-mysql", Fields: ParsedLogEntry{}})
+	require.NoError(t, err)
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal(value, &record))
+
+	for field := range record {
+		assert.Contains(t, schema.Properties, field)
+	}
+	for _, field := range schema.Required {
+		assert.Contains(t, record, field)
+	}
+}
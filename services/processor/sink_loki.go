@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/yourorg/aurora-log-system/common/env"
+	"github.com/yourorg/aurora-log-system/common/metrics"
+	commontls "github.com/yourorg/aurora-log-system/common/tls"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Out-of-order strategies for the Loki sink
+const (
+	LokiOutOfOrderAccept = "accept" // Loki has unordered_writes enabled; only sort each push
+	LokiOutOfOrderClamp  = "clamp"  // raise older timestamps to the newest pushed for the stream
+)
+
+// lokiLabelFields become stream labels; everything else goes into the line
+var lokiLabelFields = []string{"cluster_id", "instance_id", "log_type", "level"}
+
+// LokiSinkConfig configures the Loki push sink
+type LokiSinkConfig struct {
+	URL        string
+	TenantID   string
+	User       string
+	Pass       *commontls.FileSecret
+	Encoding   string // protobuf or json
+	OutOfOrder string
+}
+
+func loadLokiSinkConfig() LokiSinkConfig {
+	return LokiSinkConfig{
+		URL:        strings.TrimRight(env.Get("LOKI_URL", "http://localhost:3100"), "/"),
+		TenantID:   env.Get("LOKI_TENANT_ID", ""),
+		User:       env.Get("LOKI_USER", ""),
+		Pass:       commontls.NewFileSecret(env.Get("LOKI_PASSWORD_FILE", "")),
+		Encoding:   env.Get("LOKI_ENCODING", "protobuf"),
+		OutOfOrder: env.Get("LOKI_OUT_OF_ORDER", LokiOutOfOrderAccept),
+	}
+}
+
+// LokiSink pushes entries to Loki's /loki/api/v1/push endpoint
+type LokiSink struct {
+	config   LokiSinkConfig
+	httpPool *HTTPConnectionPool
+	metrics  *metrics.Exporter
+
+	// newest timestamp pushed per stream, used by the clamp strategy
+	mu     sync.Mutex
+	latest map[string]time.Time
+}
+
+func NewLokiSink(config LokiSinkConfig, httpPool *HTTPConnectionPool, metrics *metrics.Exporter) (*LokiSink, error) {
+	switch config.Encoding {
+	case "protobuf", "json":
+	default:
+		return nil, fmt.Errorf("unsupported Loki encoding %q", config.Encoding)
+	}
+	switch config.OutOfOrder {
+	case LokiOutOfOrderAccept, LokiOutOfOrderClamp:
+	default:
+		return nil, fmt.Errorf("unsupported Loki out-of-order strategy %q", config.OutOfOrder)
+	}
+
+	return &LokiSink{
+		config:   config,
+		httpPool: httpPool,
+		metrics:  metrics,
+		latest:   make(map[string]time.Time),
+	}, nil
+}
+
+func (s *LokiSink) Name() string { return "loki" }
+
+type lokiEntry struct {
+	ts   time.Time
+	line string
+}
+
+type lokiStream struct {
+	labels  map[string]string
+	key     string // labels in Loki's `{k="v", ...}` form
+	entries []lokiEntry
+}
+
+func (s *LokiSink) WriteBatch(ctx context.Context, logMsg LogMessage, batch []ParsedLogEntry) error {
+	streams, err := s.buildStreams(batch)
+	if err != nil {
+		return err
+	}
+
+	var body []byte
+	var contentType string
+	if s.config.Encoding == "json" {
+		body, err = encodeLokiJSON(streams)
+		contentType = "application/json"
+	} else {
+		body = s2.EncodeSnappy(nil, encodeLokiProtobuf(streams))
+		contentType = "application/x-protobuf"
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode Loki push: %w", err)
+	}
+
+	entries := 0
+	for _, stream := range streams {
+		entries += len(stream.entries)
+	}
+	if err := s.push(ctx, body, contentType, entries); err != nil {
+		return err
+	}
+
+	if s.config.OutOfOrder == LokiOutOfOrderClamp {
+		s.mu.Lock()
+		for _, stream := range streams {
+			if last := stream.entries[len(stream.entries)-1].ts; last.After(s.latest[stream.key]) {
+				s.latest[stream.key] = last
+			}
+		}
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// Flush is a no-op; every batch is pushed synchronously
+func (s *LokiSink) Flush(ctx context.Context) error { return nil }
+
+func (s *LokiSink) Close() error { return nil }
+
+// Health checks Loki's /ready endpoint
+func (s *LokiSink) Health(ctx context.Context) error {
+	httpClient := s.httpPool.Get()
+	defer s.httpPool.Put(httpClient)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", s.config.URL+"/ready", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("Loki readiness check returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// buildStreams groups entries by label set and orders each stream by time
+func (s *LokiSink) buildStreams(batch []ParsedLogEntry) ([]*lokiStream, error) {
+	byKey := make(map[string]*lokiStream)
+	var streams []*lokiStream
+
+	for _, entry := range batch {
+		labels := make(map[string]string, len(lokiLabelFields))
+		line := make(map[string]interface{}, len(entry))
+		for k, v := range entry {
+			line[k] = v
+		}
+		for _, field := range lokiLabelFields {
+			if value, ok := entry[field].(string); ok && value != "" {
+				labels[field] = value
+				delete(line, field)
+			}
+		}
+
+		data, err := json.Marshal(line)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal entry: %w", err)
+		}
+
+		key := lokiLabelString(labels)
+		stream, ok := byKey[key]
+		if !ok {
+			stream = &lokiStream{labels: labels, key: key}
+			byKey[key] = stream
+			streams = append(streams, stream)
+		}
+		stream.entries = append(stream.entries, lokiEntry{ts: entryTimestamp(entry), line: string(data)})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, stream := range streams {
+		sort.SliceStable(stream.entries, func(i, j int) bool {
+			return stream.entries[i].ts.Before(stream.entries[j].ts)
+		})
+		if s.config.OutOfOrder == LokiOutOfOrderClamp {
+			floor := s.latest[stream.key]
+			for i := range stream.entries {
+				if stream.entries[i].ts.Before(floor) {
+					stream.entries[i].ts = floor
+				}
+			}
+		}
+	}
+	return streams, nil
+}
+
+// lokiLabelString renders labels as `{name="value", ...}` sorted by name
+func lokiLabelString(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[name]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// encodeLokiProtobuf encodes a logproto.PushRequest:
+//
+//	PushRequest  { repeated Stream streams = 1; }
+//	Stream       { string labels = 1; repeated Entry entries = 2; }
+//	Entry        { google.protobuf.Timestamp timestamp = 1; string line = 2; }
+func encodeLokiProtobuf(streams []*lokiStream) []byte {
+	var req []byte
+	for _, stream := range streams {
+		var msg []byte
+		msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+		msg = protowire.AppendString(msg, stream.key)
+
+		for _, entry := range stream.entries {
+			var ts []byte
+			ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(entry.ts.Unix()))
+			ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(entry.ts.Nanosecond()))
+
+			var e []byte
+			e = protowire.AppendTag(e, 1, protowire.BytesType)
+			e = protowire.AppendBytes(e, ts)
+			e = protowire.AppendTag(e, 2, protowire.BytesType)
+			e = protowire.AppendString(e, entry.line)
+
+			msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+			msg = protowire.AppendBytes(msg, e)
+		}
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, msg)
+	}
+	return req
+}
+
+// encodeLokiJSON encodes the JSON form of the push API
+func encodeLokiJSON(streams []*lokiStream) ([]byte, error) {
+	type jsonStream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	payload := struct {
+		Streams []jsonStream `json:"streams"`
+	}{}
+
+	for _, stream := range streams {
+		values := make([][2]string, 0, len(stream.entries))
+		for _, entry := range stream.entries {
+			values = append(values, [2]string{strconv.FormatInt(entry.ts.UnixNano(), 10), entry.line})
+		}
+		payload.Streams = append(payload.Streams, jsonStream{Stream: stream.labels, Values: values})
+	}
+	return json.Marshal(payload)
+}
+
+func (s *LokiSink) push(ctx context.Context, body []byte, contentType string, entries int) error {
+	httpClient := s.httpPool.Get()
+	defer s.httpPool.Put(httpClient)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.config.URL+"/loki/api/v1/push", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if s.config.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", s.config.TenantID)
+	}
+	if s.config.User != "" {
+		password, err := s.config.Pass.Value()
+		if err != nil {
+			return fmt.Errorf("failed to read Loki password: %w", err)
+		}
+		req.SetBasicAuth(s.config.User, password)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 300 {
+		return nil
+	}
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	message := string(respBody)
+
+	// Loki stores the rest of the push and rejects only the entries that are
+	// out of order or too old; resending would be rejected the same way, so
+	// they are counted as rejected instead
+	if resp.StatusCode == http.StatusBadRequest &&
+		(strings.Contains(message, "out of order") || strings.Contains(message, "too far behind") || strings.Contains(message, "too old")) {
+		rejected := lokiRejectedEntries(message, entries)
+		s.metrics.IncrementCounter("sink_loki_rejected_entries", int64(rejected))
+		slog.Warn("Loki rejected out-of-order entries", "rejected", rejected, "entries", entries, "response", message)
+		return nil
+	}
+
+	return fmt.Errorf("Loki push returned %d: %s", resp.StatusCode, message)
+}
+
+var lokiTotalIgnored = regexp.MustCompile(`total ignored: (\d+) out of`)
+
+// lokiRejectedEntries reads how many entries of a push Loki rejected from
+// its error message: the "total ignored" summary, else the number of listed
+// entries. A message naming none counts the whole push.
+func lokiRejectedEntries(message string, entries int) int {
+	rejected := 0
+	if match := lokiTotalIgnored.FindStringSubmatch(message); match != nil {
+		rejected, _ = strconv.Atoi(match[1])
+	} else {
+		rejected = strings.Count(message, "ignored, reason:")
+	}
+	if rejected <= 0 || rejected > entries {
+		return entries
+	}
+	return rejected
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourorg/aurora-log-system/common/metrics"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type decodedLokiStream struct {
+	labels  string
+	entries []lokiEntry
+}
+
+// decodeLokiProtobuf is a minimal logproto.PushRequest decoder for tests
+func decodeLokiProtobuf(t *testing.T, data []byte) []decodedLokiStream {
+	var streams []decodedLokiStream
+	forEachField(t, data, func(num protowire.Number, value []byte, _ uint64) {
+		require.Equal(t, protowire.Number(1), num)
+		var stream decodedLokiStream
+		forEachField(t, value, func(num protowire.Number, value []byte, _ uint64) {
+			switch num {
+			case 1:
+				stream.labels = string(value)
+			case 2:
+				var entry lokiEntry
+				forEachField(t, value, func(num protowire.Number, value []byte, _ uint64) {
+					switch num {
+					case 1:
+						var seconds, nanos uint64
+						forEachField(t, value, func(num protowire.Number, _ []byte, v uint64) {
+							if num == 1 {
+								seconds = v
+							} else {
+								nanos = v
+							}
+						})
+						entry.ts = time.Unix(int64(seconds), int64(nanos))
+					case 2:
+						entry.line = string(value)
+					}
+				})
+				stream.entries = append(stream.entries, entry)
+			}
+		})
+		streams = append(streams, stream)
+	})
+	return streams
+}
+
+func forEachField(t *testing.T, data []byte, fn func(protowire.Number, []byte, uint64)) {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		require.Greater(t, n, 0)
+		data = data[n:]
+		switch typ {
+		case protowire.BytesType:
+			value, n := protowire.ConsumeBytes(data)
+			require.Greater(t, n, 0)
+			fn(num, value, 0)
+			data = data[n:]
+		case protowire.VarintType:
+			value, n := protowire.ConsumeVarint(data)
+			require.Greater(t, n, 0)
+			fn(num, nil, value)
+			data = data[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+	}
+}
+
+func lokiTestEntries(base time.Time) []ParsedLogEntry {
+	return []ParsedLogEntry{
+		{"cluster_id": "c1", "instance_id": "db-1", "log_type": "error", "level": "ERROR", "message": "late", "_timestamp": base.Add(time.Second).UnixMilli()},
+		{"cluster_id": "c1", "instance_id": "db-1", "log_type": "error", "level": "ERROR", "message": "early", "_timestamp": base.UnixMilli()},
+		{"cluster_id": "c1", "instance_id": "db-1", "log_type": "error", "level": "INFO", "message": "note", "_timestamp": base.UnixMilli()},
+	}
+}
+
+func TestLokiSinkProtobufPush(t *testing.T) {
+	var body []byte
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/loki/api/v1/push", r.URL.Path)
+		headers = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink, err := NewLokiSink(LokiSinkConfig{URL: server.URL, TenantID: "aurora", Encoding: "protobuf", OutOfOrder: LokiOutOfOrderAccept}, NewHTTPConnectionPool(1, time.Second), metrics.NewExporter("", "", ""))
+	require.NoError(t, err)
+
+	base := time.Date(2025, 8, 2, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, sink.WriteBatch(context.Background(), LogMessage{LogType: "error"}, lokiTestEntries(base)))
+
+	assert.Equal(t, "application/x-protobuf", headers.Get("Content-Type"))
+	assert.Equal(t, "aurora", headers.Get("X-Scope-OrgID"))
+
+	decoded, err := s2.Decode(nil, body)
+	require.NoError(t, err)
+	streams := decodeLokiProtobuf(t, decoded)
+	require.Len(t, streams, 2)
+
+	assert.Equal(t, `{cluster_id="c1", instance_id="db-1", level="ERROR", log_type="error"}`, streams[0].labels)
+	require.Len(t, streams[0].entries, 2)
+	assert.True(t, streams[0].entries[0].ts.Equal(base), "entries are sorted within a stream")
+
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(streams[0].entries[0].line), &line))
+	assert.Equal(t, "early", line["message"])
+	assert.NotContains(t, line, "cluster_id", "labels are not repeated in the line")
+
+	assert.Equal(t, `{cluster_id="c1", instance_id="db-1", level="INFO", log_type="error"}`, streams[1].labels)
+}
+
+func TestLokiSinkJSONPushWithClamp(t *testing.T) {
+	var pushes []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var push map[string]interface{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&push))
+		pushes = append(pushes, push)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink, err := NewLokiSink(LokiSinkConfig{URL: server.URL, Encoding: "json", OutOfOrder: LokiOutOfOrderClamp}, NewHTTPConnectionPool(1, time.Second), metrics.NewExporter("", "", ""))
+	require.NoError(t, err)
+
+	base := time.Date(2025, 8, 2, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, sink.WriteBatch(context.Background(), LogMessage{}, lokiTestEntries(base)[:1]))
+	require.NoError(t, sink.WriteBatch(context.Background(), LogMessage{}, lokiTestEntries(base)[1:2]))
+	require.Len(t, pushes, 2)
+
+	values := pushes[1]["streams"].([]interface{})[0].(map[string]interface{})["values"].([]interface{})
+	value := values[0].([]interface{})
+	assert.Equal(t, "1754136001000000000", value[0], "older entry is raised to the newest pushed timestamp")
+	assert.Contains(t, value[1], `"_timestamp":1754136000000`, "the original timestamp stays in the line")
+}
+
+func TestLokiSinkOutOfOrderRejection(t *testing.T) {
+	status, message := http.StatusBadRequest, "entry with timestamp 2025-08-02 12:00:00 ignored, reason: 'entry out of order'"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, message, status)
+	}))
+	defer server.Close()
+
+	exporter := metrics.NewExporter("", "", "")
+	sink, err := NewLokiSink(LokiSinkConfig{URL: server.URL, Encoding: "protobuf", OutOfOrder: LokiOutOfOrderAccept}, NewHTTPConnectionPool(1, time.Second), exporter)
+	require.NoError(t, err)
+
+	batch := lokiTestEntries(time.Now())
+	assert.NoError(t, sink.WriteBatch(context.Background(), LogMessage{}, batch), "out-of-order rejections are not retried")
+	assert.Equal(t, int64(1), exporter.Counter("sink_loki_rejected_entries"))
+
+	message = "entry with timestamp 2025-08-02 12:00:00 ignored, reason: 'entry too far behind'\n" +
+		"user 'fake', total ignored: 2 out of 3"
+	assert.NoError(t, sink.WriteBatch(context.Background(), LogMessage{}, batch))
+	assert.Equal(t, int64(3), exporter.Counter("sink_loki_rejected_entries"), "the summary counts entries not listed")
+
+	status, message = http.StatusTooManyRequests, "ingestion rate limit exceeded"
+	assert.Error(t, sink.WriteBatch(context.Background(), LogMessage{}, batch))
+
+	_, err = NewLokiSink(LokiSinkConfig{Encoding: "msgpack", OutOfOrder: LokiOutOfOrderAccept}, nil, nil)
+	assert.Error(t, err)
+}
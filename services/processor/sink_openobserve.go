@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/yourorg/aurora-log-system/common/env"
+	"github.com/yourorg/aurora-log-system/common/metrics"
+)
+
+// OpenObserveSinkConfig configures the OpenObserve `_json` ingestion sink
+type OpenObserveSinkConfig struct {
+	URL    string
+	Org    string
+	User   string
+	Pass   string
+	Stream string // stream for log types without their own
+	// Streams for error and slow query logs
+	ErrorStream     string
+	SlowQueryStream string
+	Routes          []OpenObserveRoute
+	Gzip            bool
+	// Retries of throttled (429/503) requests inside one write
+	MaxRetries     int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+}
+
+func loadOpenObserveSinkConfig(cfg Config) (OpenObserveSinkConfig, error) {
+	routes, err := loadOpenObserveRoutes(env.Get("OPENOBSERVE_ROUTES_FILE", ""))
+	if err != nil {
+		return OpenObserveSinkConfig{}, err
+	}
+
+	return OpenObserveSinkConfig{
+		URL:             cfg.OpenObserveURL,
+		Org:             env.Get("OPENOBSERVE_ORG", "default"),
+		User:            cfg.OpenObserveUser,
+		Pass:            cfg.OpenObservePass,
+		Stream:          cfg.OpenObserveStream,
+		ErrorStream:     env.Get("OPENOBSERVE_ERROR_STREAM", "aurora_error_logs"),
+		SlowQueryStream: env.Get("OPENOBSERVE_SLOWQUERY_STREAM", "aurora_slowquery_logs"),
+		Routes:          routes,
+		Gzip:            env.Get("OPENOBSERVE_GZIP", "true") == "true",
+		MaxRetries:      env.Int("OPENOBSERVE_THROTTLE_RETRIES", 5),
+		RetryBaseDelay:  time.Duration(env.Int("OPENOBSERVE_RETRY_BASE_MS", 500)) * time.Millisecond,
+		RetryMaxDelay:   time.Duration(env.Int("OPENOBSERVE_RETRY_MAX_SEC", 30)) * time.Second,
+	}, nil
+}
+
+// OpenObserveSink writes batches to OpenObserve's JSON ingestion API
+type OpenObserveSink struct {
+	config   OpenObserveSinkConfig
+	httpPool *HTTPConnectionPool
+	metrics  *metrics.Exporter
+}
+
+func NewOpenObserveSink(config OpenObserveSinkConfig, httpPool *HTTPConnectionPool, metrics *metrics.Exporter) *OpenObserveSink {
+	if config.Org == "" {
+		config.Org = "default"
+	}
+	if config.ErrorStream == "" {
+		config.ErrorStream = "aurora_error_logs"
+	}
+	if config.SlowQueryStream == "" {
+		config.SlowQueryStream = "aurora_slowquery_logs"
+	}
+	if config.RetryBaseDelay <= 0 {
+		config.RetryBaseDelay = 500 * time.Millisecond
+	}
+	if config.RetryMaxDelay <= 0 {
+		config.RetryMaxDelay = 30 * time.Second
+	}
+	return &OpenObserveSink{
+		config:   config,
+		httpPool: httpPool,
+		metrics:  metrics,
+	}
+}
+
+func (s *OpenObserveSink) Name() string { return "openobserve" }
+
+func (s *OpenObserveSink) WriteBatch(ctx context.Context, logMsg LogMessage, batch []ParsedLogEntry) error {
+	return s.sendBatch(ctx, logMsg, batch)
+}
+
+// Flush is a no-op; every batch is posted synchronously
+func (s *OpenObserveSink) Flush(ctx context.Context) error { return nil }
+
+func (s *OpenObserveSink) Close() error { return nil }
+
+// Health checks OpenObserve's /healthz endpoint
+func (s *OpenObserveSink) Health(ctx context.Context) error {
+	httpClient := s.httpPool.Get()
+	defer s.httpPool.Put(httpClient)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", s.config.URL+"/healthz", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("OpenObserve health check returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// openObserveIngestResponse is the body of a `_json` ingestion response
+type openObserveIngestResponse struct {
+	Code   int `json:"code"`
+	Status []struct {
+		Name       string `json:"name"`
+		Successful int64  `json:"successful"`
+		Failed     int64  `json:"failed"`
+		Error      string `json:"error"`
+	} `json:"status"`
+}
+
+// encodeOpenObserveBatch streams the batch as a JSON array, gzipped if
+// enabled, so the uncompressed payload is never held in memory
+func encodeOpenObserveBatch(batch []ParsedLogEntry, compress bool) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(&buf)
+		w = gz
+	}
+
+	encoder := json.NewEncoder(w)
+	if _, err := io.WriteString(w, "["); err != nil {
+		return nil, err
+	}
+	for i, entry := range batch {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return nil, err
+			}
+		}
+		if err := encoder.Encode(entry); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := io.WriteString(w, "]"); err != nil {
+		return nil, err
+	}
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// defaultStream is the stream for files that match no route
+func (s *OpenObserveSink) defaultStream(logType string) string {
+	switch logType {
+	case "error":
+		return s.config.ErrorStream
+	case "slowquery":
+		return s.config.SlowQueryStream
+	}
+	return s.config.Stream
+}
+
+func (s *OpenObserveSink) sendBatch(ctx context.Context, logMsg LogMessage, batch []ParsedLogEntry) error {
+	body, err := encodeOpenObserveBatch(batch, s.config.Gzip)
+	if err != nil {
+		return fmt.Errorf("failed to marshal batch: %w", err)
+	}
+
+	target := s.resolveTarget(logMsg)
+	streamName := target.stream
+	url := fmt.Sprintf("%s/api/%s/%s/_json", s.config.URL, target.org, streamName)
+
+	slog.Info("Sending batch to OpenObserve",
+		"url", url,
+		"route", target.route,
+		"stream", streamName,
+		"batch_size", len(batch),
+		"data_size", len(body))
+
+	for attempt := 0; ; attempt++ {
+		resp, err := s.post(ctx, url, target, body)
+		if err != nil {
+			return err
+		}
+
+		if resp.status == http.StatusTooManyRequests || resp.status == http.StatusServiceUnavailable {
+			s.metrics.IncrementCounter("openobserve_throttled_requests", 1)
+			if attempt >= s.config.MaxRetries {
+				return fmt.Errorf("OpenObserve throttled the batch after %d retries: HTTP %d", attempt, resp.status)
+			}
+
+			delay := s.retryDelay(attempt, resp.retryAfter)
+			slog.Warn("OpenObserve throttled batch",
+				"status", resp.status,
+				"stream", streamName,
+				"retry_in", delay,
+				"attempt", attempt+1)
+			select {
+			case <-time.After(delay):
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if resp.status >= 400 {
+			slog.Error("Failed to send batch to OpenObserve",
+				"status", resp.status,
+				"body", truncate(string(resp.body), 512),
+				"stream", streamName)
+			s.metrics.IncrementCounter("openobserve_failed_records", int64(len(batch)))
+			return fmt.Errorf("HTTP error: %d", resp.status)
+		}
+
+		s.recordIngestResult(streamName, resp.body, len(batch))
+		return nil
+	}
+}
+
+// recordIngestResult reports per-record failures from the response body.
+// OpenObserve stores the accepted records of a partially failed request, so
+// the batch is not retried; resending it would duplicate those records.
+func (s *OpenObserveSink) recordIngestResult(streamName string, body []byte, entries int) {
+	var result openObserveIngestResponse
+	if err := json.Unmarshal(body, &result); err != nil || len(result.Status) == 0 {
+		s.metrics.IncrementCounter("openobserve_successful_records", int64(entries))
+		return
+	}
+
+	for _, status := range result.Status {
+		s.metrics.IncrementCounter("openobserve_successful_records", status.Successful)
+		if status.Failed > 0 {
+			s.metrics.IncrementCounter("openobserve_failed_records", status.Failed)
+			slog.Warn("OpenObserve rejected records",
+				"stream", status.Name,
+				"successful", status.Successful,
+				"failed", status.Failed,
+				"error", status.Error)
+		}
+	}
+}
+
+// retryDelay honours Retry-After when present and otherwise backs off
+// exponentially with full jitter
+func (s *OpenObserveSink) retryDelay(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		// Spread clients that were all told the same instant
+		return retryAfter + time.Duration(rand.Int63n(int64(retryAfter)/10+1))
+	}
+
+	backoff := s.config.RetryBaseDelay << attempt
+	if backoff <= 0 || backoff > s.config.RetryMaxDelay {
+		backoff = s.config.RetryMaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// parseRetryAfter reads a Retry-After header in seconds or HTTP-date form
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+type openObserveResponse struct {
+	status     int
+	body       []byte
+	retryAfter time.Duration
+}
+
+func (s *OpenObserveSink) post(ctx context.Context, url string, target openObserveTarget, body []byte) (openObserveResponse, error) {
+	password, err := target.password()
+	if err != nil {
+		return openObserveResponse{}, err
+	}
+
+	httpClient := s.httpPool.Get()
+	defer s.httpPool.Put(httpClient)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return openObserveResponse{}, err
+	}
+	req.SetBasicAuth(target.user, password)
+	req.Header.Set("Content-Type", "application/json")
+	if s.config.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return openObserveResponse{}, err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			slog.Error("Failed to close response body", "error", err)
+		}
+	}()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return openObserveResponse{}, err
+	}
+	return openObserveResponse{
+		status:     resp.StatusCode,
+		body:       respBody,
+		retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	commontls "github.com/yourorg/aurora-log-system/common/tls"
+	"gopkg.in/yaml.v3"
+)
+
+// OpenObserveRoute sends matching files to an organisation and stream with
+// their own credentials. Every criterion that is set must match; the first
+// matching route wins.
+type OpenObserveRoute struct {
+	Name        string            `yaml:"name"`
+	ClusterTags map[string]string `yaml:"cluster_tags"` // tag -> value or path.Match pattern
+	ClusterIDs  []string          `yaml:"cluster_ids"`  // path.Match patterns
+	LogTypes    []string          `yaml:"log_types"`
+
+	Org string `yaml:"org"`
+	// Stream name template, e.g. "{{team}}_{{log_type}}". Placeholders are
+	// log_type, cluster_id, instance_id, engine or any cluster tag.
+	Stream string `yaml:"stream"`
+	// Set together or not at all; without them the default credentials apply
+	User         string `yaml:"user"`
+	PasswordFile string `yaml:"password_file"`
+
+	filter   SinkFilter
+	password *commontls.FileSecret
+}
+
+type openObserveRoutesFile struct {
+	Routes []OpenObserveRoute `yaml:"routes"`
+}
+
+// loadOpenObserveRoutes reads the routing table from a YAML (or JSON) file
+func loadOpenObserveRoutes(file string) ([]OpenObserveRoute, error) {
+	if file == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OpenObserve routes: %w", err)
+	}
+
+	var parsed openObserveRoutesFile
+	if err := yaml.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenObserve routes %s: %w", file, err)
+	}
+
+	for i := range parsed.Routes {
+		route := &parsed.Routes[i]
+		if route.Name == "" {
+			route.Name = fmt.Sprintf("route-%d", i+1)
+		}
+		if route.Org == "" && route.Stream == "" {
+			return nil, fmt.Errorf("OpenObserve route %s sets neither org nor stream", route.Name)
+		}
+		// Credentials are replaced as a pair, never mixed with the defaults
+		if (route.User == "") != (route.PasswordFile == "") {
+			return nil, fmt.Errorf("OpenObserve route %s must set both user and password_file, or neither", route.Name)
+		}
+		for key, pattern := range route.ClusterTags {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("OpenObserve route %s: bad pattern for tag %s: %w", route.Name, key, err)
+			}
+		}
+		for _, pattern := range route.ClusterIDs {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("OpenObserve route %s: bad cluster pattern %q: %w", route.Name, pattern, err)
+			}
+		}
+		route.filter = SinkFilter{LogTypes: route.LogTypes, Clusters: route.ClusterIDs}
+		route.password = commontls.NewFileSecret(route.PasswordFile)
+	}
+	return parsed.Routes, nil
+}
+
+func (r *OpenObserveRoute) matches(logMsg LogMessage) bool {
+	if !r.filter.matchesFile(logMsg) {
+		return false
+	}
+	for key, pattern := range r.ClusterTags {
+		value, ok := logMsg.ClusterTags[key]
+		if !ok {
+			return false
+		}
+		if matched, _ := path.Match(pattern, value); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// openObserveTarget is where one batch is written
+type openObserveTarget struct {
+	route    string
+	org      string
+	stream   string
+	user     string
+	pass     string
+	passFile *commontls.FileSecret
+}
+
+func (t openObserveTarget) password() (string, error) {
+	if t.passFile == nil {
+		return t.pass, nil
+	}
+	pass, err := t.passFile.Value()
+	if err != nil {
+		return "", fmt.Errorf("failed to read OpenObserve password for route %s: %w", t.route, err)
+	}
+	return strings.TrimSpace(pass), nil
+}
+
+// resolveTarget picks the route for a file; files that match no route use
+// the sink's default organisation, streams and credentials
+func (s *OpenObserveSink) resolveTarget(logMsg LogMessage) openObserveTarget {
+	target := openObserveTarget{
+		route:  "default",
+		org:    s.config.Org,
+		stream: s.defaultStream(logMsg.LogType),
+		user:   s.config.User,
+		pass:   s.config.Pass,
+	}
+
+	for i := range s.config.Routes {
+		route := &s.config.Routes[i]
+		if !route.matches(logMsg) {
+			continue
+		}
+		target.route = route.Name
+		if route.Org != "" {
+			target.org = route.Org
+		}
+		if route.Stream != "" {
+			target.stream = renderStreamTemplate(route.Stream, logMsg)
+		}
+		if route.User != "" {
+			target.user = route.User
+			target.pass = ""
+			target.passFile = route.password
+		}
+		break
+	}
+	return target
+}
+
+var streamPlaceholder = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.:/-]+)\s*\}\}`)
+
+// renderStreamTemplate fills in a stream name template and normalises the
+// result to a valid OpenObserve stream name. Missing tags render as
+// "unknown".
+func renderStreamTemplate(template string, logMsg LogMessage) string {
+	rendered := streamPlaceholder.ReplaceAllStringFunc(template, func(match string) string {
+		name := streamPlaceholder.FindStringSubmatch(match)[1]
+		var value string
+		switch name {
+		case "log_type":
+			value = logMsg.LogType
+		case "cluster_id":
+			value = logMsg.ClusterID
+		case "instance_id":
+			value = logMsg.InstanceID
+		case "engine":
+			value = logMsg.Engine
+		default:
+			value = logMsg.ClusterTags[name]
+		}
+		if value == "" {
+			return "unknown"
+		}
+		return value
+	})
+	return sanitizeStreamName(rendered)
+}
+
+// sanitizeStreamName lower-cases a name and replaces anything but letters,
+// digits and underscores
+func sanitizeStreamName(name string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(name) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_' {
+			b.WriteRune(c)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourorg/aurora-log-system/common/metrics"
+)
+
+func writeRoutesFile(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "routes.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(content), 0o600))
+	return file
+}
+
+func TestLoadOpenObserveRoutes(t *testing.T) {
+	routes, err := loadOpenObserveRoutes("")
+	require.NoError(t, err)
+	assert.Empty(t, routes)
+
+	routes, err = loadOpenObserveRoutes(writeRoutesFile(t, `
+routes:
+  - cluster_tags: {team: payments}
+    org: payments
+    stream: "{{team}}_{{log_type}}"
+  - name: staging
+    cluster_ids: ["staging-*"]
+    stream: staging_logs
+`))
+	require.NoError(t, err)
+	require.Len(t, routes, 2)
+	assert.Equal(t, "route-1", routes[0].Name)
+	assert.Equal(t, "staging", routes[1].Name)
+
+	_, err = loadOpenObserveRoutes(writeRoutesFile(t, "routes:\n  - name: empty\n    log_types: [error]\n"))
+	assert.ErrorContains(t, err, "neither org nor stream")
+
+	_, err = loadOpenObserveRoutes(writeRoutesFile(t, "routes:\n  - org: x\n    cluster_ids: [\"[\"]\n"))
+	assert.ErrorContains(t, err, "bad cluster pattern")
+
+	_, err = loadOpenObserveRoutes(writeRoutesFile(t, "routes:\n  - org: x\n    password_file: /run/secrets/x\n"))
+	assert.ErrorContains(t, err, "must set both user and password_file")
+
+	_, err = loadOpenObserveRoutes(writeRoutesFile(t, "routes:\n  - org: x\n    user: ingest\n"))
+	assert.ErrorContains(t, err, "must set both user and password_file")
+}
+
+func TestOpenObserveResolveTarget(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "payments-password")
+	require.NoError(t, os.WriteFile(secret, []byte("s3cret\n"), 0o600))
+
+	routes, err := loadOpenObserveRoutes(writeRoutesFile(t, `
+routes:
+  - name: payments
+    cluster_tags: {team: payments, env: "prod*"}
+    org: payments
+    stream: "{{team}}_{{log_type}}"
+    user: payments-ingest
+    password_file: `+secret+`
+  - name: slow-queries
+    log_types: [slowquery]
+    stream: "slow_{{cluster_id}}"
+  - name: staging
+    cluster_ids: ["staging-*"]
+    org: staging
+`))
+	require.NoError(t, err)
+
+	sink := newTestOpenObserveSink("", metrics.NewExporter("", "", ""))
+	sink.config.Routes = routes
+
+	// Every tag criterion must match
+	target := sink.resolveTarget(LogMessage{ClusterID: "pay-1", LogType: "error", ClusterTags: map[string]string{"team": "payments", "env": "production"}})
+	assert.Equal(t, "payments", target.route)
+	assert.Equal(t, "payments", target.org)
+	assert.Equal(t, "payments_error", target.stream)
+	assert.Equal(t, "payments-ingest", target.user)
+	password, err := target.password()
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", password)
+
+	// First match wins; unset fields keep the defaults
+	target = sink.resolveTarget(LogMessage{ClusterID: "staging-db", LogType: "slowquery", ClusterTags: map[string]string{"team": "payments", "env": "staging"}})
+	assert.Equal(t, "slow-queries", target.route)
+	assert.Equal(t, "team-a", target.org)
+	assert.Equal(t, "slow_staging_db", target.stream)
+	assert.Equal(t, "user", target.user)
+
+	target = sink.resolveTarget(LogMessage{ClusterID: "staging-db", LogType: "error"})
+	assert.Equal(t, "staging", target.route)
+	assert.Equal(t, "staging", target.org)
+	assert.Equal(t, "aurora_error_logs", target.stream)
+
+	// No route: default org, configured streams and credentials
+	target = sink.resolveTarget(LogMessage{ClusterID: "prod-db", LogType: "general"})
+	assert.Equal(t, "default", target.route)
+	assert.Equal(t, "team-a", target.org)
+	assert.Equal(t, "aurora_logs", target.stream)
+	password, err = target.password()
+	require.NoError(t, err)
+	assert.Equal(t, "pass", password)
+}
+
+func TestOpenObserveDefaultStreams(t *testing.T) {
+	sink := NewOpenObserveSink(OpenObserveSinkConfig{
+		URL:             "http://localhost:5080",
+		Stream:          "custom_logs",
+		SlowQueryStream: "custom_slow",
+	}, NewHTTPConnectionPool(1, 0), metrics.NewExporter("", "", ""))
+
+	assert.Equal(t, "default", sink.config.Org)
+	assert.Equal(t, "custom_logs", sink.defaultStream("general"))
+	assert.Equal(t, "custom_slow", sink.defaultStream("slowquery"))
+	assert.Equal(t, "aurora_error_logs", sink.defaultStream("error"))
+}
+
+func TestRenderStreamTemplate(t *testing.T) {
+	logMsg := LogMessage{
+		ClusterID:   "Orders-DB",
+		InstanceID:  "orders-db-1",
+		Engine:      "aurora-mysql",
+		LogType:     "error",
+		ClusterTags: map[string]string{"team": "Orders", "cost-center": "cc.42"},
+	}
+
+	assert.Equal(t, "orders_error", renderStreamTemplate("{{team}}_{{log_type}}", logMsg))
+	assert.Equal(t, "orders_db_orders_db_1_aurora_mysql", renderStreamTemplate("{{ cluster_id }}_{{instance_id}}_{{engine}}", logMsg))
+	assert.Equal(t, "cc_42_logs", renderStreamTemplate("{{cost-center}}_logs", logMsg))
+	assert.Equal(t, "unknown_error", renderStreamTemplate("{{owner}}_{{log_type}}", logMsg))
+}
+
+func TestOpenObserveSinkRoutesRequests(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "password")
+	require.NoError(t, os.WriteFile(secret, []byte("team-pass"), 0o600))
+
+	type request struct{ path, user, pass string }
+	var requests []request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		requests = append(requests, request{r.URL.Path, user, pass})
+		w.Write([]byte(`{"code":200,"status":[]}`))
+	}))
+	defer server.Close()
+
+	routes, err := loadOpenObserveRoutes(writeRoutesFile(t, `
+routes:
+  - cluster_tags: {team: search}
+    org: search
+    stream: "{{team}}_{{log_type}}"
+    user: search-ingest
+    password_file: `+secret+`
+`))
+	require.NoError(t, err)
+
+	sink := newTestOpenObserveSink(server.URL, metrics.NewExporter("", "", ""))
+	sink.config.Routes = routes
+
+	ctx := context.Background()
+	require.NoError(t, sink.WriteBatch(ctx, LogMessage{LogType: "error", ClusterTags: map[string]string{"team": "search"}}, makeEntries("ERROR")))
+	require.NoError(t, sink.WriteBatch(ctx, LogMessage{LogType: "general"}, makeEntries("INFO")))
+
+	assert.Equal(t, []request{
+		{"/api/search/search_error/_json", "search-ingest", "team-pass"},
+		{"/api/team-a/aurora_logs/_json", "user", "pass"},
+	}, requests)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourorg/aurora-log-system/common/metrics"
+)
+
+func newTestOpenObserveSink(url string, metrics *metrics.Exporter) *OpenObserveSink {
+	return NewOpenObserveSink(OpenObserveSinkConfig{
+		URL:            url,
+		Org:            "team-a",
+		User:           "user",
+		Pass:           "pass",
+		Stream:         "aurora_logs",
+		Gzip:           true,
+		MaxRetries:     2,
+		RetryBaseDelay: time.Millisecond,
+		RetryMaxDelay:  5 * time.Millisecond,
+	}, NewHTTPConnectionPool(1, time.Second), metrics)
+}
+
+func TestOpenObserveSinkGzipAndOrg(t *testing.T) {
+	var entries []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/team-a/aurora_error_logs/_json", r.URL.Path)
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		gz, err := gzip.NewReader(r.Body)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.NoError(t, json.NewDecoder(gz).Decode(&entries))
+		w.Write([]byte(`{"code":200,"status":[{"name":"aurora_error_logs","successful":2,"failed":0}]}`))
+	}))
+	defer server.Close()
+
+	metrics := metrics.NewExporter("", "", "")
+	sink := newTestOpenObserveSink(server.URL, metrics)
+	require.NoError(t, sink.WriteBatch(context.Background(), LogMessage{LogType: "error"}, makeEntries("ERROR", "INFO")))
+
+	require.Len(t, entries, 2)
+	assert.Equal(t, "ERROR", entries[0]["level"])
+	assert.Equal(t, int64(2), metrics.Counter("openobserve_successful_records"))
+}
+
+func TestOpenObserveSinkPartialFailure(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte(`{"code":200,"status":[{"name":"aurora_logs","successful":1,"failed":2,"error":"invalid _timestamp"}]}`))
+	}))
+	defer server.Close()
+
+	metrics := metrics.NewExporter("", "", "")
+	sink := newTestOpenObserveSink(server.URL, metrics)
+	require.NoError(t, sink.WriteBatch(context.Background(), LogMessage{LogType: "general"}, makeEntries("INFO", "INFO", "INFO")))
+
+	assert.Equal(t, int32(1), requests.Load(), "partial failures are not resent")
+	assert.Equal(t, int64(1), metrics.Counter("openobserve_successful_records"))
+	assert.Equal(t, int64(2), metrics.Counter("openobserve_failed_records"))
+}
+
+func TestOpenObserveSinkThrottling(t *testing.T) {
+	var requests atomic.Int32
+	var alwaysThrottle atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if alwaysThrottle.Load() {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		switch requests.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte(`{"code":200,"status":[]}`))
+		}
+	}))
+	defer server.Close()
+
+	metrics := metrics.NewExporter("", "", "")
+	sink := newTestOpenObserveSink(server.URL, metrics)
+	require.NoError(t, sink.WriteBatch(context.Background(), LogMessage{LogType: "error"}, makeEntries("ERROR")))
+	assert.Equal(t, int32(3), requests.Load())
+	assert.Equal(t, int64(2), metrics.Counter("openobserve_throttled_requests"))
+
+	// Throttling beyond MaxRetries fails the write so the sink runner can retry or spool
+	alwaysThrottle.Store(true)
+	err := sink.WriteBatch(context.Background(), LogMessage{LogType: "error"}, makeEntries("ERROR"))
+	assert.ErrorContains(t, err, "throttled")
+}
+
+func TestOpenObserveSinkRejectsBatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	metrics := metrics.NewExporter("", "", "")
+	sink := newTestOpenObserveSink(server.URL, metrics)
+	err := sink.WriteBatch(context.Background(), LogMessage{LogType: "error"}, makeEntries("ERROR"))
+	assert.ErrorContains(t, err, "401")
+	assert.Equal(t, int64(1), metrics.Counter("openobserve_failed_records"))
+	assert.Zero(t, metrics.Counter("openobserve_throttled_requests"))
+}
+
+func TestOpenObserveRetryDelay(t *testing.T) {
+	now := time.Date(2025, 8, 2, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, 7*time.Second, parseRetryAfter("7", now))
+	assert.Equal(t, 30*time.Second, parseRetryAfter(now.Add(30*time.Second).Format(http.TimeFormat), now))
+	assert.Zero(t, parseRetryAfter("soon", now))
+	assert.Zero(t, parseRetryAfter("", now))
+
+	sink := newTestOpenObserveSink("", metrics.NewExporter("", "", ""))
+	delay := sink.retryDelay(0, 10*time.Second)
+	assert.GreaterOrEqual(t, delay, 10*time.Second)
+	assert.LessOrEqual(t, delay, 11*time.Second)
+
+	for attempt := 0; attempt < 20; attempt++ {
+		assert.LessOrEqual(t, sink.retryDelay(attempt, 0), 5*time.Millisecond)
+	}
+}
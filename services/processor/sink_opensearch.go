@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourorg/aurora-log-system/common/env"
+	commontls "github.com/yourorg/aurora-log-system/common/tls"
+)
+
+// OpenSearchSinkConfig configures the OpenSearch/Elasticsearch bulk sink
+type OpenSearchSinkConfig struct {
+	URL             string
+	User            string
+	Pass            string
+	PassFile        *commontls.FileSecret
+	IndexPrefix     string
+	IndexDateFormat string // Go layout used for daily indices
+	ManageTemplates bool
+	DeadLetterIndex string
+	MaxItemRetries  int
+	RetryBackoff    time.Duration
+}
+
+func loadOpenSearchSinkConfig() OpenSearchSinkConfig {
+	prefix := env.Get("OPENSEARCH_INDEX_PREFIX", "aurora")
+	return OpenSearchSinkConfig{
+		URL:             strings.TrimRight(env.Get("OPENSEARCH_URL", "http://localhost:9200"), "/"),
+		User:            env.Get("OPENSEARCH_USER", ""),
+		Pass:            env.Get("OPENSEARCH_PASSWORD", ""),
+		PassFile:        commontls.NewFileSecret(env.Get("OPENSEARCH_PASSWORD_FILE", "")),
+		IndexPrefix:     prefix,
+		IndexDateFormat: env.Get("OPENSEARCH_INDEX_DATE_FORMAT", "2006.01.02"),
+		ManageTemplates: env.Get("OPENSEARCH_MANAGE_TEMPLATES", "true") == "true",
+		DeadLetterIndex: env.Get("OPENSEARCH_DEAD_LETTER_INDEX", prefix+"-deadletter"),
+		MaxItemRetries:  env.Int("OPENSEARCH_MAX_ITEM_RETRIES", 3),
+		RetryBackoff:    time.Second,
+	}
+}
+
+// OpenSearchSink writes entries with the `_bulk` API into daily indices
+// named <prefix>-<log_type>-<date>. Documents get IDs derived from the
+// file line they were parsed from, and are sent with the `create` action,
+// so a batch retried by the sink runner, or a file reprocessed from its
+// checkpoint, does not duplicate documents that were already accepted.
+type OpenSearchSink struct {
+	config   OpenSearchSinkConfig
+	httpPool *HTTPConnectionPool
+
+	mu        sync.Mutex
+	templates map[string]bool
+}
+
+func NewOpenSearchSink(config OpenSearchSinkConfig, httpPool *HTTPConnectionPool) *OpenSearchSink {
+	if config.IndexDateFormat == "" {
+		config.IndexDateFormat = "2006.01.02"
+	}
+	return &OpenSearchSink{
+		config:    config,
+		httpPool:  httpPool,
+		templates: make(map[string]bool),
+	}
+}
+
+func (s *OpenSearchSink) Name() string { return "opensearch" }
+
+// bulkDocument is one document of a bulk request
+type bulkDocument struct {
+	index  string
+	id     string
+	source []byte
+}
+
+type bulkItemError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+type bulkItemResult struct {
+	Index  string         `json:"_index"`
+	ID     string         `json:"_id"`
+	Status int            `json:"status"`
+	Error  *bulkItemError `json:"error,omitempty"`
+}
+
+type bulkResponse struct {
+	Errors bool                        `json:"errors"`
+	Items  []map[string]bulkItemResult `json:"items"`
+}
+
+func (s *OpenSearchSink) WriteBatch(ctx context.Context, logMsg LogMessage, batch []ParsedLogEntry) error {
+	if s.config.ManageTemplates {
+		if err := s.ensureTemplate(ctx, logMsg.LogType); err != nil {
+			return err
+		}
+	}
+
+	docs := make([]bulkDocument, 0, len(batch))
+	for i, entry := range batch {
+		source, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to marshal entry: %w", err)
+		}
+		index := s.indexName(logMsg.LogType, entryTimestamp(entry))
+		docs = append(docs, bulkDocument{
+			index:  index,
+			id:     entryID(logMsg, entry, i, source),
+			source: source,
+		})
+	}
+
+	for attempt := 0; len(docs) > 0; attempt++ {
+		if attempt > 0 {
+			if attempt > s.config.MaxItemRetries {
+				return fmt.Errorf("%d documents still rejected after %d retries", len(docs), s.config.MaxItemRetries)
+			}
+			select {
+			case <-time.After(time.Duration(attempt) * s.config.RetryBackoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		results, err := s.bulk(ctx, docs)
+		if err != nil {
+			return err
+		}
+
+		var retry, deadLetters []bulkDocument
+		var deadLetterResults []bulkItemResult
+		for i, result := range results {
+			switch {
+			case result.Status < 300, result.Status == http.StatusConflict:
+				// Created, or already created by an earlier attempt
+			case result.Status == http.StatusTooManyRequests || result.Status >= 500:
+				retry = append(retry, docs[i])
+			default:
+				deadLetters = append(deadLetters, docs[i])
+				deadLetterResults = append(deadLetterResults, result)
+			}
+		}
+
+		if len(deadLetters) > 0 {
+			if err := s.deadLetter(ctx, deadLetters, deadLetterResults); err != nil {
+				return err
+			}
+		}
+		if len(retry) > 0 {
+			slog.Warn("OpenSearch rejected documents, retrying",
+				"rejected", len(retry),
+				"attempt", attempt+1,
+				"log_type", logMsg.LogType)
+		}
+		docs = retry
+	}
+
+	return nil
+}
+
+// Flush is a no-op; every batch is sent synchronously
+func (s *OpenSearchSink) Flush(ctx context.Context) error { return nil }
+
+func (s *OpenSearchSink) Close() error { return nil }
+
+// Health fails when the cluster health is red
+func (s *OpenSearchSink) Health(ctx context.Context) error {
+	body, err := s.do(ctx, "GET", "/_cluster/health", "", nil)
+	if err != nil {
+		return err
+	}
+	var health struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(body, &health); err != nil {
+		return fmt.Errorf("failed to decode cluster health: %w", err)
+	}
+	if health.Status == "red" {
+		return fmt.Errorf("OpenSearch cluster health is red")
+	}
+	return nil
+}
+
+func (s *OpenSearchSink) indexName(logType string, ts time.Time) string {
+	return fmt.Sprintf("%s-%s-%s", s.config.IndexPrefix, logType, ts.UTC().Format(s.config.IndexDateFormat))
+}
+
+// bulk sends one `_bulk` request and returns the per-item results in
+// request order
+func (s *OpenSearchSink) bulk(ctx context.Context, docs []bulkDocument) ([]bulkItemResult, error) {
+	var payload bytes.Buffer
+	for _, doc := range docs {
+		action, _ := json.Marshal(map[string]map[string]string{
+			"create": {"_index": doc.index, "_id": doc.id},
+		})
+		payload.Write(action)
+		payload.WriteByte('\n')
+		payload.Write(doc.source)
+		payload.WriteByte('\n')
+	}
+
+	body, err := s.do(ctx, "POST", "/_bulk", "application/x-ndjson", payload.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	var resp bulkResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode bulk response: %w", err)
+	}
+	if len(resp.Items) != len(docs) {
+		return nil, fmt.Errorf("bulk response has %d items for %d documents", len(resp.Items), len(docs))
+	}
+
+	results := make([]bulkItemResult, 0, len(resp.Items))
+	for _, item := range resp.Items {
+		for _, result := range item {
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// deadLetter stores documents OpenSearch refused for good (mapping
+// conflicts, malformed fields) in the dead-letter index together with the
+// error, so they can be inspected and replayed. An error means some of them
+// are in neither index; the batch then fails and is retried.
+func (s *OpenSearchSink) deadLetter(ctx context.Context, docs []bulkDocument, results []bulkItemResult) error {
+	letters := make([]bulkDocument, 0, len(docs))
+	for i, doc := range docs {
+		letter := map[string]interface{}{
+			"@timestamp": time.Now().UTC().Format(time.RFC3339),
+			"index":      doc.index,
+			"status":     results[i].Status,
+			"document":   string(doc.source),
+		}
+		if results[i].Error != nil {
+			letter["error_type"] = results[i].Error.Type
+			letter["error_reason"] = results[i].Error.Reason
+		}
+		source, _ := json.Marshal(letter)
+		letters = append(letters, bulkDocument{index: s.config.DeadLetterIndex, id: doc.id, source: source})
+	}
+
+	slog.Error("OpenSearch rejected documents permanently, writing to dead-letter index",
+		"documents", len(docs),
+		"dead_letter_index", s.config.DeadLetterIndex)
+
+	results, err := s.bulk(ctx, letters)
+	if err != nil {
+		return fmt.Errorf("failed to write %d dead letters: %w", len(docs), err)
+	}
+	failed := 0
+	for _, result := range results {
+		if result.Status >= 300 && result.Status != http.StatusConflict {
+			slog.Error("Failed to write OpenSearch dead letter", "status", result.Status, "id", result.ID)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to write %d of %d dead letters to %s", failed, len(docs), s.config.DeadLetterIndex)
+	}
+	return nil
+}
+
+// ensureTemplate installs the index template for a log type once
+func (s *OpenSearchSink) ensureTemplate(ctx context.Context, logType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.templates[logType] {
+		return nil
+	}
+
+	name := fmt.Sprintf("%s-%s", s.config.IndexPrefix, logType)
+	template, err := json.Marshal(openSearchIndexTemplate(name, logType))
+	if err != nil {
+		return err
+	}
+	if _, err := s.do(ctx, "PUT", "/_index_template/"+name, "application/json", template); err != nil {
+		return fmt.Errorf("failed to install index template %s: %w", name, err)
+	}
+
+	slog.Info("Installed OpenSearch index template", "template", name)
+	s.templates[logType] = true
+	return nil
+}
+
+// openSearchIndexTemplate maps the fields the parsers produce for a log type
+func openSearchIndexTemplate(name, logType string) map[string]interface{} {
+	properties := map[string]interface{}{
+		"@timestamp":    map[string]string{"type": "date"},
+		"_timestamp":    map[string]string{"type": "date", "format": "epoch_millis"},
+		"timestamp":     map[string]string{"type": "keyword"},
+		"cluster_id":    map[string]string{"type": "keyword"},
+		"instance_id":   map[string]string{"type": "keyword"},
+		"log_type":      map[string]string{"type": "keyword"},
+		"log_file_name": map[string]string{"type": "keyword"},
+		"message":       map[string]string{"type": "text"},
+		"raw_line":      map[string]interface{}{"type": "text", "index": false},
+	}
+
+	switch logType {
+	case "error":
+		properties["level"] = map[string]string{"type": "keyword"}
+		properties["thread_id"] = map[string]string{"type": "keyword"}
+	case "slowquery":
+		properties["event_type"] = map[string]string{"type": "keyword"}
+		properties["user"] = map[string]string{"type": "keyword"}
+		properties["host"] = map[string]string{"type": "keyword"}
+		properties["user_host"] = map[string]string{"type": "keyword"}
+		properties["query_time"] = map[string]string{"type": "double"}
+		properties["lock_time"] = map[string]string{"type": "double"}
+		properties["rows_sent"] = map[string]string{"type": "long"}
+		properties["rows_examined"] = map[string]string{"type": "long"}
+		properties["sql_statement"] = map[string]string{"type": "text"}
+	}
+
+	return map[string]interface{}{
+		"index_patterns": []string{name + "-*"},
+		"template": map[string]interface{}{
+			"mappings": map[string]interface{}{
+				"properties": properties,
+			},
+		},
+	}
+}
+
+// do sends a request through the shared HTTP connection pool and returns
+// the response body, failing on HTTP errors
+func (s *OpenSearchSink) do(ctx context.Context, method, path, contentType string, body []byte) ([]byte, error) {
+	httpClient := s.httpPool.Get()
+	defer s.httpPool.Put(httpClient)
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.config.URL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if s.config.User != "" {
+		password := s.config.Pass
+		if s.config.PassFile != nil {
+			if password, err = s.config.PassFile.Value(); err != nil {
+				return nil, fmt.Errorf("failed to read OpenSearch password: %w", err)
+			}
+		}
+		req.SetBasicAuth(s.config.User, password)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("OpenSearch %s %s returned %d: %s", method, path, resp.StatusCode, truncate(string(respBody), 512))
+	}
+	return respBody, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
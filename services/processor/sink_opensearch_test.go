@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeOpenSearch answers bulk requests item by item through respond
+type fakeOpenSearch struct {
+	mu        sync.Mutex
+	templates []string
+	requests  int
+	indexed   map[string]string // _id -> _index
+	respond   func(request int, index string, source map[string]interface{}) (int, string)
+}
+
+func (f *fakeOpenSearch) handler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		switch {
+		case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/_index_template/"):
+			f.templates = append(f.templates, strings.TrimPrefix(r.URL.Path, "/_index_template/"))
+			w.Write([]byte(`{"acknowledged":true}`))
+		case r.URL.Path == "/_cluster/health":
+			w.Write([]byte(`{"status":"red"}`))
+		case r.URL.Path == "/_bulk":
+			assert.Equal(t, "application/x-ndjson", r.Header.Get("Content-Type"))
+			f.requests++
+
+			var items []map[string]bulkItemResult
+			scanner := bufio.NewScanner(r.Body)
+			for scanner.Scan() {
+				var action map[string]map[string]string
+				var source map[string]interface{}
+				if !assert.NoError(t, json.Unmarshal(scanner.Bytes(), &action)) ||
+					!assert.True(t, scanner.Scan()) ||
+					!assert.NoError(t, json.Unmarshal(scanner.Bytes(), &source)) {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+
+				meta := action["create"]
+				status, errorType := 201, ""
+				if _, exists := f.indexed[meta["_id"]]; exists {
+					status, errorType = 409, "version_conflict_engine_exception"
+				} else if f.respond != nil {
+					status, errorType = f.respond(f.requests, meta["_index"], source)
+				}
+				if status == 201 {
+					f.indexed[meta["_id"]] = meta["_index"]
+				}
+
+				result := bulkItemResult{Index: meta["_index"], ID: meta["_id"], Status: status}
+				if errorType != "" {
+					result.Error = &bulkItemError{Type: errorType, Reason: "test"}
+				}
+				items = append(items, map[string]bulkItemResult{"create": result})
+			}
+			json.NewEncoder(w).Encode(bulkResponse{Errors: true, Items: items})
+		default:
+			io.Copy(io.Discard, r.Body)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+func (f *fakeOpenSearch) countIndex(index string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, idx := range f.indexed {
+		if idx == index {
+			n++
+		}
+	}
+	return n
+}
+
+func newTestOpenSearchSink(t *testing.T, fake *fakeOpenSearch) *OpenSearchSink {
+	server := httptest.NewServer(fake.handler(t))
+	t.Cleanup(server.Close)
+
+	return NewOpenSearchSink(OpenSearchSinkConfig{
+		URL:             server.URL,
+		IndexPrefix:     "aurora",
+		IndexDateFormat: "2006.01.02",
+		ManageTemplates: true,
+		DeadLetterIndex: "aurora-deadletter",
+		MaxItemRetries:  2,
+		RetryBackoff:    time.Millisecond,
+	}, NewHTTPConnectionPool(2, 5*time.Second))
+}
+
+func osEntries(messages ...string) []ParsedLogEntry {
+	ts := time.Date(2025, 8, 2, 12, 0, 0, 0, time.UTC).UnixMilli()
+	entries := make([]ParsedLogEntry, 0, len(messages))
+	for _, message := range messages {
+		entries = append(entries, ParsedLogEntry{"message": message, "_timestamp": ts})
+	}
+	return entries
+}
+
+func TestOpenSearchSinkBulk(t *testing.T) {
+	fake := &fakeOpenSearch{indexed: map[string]string{}}
+	sink := newTestOpenSearchSink(t, fake)
+	logMsg := LogMessage{LogType: "error", InstanceID: "db-1", LogFileName: "error/mysql-error.log"}
+
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, osEntries("a", "b")))
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, osEntries("c")))
+
+	assert.Equal(t, []string{"aurora-error"}, fake.templates, "template is installed once")
+	assert.Equal(t, 3, fake.countIndex("aurora-error-2025.08.02"))
+
+	// Resending a batch does not create duplicates
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, osEntries("a", "b")))
+	assert.Equal(t, 3, fake.countIndex("aurora-error-2025.08.02"))
+
+	// Identical lines at different positions are separate documents
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, osEntries("x", "x")))
+	assert.Equal(t, 5, fake.countIndex("aurora-error-2025.08.02"))
+}
+
+func TestOpenSearchSinkDocumentIDsFollowFileLines(t *testing.T) {
+	fake := &fakeOpenSearch{indexed: map[string]string{}}
+	sink := newTestOpenSearchSink(t, fake)
+	logMsg := LogMessage{LogType: "error", InstanceID: "db-1", LogFileName: "error/mysql-error.log"}
+
+	entries := osEntries("a", "a")
+	entries[0]["line_number"] = 10
+	entries[1]["line_number"] = 11
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, entries))
+	assert.Equal(t, 2, fake.countIndex("aurora-error-2025.08.02"))
+
+	// Reprocessed from a checkpoint: other batch positions and a fallback
+	// timestamp that moved on still map to the same documents
+	resumed := osEntries("a")
+	resumed[0]["line_number"] = 11.0
+	resumed[0]["_timestamp"] = time.Date(2025, 8, 2, 12, 5, 0, 0, time.UTC).UnixMilli()
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, resumed))
+	assert.Equal(t, 2, fake.countIndex("aurora-error-2025.08.02"))
+}
+
+func TestOpenSearchSinkRetriesRejectedItems(t *testing.T) {
+	fake := &fakeOpenSearch{indexed: map[string]string{}}
+	fake.respond = func(request int, index string, source map[string]interface{}) (int, string) {
+		if request == 1 && source["message"] == "busy" {
+			return 429, "es_rejected_execution_exception"
+		}
+		return 201, ""
+	}
+	sink := newTestOpenSearchSink(t, fake)
+
+	require.NoError(t, sink.WriteBatch(context.Background(), LogMessage{LogType: "error"}, osEntries("ok", "busy")))
+	assert.Equal(t, 2, fake.requests, "only the rejected document is resent")
+	assert.Equal(t, 2, fake.countIndex("aurora-error-2025.08.02"))
+
+	// Documents that stay rejected fail the batch
+	fake.respond = func(int, string, map[string]interface{}) (int, string) { return 503, "unavailable_shards_exception" }
+	assert.Error(t, sink.WriteBatch(context.Background(), LogMessage{LogType: "error"}, osEntries("down")))
+}
+
+func TestOpenSearchSinkDeadLettersMappingFailures(t *testing.T) {
+	fake := &fakeOpenSearch{indexed: map[string]string{}}
+	fake.respond = func(request int, index string, source map[string]interface{}) (int, string) {
+		if index != "aurora-deadletter" && source["message"] == "bad" {
+			return 400, "mapper_parsing_exception"
+		}
+		return 201, ""
+	}
+	sink := newTestOpenSearchSink(t, fake)
+
+	require.NoError(t, sink.WriteBatch(context.Background(), LogMessage{LogType: "slowquery"}, osEntries("good", "bad")))
+	assert.Equal(t, 1, fake.countIndex("aurora-slowquery-2025.08.02"))
+	assert.Equal(t, 1, fake.countIndex("aurora-deadletter"))
+	assert.Equal(t, 2, fake.requests, "mapping failures are not retried")
+}
+
+func TestOpenSearchSinkFailsWhenDeadLetteringFails(t *testing.T) {
+	fake := &fakeOpenSearch{indexed: map[string]string{}}
+	fake.respond = func(request int, index string, source map[string]interface{}) (int, string) {
+		if index == "aurora-deadletter" || source["message"] == "bad" {
+			return 400, "mapper_parsing_exception"
+		}
+		return 201, ""
+	}
+	sink := newTestOpenSearchSink(t, fake)
+
+	err := sink.WriteBatch(context.Background(), LogMessage{LogType: "slowquery"}, osEntries("good", "bad"))
+	assert.ErrorContains(t, err, "failed to write 1 of 1 dead letters to aurora-deadletter")
+	assert.Equal(t, 1, fake.countIndex("aurora-slowquery-2025.08.02"))
+}
+
+func TestOpenSearchSinkHealthAndTemplate(t *testing.T) {
+	fake := &fakeOpenSearch{indexed: map[string]string{}}
+	sink := newTestOpenSearchSink(t, fake)
+	assert.ErrorContains(t, sink.Health(context.Background()), "red")
+
+	template := openSearchIndexTemplate("aurora-slowquery", "slowquery")
+	data, err := json.Marshal(template)
+	require.NoError(t, err)
+	assert.True(t, bytes.Contains(data, []byte(`"index_patterns":["aurora-slowquery-*"]`)))
+	assert.True(t, bytes.Contains(data, []byte(`"query_time":{"type":"double"}`)))
+}
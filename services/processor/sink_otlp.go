@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yourorg/aurora-log-system/common/env"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// OTLPSinkConfig configures the OTLP/HTTP logs exporter. The environment
+// variables follow the OpenTelemetry exporter conventions.
+type OTLPSinkConfig struct {
+	Endpoint    string // base URL; /v1/logs is appended
+	Protocol    string // http/protobuf or http/json
+	Headers     map[string]string
+	Compression string // gzip or none
+	ServiceName string
+	Region      string
+}
+
+func loadOTLPSinkConfig(region string) OTLPSinkConfig {
+	headers := make(map[string]string)
+	for _, header := range env.List("OTEL_EXPORTER_OTLP_HEADERS") {
+		if key, value, ok := strings.Cut(header, "="); ok {
+			headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+
+	return OTLPSinkConfig{
+		Endpoint:    strings.TrimRight(env.Get("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318"), "/"),
+		Protocol:    env.Get("OTEL_EXPORTER_OTLP_PROTOCOL", "http/protobuf"),
+		Headers:     headers,
+		Compression: env.Get("OTEL_EXPORTER_OTLP_COMPRESSION", "gzip"),
+		ServiceName: env.Get("OTEL_SERVICE_NAME", "aurora-mysql"),
+		Region:      region,
+	}
+}
+
+// OTel severity numbers (logs data model)
+const (
+	otlpSeverityUnspecified = 0
+	otlpSeverityDebug       = 5
+	otlpSeverityInfo        = 9
+	otlpSeverityWarn        = 13
+	otlpSeverityError       = 17
+	otlpSeverityFatal       = 21
+)
+
+func otlpSeverity(level string) int {
+	switch strings.ToUpper(level) {
+	case "DEBUG", "TRACE":
+		return otlpSeverityDebug
+	case "INFO", "NOTE", "SYSTEM":
+		return otlpSeverityInfo
+	case "WARNING", "WARN":
+		return otlpSeverityWarn
+	case "ERROR":
+		return otlpSeverityError
+	case "FATAL", "CRITICAL":
+		return otlpSeverityFatal
+	}
+	return otlpSeverityUnspecified
+}
+
+// otlpBodyFields are tried in order for the log record body
+var otlpBodyFields = []string{"message", "sql_statement", "raw_line"}
+
+// otlpSkipFields are not repeated as log attributes
+var otlpSkipFields = map[string]bool{
+	"level":       true,
+	"_timestamp":  true,
+	"@timestamp":  true,
+	"cluster_id":  true,
+	"instance_id": true,
+}
+
+type otlpValue struct {
+	kind   string // string, bool, int or double
+	str    string
+	boolV  bool
+	intV   int64
+	double float64
+}
+
+type otlpKeyValue struct {
+	key   string
+	value otlpValue
+}
+
+type otlpLogRecord struct {
+	timeUnixNano     uint64
+	observedUnixNano uint64
+	severityNumber   int
+	severityText     string
+	body             string
+	attributes       []otlpKeyValue
+}
+
+func otlpValueOf(v interface{}) (otlpValue, bool) {
+	switch val := v.(type) {
+	case string:
+		return otlpValue{kind: "string", str: val}, true
+	case bool:
+		return otlpValue{kind: "bool", boolV: val}, true
+	case int:
+		return otlpValue{kind: "int", intV: int64(val)}, true
+	case int64:
+		return otlpValue{kind: "int", intV: val}, true
+	case float64:
+		return otlpValue{kind: "double", double: val}, true
+	case nil:
+		return otlpValue{}, false
+	}
+	return otlpValue{kind: "string", str: fmt.Sprint(v)}, true
+}
+
+// OTLPSink exports entries as OpenTelemetry log records over OTLP/HTTP
+type OTLPSink struct {
+	config   OTLPSinkConfig
+	httpPool *HTTPConnectionPool
+}
+
+func NewOTLPSink(config OTLPSinkConfig, httpPool *HTTPConnectionPool) (*OTLPSink, error) {
+	switch config.Protocol {
+	case "http/protobuf", "http/json":
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %q", config.Protocol)
+	}
+	return &OTLPSink{config: config, httpPool: httpPool}, nil
+}
+
+func (s *OTLPSink) Name() string { return "otlp" }
+
+func (s *OTLPSink) resourceAttributes(logMsg LogMessage) []otlpKeyValue {
+	attrs := []otlpKeyValue{
+		{"service.name", otlpValue{kind: "string", str: s.config.ServiceName}},
+		{"cloud.provider", otlpValue{kind: "string", str: "aws"}},
+		{"cloud.platform", otlpValue{kind: "string", str: "aws_rds"}},
+		{"aurora.cluster_id", otlpValue{kind: "string", str: logMsg.ClusterID}},
+		{"aurora.instance_id", otlpValue{kind: "string", str: logMsg.InstanceID}},
+	}
+	if logMsg.Engine != "" {
+		attrs = append(attrs, otlpKeyValue{"aurora.engine", otlpValue{kind: "string", str: logMsg.Engine}})
+	}
+	if s.config.Region != "" {
+		attrs = append(attrs, otlpKeyValue{"cloud.region", otlpValue{kind: "string", str: s.config.Region}})
+	}
+	return attrs
+}
+
+func otlpRecord(entry ParsedLogEntry, observed time.Time) otlpLogRecord {
+	level, _ := entry["level"].(string)
+	record := otlpLogRecord{
+		timeUnixNano:     uint64(entryTimestamp(entry).UnixNano()),
+		observedUnixNano: uint64(observed.UnixNano()),
+		severityNumber:   otlpSeverity(level),
+		severityText:     level,
+	}
+
+	bodyField := ""
+	for _, field := range otlpBodyFields {
+		if body, ok := entry[field].(string); ok && body != "" {
+			record.body = body
+			bodyField = field
+			break
+		}
+	}
+
+	keys := make([]string, 0, len(entry))
+	for key := range entry {
+		if key != bodyField && !otlpSkipFields[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if value, ok := otlpValueOf(entry[key]); ok {
+			record.attributes = append(record.attributes, otlpKeyValue{key: key, value: value})
+		}
+	}
+	return record
+}
+
+func (s *OTLPSink) WriteBatch(ctx context.Context, logMsg LogMessage, batch []ParsedLogEntry) error {
+	observed := time.Now()
+	records := make([]otlpLogRecord, 0, len(batch))
+	for _, entry := range batch {
+		records = append(records, otlpRecord(entry, observed))
+	}
+	resource := s.resourceAttributes(logMsg)
+
+	var body []byte
+	var contentType string
+	var err error
+	if s.config.Protocol == "http/json" {
+		body, err = encodeOTLPJSON(resource, records)
+		contentType = "application/json"
+	} else {
+		body = encodeOTLPProtobuf(resource, records)
+		contentType = "application/x-protobuf"
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode OTLP request: %w", err)
+	}
+
+	return s.export(ctx, body, contentType)
+}
+
+// Flush is a no-op; every batch is exported synchronously
+func (s *OTLPSink) Flush(ctx context.Context) error { return nil }
+
+func (s *OTLPSink) Close() error { return nil }
+
+// Health has no standard OTLP endpoint to probe; export errors surface
+// through the sink runner instead
+func (s *OTLPSink) Health(ctx context.Context) error { return nil }
+
+func (s *OTLPSink) export(ctx context.Context, body []byte, contentType string) error {
+	var encoding string
+	if s.config.Compression == "gzip" {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(body); err != nil {
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+		encoding = "gzip"
+	}
+
+	httpClient := s.httpPool.Get()
+	defer s.httpPool.Put(httpClient)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.config.Endpoint+"/v1/logs", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	for key, value := range s.config.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("OTLP export returned %d: %s", resp.StatusCode, truncate(string(respBody), 512))
+	}
+
+	// The collector may accept the request but reject some records; those
+	// are not retryable according to the OTLP specification
+	if rejected, message := otlpPartialSuccess(respBody, contentType); rejected > 0 {
+		slog.Warn("OTLP collector rejected log records", "rejected", rejected, "message", message)
+	}
+	return nil
+}
+
+// otlpPartialSuccess reads ExportLogsServiceResponse.partial_success
+func otlpPartialSuccess(body []byte, contentType string) (int64, string) {
+	if len(body) == 0 {
+		return 0, ""
+	}
+	if contentType == "application/json" {
+		var resp struct {
+			PartialSuccess struct {
+				RejectedLogRecords json.Number `json:"rejectedLogRecords"`
+				ErrorMessage       string      `json:"errorMessage"`
+			} `json:"partialSuccess"`
+		}
+		if json.Unmarshal(body, &resp) != nil {
+			return 0, ""
+		}
+		rejected, _ := resp.PartialSuccess.RejectedLogRecords.Int64()
+		return rejected, resp.PartialSuccess.ErrorMessage
+	}
+
+	var rejected int64
+	var message string
+	for len(body) > 0 {
+		num, typ, n := protowire.ConsumeTag(body)
+		if n < 0 {
+			return rejected, message
+		}
+		body = body[n:]
+		if num != 1 || typ != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(num, typ, body); n < 0 {
+				return rejected, message
+			}
+			body = body[n:]
+			continue
+		}
+		partial, n := protowire.ConsumeBytes(body)
+		if n < 0 {
+			return rejected, message
+		}
+		body = body[n:]
+		for len(partial) > 0 {
+			pnum, ptyp, pn := protowire.ConsumeTag(partial)
+			if pn < 0 {
+				break
+			}
+			partial = partial[pn:]
+			switch {
+			case pnum == 1 && ptyp == protowire.VarintType:
+				v, vn := protowire.ConsumeVarint(partial)
+				if vn < 0 {
+					return rejected, message
+				}
+				rejected = int64(v)
+				partial = partial[vn:]
+			case pnum == 2 && ptyp == protowire.BytesType:
+				v, vn := protowire.ConsumeString(partial)
+				if vn < 0 {
+					return rejected, message
+				}
+				message = v
+				partial = partial[vn:]
+			default:
+				vn := protowire.ConsumeFieldValue(pnum, ptyp, partial)
+				if vn < 0 {
+					return rejected, message
+				}
+				partial = partial[vn:]
+			}
+		}
+	}
+	return rejected, message
+}
+
+// encodeOTLPProtobuf encodes an ExportLogsServiceRequest with a single
+// ResourceLogs/ScopeLogs pair
+func encodeOTLPProtobuf(resource []otlpKeyValue, records []otlpLogRecord) []byte {
+	var resourceMsg []byte
+	for _, kv := range resource {
+		resourceMsg = appendOTLPMessage(resourceMsg, 1, encodeOTLPKeyValue(kv))
+	}
+
+	var scope []byte
+	scope = protowire.AppendTag(scope, 1, protowire.BytesType)
+	scope = protowire.AppendString(scope, "aurora-log-processor")
+
+	var scopeLogs []byte
+	scopeLogs = appendOTLPMessage(scopeLogs, 1, scope)
+	for _, record := range records {
+		scopeLogs = appendOTLPMessage(scopeLogs, 2, encodeOTLPLogRecord(record))
+	}
+
+	var resourceLogs []byte
+	resourceLogs = appendOTLPMessage(resourceLogs, 1, resourceMsg)
+	resourceLogs = appendOTLPMessage(resourceLogs, 2, scopeLogs)
+
+	return appendOTLPMessage(nil, 1, resourceLogs)
+}
+
+func encodeOTLPLogRecord(record otlpLogRecord) []byte {
+	var msg []byte
+	msg = protowire.AppendTag(msg, 1, protowire.Fixed64Type)
+	msg = protowire.AppendFixed64(msg, record.timeUnixNano)
+	if record.severityNumber != otlpSeverityUnspecified {
+		msg = protowire.AppendTag(msg, 2, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, uint64(record.severityNumber))
+	}
+	if record.severityText != "" {
+		msg = protowire.AppendTag(msg, 3, protowire.BytesType)
+		msg = protowire.AppendString(msg, record.severityText)
+	}
+	msg = appendOTLPMessage(msg, 5, encodeOTLPAnyValue(otlpValue{kind: "string", str: record.body}))
+	for _, kv := range record.attributes {
+		msg = appendOTLPMessage(msg, 6, encodeOTLPKeyValue(kv))
+	}
+	msg = protowire.AppendTag(msg, 11, protowire.Fixed64Type)
+	msg = protowire.AppendFixed64(msg, record.observedUnixNano)
+	return msg
+}
+
+func encodeOTLPKeyValue(kv otlpKeyValue) []byte {
+	var msg []byte
+	msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+	msg = protowire.AppendString(msg, kv.key)
+	return appendOTLPMessage(msg, 2, encodeOTLPAnyValue(kv.value))
+}
+
+func encodeOTLPAnyValue(v otlpValue) []byte {
+	var msg []byte
+	switch v.kind {
+	case "bool":
+		msg = protowire.AppendTag(msg, 2, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, protowire.EncodeBool(v.boolV))
+	case "int":
+		msg = protowire.AppendTag(msg, 3, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, uint64(v.intV))
+	case "double":
+		msg = protowire.AppendTag(msg, 4, protowire.Fixed64Type)
+		msg = protowire.AppendFixed64(msg, math.Float64bits(v.double))
+	default:
+		msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+		msg = protowire.AppendString(msg, v.str)
+	}
+	return msg
+}
+
+func appendOTLPMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+// encodeOTLPJSON encodes the OTLP/JSON form: camelCase field names, 64-bit
+// integers as strings and enums as numbers
+func encodeOTLPJSON(resource []otlpKeyValue, records []otlpLogRecord) ([]byte, error) {
+	jsonAttrs := func(kvs []otlpKeyValue) []map[string]interface{} {
+		attrs := make([]map[string]interface{}, 0, len(kvs))
+		for _, kv := range kvs {
+			attrs = append(attrs, map[string]interface{}{"key": kv.key, "value": otlpJSONValue(kv.value)})
+		}
+		return attrs
+	}
+
+	logRecords := make([]map[string]interface{}, 0, len(records))
+	for _, record := range records {
+		logRecord := map[string]interface{}{
+			"timeUnixNano":         strconv.FormatUint(record.timeUnixNano, 10),
+			"observedTimeUnixNano": strconv.FormatUint(record.observedUnixNano, 10),
+			"body":                 otlpJSONValue(otlpValue{kind: "string", str: record.body}),
+			"attributes":           jsonAttrs(record.attributes),
+		}
+		if record.severityNumber != otlpSeverityUnspecified {
+			logRecord["severityNumber"] = record.severityNumber
+		}
+		if record.severityText != "" {
+			logRecord["severityText"] = record.severityText
+		}
+		logRecords = append(logRecords, logRecord)
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"resourceLogs": []map[string]interface{}{{
+			"resource": map[string]interface{}{"attributes": jsonAttrs(resource)},
+			"scopeLogs": []map[string]interface{}{{
+				"scope":      map[string]string{"name": "aurora-log-processor"},
+				"logRecords": logRecords,
+			}},
+		}},
+	})
+}
+
+func otlpJSONValue(v otlpValue) map[string]interface{} {
+	switch v.kind {
+	case "bool":
+		return map[string]interface{}{"boolValue": v.boolV}
+	case "int":
+		return map[string]interface{}{"intValue": strconv.FormatInt(v.intV, 10)}
+	case "double":
+		return map[string]interface{}{"doubleValue": v.double}
+	}
+	return map[string]interface{}{"stringValue": v.str}
+}
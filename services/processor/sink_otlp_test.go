@@ -0,0 +1,228 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type protoField struct {
+	num   protowire.Number
+	bytes []byte
+	value uint64
+}
+
+// decodeProto splits a message into its top-level fields
+func decodeProto(t *testing.T, b []byte) []protoField {
+	var fields []protoField
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		require.GreaterOrEqual(t, n, 0)
+		b = b[n:]
+		field := protoField{num: num}
+		switch typ {
+		case protowire.BytesType:
+			field.bytes, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			field.value, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			field.value, n = protowire.ConsumeFixed64(b)
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+		require.GreaterOrEqual(t, n, 0)
+		b = b[n:]
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func protoChildren(t *testing.T, b []byte, num protowire.Number) []protoField {
+	var out []protoField
+	for _, field := range decodeProto(t, b) {
+		if field.num == num {
+			out = append(out, field)
+		}
+	}
+	return out
+}
+
+// protoAttributes decodes repeated KeyValue fields into Go values
+func protoAttributes(t *testing.T, b []byte, num protowire.Number) map[string]interface{} {
+	attrs := make(map[string]interface{})
+	for _, kv := range protoChildren(t, b, num) {
+		key := string(protoChildren(t, kv.bytes, 1)[0].bytes)
+		value := decodeProto(t, protoChildren(t, kv.bytes, 2)[0].bytes)[0]
+		switch value.num {
+		case 1:
+			attrs[key] = string(value.bytes)
+		case 2:
+			attrs[key] = value.value != 0
+		case 3:
+			attrs[key] = int64(value.value)
+		case 4:
+			attrs[key] = math.Float64frombits(value.value)
+		}
+	}
+	return attrs
+}
+
+func newTestOTLPSink(t *testing.T, url, protocol string) *OTLPSink {
+	sink, err := NewOTLPSink(OTLPSinkConfig{
+		Endpoint:    url,
+		Protocol:    protocol,
+		Headers:     map[string]string{"Authorization": "Bearer token"},
+		Compression: "gzip",
+		ServiceName: "aurora-mysql",
+		Region:      "eu-west-1",
+	}, NewHTTPConnectionPool(1, time.Second))
+	require.NoError(t, err)
+	return sink
+}
+
+func otlpTestBatch(ts time.Time) []ParsedLogEntry {
+	return []ParsedLogEntry{
+		{"_timestamp": ts.UnixMilli(), "level": "ERROR", "message": "Deadlock found", "thread_id": "42", "cluster_id": "c1"},
+		{"_timestamp": ts.UnixMilli(), "level": "WARNING", "query_time": 2.5, "rows_sent": int64(10), "sql_statement": "SELECT 1;"},
+	}
+}
+
+func readOTLPBody(t *testing.T, r *http.Request) []byte {
+	assert.Equal(t, "/v1/logs", r.URL.Path)
+	assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+	assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+	gz, err := gzip.NewReader(r.Body)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	body, err := io.ReadAll(gz)
+	assert.NoError(t, err)
+	return body
+}
+
+func TestOTLPSinkProtobuf(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		body = readOTLPBody(t, r)
+	}))
+	defer server.Close()
+
+	sink := newTestOTLPSink(t, server.URL, "http/protobuf")
+	ts := time.Date(2025, 8, 2, 12, 0, 0, 0, time.UTC)
+	logMsg := LogMessage{ClusterID: "c1", InstanceID: "db-1", Engine: "aurora-mysql", LogType: "error"}
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, otlpTestBatch(ts)))
+
+	resourceLogs := protoChildren(t, body, 1)
+	require.Len(t, resourceLogs, 1)
+
+	resource := protoChildren(t, resourceLogs[0].bytes, 1)[0].bytes
+	assert.Equal(t, map[string]interface{}{
+		"service.name":       "aurora-mysql",
+		"cloud.provider":     "aws",
+		"cloud.platform":     "aws_rds",
+		"cloud.region":       "eu-west-1",
+		"aurora.cluster_id":  "c1",
+		"aurora.instance_id": "db-1",
+		"aurora.engine":      "aurora-mysql",
+	}, protoAttributes(t, resource, 1))
+
+	scopeLogs := protoChildren(t, resourceLogs[0].bytes, 2)[0].bytes
+	records := protoChildren(t, scopeLogs, 2)
+	require.Len(t, records, 2)
+
+	first := records[0].bytes
+	assert.Equal(t, uint64(ts.UnixNano()), protoChildren(t, first, 1)[0].value)
+	assert.Equal(t, uint64(otlpSeverityError), protoChildren(t, first, 2)[0].value)
+	assert.Equal(t, "ERROR", string(protoChildren(t, first, 3)[0].bytes))
+	body0 := decodeProto(t, protoChildren(t, first, 5)[0].bytes)[0]
+	assert.Equal(t, "Deadlock found", string(body0.bytes))
+	assert.Equal(t, map[string]interface{}{"thread_id": "42"}, protoAttributes(t, first, 6))
+
+	second := records[1].bytes
+	assert.Equal(t, uint64(otlpSeverityWarn), protoChildren(t, second, 2)[0].value)
+	assert.Equal(t, map[string]interface{}{"query_time": 2.5, "rows_sent": int64(10)}, protoAttributes(t, second, 6))
+}
+
+func TestOTLPSinkJSON(t *testing.T) {
+	var request struct {
+		ResourceLogs []struct {
+			Resource struct {
+				Attributes []map[string]interface{} `json:"attributes"`
+			} `json:"resource"`
+			ScopeLogs []struct {
+				LogRecords []map[string]interface{} `json:"logRecords"`
+			} `json:"scopeLogs"`
+		} `json:"resourceLogs"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.Unmarshal(readOTLPBody(t, r), &request))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"partialSuccess":{"rejectedLogRecords":"1","errorMessage":"too large"}}`))
+	}))
+	defer server.Close()
+
+	sink := newTestOTLPSink(t, server.URL, "http/json")
+	ts := time.Date(2025, 8, 2, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, sink.WriteBatch(context.Background(), LogMessage{ClusterID: "c1", InstanceID: "db-1"}, otlpTestBatch(ts)))
+
+	require.Len(t, request.ResourceLogs, 1)
+	assert.Contains(t, request.ResourceLogs[0].Resource.Attributes, map[string]interface{}{
+		"key": "cloud.region", "value": map[string]interface{}{"stringValue": "eu-west-1"},
+	})
+
+	records := request.ResourceLogs[0].ScopeLogs[0].LogRecords
+	require.Len(t, records, 2)
+	assert.Equal(t, "1754136000000000000", records[0]["timeUnixNano"])
+	assert.Equal(t, float64(otlpSeverityError), records[0]["severityNumber"])
+	assert.Equal(t, map[string]interface{}{"stringValue": "Deadlock found"}, records[0]["body"])
+	assert.Equal(t, map[string]interface{}{"stringValue": "SELECT 1;"}, records[1]["body"])
+	assert.Contains(t, records[1]["attributes"], map[string]interface{}{
+		"key": "rows_sent", "value": map[string]interface{}{"intValue": "10"},
+	})
+}
+
+func TestOTLPSinkErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	sink := newTestOTLPSink(t, server.URL, "http/protobuf")
+	err := sink.WriteBatch(context.Background(), LogMessage{}, []ParsedLogEntry{{"message": "m"}})
+	assert.ErrorContains(t, err, "503")
+
+	_, err = NewOTLPSink(OTLPSinkConfig{Protocol: "grpc"}, nil)
+	assert.Error(t, err)
+}
+
+func TestOTLPSeverity(t *testing.T) {
+	assert.Equal(t, otlpSeverityInfo, otlpSeverity("Note"))
+	assert.Equal(t, otlpSeverityWarn, otlpSeverity("Warning"))
+	assert.Equal(t, otlpSeverityError, otlpSeverity("ERROR"))
+	assert.Equal(t, otlpSeverityUnspecified, otlpSeverity(""))
+}
+
+func TestOTLPPartialSuccessProtobuf(t *testing.T) {
+	var partial []byte
+	partial = protowire.AppendTag(partial, 1, protowire.VarintType)
+	partial = protowire.AppendVarint(partial, 3)
+	partial = protowire.AppendTag(partial, 2, protowire.BytesType)
+	partial = protowire.AppendString(partial, "bad records")
+	resp := appendOTLPMessage(nil, 1, partial)
+
+	rejected, message := otlpPartialSuccess(resp, "application/x-protobuf")
+	assert.Equal(t, int64(3), rejected)
+	assert.Equal(t, "bad records", message)
+}
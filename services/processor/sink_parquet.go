@@ -0,0 +1,775 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+	"github.com/yourorg/aurora-log-system/common/env"
+)
+
+// ParquetArchiveSinkConfig configures the Parquet archive sink. Upload and
+// rolling settings are shared with the NDJSON archive; Compression selects
+// the Parquet page codec instead of a stream compressor.
+type ParquetArchiveSinkConfig struct {
+	S3             S3ArchiveSinkConfig
+	RowGroupRows   int64
+	PageBufferSize int
+	Dictionary     bool
+}
+
+func loadParquetArchiveSinkConfig() ParquetArchiveSinkConfig {
+	s3Config := loadS3ArchiveSinkConfig()
+	s3Config.Bucket = env.Get("PARQUET_ARCHIVE_BUCKET", s3Config.Bucket)
+	s3Config.Prefix = env.Get("PARQUET_ARCHIVE_PREFIX", "aurora-parquet")
+	s3Config.Compression = env.Get("PARQUET_ARCHIVE_COMPRESSION", "snappy")
+	s3Config.MaxObjectSize = int64(env.Int("PARQUET_ARCHIVE_MAX_FILE_MB", 128)) * 1024 * 1024
+	s3Config.MaxObjectAge = time.Duration(env.Int("PARQUET_ARCHIVE_MAX_FILE_AGE_SEC", 900)) * time.Second
+
+	return ParquetArchiveSinkConfig{
+		S3:             s3Config,
+		RowGroupRows:   int64(env.Int("PARQUET_ARCHIVE_ROW_GROUP_ROWS", 100000)),
+		PageBufferSize: env.Int("PARQUET_ARCHIVE_PAGE_BUFFER_KB", 256) * 1024,
+		Dictionary:     env.Get("PARQUET_ARCHIVE_DICTIONARY", "true") == "true",
+	}
+}
+
+// ErrorEventRow is the Parquet schema for error log events
+type ErrorEventRow struct {
+	Timestamp   int64  `parquet:"timestamp"`
+	ClusterID   string `parquet:"cluster_id"`
+	InstanceID  string `parquet:"instance_id"`
+	LogFileName string `parquet:"log_file_name"`
+	Level       string `parquet:"level"`
+	ThreadID    string `parquet:"thread_id"`
+	Message     string `parquet:"message"`
+	RawLine     string `parquet:"raw_line"`
+}
+
+// SlowQueryRow is the Parquet schema for slow queries. The parser emits one
+// entry per slow log line; the sink reassembles them into one row per query.
+type SlowQueryRow struct {
+	Timestamp    int64    `parquet:"timestamp"`
+	ClusterID    string   `parquet:"cluster_id"`
+	InstanceID   string   `parquet:"instance_id"`
+	LogFileName  string   `parquet:"log_file_name"`
+	User         string   `parquet:"user"`
+	Host         string   `parquet:"host"`
+	QueryTime    *float64 `parquet:"query_time,optional"`
+	LockTime     *float64 `parquet:"lock_time,optional"`
+	RowsSent     *int64   `parquet:"rows_sent,optional"`
+	RowsExamined *int64   `parquet:"rows_examined,optional"`
+	SQLStatement string   `parquet:"sql_statement"`
+}
+
+// parquetDictionaryColumns are the low-cardinality string columns that are
+// dictionary encoded when PARQUET_ARCHIVE_DICTIONARY is enabled
+var parquetDictionaryColumns = map[string]bool{
+	"cluster_id":    true,
+	"instance_id":   true,
+	"log_file_name": true,
+	"level":         true,
+	"user":          true,
+	"host":          true,
+}
+
+func errorEventSchema(dictionary bool) *parquet.Schema {
+	str := parquetString(dictionary)
+	return parquet.NewSchema("aurora_error_event", parquet.Group{
+		"timestamp":     parquet.Timestamp(parquet.Millisecond),
+		"cluster_id":    str("cluster_id"),
+		"instance_id":   str("instance_id"),
+		"log_file_name": str("log_file_name"),
+		"level":         str("level"),
+		"thread_id":     str("thread_id"),
+		"message":       str("message"),
+		"raw_line":      str("raw_line"),
+	})
+}
+
+func slowQuerySchema(dictionary bool) *parquet.Schema {
+	str := parquetString(dictionary)
+	return parquet.NewSchema("aurora_slow_query", parquet.Group{
+		"timestamp":     parquet.Timestamp(parquet.Millisecond),
+		"cluster_id":    str("cluster_id"),
+		"instance_id":   str("instance_id"),
+		"log_file_name": str("log_file_name"),
+		"user":          str("user"),
+		"host":          str("host"),
+		"query_time":    parquet.Optional(parquet.Leaf(parquet.DoubleType)),
+		"lock_time":     parquet.Optional(parquet.Leaf(parquet.DoubleType)),
+		"rows_sent":     parquet.Optional(parquet.Int(64)),
+		"rows_examined": parquet.Optional(parquet.Int(64)),
+		"sql_statement": str("sql_statement"),
+	})
+}
+
+func parquetString(dictionary bool) func(column string) parquet.Node {
+	return func(column string) parquet.Node {
+		if dictionary && parquetDictionaryColumns[column] {
+			return parquet.Encoded(parquet.String(), &parquet.RLEDictionary)
+		}
+		return parquet.String()
+	}
+}
+
+func parquetCodec(name string) (compress.Codec, error) {
+	switch name {
+	case "snappy":
+		return &parquet.Snappy, nil
+	case "zstd":
+		return &parquet.Zstd, nil
+	case "gzip":
+		return &parquet.Gzip, nil
+	case "none":
+		return &parquet.Uncompressed, nil
+	}
+	return nil, fmt.Errorf("unsupported Parquet compression %q", name)
+}
+
+// parquetFile is one Parquet file being built in memory
+type parquetFile[T any] struct {
+	parquetFileState
+	writer *parquet.GenericWriter[T]
+}
+
+// parquetFileState is the part of a parquetFile that does not depend on
+// its row type
+type parquetFileState struct {
+	partition string
+	buf       bytes.Buffer
+	opened    time.Time
+	rows      int
+	rawBytes  int64 // estimated size of all rows before encoding
+	sealed    bool
+	key       string // named when sealed, so retries never write a second copy
+
+	files       map[string]bool // source files whose rows are not durable yet
+	journal     *archiveJournal // set when the sink has a spool
+	unjournaled [][]byte        // rows not in the journal yet, as JSON
+}
+
+// size estimates the file's size. The writer holds the rows of the open
+// row group in memory and appends it to buf with the first row after it is
+// full, so those rows are counted at their average raw size; that
+// overestimates them, and files roll early rather than late.
+func (f *parquetFileState) size(rowGroupRows int64) int64 {
+	size := int64(f.buf.Len())
+	if f.sealed || f.rows == 0 {
+		return size
+	}
+	rows := int64(f.rows)
+	buffered := rows - (rows-1)/rowGroupRows*rowGroupRows
+	return size + f.rawBytes*buffered/rows
+}
+
+// slowQueryAssembler collects the per-line slow log entries of one file
+// into the query they belong to
+type slowQueryAssembler struct {
+	current *SlowQueryRow
+	updated time.Time
+}
+
+// ParquetArchiveSink writes error and slow-query events as Parquet files
+// partitioned as <log_type>/cluster_id=<id>/date=<yyyy-mm-dd>/, a layout
+// Athena and DuckDB read as Hive partitions. Other log types are ignored.
+type ParquetArchiveSink struct {
+	config   ParquetArchiveSinkConfig
+	codec    compress.Codec
+	uploader objectUploader
+	client   headBucketAPI
+	hostname string
+	now      func() time.Time
+	seq      atomic.Int64
+
+	errorSchema     *parquet.Schema
+	slowQuerySchema *parquet.Schema
+
+	mu         sync.Mutex
+	errorFiles map[string]*parquetFile[ErrorEventRow]
+	slowFiles  map[string]*parquetFile[SlowQueryRow]
+	assemblers map[string]*slowQueryAssembler
+	spool      *archiveSpool
+}
+
+func NewParquetArchiveSink(config ParquetArchiveSinkConfig, awsCfg aws.Config) (*ParquetArchiveSink, error) {
+	uploader, client := newS3Uploader(config.S3, awsCfg)
+	return newParquetArchiveSink(config, uploader, client)
+}
+
+func newParquetArchiveSink(config ParquetArchiveSinkConfig, uploader objectUploader, client headBucketAPI) (*ParquetArchiveSink, error) {
+	if config.S3.Bucket == "" {
+		return nil, fmt.Errorf("PARQUET_ARCHIVE_BUCKET or S3_ARCHIVE_BUCKET is required for the parquet sink")
+	}
+	codec, err := parquetCodec(config.S3.Compression)
+	if err != nil {
+		return nil, err
+	}
+	if config.S3.MaxObjectSize <= 0 {
+		config.S3.MaxObjectSize = 128 * 1024 * 1024
+	}
+	if config.S3.MaxObjectAge <= 0 {
+		config.S3.MaxObjectAge = 15 * time.Minute
+	}
+	if config.RowGroupRows <= 0 {
+		config.RowGroupRows = 100000
+	}
+	if config.PageBufferSize <= 0 {
+		config.PageBufferSize = 256 * 1024
+	}
+
+	sink := &ParquetArchiveSink{
+		config:          config,
+		codec:           codec,
+		uploader:        uploader,
+		client:          client,
+		hostname:        archiveHostname(),
+		now:             time.Now,
+		errorSchema:     errorEventSchema(config.Dictionary),
+		slowQuerySchema: slowQuerySchema(config.Dictionary),
+		errorFiles:      make(map[string]*parquetFile[ErrorEventRow]),
+		slowFiles:       make(map[string]*parquetFile[SlowQueryRow]),
+		assemblers:      make(map[string]*slowQueryAssembler),
+	}
+	if config.S3.SpoolDir != "" {
+		spool, err := openArchiveSpool(config.S3.SpoolDir)
+		if err != nil {
+			return nil, err
+		}
+		sink.spool = spool
+		if err := sink.recoverJournals(); err != nil {
+			return nil, err
+		}
+	}
+	return sink, nil
+}
+
+// recoverJournals rebuilds the files that were still open when the
+// previous run stopped and spools them for upload on the first roll
+func (s *ParquetArchiveSink) recoverJournals() error {
+	for _, journal := range s.spool.recovered {
+		var err error
+		switch journal.header.Table {
+		case "error":
+			err = recoverParquetJournal[ErrorEventRow](s, s.errorSchema, journal)
+		case "slowquery":
+			err = recoverParquetJournal[SlowQueryRow](s, s.slowQuerySchema, journal)
+		default:
+			err = fmt.Errorf("archive journal %s has unknown table %q", journal.path, journal.header.Table)
+		}
+		if err != nil {
+			return err
+		}
+		journal.remove()
+	}
+	s.spool.recovered = nil
+	return nil
+}
+
+func recoverParquetJournal[T any](s *ParquetArchiveSink, schema *parquet.Schema, journal *archiveJournal) error {
+	rows := make([]T, len(journal.lines))
+	for i, line := range journal.lines {
+		if err := json.Unmarshal(line, &rows[i]); err != nil {
+			return fmt.Errorf("corrupt archive journal %s: %w", journal.path, err)
+		}
+	}
+	file := newParquetFile[T](s, schema, journal.header.Partition, journal.header.Opened)
+	if _, err := file.writer.Write(rows); err != nil {
+		return fmt.Errorf("failed to write journaled Parquet rows: %w", err)
+	}
+	file.rows = len(rows)
+	if err := sealParquetFile(s, file); err != nil {
+		return err
+	}
+	return s.spool.save(file.key, file.buf.Bytes())
+}
+
+func (s *ParquetArchiveSink) Name() string { return "parquet" }
+
+// parquetPartition returns <log_type>/cluster_id=<id>/date=<yyyy-mm-dd>
+func parquetPartition(logType, clusterID string, ts time.Time) string {
+	return fmt.Sprintf("%s/cluster_id=%s/date=%s", logType, clusterID, ts.UTC().Format("2006-01-02"))
+}
+
+func (s *ParquetArchiveSink) WriteBatch(ctx context.Context, logMsg LogMessage, batch []ParsedLogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Upload full files before anything is appended, so that a failed call
+	// leaves no partial batch behind and can be retried without duplicates
+	if err := s.upload(ctx, func(f *parquetFileState) bool { return s.due(f, false) }); err != nil {
+		return err
+	}
+
+	switch logMsg.LogType {
+	case "error":
+		rows := make([]ErrorEventRow, 0, len(batch))
+		for _, entry := range batch {
+			rows = append(rows, errorEventFromEntry(logMsg, entry))
+		}
+		return writeParquetRows(s, s.errorFiles, s.errorSchema, "error", logMsg.ClusterID, rows)
+	case "slowquery":
+		// The batch is assembled on copies of the assemblers it touches,
+		// which replace the originals only once its rows are written; a
+		// retried batch then starts from the same state
+		staged := make(map[string]*slowQueryAssembler)
+		var rows []SlowQueryRow
+		for _, entry := range batch {
+			if row := s.assemble(staged, logMsg, entry); row != nil {
+				rows = append(rows, *row)
+			}
+		}
+		if err := writeParquetRows(s, s.slowFiles, s.slowQuerySchema, "slowquery", logMsg.ClusterID, rows); err != nil {
+			return err
+		}
+		for key, assembler := range staged {
+			s.assemblers[key] = assembler
+		}
+	}
+	return nil
+}
+
+// Roll uploads files spooled by an earlier shutdown, emits slow queries
+// whose file went quiet and uploads files that reached their size or age
+// limit
+func (s *ParquetArchiveSink) Roll(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.spool != nil {
+		if err := s.spool.upload(ctx, s.put); err != nil {
+			return err
+		}
+	}
+	cutoff := s.now().Add(-s.config.S3.MaxObjectAge)
+	if err := s.drainAssemblers(func(_ string, a *slowQueryAssembler) bool { return a.updated.Before(cutoff) }); err != nil {
+		return err
+	}
+	return s.upload(ctx, func(f *parquetFileState) bool { return s.due(f, true) })
+}
+
+// FlushFile makes the rows of logMsg's file written so far durable without
+// closing files early: with a spool, the files holding them are journaled
+// to disk; without one, those files are uploaded. The slow query still
+// being assembled for the file is only emitted once the file is read to
+// its end; at a checkpoint the file's next lines may continue it, and other
+// files' queries are never touched.
+func (s *ParquetArchiveSink) FlushFile(ctx context.Context, logMsg LogMessage, final bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file := sinkFileKey(logMsg)
+	if final {
+		if err := s.drainAssemblers(func(key string, _ *slowQueryAssembler) bool { return key == file }); err != nil {
+			return err
+		}
+	}
+	holding := func(f *parquetFileState) bool { return f.files[file] }
+	if s.spool == nil {
+		return s.upload(ctx, holding)
+	}
+	return errors.Join(
+		journalParquetFiles(s.errorFiles, holding),
+		journalParquetFiles(s.slowFiles, holding),
+	)
+}
+
+// Flush emits every pending slow query and uploads all open files. It is
+// only called at shutdown.
+func (s *ParquetArchiveSink) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.drainAssemblers(func(string, *slowQueryAssembler) bool { return true }); err != nil {
+		return err
+	}
+	return s.upload(ctx, func(*parquetFileState) bool { return true })
+}
+
+// Close spools the files and pending slow queries the final flush could
+// not upload, so they are uploaded on the next start. It fails if any of
+// them is lost.
+func (s *ParquetArchiveSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	drainErr := s.drainAssemblers(func(string, *slowQueryAssembler) bool { return true })
+	if pending := len(s.errorFiles) + len(s.slowFiles); pending > 0 && s.spool == nil {
+		return errors.Join(drainErr,
+			fmt.Errorf("%d Parquet files were not uploaded and are lost without a spool directory", pending))
+	}
+	return errors.Join(
+		drainErr,
+		spoolParquetFiles(s, s.errorFiles),
+		spoolParquetFiles(s, s.slowFiles),
+	)
+}
+
+// Health checks that the bucket is reachable
+func (s *ParquetArchiveSink) Health(ctx context.Context) error {
+	_, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(s.config.S3.Bucket)})
+	return err
+}
+
+// upload uploads the open files that match
+func (s *ParquetArchiveSink) upload(ctx context.Context, match func(*parquetFileState) bool) error {
+	return errors.Join(
+		uploadParquetFiles(ctx, s, s.errorFiles, match),
+		uploadParquetFiles(ctx, s, s.slowFiles, match),
+	)
+}
+
+// due reports whether a file reached MaxObjectSize, or MaxObjectAge when
+// checkAge is set
+func (s *ParquetArchiveSink) due(f *parquetFileState, checkAge bool) bool {
+	if f.sealed || f.size(s.config.RowGroupRows) >= s.config.S3.MaxObjectSize {
+		return true
+	}
+	return checkAge && s.now().Sub(f.opened) >= s.config.S3.MaxObjectAge
+}
+
+// assemble folds one slow log entry into the query being built for its
+// file and returns the previous query once a new one starts. Changes go to
+// a copy of the file's assembler in staged; s.assemblers is not modified.
+func (s *ParquetArchiveSink) assemble(staged map[string]*slowQueryAssembler, logMsg LogMessage, entry ParsedLogEntry) *SlowQueryRow {
+	fileName, _ := entry["log_file_name"].(string)
+	if fileName == "" {
+		fileName = logMsg.LogFileName
+	}
+	key := sinkFileKey(LogMessage{InstanceID: logMsg.InstanceID, LogFileName: fileName})
+
+	assembler, ok := staged[key]
+	if !ok {
+		assembler = &slowQueryAssembler{}
+		if committed, ok := s.assemblers[key]; ok && committed.current != nil {
+			current := *committed.current
+			assembler.current = &current
+		}
+		staged[key] = assembler
+	}
+	assembler.updated = s.now()
+
+	eventType, _ := entry["event_type"].(string)
+
+	var done *SlowQueryRow
+	current := assembler.current
+	if current != nil {
+		complete := current.SQLStatement != "" || current.QueryTime != nil
+		if (eventType == "query_start" || eventType == "query_metadata") && complete ||
+			eventType == "query_stats" && current.QueryTime != nil {
+			done = current
+			current = nil
+		}
+	}
+	if current == nil {
+		current = &SlowQueryRow{
+			Timestamp:   entryTimestamp(entry).UnixMilli(),
+			ClusterID:   logMsg.ClusterID,
+			InstanceID:  logMsg.InstanceID,
+			LogFileName: fileName,
+		}
+	}
+
+	switch eventType {
+	case "query_start", "query_timestamp":
+		current.Timestamp = entryTimestamp(entry).UnixMilli()
+	case "query_metadata":
+		current.User, _ = entry["user"].(string)
+		current.Host, _ = entry["host"].(string)
+	case "query_stats":
+		current.QueryTime = entryFloat(entry, "query_time")
+		current.LockTime = entryFloat(entry, "lock_time")
+		current.RowsSent = entryInt(entry, "rows_sent")
+		current.RowsExamined = entryInt(entry, "rows_examined")
+	case "query_sql":
+		sql, _ := entry["sql_statement"].(string)
+		if current.SQLStatement != "" {
+			current.SQLStatement += "\n"
+		}
+		current.SQLStatement += sql
+	}
+
+	assembler.current = current
+	return done
+}
+
+// drainAssemblers writes the pending query of every matching assembler.
+// An assembler is only removed once its query is written.
+func (s *ParquetArchiveSink) drainAssemblers(match func(key string, a *slowQueryAssembler) bool) error {
+	byCluster := make(map[string][]SlowQueryRow)
+	keys := make(map[string][]string)
+	for key, assembler := range s.assemblers {
+		if !match(key, assembler) {
+			continue
+		}
+		if assembler.current == nil {
+			delete(s.assemblers, key)
+			continue
+		}
+		clusterID := assembler.current.ClusterID
+		byCluster[clusterID] = append(byCluster[clusterID], *assembler.current)
+		keys[clusterID] = append(keys[clusterID], key)
+	}
+
+	var errs []error
+	for clusterID, rows := range byCluster {
+		if err := writeParquetRows(s, s.slowFiles, s.slowQuerySchema, "slowquery", clusterID, rows); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, key := range keys[clusterID] {
+			delete(s.assemblers, key)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func errorEventFromEntry(logMsg LogMessage, entry ParsedLogEntry) ErrorEventRow {
+	row := ErrorEventRow{
+		Timestamp:   entryTimestamp(entry).UnixMilli(),
+		ClusterID:   logMsg.ClusterID,
+		InstanceID:  logMsg.InstanceID,
+		LogFileName: logMsg.LogFileName,
+	}
+	if fileName, ok := entry["log_file_name"].(string); ok && fileName != "" {
+		row.LogFileName = fileName
+	}
+	row.Level, _ = entry["level"].(string)
+	row.ThreadID, _ = entry["thread_id"].(string)
+	row.Message, _ = entry["message"].(string)
+	row.RawLine, _ = entry["raw_line"].(string)
+	return row
+}
+
+func entryFloat(entry ParsedLogEntry, key string) *float64 {
+	if value, ok := entry[key].(float64); ok {
+		return &value
+	}
+	return nil
+}
+
+func entryInt(entry ParsedLogEntry, key string) *int64 {
+	if value, ok := entry[key].(float64); ok {
+		n := int64(value)
+		return &n
+	}
+	return nil
+}
+
+// writeParquetRows appends rows to the open file of their date partition
+func writeParquetRows[T any](s *ParquetArchiveSink, files map[string]*parquetFile[T], schema *parquet.Schema, logType, clusterID string, rows []T) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	byPartition := make(map[string][]T)
+	for _, row := range rows {
+		partition := parquetPartition(logType, clusterID, time.UnixMilli(parquetRowTimestamp(row)))
+		byPartition[partition] = append(byPartition[partition], row)
+	}
+
+	for partition, partitionRows := range byPartition {
+		file, ok := files[partition]
+		if !ok {
+			file = newParquetFile[T](s, schema, partition, s.now())
+			if s.spool != nil {
+				file.journal = s.spool.newJournal(archiveJournalHeader{Partition: partition, Table: logType, Opened: file.opened})
+			}
+			files[partition] = file
+		}
+
+		var lines [][]byte
+		if file.journal != nil {
+			for _, row := range partitionRows {
+				line, err := json.Marshal(row)
+				if err != nil {
+					return fmt.Errorf("failed to encode Parquet row: %w", err)
+				}
+				lines = append(lines, line)
+			}
+		}
+		if _, err := file.writer.Write(partitionRows); err != nil {
+			return fmt.Errorf("failed to write Parquet rows: %w", err)
+		}
+		file.rows += len(partitionRows)
+		file.unjournaled = append(file.unjournaled, lines...)
+		for _, row := range partitionRows {
+			file.rawBytes += parquetRowSize(row)
+			file.files[parquetRowFile(row)] = true
+		}
+	}
+	return nil
+}
+
+func newParquetFile[T any](s *ParquetArchiveSink, schema *parquet.Schema, partition string, opened time.Time) *parquetFile[T] {
+	file := &parquetFile[T]{parquetFileState: parquetFileState{
+		partition: partition,
+		opened:    opened,
+		files:     make(map[string]bool),
+	}}
+	file.writer = parquet.NewGenericWriter[T](&file.buf,
+		schema,
+		parquet.Compression(s.codec),
+		parquet.MaxRowsPerRowGroup(s.config.RowGroupRows),
+		parquet.PageBufferSize(s.config.PageBufferSize),
+		// Row groups go straight to buf, so its length is the
+		// size of the finished row groups
+		parquet.WriteBufferSize(0),
+		parquet.CreatedBy("aurora-log-processor", "", ""),
+	)
+	return file
+}
+
+// parquetRowFile returns the source file key of a row
+func parquetRowFile(row any) string {
+	switch r := row.(type) {
+	case ErrorEventRow:
+		return sinkFileKey(LogMessage{InstanceID: r.InstanceID, LogFileName: r.LogFileName})
+	case SlowQueryRow:
+		return sinkFileKey(LogMessage{InstanceID: r.InstanceID, LogFileName: r.LogFileName})
+	}
+	return ""
+}
+
+func parquetRowTimestamp(row any) int64 {
+	switch r := row.(type) {
+	case ErrorEventRow:
+		return r.Timestamp
+	case SlowQueryRow:
+		return r.Timestamp
+	}
+	return time.Now().UnixMilli()
+}
+
+// parquetRowSize estimates the size of a row before encoding
+func parquetRowSize(row any) int64 {
+	switch r := row.(type) {
+	case ErrorEventRow:
+		return int64(8 + len(r.ClusterID) + len(r.InstanceID) + len(r.LogFileName) + len(r.Level) +
+			len(r.ThreadID) + len(r.Message) + len(r.RawLine))
+	case SlowQueryRow:
+		return int64(5*8 + len(r.ClusterID) + len(r.InstanceID) + len(r.LogFileName) + len(r.User) +
+			len(r.Host) + len(r.SQLStatement))
+	}
+	return 0
+}
+
+func uploadParquetFiles[T any](ctx context.Context, s *ParquetArchiveSink, files map[string]*parquetFile[T], match func(*parquetFileState) bool) error {
+	var errs []error
+	for partition, file := range files {
+		if !match(&file.parquetFileState) {
+			continue
+		}
+		if err := uploadParquetFile(ctx, s, file); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delete(files, partition)
+	}
+	return errors.Join(errs...)
+}
+
+// journalParquetFiles appends the unjournaled rows of the matching files to
+// their journals
+func journalParquetFiles[T any](files map[string]*parquetFile[T], match func(*parquetFileState) bool) error {
+	var errs []error
+	for _, file := range files {
+		if !match(&file.parquetFileState) {
+			continue
+		}
+		if err := file.journal.append(file.unjournaled); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		file.unjournaled = nil
+		clear(file.files)
+	}
+	return errors.Join(errs...)
+}
+
+// spoolParquetFiles seals every open file and moves it to the spool
+func spoolParquetFiles[T any](s *ParquetArchiveSink, files map[string]*parquetFile[T]) error {
+	var errs []error
+	for partition, file := range files {
+		if err := sealParquetFile(s, file); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := s.spool.save(file.key, file.buf.Bytes()); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		file.journal.remove()
+		delete(files, partition)
+		slog.Warn("Spooled Parquet file for upload on next start", "key", file.key, "rows", file.rows)
+	}
+	return errors.Join(errs...)
+}
+
+// sealParquetFile writes the footer and names the file; only an upload
+// may follow
+func sealParquetFile[T any](s *ParquetArchiveSink, file *parquetFile[T]) error {
+	if file.sealed {
+		return nil
+	}
+	if err := file.writer.Close(); err != nil {
+		return fmt.Errorf("failed to finish Parquet file: %w", err)
+	}
+	file.sealed = true
+	file.key = parquetFileKey(s, file)
+	return nil
+}
+
+func parquetFileKey[T any](s *ParquetArchiveSink, file *parquetFile[T]) string {
+	name := fmt.Sprintf("%s-%d-%d.parquet", s.hostname, file.opened.UnixNano(), s.seq.Add(1))
+	return path.Join(strings.Trim(s.config.S3.Prefix, "/"), file.partition, name)
+}
+
+// put writes one finished file
+func (s *ParquetArchiveSink) put(ctx context.Context, key string, body []byte) error {
+	_, err := s.uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.config.S3.Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/vnd.apache.parquet"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload s3://%s/%s: %w", s.config.S3.Bucket, key, err)
+	}
+	return nil
+}
+
+// uploadParquetFile writes the footer and uploads the file. A sealed file
+// that failed to upload is retried as-is under the same key on the next
+// roll.
+func uploadParquetFile[T any](ctx context.Context, s *ParquetArchiveSink, file *parquetFile[T]) error {
+	if err := sealParquetFile(s, file); err != nil {
+		return err
+	}
+
+	if err := s.put(ctx, file.key, file.buf.Bytes()); err != nil {
+		return err
+	}
+	if file.journal != nil {
+		file.journal.remove()
+	}
+
+	slog.Info("Archived Parquet file",
+		"bucket", s.config.S3.Bucket,
+		"key", file.key,
+		"rows", file.rows,
+		"bytes", file.buf.Len())
+	return nil
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestParquetSink(t *testing.T, uploader *fakeUploader, dictionary bool) *ParquetArchiveSink {
+	sink, err := newParquetArchiveSink(ParquetArchiveSinkConfig{
+		S3: S3ArchiveSinkConfig{
+			Bucket:      "archive",
+			Prefix:      "aurora-parquet",
+			Compression: "snappy",
+		},
+		RowGroupRows: 2,
+		Dictionary:   dictionary,
+	}, uploader, uploader)
+	require.NoError(t, err)
+	return sink
+}
+
+func openParquet(t *testing.T, obj uploadedObject) *parquet.File {
+	file, err := parquet.OpenFile(bytes.NewReader(obj.body), int64(len(obj.body)))
+	require.NoError(t, err)
+	return file
+}
+
+func TestParquetArchiveSinkErrorEvents(t *testing.T) {
+	uploader := &fakeUploader{}
+	sink := newTestParquetSink(t, uploader, true)
+
+	logMsg := LogMessage{ClusterID: "prod-orders", InstanceID: "db-1", LogType: "error", LogFileName: "error/mysql-error.log"}
+	day := time.Date(2025, 8, 2, 23, 59, 0, 0, time.UTC)
+
+	batch := make([]ParsedLogEntry, 0, 4)
+	for i, ts := range []time.Time{day, day, day, day.Add(2 * time.Minute)} {
+		entry := parseErrorLog("2025-08-04T05:30:23.573848Z 58699 [Warning] [MY-010914] [Server] Got packets out of order")
+		entry["_timestamp"] = ts.UnixMilli()
+		entry["log_file_name"] = logMsg.LogFileName
+		if i == 0 {
+			entry["level"] = "ERROR"
+		}
+		batch = append(batch, entry)
+	}
+
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, batch))
+	require.NoError(t, sink.Flush(context.Background()))
+	require.Len(t, uploader.objects, 2)
+
+	objects := map[string]uploadedObject{}
+	for _, obj := range uploader.objects {
+		objects[obj.key[:strings.LastIndex(obj.key, "/")]] = obj
+	}
+
+	obj, ok := objects["aurora-parquet/error/cluster_id=prod-orders/date=2025-08-02"]
+	require.True(t, ok)
+	assert.True(t, strings.HasSuffix(obj.key, ".parquet"))
+
+	file := openParquet(t, obj)
+	assert.Len(t, file.RowGroups(), 2, "three rows with two rows per row group")
+
+	rows, err := parquet.Read[ErrorEventRow](bytes.NewReader(obj.body), int64(len(obj.body)))
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	assert.Equal(t, ErrorEventRow{
+		Timestamp:   day.UnixMilli(),
+		ClusterID:   "prod-orders",
+		InstanceID:  "db-1",
+		LogFileName: "error/mysql-error.log",
+		Level:       "ERROR",
+		ThreadID:    "58699",
+		Message:     "[MY-010914] [Server] Got packets out of order",
+		RawLine:     "2025-08-04T05:30:23.573848Z 58699 [Warning] [MY-010914] [Server] Got packets out of order",
+	}, rows[0])
+
+	_, ok = objects["aurora-parquet/error/cluster_id=prod-orders/date=2025-08-03"]
+	assert.True(t, ok)
+}
+
+func TestParquetArchiveSinkSlowQueries(t *testing.T) {
+	uploader := &fakeUploader{}
+	sink := newTestParquetSink(t, uploader, false)
+
+	logMsg := LogMessage{ClusterID: "c1", InstanceID: "db-1", LogType: "slowquery", LogFileName: "slowquery/mysql-slowquery.log"}
+	lines := []string{
+		"# Time: 2025-08-02T15:04:05.000000Z",
+		"# User@Host: app[app] @ [10.0.0.5]  Id: 42",
+		"# Query_time: 2.500000  Lock_time: 0.000100 Rows_sent: 10  Rows_examined: 50000",
+		"SET timestamp=1754147045;",
+		"SELECT *",
+		"FROM orders;",
+		"# Time: 2025-08-02T15:05:00.000000Z",
+		"# User@Host: batch[batch] @ [10.0.0.6]  Id: 43",
+		"# Query_time: 0.100000  Lock_time: 0.000000 Rows_sent: 1  Rows_examined: 1",
+		"DELETE FROM sessions;",
+	}
+
+	var batch []ParsedLogEntry
+	for _, line := range lines {
+		entry := parseSlowQueryLog(line)
+		require.NotNil(t, entry, line)
+		entry["_timestamp"] = time.Date(2025, 8, 2, 15, 4, 5, 0, time.UTC).UnixMilli()
+		entry["log_file_name"] = logMsg.LogFileName
+		batch = append(batch, entry)
+	}
+
+	// Split mid-query to show queries are reassembled across batches
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, batch[:5]))
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, batch[5:]))
+	require.NoError(t, sink.Flush(context.Background()))
+
+	require.Len(t, uploader.objects, 1)
+	obj := uploader.objects[0]
+	assert.Contains(t, obj.key, "aurora-parquet/slowquery/cluster_id=c1/date=2025-08-02/")
+
+	rows, err := parquet.Read[SlowQueryRow](bytes.NewReader(obj.body), int64(len(obj.body)))
+	require.NoError(t, err)
+	require.Len(t, rows, 2, "the last query is emitted by the flush")
+
+	first := rows[0]
+	assert.Equal(t, "SELECT *\nFROM orders;", first.SQLStatement)
+	assert.Equal(t, "db-1", first.InstanceID)
+	require.NotNil(t, first.QueryTime)
+	assert.Equal(t, 2.5, *first.QueryTime)
+	require.NotNil(t, first.RowsExamined)
+	assert.Equal(t, int64(50000), *first.RowsExamined)
+
+	assert.Equal(t, "DELETE FROM sessions;", rows[1].SQLStatement)
+	assert.Equal(t, int64(1), *rows[1].RowsSent)
+}
+
+func TestParquetArchiveSinkDictionaryEncoding(t *testing.T) {
+	for _, dictionary := range []bool{true, false} {
+		uploader := &fakeUploader{}
+		sink := newTestParquetSink(t, uploader, dictionary)
+
+		logMsg := LogMessage{ClusterID: "c1", InstanceID: "db-1", LogType: "error"}
+		require.NoError(t, sink.WriteBatch(context.Background(), logMsg, []ParsedLogEntry{
+			{"_timestamp": time.Now().UnixMilli(), "message": "m", "level": "INFO"},
+		}))
+		require.NoError(t, sink.Flush(context.Background()))
+		require.Len(t, uploader.objects, 1)
+
+		file := openParquet(t, uploader.objects[0])
+		for _, column := range file.Metadata().RowGroups[0].Columns {
+			name := column.MetaData.PathInSchema[0]
+			usesDictionary := false
+			for _, encoding := range column.MetaData.Encoding {
+				if encoding == format.RLEDictionary {
+					usesDictionary = true
+				}
+			}
+			assert.Equal(t, dictionary && parquetDictionaryColumns[name], usesDictionary, name)
+		}
+	}
+}
+
+func TestParquetArchiveSinkIgnoresOtherLogTypes(t *testing.T) {
+	uploader := &fakeUploader{}
+	sink := newTestParquetSink(t, uploader, true)
+
+	require.NoError(t, sink.WriteBatch(context.Background(), LogMessage{LogType: "general"}, []ParsedLogEntry{{"message": "m"}}))
+	require.NoError(t, sink.Flush(context.Background()))
+	assert.Empty(t, uploader.objects)
+
+	_, err := newParquetArchiveSink(ParquetArchiveSinkConfig{S3: S3ArchiveSinkConfig{Bucket: "archive", Compression: "brotli"}}, uploader, uploader)
+	assert.Error(t, err)
+}
+
+// failingWriter fails every write, like a file that cannot take more rows
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestParquetArchiveSinkRetriesSlowQueryBatches(t *testing.T) {
+	uploader := &fakeUploader{}
+	sink := newTestParquetSink(t, uploader, false)
+
+	logMsg := LogMessage{ClusterID: "c1", InstanceID: "db-1", LogType: "slowquery", LogFileName: "slowquery/mysql-slowquery.log"}
+	var batch []ParsedLogEntry
+	for _, line := range []string{
+		"# Time: 2025-08-02T15:04:05.000000Z",
+		"# Query_time: 2.500000  Lock_time: 0.000100 Rows_sent: 10  Rows_examined: 50000",
+		"SELECT 1;",
+		"# Time: 2025-08-02T15:05:00.000000Z",
+		"# Query_time: 0.100000  Lock_time: 0.000000 Rows_sent: 1  Rows_examined: 1",
+		"SELECT 2;",
+	} {
+		entry := parseSlowQueryLog(line)
+		require.NotNil(t, entry, line)
+		entry["_timestamp"] = time.Date(2025, 8, 2, 15, 4, 5, 0, time.UTC).UnixMilli()
+		batch = append(batch, entry)
+	}
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, batch[:3]))
+
+	// The next batch completes the first query, but its file cannot take rows
+	partition := parquetPartition("slowquery", "c1", time.Date(2025, 8, 2, 15, 4, 5, 0, time.UTC))
+	broken := &parquetFile[SlowQueryRow]{parquetFileState: parquetFileState{partition: partition, opened: time.Now()}}
+	broken.writer = parquet.NewGenericWriter[SlowQueryRow](failingWriter{}, sink.slowQuerySchema,
+		parquet.MaxRowsPerRowGroup(1), parquet.WriteBufferSize(0))
+	_, err := broken.writer.Write([]SlowQueryRow{{}}) // the next row flushes it
+	require.NoError(t, err)
+	sink.slowFiles[partition] = broken
+	require.Error(t, sink.WriteBatch(context.Background(), logMsg, batch[3:]))
+
+	// The retry starts from the state before the failed attempt
+	delete(sink.slowFiles, partition)
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, batch[3:]))
+	require.NoError(t, sink.Flush(context.Background()))
+
+	require.Len(t, uploader.objects, 1)
+	rows, err := parquet.Read[SlowQueryRow](bytes.NewReader(uploader.objects[0].body), int64(len(uploader.objects[0].body)))
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "SELECT 1;", rows[0].SQLStatement)
+	assert.Equal(t, "SELECT 2;", rows[1].SQLStatement)
+}
+
+func TestParquetArchiveSinkRollsOnBufferedRows(t *testing.T) {
+	uploader := &fakeUploader{}
+	sink, err := newParquetArchiveSink(ParquetArchiveSinkConfig{
+		S3:           S3ArchiveSinkConfig{Bucket: "archive", Compression: "snappy", MaxObjectSize: 4096},
+		RowGroupRows: 100000,
+	}, uploader, uploader)
+	require.NoError(t, err)
+
+	logMsg := LogMessage{ClusterID: "c1", InstanceID: "db-1", LogType: "error"}
+	entry := ParsedLogEntry{"_timestamp": time.Now().UnixMilli(), "message": strings.Repeat("m", 512)}
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, []ParsedLogEntry{entry}))
+	require.NoError(t, sink.Roll(context.Background()))
+	assert.Empty(t, uploader.objects)
+
+	// No row group is written yet, but the rows held for it exceed the limit
+	batch := make([]ParsedLogEntry, 10)
+	for i := range batch {
+		batch[i] = entry
+	}
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, batch))
+	require.NoError(t, sink.Roll(context.Background()))
+	assert.Len(t, uploader.objects, 1)
+}
+
+func TestParquetArchiveSinkSpoolsFilesOnClose(t *testing.T) {
+	config := ParquetArchiveSinkConfig{
+		S3: S3ArchiveSinkConfig{
+			Bucket:      "archive",
+			Prefix:      "aurora-parquet",
+			Compression: "snappy",
+			SpoolDir:    t.TempDir(),
+		},
+		RowGroupRows: 2,
+	}
+	uploader := &fakeUploader{fail: true}
+	sink, err := newParquetArchiveSink(config, uploader, uploader)
+	require.NoError(t, err)
+
+	logMsg := LogMessage{ClusterID: "c1", InstanceID: "db-1", LogType: "slowquery", LogFileName: "slowquery/mysql-slowquery.log"}
+	var batch []ParsedLogEntry
+	for _, line := range []string{
+		"# Time: 2025-08-02T15:04:05.000000Z",
+		"# Query_time: 2.500000  Lock_time: 0.000100 Rows_sent: 10  Rows_examined: 50000",
+		"SELECT 1;",
+	} {
+		entry := parseSlowQueryLog(line)
+		require.NotNil(t, entry, line)
+		entry["_timestamp"] = time.Date(2025, 8, 2, 15, 4, 5, 0, time.UTC).UnixMilli()
+		batch = append(batch, entry)
+	}
+
+	// The pending query is written out and spooled with its file
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, batch))
+	require.NoError(t, sink.Close())
+
+	uploader = &fakeUploader{}
+	restarted, err := newParquetArchiveSink(config, uploader, uploader)
+	require.NoError(t, err)
+	require.NoError(t, restarted.Roll(context.Background()))
+
+	require.Len(t, uploader.objects, 1)
+	obj := uploader.objects[0]
+	assert.Contains(t, obj.key, "aurora-parquet/slowquery/cluster_id=c1/date=2025-08-02/")
+	rows, err := parquet.Read[SlowQueryRow](bytes.NewReader(obj.body), int64(len(obj.body)))
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	assert.Equal(t, "SELECT 1;", rows[0].SQLStatement)
+}
+
+func TestParquetArchiveSinkCloseFailsWithoutSpool(t *testing.T) {
+	uploader := &fakeUploader{fail: true}
+	sink := newTestParquetSink(t, uploader, false)
+
+	logMsg := LogMessage{ClusterID: "c1", InstanceID: "db-1", LogType: "error"}
+	entry := ParsedLogEntry{"_timestamp": time.Now().UnixMilli(), "message": "m"}
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, []ParsedLogEntry{entry}))
+	assert.Error(t, sink.Flush(context.Background()))
+	assert.Error(t, sink.Close())
+}
+
+func TestParquetArchiveSinkFlushFileKeepsOtherQueriesPending(t *testing.T) {
+	uploader := &fakeUploader{}
+	sink := newTestParquetSink(t, uploader, false)
+
+	fileA := LogMessage{ClusterID: "c1", InstanceID: "db-1", LogType: "slowquery", LogFileName: "slowquery/a.log"}
+	fileB := LogMessage{ClusterID: "c1", InstanceID: "db-2", LogType: "slowquery", LogFileName: "slowquery/b.log"}
+	query := func(logMsg LogMessage, sql string) []ParsedLogEntry {
+		var batch []ParsedLogEntry
+		for _, line := range []string{
+			"# Time: 2025-08-02T15:04:05.000000Z",
+			"# Query_time: 2.500000  Lock_time: 0.000100 Rows_sent: 10  Rows_examined: 50000",
+			sql,
+		} {
+			entry := parseSlowQueryLog(line)
+			require.NotNil(t, entry, line)
+			entry["_timestamp"] = time.Date(2025, 8, 2, 15, 4, 5, 0, time.UTC).UnixMilli()
+			entry["log_file_name"] = logMsg.LogFileName
+			batch = append(batch, entry)
+		}
+		return batch
+	}
+	require.NoError(t, sink.WriteBatch(context.Background(), fileA, query(fileA, "SELECT 1;")))
+	require.NoError(t, sink.WriteBatch(context.Background(), fileB, query(fileB, "SELECT 2;")))
+
+	// A checkpoint leaves the query open: the file's next lines may continue it
+	require.NoError(t, sink.FlushFile(context.Background(), fileA, false))
+	assert.Empty(t, uploader.objects)
+
+	require.NoError(t, sink.WriteBatch(context.Background(), fileA, []ParsedLogEntry{parseSlowQueryLog("FROM dual;")}))
+	require.NoError(t, sink.FlushFile(context.Background(), fileA, true))
+	require.Len(t, uploader.objects, 1)
+	obj := uploader.objects[0]
+	rows, err := parquet.Read[SlowQueryRow](bytes.NewReader(obj.body), int64(len(obj.body)))
+	require.NoError(t, err)
+	require.Len(t, rows, 1, "only the flushed file's query is emitted")
+	assert.Equal(t, "SELECT 1;\nFROM dual;", rows[0].SQLStatement)
+
+	require.NoError(t, sink.FlushFile(context.Background(), fileB, true))
+	require.Len(t, uploader.objects, 2)
+	obj = uploader.objects[1]
+	rows, err = parquet.Read[SlowQueryRow](bytes.NewReader(obj.body), int64(len(obj.body)))
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	assert.Equal(t, "SELECT 2;", rows[0].SQLStatement)
+}
+
+func TestParquetArchiveSinkRecoversJournaledRows(t *testing.T) {
+	config := ParquetArchiveSinkConfig{
+		S3: S3ArchiveSinkConfig{
+			Bucket:      "archive",
+			Prefix:      "aurora-parquet",
+			Compression: "snappy",
+			SpoolDir:    t.TempDir(),
+		},
+		RowGroupRows: 2,
+	}
+	uploader := &fakeUploader{}
+	sink, err := newParquetArchiveSink(config, uploader, uploader)
+	require.NoError(t, err)
+
+	fileA := LogMessage{ClusterID: "c1", InstanceID: "db-1", LogType: "error", LogFileName: "error/a.log"}
+	fileB := LogMessage{ClusterID: "c2", InstanceID: "db-2", LogType: "error", LogFileName: "error/b.log"}
+	ts := time.Date(2025, 8, 2, 12, 0, 0, 0, time.UTC).UnixMilli()
+	require.NoError(t, sink.WriteBatch(context.Background(), fileA, []ParsedLogEntry{{"_timestamp": ts, "message": "a", "level": "ERROR"}}))
+	require.NoError(t, sink.WriteBatch(context.Background(), fileB, []ParsedLogEntry{{"_timestamp": ts, "message": "b"}}))
+	require.NoError(t, sink.FlushFile(context.Background(), fileA, true))
+	assert.Empty(t, uploader.objects, "flushed rows are journaled, not uploaded")
+
+	// A crash leaves the journal of fileA's rows only
+	restarted, err := newParquetArchiveSink(config, uploader, uploader)
+	require.NoError(t, err)
+	require.NoError(t, restarted.Roll(context.Background()))
+
+	require.Len(t, uploader.objects, 1)
+	obj := uploader.objects[0]
+	assert.Contains(t, obj.key, "aurora-parquet/error/cluster_id=c1/date=2025-08-02/")
+	rows, err := parquet.Read[ErrorEventRow](bytes.NewReader(obj.body), int64(len(obj.body)))
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	assert.Equal(t, "a", rows[0].Message)
+	assert.Equal(t, "ERROR", rows[0].Level)
+	assert.Equal(t, ts, rows[0].Timestamp)
+}
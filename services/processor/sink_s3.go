@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/klauspost/compress/zstd"
+	"github.com/yourorg/aurora-log-system/common/env"
+)
+
+// S3ArchiveSinkConfig configures the S3 archive sink
+type S3ArchiveSinkConfig struct {
+	Bucket         string
+	Prefix         string
+	Compression    string // gzip or zstd
+	MaxObjectSize  int64
+	MaxObjectAge   time.Duration
+	PartSize       int64
+	Endpoint       string // e.g. http://minio:9000 for local testing
+	ForcePathStyle bool
+	// Objects not uploaded by shutdown are kept here and uploaded on the
+	// next start; without it they are lost
+	SpoolDir string
+}
+
+func loadS3ArchiveSinkConfig() S3ArchiveSinkConfig {
+	return S3ArchiveSinkConfig{
+		Bucket:         env.Get("S3_ARCHIVE_BUCKET", ""),
+		Prefix:         env.Get("S3_ARCHIVE_PREFIX", "aurora-logs"),
+		Compression:    env.Get("S3_ARCHIVE_COMPRESSION", "gzip"),
+		MaxObjectSize:  int64(env.Int("S3_ARCHIVE_MAX_OBJECT_MB", 64)) * 1024 * 1024,
+		MaxObjectAge:   time.Duration(env.Int("S3_ARCHIVE_MAX_OBJECT_AGE_SEC", 300)) * time.Second,
+		PartSize:       int64(env.Int("S3_ARCHIVE_PART_SIZE_MB", 16)) * 1024 * 1024,
+		Endpoint:       env.Get("S3_ARCHIVE_ENDPOINT", ""),
+		ForcePathStyle: env.Get("S3_ARCHIVE_FORCE_PATH_STYLE", "false") == "true",
+	}
+}
+
+// objectUploader is the subset of manager.Uploader the sink needs
+type objectUploader interface {
+	Upload(ctx context.Context, input *s3.PutObjectInput, opts ...func(*manager.Uploader)) (*manager.UploadOutput, error)
+}
+
+type headBucketAPI interface {
+	HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
+}
+
+// archiveObject is one compressed NDJSON object being built in memory
+type archiveObject struct {
+	partition string
+	buf       bytes.Buffer
+	writer    io.WriteCloser
+	opened    time.Time
+	entries   int
+	sealed    bool   // compressor closed; only an upload may follow
+	key       string // named when sealed, so retries never write a second copy
+
+	files       map[string]bool // source files whose entries are not durable yet
+	journal     *archiveJournal // set when the sink has a spool
+	unjournaled [][]byte        // lines not in the journal yet
+}
+
+// S3ArchiveSink writes compressed NDJSON objects partitioned as
+// cluster/log_type/yyyy/mm/dd/hh/. Objects are kept open per partition and
+// rolled once they reach MaxObjectSize or MaxObjectAge; the uploader
+// switches to multipart for objects larger than PartSize.
+type S3ArchiveSink struct {
+	config   S3ArchiveSinkConfig
+	uploader objectUploader
+	client   headBucketAPI
+	hostname string
+	now      func() time.Time
+	seq      atomic.Int64
+
+	mu      sync.Mutex
+	objects map[string]*archiveObject
+	spool   *archiveSpool
+}
+
+// newS3Uploader creates the S3 client and multipart uploader shared by the
+// archive sinks
+func newS3Uploader(config S3ArchiveSinkConfig, awsCfg aws.Config) (*manager.Uploader, *s3.Client) {
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if config.Endpoint != "" {
+			o.BaseEndpoint = aws.String(config.Endpoint)
+		}
+		o.UsePathStyle = config.ForcePathStyle
+	})
+	uploader := manager.NewUploader(client, func(u *manager.Uploader) {
+		if config.PartSize >= manager.MinUploadPartSize {
+			u.PartSize = config.PartSize
+		}
+	})
+	return uploader, client
+}
+
+func NewS3ArchiveSink(config S3ArchiveSinkConfig, awsCfg aws.Config) (*S3ArchiveSink, error) {
+	uploader, client := newS3Uploader(config, awsCfg)
+	return newS3ArchiveSink(config, uploader, client)
+}
+
+func newS3ArchiveSink(config S3ArchiveSinkConfig, uploader objectUploader, client headBucketAPI) (*S3ArchiveSink, error) {
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("S3_ARCHIVE_BUCKET is required for the s3 sink")
+	}
+	switch config.Compression {
+	case "gzip", "zstd":
+	default:
+		return nil, fmt.Errorf("unsupported S3 archive compression %q", config.Compression)
+	}
+	if config.MaxObjectSize <= 0 {
+		config.MaxObjectSize = 64 * 1024 * 1024
+	}
+	if config.MaxObjectAge <= 0 {
+		config.MaxObjectAge = 5 * time.Minute
+	}
+
+	sink := &S3ArchiveSink{
+		config:   config,
+		uploader: uploader,
+		client:   client,
+		hostname: archiveHostname(),
+		now:      time.Now,
+		objects:  make(map[string]*archiveObject),
+	}
+	if config.SpoolDir != "" {
+		spool, err := openArchiveSpool(config.SpoolDir)
+		if err != nil {
+			return nil, err
+		}
+		sink.spool = spool
+		if err := sink.recoverJournals(); err != nil {
+			return nil, err
+		}
+	}
+	return sink, nil
+}
+
+// recoverJournals rebuilds the objects that were still open when the
+// previous run stopped and spools them for upload on the first roll
+func (s *S3ArchiveSink) recoverJournals() error {
+	for _, journal := range s.spool.recovered {
+		obj, err := s.newObject(journal.header.Partition)
+		if err != nil {
+			return err
+		}
+		obj.opened = journal.header.Opened
+		for _, line := range journal.lines {
+			if _, err := obj.writer.Write(append(line, '\n')); err != nil {
+				return fmt.Errorf("failed to compress journaled entry: %w", err)
+			}
+		}
+		obj.entries = len(journal.lines)
+		if err := s.seal(obj); err != nil {
+			return err
+		}
+		if err := s.spool.save(obj.key, obj.buf.Bytes()); err != nil {
+			return err
+		}
+		journal.remove()
+	}
+	s.spool.recovered = nil
+	return nil
+}
+
+func (s *S3ArchiveSink) Name() string { return "s3" }
+
+// archiveHostname names the objects written by this replica so that
+// several processors never overwrite each other's keys
+func archiveHostname() string {
+	if hostname, _ := os.Hostname(); hostname != "" {
+		return hostname
+	}
+	return "processor"
+}
+
+// archivePartition returns cluster/log_type/yyyy/mm/dd/hh for an entry
+func archivePartition(logMsg LogMessage, ts time.Time) string {
+	ts = ts.UTC()
+	return fmt.Sprintf("%s/%s/%04d/%02d/%02d/%02d",
+		logMsg.ClusterID, logMsg.LogType, ts.Year(), ts.Month(), ts.Day(), ts.Hour())
+}
+
+// WriteBatch appends entries to the open object of their hour partition.
+// Full objects are uploaded before anything is appended, so a failed call
+// leaves no partial batch behind and can be retried safely.
+func (s *S3ArchiveSink) WriteBatch(ctx context.Context, logMsg LogMessage, batch []ParsedLogEntry) error {
+	lines := make(map[string][][]byte)
+	for _, entry := range batch {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to encode entry: %w", err)
+		}
+		partition := archivePartition(logMsg, entryTimestamp(entry))
+		lines[partition] = append(lines[partition], data)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for partition := range lines {
+		if obj, ok := s.objects[partition]; ok && s.due(obj, false) {
+			if err := s.upload(ctx, obj); err != nil {
+				return err
+			}
+			delete(s.objects, partition)
+		}
+	}
+
+	file := sinkFileKey(logMsg)
+	for partition, partitionLines := range lines {
+		obj, ok := s.objects[partition]
+		if !ok {
+			var err error
+			obj, err = s.newObject(partition)
+			if err != nil {
+				return err
+			}
+			if s.spool != nil {
+				obj.journal = s.spool.newJournal(archiveJournalHeader{Partition: partition, Opened: obj.opened})
+			}
+			s.objects[partition] = obj
+		}
+		for _, line := range partitionLines {
+			if _, err := obj.writer.Write(append(line, '\n')); err != nil {
+				return fmt.Errorf("failed to compress entry: %w", err)
+			}
+		}
+		obj.entries += len(partitionLines)
+		obj.files[file] = true
+		if obj.journal != nil {
+			obj.unjournaled = append(obj.unjournaled, partitionLines...)
+		}
+	}
+
+	return nil
+}
+
+// Roll uploads objects spooled by an earlier shutdown and objects that
+// reached their size or age limit. The sink runner calls it on every flush
+// tick.
+func (s *S3ArchiveSink) Roll(ctx context.Context) error {
+	var spoolErr error
+	if s.spool != nil {
+		spoolErr = s.spool.upload(ctx, s.put)
+	}
+	return errors.Join(spoolErr, s.uploadWhere(ctx, func(obj *archiveObject) bool { return s.due(obj, true) }))
+}
+
+// FlushFile makes the entries of logMsg's file written so far durable
+// without closing objects early: with a spool, the objects holding them are
+// journaled to disk; without one, those objects are uploaded. Objects of
+// other files keep filling up to their size or age limit.
+func (s *S3ArchiveSink) FlushFile(ctx context.Context, logMsg LogMessage, final bool) error {
+	file := sinkFileKey(logMsg)
+	if s.spool == nil {
+		return s.uploadWhere(ctx, func(obj *archiveObject) bool { return obj.files[file] })
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for _, obj := range s.objects {
+		if !obj.files[file] {
+			continue
+		}
+		if err := obj.journal.append(obj.unjournaled); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		obj.unjournaled = nil
+		clear(obj.files)
+	}
+	return errors.Join(errs...)
+}
+
+// Flush uploads every open object. It is only called at shutdown.
+func (s *S3ArchiveSink) Flush(ctx context.Context) error {
+	return s.uploadWhere(ctx, func(*archiveObject) bool { return true })
+}
+
+// Close spools the objects the final flush could not upload, so they are
+// uploaded on the next start. It fails if any of them is lost.
+func (s *S3ArchiveSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.objects) == 0 {
+		return nil
+	}
+	if s.spool == nil {
+		return fmt.Errorf("%d archive objects were not uploaded and are lost without a spool directory", len(s.objects))
+	}
+
+	var errs []error
+	for partition, obj := range s.objects {
+		if err := s.seal(obj); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := s.spool.save(obj.key, obj.buf.Bytes()); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		obj.journal.remove()
+		delete(s.objects, partition)
+		slog.Warn("Spooled archive object for upload on next start", "key", obj.key, "entries", obj.entries)
+	}
+	return errors.Join(errs...)
+}
+
+// Health checks that the bucket is reachable
+func (s *S3ArchiveSink) Health(ctx context.Context) error {
+	_, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(s.config.Bucket)})
+	return err
+}
+
+func (s *S3ArchiveSink) uploadWhere(ctx context.Context, match func(*archiveObject) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for partition, obj := range s.objects {
+		if !match(obj) {
+			continue
+		}
+		if err := s.upload(ctx, obj); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delete(s.objects, partition)
+	}
+	return errors.Join(errs...)
+}
+
+// due reports whether obj should be closed; size is measured on the
+// compressed output
+func (s *S3ArchiveSink) due(obj *archiveObject, checkAge bool) bool {
+	if obj.sealed || int64(obj.buf.Len()) >= s.config.MaxObjectSize {
+		return true
+	}
+	return checkAge && s.now().Sub(obj.opened) >= s.config.MaxObjectAge
+}
+
+func (s *S3ArchiveSink) newObject(partition string) (*archiveObject, error) {
+	obj := &archiveObject{partition: partition, opened: s.now(), files: make(map[string]bool)}
+	switch s.config.Compression {
+	case "zstd":
+		encoder, err := zstd.NewWriter(&obj.buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+		}
+		obj.writer = encoder
+	default:
+		obj.writer = gzip.NewWriter(&obj.buf)
+	}
+	return obj, nil
+}
+
+func (s *S3ArchiveSink) objectKey(obj *archiveObject) string {
+	extension := ".ndjson.gz"
+	if s.config.Compression == "zstd" {
+		extension = ".ndjson.zst"
+	}
+	name := fmt.Sprintf("%s-%d-%d%s", s.hostname, obj.opened.UnixNano(), s.seq.Add(1), extension)
+	return path.Join(strings.Trim(s.config.Prefix, "/"), obj.partition, name)
+}
+
+// seal closes obj's compressor and names the object; only an upload may
+// follow
+func (s *S3ArchiveSink) seal(obj *archiveObject) error {
+	if obj.sealed {
+		return nil
+	}
+	if err := obj.writer.Close(); err != nil {
+		return fmt.Errorf("failed to finish compressed object: %w", err)
+	}
+	obj.sealed = true
+	obj.key = s.objectKey(obj)
+	return nil
+}
+
+// upload seals obj and writes it to S3. A sealed object that failed to
+// upload is retried as-is under the same key on the next roll, so an
+// upload that timed out after S3 stored it is overwritten, not duplicated.
+func (s *S3ArchiveSink) upload(ctx context.Context, obj *archiveObject) error {
+	if err := s.seal(obj); err != nil {
+		return err
+	}
+
+	if err := s.put(ctx, obj.key, obj.buf.Bytes()); err != nil {
+		return err
+	}
+	if obj.journal != nil {
+		obj.journal.remove()
+	}
+
+	slog.Info("Archived log object",
+		"bucket", s.config.Bucket,
+		"key", obj.key,
+		"entries", obj.entries,
+		"bytes", obj.buf.Len())
+	return nil
+}
+
+// put writes one finished object. The encoding follows from the key, as
+// spooled objects may predate a change of compression.
+func (s *S3ArchiveSink) put(ctx context.Context, key string, body []byte) error {
+	encoding := "gzip"
+	if strings.HasSuffix(key, ".zst") {
+		encoding = "zstd"
+	}
+	_, err := s.uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:          aws.String(s.config.Bucket),
+		Key:             aws.String(key),
+		Body:            bytes.NewReader(body),
+		ContentType:     aws.String("application/x-ndjson"),
+		ContentEncoding: aws.String(encoding),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload s3://%s/%s: %w", s.config.Bucket, key, err)
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type uploadedObject struct {
+	key      string
+	encoding string
+	body     []byte
+}
+
+// fakeUploader keeps uploaded objects in memory
+type fakeUploader struct {
+	mu       sync.Mutex
+	objects  []uploadedObject
+	attempts []string // keys of every upload, failed or not
+	fail     bool
+}
+
+func (u *fakeUploader) Upload(ctx context.Context, input *s3.PutObjectInput, opts ...func(*manager.Uploader)) (*manager.UploadOutput, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.attempts = append(u.attempts, aws.ToString(input.Key))
+	if u.fail {
+		return nil, errors.New("bucket unavailable")
+	}
+	body, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	u.objects = append(u.objects, uploadedObject{
+		key:      aws.ToString(input.Key),
+		encoding: aws.ToString(input.ContentEncoding),
+		body:     body,
+	})
+	return &manager.UploadOutput{Key: input.Key}, nil
+}
+
+func (u *fakeUploader) HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	return &s3.HeadBucketOutput{}, nil
+}
+
+func decodeArchive(t *testing.T, obj uploadedObject) []map[string]interface{} {
+	var reader io.Reader
+	switch obj.encoding {
+	case "gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(obj.body))
+		require.NoError(t, err)
+		reader = gz
+	case "zstd":
+		dec, err := zstd.NewReader(bytes.NewReader(obj.body))
+		require.NoError(t, err)
+		defer dec.Close()
+		reader = dec
+	}
+
+	var records []map[string]interface{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.NoError(t, scanner.Err())
+	return records
+}
+
+func entryAt(ts time.Time, message string) ParsedLogEntry {
+	return ParsedLogEntry{"_timestamp": ts.UnixMilli(), "message": message}
+}
+
+func TestS3ArchiveSinkPartitions(t *testing.T) {
+	for _, compression := range []string{"gzip", "zstd"} {
+		t.Run(compression, func(t *testing.T) {
+			uploader := &fakeUploader{}
+			sink, err := newS3ArchiveSink(S3ArchiveSinkConfig{
+				Bucket:      "archive",
+				Prefix:      "aurora-logs",
+				Compression: compression,
+			}, uploader, uploader)
+			require.NoError(t, err)
+
+			logMsg := LogMessage{ClusterID: "prod-orders", LogType: "error", InstanceID: "db-1"}
+			first := time.Date(2025, 8, 2, 12, 59, 0, 0, time.UTC)
+			second := first.Add(2 * time.Minute)
+
+			require.NoError(t, sink.WriteBatch(context.Background(), logMsg, []ParsedLogEntry{
+				entryAt(first, "a"), entryAt(second, "b"), entryAt(first, "c"),
+			}))
+			assert.Empty(t, uploader.objects, "objects stay open until rolled")
+
+			require.NoError(t, sink.Flush(context.Background()))
+			require.Len(t, uploader.objects, 2)
+
+			byPartition := map[string][]map[string]interface{}{}
+			for _, obj := range uploader.objects {
+				assert.Equal(t, compression, obj.encoding)
+				partition := obj.key[:strings.LastIndex(obj.key, "/")]
+				byPartition[partition] = decodeArchive(t, obj)
+			}
+
+			require.Len(t, byPartition["aurora-logs/prod-orders/error/2025/08/02/12"], 2)
+			require.Len(t, byPartition["aurora-logs/prod-orders/error/2025/08/02/13"], 1)
+			assert.Equal(t, "c", byPartition["aurora-logs/prod-orders/error/2025/08/02/12"][1]["message"])
+		})
+	}
+}
+
+func TestS3ArchiveSinkRolling(t *testing.T) {
+	uploader := &fakeUploader{}
+	sink, err := newS3ArchiveSink(S3ArchiveSinkConfig{
+		Bucket:        "archive",
+		Compression:   "gzip",
+		MaxObjectSize: 1,
+		MaxObjectAge:  time.Minute,
+	}, uploader, uploader)
+	require.NoError(t, err)
+
+	now := time.Date(2025, 8, 2, 12, 0, 0, 0, time.UTC)
+	sink.now = func() time.Time { return now }
+	logMsg := LogMessage{ClusterID: "c1", LogType: "slowquery"}
+
+	// The gzip header alone exceeds a 1 byte limit, so the next write rolls
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, []ParsedLogEntry{entryAt(now, "a")}))
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, []ParsedLogEntry{entryAt(now, "b")}))
+	require.Len(t, uploader.objects, 1)
+	assert.Len(t, decodeArchive(t, uploader.objects[0]), 1)
+
+	// Age based roll
+	sink.config.MaxObjectSize = 1 << 30
+	require.NoError(t, sink.Roll(context.Background()))
+	assert.Len(t, uploader.objects, 1, "object is not old enough yet")
+
+	now = now.Add(2 * time.Minute)
+	require.NoError(t, sink.Roll(context.Background()))
+	assert.Len(t, uploader.objects, 2)
+}
+
+func TestS3ArchiveSinkUploadFailure(t *testing.T) {
+	uploader := &fakeUploader{fail: true}
+	sink, err := newS3ArchiveSink(S3ArchiveSinkConfig{
+		Bucket:        "archive",
+		Compression:   "gzip",
+		MaxObjectSize: 1,
+	}, uploader, uploader)
+	require.NoError(t, err)
+
+	logMsg := LogMessage{ClusterID: "c1", LogType: "error"}
+	now := time.Now()
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, []ParsedLogEntry{entryAt(now, "a")}))
+
+	// The full object cannot be uploaded, so the batch is rejected untouched
+	assert.Error(t, sink.WriteBatch(context.Background(), logMsg, []ParsedLogEntry{entryAt(now, "b")}))
+
+	uploader.fail = false
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, []ParsedLogEntry{entryAt(now, "b")}))
+	require.NoError(t, sink.Flush(context.Background()))
+
+	require.Len(t, uploader.objects, 2)
+	assert.Len(t, decodeArchive(t, uploader.objects[0]), 1)
+	assert.Len(t, decodeArchive(t, uploader.objects[1]), 1)
+}
+
+func TestS3ArchiveSinkRetriesUnderSameKey(t *testing.T) {
+	uploader := &fakeUploader{fail: true}
+	sink, err := newS3ArchiveSink(S3ArchiveSinkConfig{Bucket: "archive", Compression: "gzip"}, uploader, uploader)
+	require.NoError(t, err)
+
+	logMsg := LogMessage{ClusterID: "c1", LogType: "error"}
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, []ParsedLogEntry{entryAt(time.Now(), "a")}))
+	assert.Error(t, sink.Flush(context.Background()))
+
+	uploader.fail = false
+	require.NoError(t, sink.Flush(context.Background()))
+
+	require.Len(t, uploader.attempts, 2)
+	assert.Equal(t, uploader.attempts[0], uploader.attempts[1], "a retry must not store the object a second time")
+}
+
+func TestS3ArchiveSinkSpoolsObjectsOnClose(t *testing.T) {
+	config := S3ArchiveSinkConfig{
+		Bucket:      "archive",
+		Prefix:      "logs",
+		Compression: "zstd",
+		SpoolDir:    t.TempDir(),
+	}
+	uploader := &fakeUploader{fail: true}
+	sink, err := newS3ArchiveSink(config, uploader, uploader)
+	require.NoError(t, err)
+
+	logMsg := LogMessage{ClusterID: "c1", LogType: "error"}
+	now := time.Now()
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, []ParsedLogEntry{entryAt(now, "a"), entryAt(now, "b")}))
+	assert.Error(t, sink.Flush(context.Background()))
+	require.NoError(t, sink.Close())
+
+	// The next start uploads the spooled object on its first roll
+	config.Compression = "gzip"
+	uploader = &fakeUploader{}
+	restarted, err := newS3ArchiveSink(config, uploader, uploader)
+	require.NoError(t, err)
+	require.NoError(t, restarted.Roll(context.Background()))
+
+	require.Len(t, uploader.objects, 1)
+	assert.True(t, strings.HasPrefix(uploader.objects[0].key, "logs/c1/error/"))
+	assert.Equal(t, "zstd", uploader.objects[0].encoding)
+	assert.Len(t, decodeArchive(t, uploader.objects[0]), 2)
+
+	require.NoError(t, restarted.Roll(context.Background()))
+	assert.Len(t, uploader.objects, 1)
+}
+
+func TestS3ArchiveSinkFlushFileUploadsOnlyItsObjects(t *testing.T) {
+	uploader := &fakeUploader{}
+	sink, err := newS3ArchiveSink(S3ArchiveSinkConfig{Bucket: "archive", Compression: "gzip"}, uploader, uploader)
+	require.NoError(t, err)
+
+	fileA := LogMessage{ClusterID: "c1", InstanceID: "db-1", LogType: "error", LogFileName: "error/mysql-error.log"}
+	fileB := LogMessage{ClusterID: "c2", InstanceID: "db-2", LogType: "error", LogFileName: "error/mysql-error.log"}
+	now := time.Now()
+	require.NoError(t, sink.WriteBatch(context.Background(), fileA, []ParsedLogEntry{entryAt(now, "a")}))
+	require.NoError(t, sink.WriteBatch(context.Background(), fileB, []ParsedLogEntry{entryAt(now, "b")}))
+
+	require.NoError(t, sink.FlushFile(context.Background(), fileA, false))
+	require.Len(t, uploader.objects, 1, "the other cluster's object keeps filling")
+	assert.True(t, strings.HasPrefix(uploader.objects[0].key, "c1/error/"))
+}
+
+func TestS3ArchiveSinkFlushFileJournalsWithSpool(t *testing.T) {
+	config := S3ArchiveSinkConfig{
+		Bucket:      "archive",
+		Compression: "gzip",
+		SpoolDir:    t.TempDir(),
+	}
+	uploader := &fakeUploader{}
+	sink, err := newS3ArchiveSink(config, uploader, uploader)
+	require.NoError(t, err)
+
+	logMsg := LogMessage{ClusterID: "c1", InstanceID: "db-1", LogType: "error", LogFileName: "error/mysql-error.log"}
+	now := time.Now()
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, []ParsedLogEntry{entryAt(now, "a"), entryAt(now, "b")}))
+	require.NoError(t, sink.FlushFile(context.Background(), logMsg, true))
+	assert.Empty(t, uploader.objects, "flushed entries are journaled, not uploaded")
+
+	// Not flushed, so lost with the process like any buffered batch
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, []ParsedLogEntry{entryAt(now, "c")}))
+
+	// A crash leaves the journal; the next start rebuilds its object
+	restarted, err := newS3ArchiveSink(config, uploader, uploader)
+	require.NoError(t, err)
+	require.NoError(t, restarted.Roll(context.Background()))
+
+	require.Len(t, uploader.objects, 1)
+	entries := decodeArchive(t, uploader.objects[0])
+	require.Len(t, entries, 2)
+	assert.Equal(t, "a", entries[0]["message"])
+	assert.Equal(t, "b", entries[1]["message"])
+
+	journals, err := os.ReadDir(filepath.Join(config.SpoolDir, "open"))
+	require.NoError(t, err)
+	assert.Empty(t, journals)
+}
+
+func TestS3ArchiveSinkCloseFailsWithoutSpool(t *testing.T) {
+	uploader := &fakeUploader{fail: true}
+	sink, err := newS3ArchiveSink(S3ArchiveSinkConfig{Bucket: "archive", Compression: "gzip"}, uploader, uploader)
+	require.NoError(t, err)
+
+	logMsg := LogMessage{ClusterID: "c1", LogType: "error"}
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, []ParsedLogEntry{entryAt(time.Now(), "a")}))
+	assert.Error(t, sink.Flush(context.Background()))
+	assert.Error(t, sink.Close())
+}
+
+func TestS3ArchiveSinkConfigValidation(t *testing.T) {
+	uploader := &fakeUploader{}
+
+	_, err := newS3ArchiveSink(S3ArchiveSinkConfig{Compression: "gzip"}, uploader, uploader)
+	assert.Error(t, err)
+
+	_, err = newS3ArchiveSink(S3ArchiveSinkConfig{Bucket: "archive", Compression: "lz4"}, uploader, uploader)
+	assert.Error(t, err)
+}
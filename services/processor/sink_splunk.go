@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yourorg/aurora-log-system/common/env"
+	commontls "github.com/yourorg/aurora-log-system/common/tls"
+)
+
+// defaultSplunkSourcetypes maps Aurora log types to Splunk sourcetypes
+var defaultSplunkSourcetypes = map[string]string{
+	"error":     "aurora:mysql:error",
+	"slowquery": "aurora:mysql:slowquery",
+	"general":   "aurora:mysql:general",
+	"audit":     "aurora:mysql:audit",
+}
+
+// SplunkHECSinkConfig configures the Splunk HTTP Event Collector sink
+type SplunkHECSinkConfig struct {
+	URL             string
+	Token           *commontls.FileSecret
+	Index           string
+	Sourcetypes     map[string]string
+	UseAck          bool
+	AckTimeout      time.Duration
+	AckPollInterval time.Duration
+}
+
+func loadSplunkHECSinkConfig() SplunkHECSinkConfig {
+	sourcetypes := make(map[string]string, len(defaultSplunkSourcetypes))
+	for logType, sourcetype := range defaultSplunkSourcetypes {
+		sourcetypes[logType] = sourcetype
+	}
+	// SPLUNK_HEC_SOURCETYPES=error=mysql:error,slowquery=mysql:slow
+	for _, mapping := range env.List("SPLUNK_HEC_SOURCETYPES") {
+		if logType, sourcetype, ok := strings.Cut(mapping, "="); ok {
+			sourcetypes[strings.TrimSpace(logType)] = strings.TrimSpace(sourcetype)
+		}
+	}
+
+	return SplunkHECSinkConfig{
+		URL:             strings.TrimRight(env.Get("SPLUNK_HEC_URL", "https://localhost:8088"), "/"),
+		Token:           commontls.NewFileSecret(env.Get("SPLUNK_HEC_TOKEN_FILE", "")),
+		Index:           env.Get("SPLUNK_HEC_INDEX", ""),
+		Sourcetypes:     sourcetypes,
+		UseAck:          env.Get("SPLUNK_HEC_ACK_ENABLED", "false") == "true",
+		AckTimeout:      time.Duration(env.Int("SPLUNK_HEC_ACK_TIMEOUT_SEC", 60)) * time.Second,
+		AckPollInterval: time.Duration(env.Int("SPLUNK_HEC_ACK_POLL_MS", 1000)) * time.Millisecond,
+	}
+}
+
+// SplunkHECSink sends batched events to /services/collector/event. With
+// indexer acknowledgement enabled a batch only counts as delivered once
+// Splunk reports its ack ID as indexed.
+type SplunkHECSink struct {
+	config   SplunkHECSinkConfig
+	httpPool *HTTPConnectionPool
+	channel  string
+}
+
+func NewSplunkHECSink(config SplunkHECSinkConfig, httpPool *HTTPConnectionPool) (*SplunkHECSink, error) {
+	if config.Token == nil {
+		return nil, fmt.Errorf("SPLUNK_HEC_TOKEN_FILE is required for the splunk sink")
+	}
+	if config.AckTimeout <= 0 {
+		config.AckTimeout = time.Minute
+	}
+	if config.AckPollInterval <= 0 {
+		config.AckPollInterval = time.Second
+	}
+
+	channel, err := newSplunkChannel()
+	if err != nil {
+		return nil, err
+	}
+
+	return &SplunkHECSink{
+		config:   config,
+		httpPool: httpPool,
+		channel:  channel,
+	}, nil
+}
+
+func (s *SplunkHECSink) Name() string { return "splunk" }
+
+// newSplunkChannel returns a random GUID; acks are scoped to a channel
+func newSplunkChannel() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate HEC channel: %w", err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	h := hex.EncodeToString(b)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
+
+type splunkEvent struct {
+	Time       string            `json:"time"`
+	Host       string            `json:"host,omitempty"`
+	Source     string            `json:"source,omitempty"`
+	Sourcetype string            `json:"sourcetype,omitempty"`
+	Index      string            `json:"index,omitempty"`
+	Event      ParsedLogEntry    `json:"event"`
+	Fields     map[string]string `json:"fields,omitempty"`
+}
+
+type splunkResponse struct {
+	Text  string `json:"text"`
+	Code  int    `json:"code"`
+	AckID *int64 `json:"ackId"`
+}
+
+func (s *SplunkHECSink) WriteBatch(ctx context.Context, logMsg LogMessage, batch []ParsedLogEntry) error {
+	sourcetype := s.config.Sourcetypes[logMsg.LogType]
+	if sourcetype == "" {
+		sourcetype = "aurora:mysql:" + logMsg.LogType
+	}
+
+	// HEC accepts several events in one request as concatenated JSON objects
+	var payload bytes.Buffer
+	encoder := json.NewEncoder(&payload)
+	for _, entry := range batch {
+		ts := entryTimestamp(entry)
+		source := logMsg.LogFileName
+		if fileName, ok := entry["log_file_name"].(string); ok && fileName != "" {
+			source = fileName
+		}
+		event := splunkEvent{
+			Time:       strconv.FormatFloat(float64(ts.UnixMilli())/1000, 'f', 3, 64),
+			Host:       logMsg.InstanceID,
+			Source:     source,
+			Sourcetype: sourcetype,
+			Index:      s.config.Index,
+			Event:      entry,
+			Fields: map[string]string{
+				"cluster_id": logMsg.ClusterID,
+				"log_type":   logMsg.LogType,
+			},
+		}
+		if err := encoder.Encode(event); err != nil {
+			return fmt.Errorf("failed to marshal event: %w", err)
+		}
+	}
+
+	body, err := s.do(ctx, "/services/collector/event", payload.Bytes())
+	if err != nil {
+		return err
+	}
+
+	var resp splunkResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return fmt.Errorf("failed to decode HEC response: %w", err)
+	}
+	if resp.Code != 0 {
+		return fmt.Errorf("HEC rejected batch: %s (code %d)", resp.Text, resp.Code)
+	}
+
+	if !s.config.UseAck {
+		return nil
+	}
+	if resp.AckID == nil {
+		return fmt.Errorf("HEC returned no ackId; enable indexer acknowledgement on the token")
+	}
+	return s.waitForAck(ctx, *resp.AckID)
+}
+
+// waitForAck polls /services/collector/ack until Splunk reports the batch
+// as indexed
+func (s *SplunkHECSink) waitForAck(ctx context.Context, ackID int64) error {
+	query, _ := json.Marshal(map[string][]int64{"acks": {ackID}})
+	deadline := time.Now().Add(s.config.AckTimeout)
+
+	for {
+		body, err := s.do(ctx, "/services/collector/ack", query)
+		if err != nil {
+			return err
+		}
+
+		var resp struct {
+			Acks map[string]bool `json:"acks"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return fmt.Errorf("failed to decode HEC ack response: %w", err)
+		}
+		if resp.Acks[strconv.FormatInt(ackID, 10)] {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("HEC ack %d not confirmed within %s", ackID, s.config.AckTimeout)
+		}
+		select {
+		case <-time.After(s.config.AckPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Flush is a no-op; every batch is sent synchronously
+func (s *SplunkHECSink) Flush(ctx context.Context) error { return nil }
+
+func (s *SplunkHECSink) Close() error { return nil }
+
+// Health checks the HEC health endpoint
+func (s *SplunkHECSink) Health(ctx context.Context) error {
+	httpClient := s.httpPool.Get()
+	defer s.httpPool.Put(httpClient)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", s.config.URL+"/services/collector/health", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("HEC health check returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *SplunkHECSink) do(ctx context.Context, path string, payload []byte) ([]byte, error) {
+	token, err := s.config.Token.Value()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HEC token: %w", err)
+	}
+
+	httpClient := s.httpPool.Get()
+	defer s.httpPool.Put(httpClient)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.config.URL+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Splunk "+strings.TrimSpace(token))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Splunk-Request-Channel", s.channel)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		slog.Error("Splunk HEC request failed", "path", path, "status", resp.StatusCode, "body", truncate(string(body), 512))
+		return nil, fmt.Errorf("HEC %s returned %d", path, resp.StatusCode)
+	}
+	return body, nil
+}
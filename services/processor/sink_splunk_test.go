@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	commontls "github.com/yourorg/aurora-log-system/common/tls"
+)
+
+func writeTokenFile(t *testing.T, token string) *commontls.FileSecret {
+	path := filepath.Join(t.TempDir(), "hec-token")
+	require.NoError(t, os.WriteFile(path, []byte(token+"\n"), 0600))
+	return commontls.NewFileSecret(path)
+}
+
+func TestSplunkHECSinkEvents(t *testing.T) {
+	var events []splunkEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/services/collector/event", r.URL.Path)
+		assert.Equal(t, "Splunk secret-token", r.Header.Get("Authorization"))
+		assert.NotEmpty(t, r.Header.Get("X-Splunk-Request-Channel"))
+
+		dec := json.NewDecoder(r.Body)
+		for dec.More() {
+			var event splunkEvent
+			assert.NoError(t, dec.Decode(&event))
+			events = append(events, event)
+		}
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer server.Close()
+
+	sink, err := NewSplunkHECSink(SplunkHECSinkConfig{
+		URL:         server.URL,
+		Token:       writeTokenFile(t, "secret-token"),
+		Index:       "aurora",
+		Sourcetypes: defaultSplunkSourcetypes,
+	}, NewHTTPConnectionPool(1, time.Second))
+	require.NoError(t, err)
+
+	logMsg := LogMessage{LogType: "slowquery", ClusterID: "c1", InstanceID: "db-1", LogFileName: "slowquery/mysql-slowquery.log"}
+	ts := time.Date(2025, 8, 2, 12, 0, 0, 250*int(time.Millisecond), time.UTC)
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, []ParsedLogEntry{
+		{"sql_statement": "SELECT 1;", "_timestamp": ts.UnixMilli()},
+		{"query_time": 2.5, "_timestamp": ts.UnixMilli()},
+	}))
+
+	require.Len(t, events, 2)
+	assert.Equal(t, "1754136000.250", events[0].Time)
+	assert.Equal(t, "aurora:mysql:slowquery", events[0].Sourcetype)
+	assert.Equal(t, "aurora", events[0].Index)
+	assert.Equal(t, "db-1", events[0].Host)
+	assert.Equal(t, "slowquery/mysql-slowquery.log", events[0].Source)
+	assert.Equal(t, "c1", events[0].Fields["cluster_id"])
+	assert.Equal(t, "SELECT 1;", events[0].Event["sql_statement"])
+}
+
+func TestSplunkHECSinkIndexerAck(t *testing.T) {
+	var polls atomic.Int32
+	var confirmAfter atomic.Int32
+	confirmAfter.Store(2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		channel := r.Header.Get("X-Splunk-Request-Channel")
+		assert.NotEmpty(t, channel)
+
+		switch r.URL.Path {
+		case "/services/collector/event":
+			io.Copy(io.Discard, r.Body)
+			w.Write([]byte(`{"text":"Success","code":0,"ackId":7}`))
+		case "/services/collector/ack":
+			var query map[string][]int64
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&query))
+			assert.Equal(t, []int64{7}, query["acks"])
+			indexed := polls.Add(1) >= confirmAfter.Load()
+			json.NewEncoder(w).Encode(map[string]map[string]bool{"acks": {"7": indexed}})
+		}
+	}))
+	defer server.Close()
+
+	sink, err := NewSplunkHECSink(SplunkHECSinkConfig{
+		URL:             server.URL,
+		Token:           writeTokenFile(t, "token"),
+		UseAck:          true,
+		AckTimeout:      time.Second,
+		AckPollInterval: 10 * time.Millisecond,
+	}, NewHTTPConnectionPool(1, time.Second))
+	require.NoError(t, err)
+
+	batch := []ParsedLogEntry{{"message": "m"}}
+	require.NoError(t, sink.WriteBatch(context.Background(), LogMessage{LogType: "error"}, batch))
+	assert.Equal(t, int32(2), polls.Load(), "delivered only after the ack was confirmed")
+
+	// Unconfirmed acks fail the batch so the runner retries it
+	polls.Store(0)
+	confirmAfter.Store(1000)
+	sink.config.AckTimeout = 50 * time.Millisecond
+	err = sink.WriteBatch(context.Background(), LogMessage{LogType: "error"}, batch)
+	assert.ErrorContains(t, err, "not confirmed")
+}
+
+func TestSplunkHECSinkRejections(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"text":"Invalid token","code":4}`))
+	}))
+	defer server.Close()
+
+	sink, err := NewSplunkHECSink(SplunkHECSinkConfig{URL: server.URL, Token: writeTokenFile(t, "bad")}, NewHTTPConnectionPool(1, time.Second))
+	require.NoError(t, err)
+	assert.Error(t, sink.WriteBatch(context.Background(), LogMessage{LogType: "error"}, []ParsedLogEntry{{"message": "m"}}))
+
+	_, err = NewSplunkHECSink(SplunkHECSinkConfig{URL: server.URL}, nil)
+	assert.Error(t, err, "token file is required")
+
+	t.Setenv("SPLUNK_HEC_SOURCETYPES", "error=mysql:error, audit = custom:audit")
+	config := loadSplunkHECSinkConfig()
+	assert.Equal(t, "mysql:error", config.Sourcetypes["error"])
+	assert.Equal(t, "custom:audit", config.Sourcetypes["audit"])
+	assert.Equal(t, "aurora:mysql:slowquery", config.Sourcetypes["slowquery"])
+	assert.True(t, strings.HasPrefix(config.URL, "https://"))
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// errSpoolFull is returned when an append would exceed the spool's size cap
+var errSpoolFull = errors.New("spool is full")
+
+const spoolSegmentSuffix = ".seg"
+
+// spoolRecordHeader is the length and CRC32 of the JSON payload that follows
+const spoolRecordHeader = 8
+
+// spoolRecord is the on-disk form of a sinkBatch
+type spoolRecord struct {
+	LogMsg  LogMessage       `json:"log_msg"`
+	Entries []ParsedLogEntry `json:"entries"`
+	Flush   bool             `json:"flush,omitempty"`
+	Final   bool             `json:"final,omitempty"`
+}
+
+type spoolSegment struct {
+	path    string
+	seq     uint64
+	size    int64
+	records int
+	offset  int64    // replay position; only kept in memory
+	file    *os.File // set while the segment is open for appends
+}
+
+// SinkSpool is a disk-backed write-ahead buffer for one sink. Batches the
+// sink cannot accept are appended to segment files and fsynced; Drain
+// replays them in order once the sink recovers. Each segment is deleted
+// after all of its records are replayed, so a crash mid-segment replays
+// that segment's delivered records again (at-least-once).
+type SinkSpool struct {
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+
+	mu      sync.Mutex
+	sealed  []*spoolSegment
+	current *spoolSegment
+	size    int64
+	records int
+	nextSeq uint64
+}
+
+// OpenSinkSpool opens or creates the spool in dir. Existing segments are
+// validated and queued for replay; a torn record at the end of a segment
+// (from a crash during an append) is truncated away.
+func OpenSinkSpool(dir string, maxBytes, segmentBytes int64) (*SinkSpool, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	if segmentBytes <= 0 {
+		segmentBytes = 16 << 20
+	}
+
+	s := &SinkSpool{dir: dir, maxBytes: maxBytes, segmentBytes: segmentBytes, nextSeq: 1}
+
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list spool directory: %w", err)
+	}
+	for _, name := range names {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name.Name(), spoolSegmentSuffix), 10, 64)
+		if name.IsDir() || !strings.HasSuffix(name.Name(), spoolSegmentSuffix) || err != nil {
+			continue
+		}
+
+		segment := &spoolSegment{path: filepath.Join(dir, name.Name()), seq: seq}
+		if err := recoverSpoolSegment(segment); err != nil {
+			return nil, err
+		}
+		if segment.records == 0 {
+			os.Remove(segment.path)
+			continue
+		}
+
+		s.sealed = append(s.sealed, segment)
+		s.size += segment.size
+		s.records += segment.records
+		if seq >= s.nextSeq {
+			s.nextSeq = seq + 1
+		}
+	}
+	sort.Slice(s.sealed, func(i, j int) bool { return s.sealed[i].seq < s.sealed[j].seq })
+
+	if s.records > 0 {
+		slog.Info("Recovered spooled batches", "dir", dir, "batches", s.records, "bytes", s.size)
+	}
+	return s, nil
+}
+
+// recoverSpoolSegment counts the valid records in a segment and truncates
+// anything after the last one
+func recoverSpoolSegment(segment *spoolSegment) error {
+	f, err := os.OpenFile(segment.path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open spool segment: %w", err)
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for {
+		_, n, err := readSpoolRecord(reader)
+		if err != nil {
+			break
+		}
+		segment.size += n
+		segment.records++
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() != segment.size {
+		slog.Warn("Truncating torn spool segment", "path", segment.path, "valid_bytes", segment.size, "file_bytes", info.Size())
+		if err := f.Truncate(segment.size); err != nil {
+			return fmt.Errorf("failed to truncate spool segment: %w", err)
+		}
+	}
+	return nil
+}
+
+// Append durably stores a batch
+func (s *SinkSpool) Append(batch sinkBatch) error {
+	payload, err := json.Marshal(spoolRecord{LogMsg: batch.logMsg, Entries: batch.entries, Flush: batch.flush, Final: batch.final})
+	if err != nil {
+		return fmt.Errorf("failed to encode spool record: %w", err)
+	}
+	record := make([]byte, spoolRecordHeader+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[spoolRecordHeader:], payload)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxBytes > 0 && s.size+int64(len(record)) > s.maxBytes {
+		return errSpoolFull
+	}
+
+	if s.current == nil {
+		path := filepath.Join(s.dir, fmt.Sprintf("%020d%s", s.nextSeq, spoolSegmentSuffix))
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+		if err != nil {
+			return fmt.Errorf("failed to create spool segment: %w", err)
+		}
+		s.current = &spoolSegment{path: path, seq: s.nextSeq, file: f}
+		s.nextSeq++
+	}
+
+	if _, err := s.current.file.Write(record); err != nil {
+		// Drop the segment's partial tail on the next open
+		s.sealCurrentLocked()
+		return fmt.Errorf("failed to write spool segment: %w", err)
+	}
+	if err := s.current.file.Sync(); err != nil {
+		s.sealCurrentLocked()
+		return fmt.Errorf("failed to sync spool segment: %w", err)
+	}
+
+	s.current.size += int64(len(record))
+	s.current.records++
+	s.size += int64(len(record))
+	s.records++
+
+	if s.current.size >= s.segmentBytes {
+		s.sealCurrentLocked()
+	}
+	return nil
+}
+
+func (s *SinkSpool) sealCurrentLocked() {
+	if s.current == nil {
+		return
+	}
+	s.current.file.Close()
+	s.current.file = nil
+	if s.current.records > 0 {
+		s.sealed = append(s.sealed, s.current)
+	} else {
+		os.Remove(s.current.path)
+	}
+	s.current = nil
+}
+
+// Len returns the number of batches waiting for replay
+func (s *SinkSpool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records
+}
+
+// Size returns the bytes held on disk
+func (s *SinkSpool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Drain replays spooled batches oldest first until the spool is empty or
+// write fails. It returns the number of entries replayed.
+func (s *SinkSpool) Drain(ctx context.Context, write func(sinkBatch) error) (int, error) {
+	replayed := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return replayed, err
+		}
+
+		s.mu.Lock()
+		if len(s.sealed) == 0 {
+			s.sealCurrentLocked()
+		}
+		if len(s.sealed) == 0 {
+			s.mu.Unlock()
+			return replayed, nil
+		}
+		segment := s.sealed[0]
+		s.mu.Unlock()
+
+		n, err := s.drainSegment(segment, write)
+		replayed += n
+		if err != nil {
+			return replayed, err
+		}
+
+		s.mu.Lock()
+		if err := os.Remove(segment.path); err != nil && !os.IsNotExist(err) {
+			s.mu.Unlock()
+			return replayed, fmt.Errorf("failed to remove spool segment: %w", err)
+		}
+		s.sealed = s.sealed[1:]
+		s.size -= segment.size
+		s.mu.Unlock()
+	}
+}
+
+// drainSegment replays a sealed segment from its replay position. Sealed
+// segments are never appended to, so the file is read without the lock.
+func (s *SinkSpool) drainSegment(segment *spoolSegment, write func(sinkBatch) error) (int, error) {
+	f, err := os.Open(segment.path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open spool segment: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(segment.offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	reader := bufio.NewReader(f)
+	for segment.offset < segment.size {
+		record, n, err := readSpoolRecord(reader)
+		if err != nil {
+			return replayed, fmt.Errorf("corrupt spool segment %s: %w", segment.path, err)
+		}
+		if err := write(sinkBatch{logMsg: record.LogMsg, entries: record.Entries, flush: record.Flush, final: record.Final}); err != nil {
+			return replayed, err
+		}
+
+		s.mu.Lock()
+		segment.offset += n
+		s.records--
+		s.mu.Unlock()
+		replayed += len(record.Entries)
+	}
+	return replayed, nil
+}
+
+func readSpoolRecord(r io.Reader) (spoolRecord, int64, error) {
+	var header [spoolRecordHeader]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return spoolRecord{}, 0, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return spoolRecord{}, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return spoolRecord{}, 0, errors.New("checksum mismatch")
+	}
+
+	var record spoolRecord
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return spoolRecord{}, 0, err
+	}
+	return record, int64(spoolRecordHeader + len(payload)), nil
+}
+
+// Close closes the segment open for appends; spooled data stays on disk
+func (s *SinkSpool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current != nil && s.current.file != nil {
+		return s.current.file.Close()
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourorg/aurora-log-system/common/metrics"
+)
+
+func spoolTestBatch(file string, n int) sinkBatch {
+	entries := make([]ParsedLogEntry, 0, n)
+	for i := 0; i < n; i++ {
+		entries = append(entries, ParsedLogEntry{"message": "m", "_timestamp": int64(1754136000000 + i)})
+	}
+	return sinkBatch{logMsg: LogMessage{InstanceID: "db-1", LogType: "error", LogFileName: file}, entries: entries}
+}
+
+func drainAll(t *testing.T, spool *SinkSpool) []sinkBatch {
+	var batches []sinkBatch
+	_, err := spool.Drain(context.Background(), func(batch sinkBatch) error {
+		batches = append(batches, batch)
+		return nil
+	})
+	require.NoError(t, err)
+	return batches
+}
+
+func TestSinkSpoolAppendAndDrain(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := OpenSinkSpool(dir, 0, 200)
+	require.NoError(t, err)
+
+	for _, file := range []string{"a.log", "b.log", "c.log"} {
+		require.NoError(t, spool.Append(spoolTestBatch(file, 2)))
+	}
+	assert.Equal(t, 3, spool.Len())
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	assert.Greater(t, len(segments), 1, "small segments rotate")
+
+	batches := drainAll(t, spool)
+	require.Len(t, batches, 3)
+	assert.Equal(t, "a.log", batches[0].logMsg.LogFileName)
+	assert.Equal(t, "c.log", batches[2].logMsg.LogFileName)
+	assert.Equal(t, 1754136000001.0, batches[0].entries[1]["_timestamp"])
+	assert.Equal(t, 0, spool.Len())
+	assert.Equal(t, int64(0), spool.Size())
+
+	segments, _ = filepath.Glob(filepath.Join(dir, "*.seg"))
+	assert.Empty(t, segments)
+}
+
+func TestSinkSpoolResumesAfterFailure(t *testing.T) {
+	spool, err := OpenSinkSpool(t.TempDir(), 0, 1<<20)
+	require.NoError(t, err)
+	for _, file := range []string{"a.log", "b.log", "c.log"} {
+		require.NoError(t, spool.Append(spoolTestBatch(file, 1)))
+	}
+
+	var written []string
+	_, err = spool.Drain(context.Background(), func(batch sinkBatch) error {
+		if batch.logMsg.LogFileName == "b.log" {
+			return errors.New("sink down")
+		}
+		written = append(written, batch.logMsg.LogFileName)
+		return nil
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 2, spool.Len())
+
+	for _, batch := range drainAll(t, spool) {
+		written = append(written, batch.logMsg.LogFileName)
+	}
+	assert.Equal(t, []string{"a.log", "b.log", "c.log"}, written, "replay resumes at the failed batch")
+}
+
+func TestSinkSpoolRecoversOnOpen(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := OpenSinkSpool(dir, 0, 1<<20)
+	require.NoError(t, err)
+	require.NoError(t, spool.Append(spoolTestBatch("a.log", 1)))
+	require.NoError(t, spool.Append(spoolTestBatch("b.log", 1)))
+	require.NoError(t, spool.Close())
+
+	// Simulate a crash in the middle of an append
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	require.Len(t, segments, 1)
+	f, err := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 1, 0, 'x'})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	reopened, err := OpenSinkSpool(dir, 0, 1<<20)
+	require.NoError(t, err)
+	assert.Equal(t, 2, reopened.Len())
+
+	require.NoError(t, reopened.Append(spoolTestBatch("c.log", 1)))
+	batches := drainAll(t, reopened)
+	require.Len(t, batches, 3)
+	assert.Equal(t, "c.log", batches[2].logMsg.LogFileName)
+}
+
+func TestSinkSpoolSizeCap(t *testing.T) {
+	spool, err := OpenSinkSpool(t.TempDir(), 300, 1<<20)
+	require.NoError(t, err)
+
+	require.NoError(t, spool.Append(spoolTestBatch("a.log", 1)))
+	err = spool.Append(spoolTestBatch("b.log", 10))
+	assert.ErrorIs(t, err, errSpoolFull)
+	assert.Equal(t, 1, spool.Len())
+}
+
+func TestSinkRunnerSpoolsAndReplays(t *testing.T) {
+	sink := &recordingSink{name: "flaky", failures: 2}
+	metrics := metrics.NewExporter("", "", "")
+	fanOut := NewSinkFanOut(metrics)
+	require.NoError(t, fanOut.Add(sink, SinkOptions{
+		MaxRetries:        1,
+		RetryBackoff:      time.Millisecond,
+		CircuitBreakerMax: 100,
+		SpoolDir:          t.TempDir(),
+	}))
+	runner := fanOut.runners[0]
+
+	// Both attempts fail, so the batch is spooled instead of lost
+	require.NoError(t, runner.write(context.Background(), spoolTestBatch("a.log", 2)))
+	assert.Equal(t, 1, runner.spool.Len())
+	assert.Equal(t, int64(2), metrics.Counter("sink_flaky_spooled_entries"))
+
+	// While the spool holds data, new batches queue up behind it
+	require.NoError(t, runner.write(context.Background(), spoolTestBatch("b.log", 1)))
+	assert.Equal(t, 2, runner.spool.Len())
+	assert.Equal(t, 0, sink.entries())
+
+	runner.drainSpool(context.Background())
+	assert.Equal(t, 0, runner.spool.Len())
+	assert.Equal(t, 3, sink.entries())
+	assert.Equal(t, int64(3), metrics.Counter("sink_flaky_replayed_entries"))
+	assert.Zero(t, metrics.Counter("sink_flaky_failed_entries"))
+}
+
+func TestSinkRunnerFlushesBufferingSinkBeforeReplayCounts(t *testing.T) {
+	sink := &bufferingSink{recordingSink: recordingSink{name: "archive", failures: 1}}
+	fanOut := NewSinkFanOut(metrics.NewExporter("", "", ""))
+	require.NoError(t, fanOut.Add(sink, SinkOptions{
+		CircuitBreakerMax: 100,
+		SpoolDir:          t.TempDir(),
+	}))
+	runner := fanOut.runners[0]
+
+	batch := spoolTestBatch("a.log", 2)
+	require.NoError(t, runner.write(context.Background(), batch))
+	assert.Equal(t, 1, runner.spool.Len())
+
+	// The end of the file waits behind its spooled batch
+	require.NoError(t, runner.flushSink(context.Background(), sinkBatch{logMsg: batch.logMsg, flush: true, final: true}))
+	assert.Equal(t, 2, runner.spool.Len())
+	assert.Zero(t, sink.finals)
+
+	// Written but not flushed: the record stays in the spool
+	sink.flushErr = errors.New("bucket unavailable")
+	runner.drainSpool(context.Background())
+	assert.Equal(t, 2, runner.spool.Len())
+
+	sink.flushErr = nil
+	runner.drainSpool(context.Background())
+	assert.Equal(t, 0, runner.spool.Len())
+	assert.Equal(t, 4, sink.uploaded, "the unflushed replay is written again")
+	assert.Equal(t, 1, sink.finals)
+}
+
+func TestSinkRunnerSpoolsWhenQueueIsFull(t *testing.T) {
+	slow := &recordingSink{name: "slow", block: make(chan struct{})}
+	fanOut := NewSinkFanOut(metrics.NewExporter("", "", ""))
+	require.NoError(t, fanOut.Add(slow, SinkOptions{
+		BatchSize:      1,
+		QueueSize:      1,
+		EnqueueTimeout: 20 * time.Millisecond,
+		SpoolDir:       t.TempDir(),
+	}))
+	fanOut.Start()
+
+	delivery := NewSinkDelivery()
+	for i := 0; i < 4; i++ {
+		require.NoError(t, fanOut.WriteBatch(context.Background(), LogMessage{LogType: "error"}, makeEntries("ERROR"), delivery))
+	}
+	assert.Greater(t, fanOut.runners[0].spool.Len(), 0)
+
+	close(slow.block)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, delivery.Wait(ctx))
+	require.NoError(t, fanOut.Close(context.Background()))
+}
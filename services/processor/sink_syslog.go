@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourorg/aurora-log-system/common/env"
+	commontls "github.com/yourorg/aurora-log-system/common/tls"
+)
+
+// syslogFacilities maps RFC 5424 facility names to their codes
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// defaultSyslogSeverities maps parsed levels to RFC 5424 severities
+var defaultSyslogSeverities = map[string]int{
+	"FATAL":   2, // critical
+	"ERROR":   3, // error
+	"WARNING": 4, // warning
+	"NOTE":    5, // notice
+	"SYSTEM":  5, // notice
+	"INFO":    6, // informational
+	"DEBUG":   7, // debug
+}
+
+// SyslogSinkConfig configures the RFC 5424 syslog sink
+type SyslogSinkConfig struct {
+	Address         string
+	TLS             commontls.FileConfig
+	Facility        int
+	Severities      map[string]int // upper-case level -> severity
+	DefaultSeverity int
+	AppName         string
+	SDID            string // structured data ID, name@<private enterprise number>
+	MessageFormat   string // raw or json
+	WriteTimeout    time.Duration
+}
+
+func loadSyslogSinkConfig() (SyslogSinkConfig, error) {
+	facility, err := parseSyslogFacility(env.Get("SYSLOG_FACILITY", "local0"))
+	if err != nil {
+		return SyslogSinkConfig{}, err
+	}
+
+	severities := make(map[string]int, len(defaultSyslogSeverities))
+	for level, severity := range defaultSyslogSeverities {
+		severities[level] = severity
+	}
+	// SYSLOG_SEVERITY_MAP=ERROR=2,WARNING=3
+	for _, mapping := range env.List("SYSLOG_SEVERITY_MAP") {
+		level, value, ok := strings.Cut(mapping, "=")
+		if !ok {
+			return SyslogSinkConfig{}, fmt.Errorf("invalid SYSLOG_SEVERITY_MAP entry %q", mapping)
+		}
+		severity, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || severity < 0 || severity > 7 {
+			return SyslogSinkConfig{}, fmt.Errorf("invalid syslog severity %q for level %s", value, level)
+		}
+		severities[strings.ToUpper(strings.TrimSpace(level))] = severity
+	}
+
+	return SyslogSinkConfig{
+		Address: env.Get("SYSLOG_ADDRESS", "localhost:6514"),
+		TLS: commontls.FileConfig{
+			Enabled:            env.Get("SYSLOG_TLS_ENABLED", "false") == "true",
+			CAFile:             env.Get("SYSLOG_TLS_CA_FILE", ""),
+			CertFile:           env.Get("SYSLOG_TLS_CERT_FILE", ""),
+			KeyFile:            env.Get("SYSLOG_TLS_KEY_FILE", ""),
+			ServerName:         env.Get("SYSLOG_TLS_SERVER_NAME", ""),
+			InsecureSkipVerify: env.Get("SYSLOG_TLS_INSECURE_SKIP_VERIFY", "false") == "true",
+		},
+		Facility:        facility,
+		Severities:      severities,
+		DefaultSeverity: env.Int("SYSLOG_DEFAULT_SEVERITY", 6),
+		AppName:         env.Get("SYSLOG_APP_NAME", "aurora-mysql"),
+		SDID:            env.Get("SYSLOG_SD_ID", "aurora@32473"),
+		MessageFormat:   env.Get("SYSLOG_MESSAGE_FORMAT", "raw"),
+		WriteTimeout:    time.Duration(env.Int("SYSLOG_WRITE_TIMEOUT_SEC", 10)) * time.Second,
+	}, nil
+}
+
+func parseSyslogFacility(value string) (int, error) {
+	if code, ok := syslogFacilities[strings.ToLower(value)]; ok {
+		return code, nil
+	}
+	code, err := strconv.Atoi(value)
+	if err != nil || code < 0 || code > 23 {
+		return 0, fmt.Errorf("invalid syslog facility %q", value)
+	}
+	return code, nil
+}
+
+// SyslogSink writes RFC 5424 messages with octet-counting framing (RFC 6587)
+// over TCP, or over TLS as described in RFC 5425. A connection error drops
+// the connection and fails the batch; messages written before the error may
+// be delivered again when the runner retries.
+type SyslogSink struct {
+	config    SyslogSinkConfig
+	tlsConfig *tls.Config
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func NewSyslogSink(config SyslogSinkConfig) (*SyslogSink, error) {
+	switch config.MessageFormat {
+	case "raw", "json":
+	default:
+		return nil, fmt.Errorf("unsupported syslog message format %q", config.MessageFormat)
+	}
+	if config.WriteTimeout <= 0 {
+		config.WriteTimeout = 10 * time.Second
+	}
+
+	tlsConfig, err := config.TLS.Build()
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog TLS configuration: %w", err)
+	}
+
+	return &SyslogSink{config: config, tlsConfig: tlsConfig}, nil
+}
+
+func (s *SyslogSink) Name() string { return "syslog" }
+
+func (s *SyslogSink) WriteBatch(ctx context.Context, logMsg LogMessage, batch []ParsedLogEntry) error {
+	var buf bytes.Buffer
+	for _, entry := range batch {
+		msg, err := s.formatMessage(logMsg, entry)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(strconv.Itoa(len(msg)))
+		buf.WriteByte(' ')
+		buf.Write(msg)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.connectLocked(ctx); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(s.config.WriteTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	s.conn.SetWriteDeadline(deadline)
+	if _, err := s.conn.Write(buf.Bytes()); err != nil {
+		s.disconnectLocked()
+		return fmt.Errorf("failed to write to syslog at %s: %w", s.config.Address, err)
+	}
+	return nil
+}
+
+// formatMessage renders one RFC 5424 message:
+//
+//	<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD-ID k="v" ...] MSG
+func (s *SyslogSink) formatMessage(logMsg LogMessage, entry ParsedLogEntry) ([]byte, error) {
+	level, _ := entry["level"].(string)
+	severity, ok := s.config.Severities[strings.ToUpper(level)]
+	if !ok {
+		severity = s.config.DefaultSeverity
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "<%d>1 %s %s %s - %s [%s",
+		s.config.Facility*8+severity,
+		entryTimestamp(entry).UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeaderField(logMsg.InstanceID, 255),
+		syslogHeaderField(s.config.AppName, 48),
+		syslogHeaderField(logMsg.LogType, 32),
+		s.config.SDID)
+	for _, param := range [][2]string{
+		{"instance_id", logMsg.InstanceID},
+		{"cluster_id", logMsg.ClusterID},
+		{"log_type", logMsg.LogType},
+	} {
+		fmt.Fprintf(&msg, " %s=\"%s\"", param[0], syslogParamEscaper.Replace(param[1]))
+	}
+	msg.WriteString("] ")
+
+	if s.config.MessageFormat == "json" {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal entry: %w", err)
+		}
+		msg.Write(data)
+	} else {
+		for _, field := range []string{"raw_line", "message", "sql_statement"} {
+			if text, ok := entry[field].(string); ok && text != "" {
+				msg.WriteString(text)
+				break
+			}
+		}
+	}
+	return msg.Bytes(), nil
+}
+
+// syslogParamEscaper escapes the characters RFC 5424 reserves in PARAM-VALUE
+var syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogHeaderField returns a header field restricted to printable US-ASCII
+// without spaces, or the NILVALUE when empty
+func syslogHeaderField(value string, maxLen int) string {
+	var b strings.Builder
+	for i := 0; i < len(value) && b.Len() < maxLen; i++ {
+		if c := value[i]; c > 32 && c < 127 {
+			b.WriteByte(c)
+		}
+	}
+	if b.Len() == 0 {
+		return "-"
+	}
+	return b.String()
+}
+
+func (s *SyslogSink) connectLocked(ctx context.Context) error {
+	if s.conn != nil {
+		return nil
+	}
+
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	var conn net.Conn
+	var err error
+	if s.tlsConfig != nil {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: s.tlsConfig}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", s.config.Address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", s.config.Address)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to syslog at %s: %w", s.config.Address, err)
+	}
+
+	s.conn = conn
+	slog.Info("Connected to syslog", "address", s.config.Address, "tls", s.tlsConfig != nil)
+	return nil
+}
+
+func (s *SyslogSink) disconnectLocked() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// Flush is a no-op; every batch is written synchronously
+func (s *SyslogSink) Flush(ctx context.Context) error { return nil }
+
+func (s *SyslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disconnectLocked()
+	return nil
+}
+
+// Health reports whether the syslog receiver accepts connections
+func (s *SyslogSink) Health(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connectLocked(ctx)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	commontls "github.com/yourorg/aurora-log-system/common/tls"
+)
+
+// startSyslogServer accepts one connection and returns its octet-counted frames
+func startSyslogServer(t *testing.T, listener net.Listener, frames int) <-chan []string {
+	out := make(chan []string, 1)
+	go func() {
+		var messages []string
+		defer func() { out <- messages }()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		reader := bufio.NewReader(conn)
+		for len(messages) < frames {
+			length, err := reader.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, err := strconv.Atoi(strings.TrimSpace(length))
+			if !assert.NoError(t, err) {
+				return
+			}
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(reader, msg); err != nil {
+				return
+			}
+			messages = append(messages, string(msg))
+		}
+	}()
+	return out
+}
+
+func testSyslogConfig(address string) SyslogSinkConfig {
+	return SyslogSinkConfig{
+		Address:         address,
+		Facility:        16,
+		Severities:      defaultSyslogSeverities,
+		DefaultSeverity: 6,
+		AppName:         "aurora-mysql",
+		SDID:            "aurora@32473",
+		MessageFormat:   "raw",
+	}
+}
+
+func TestSyslogSinkTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	received := startSyslogServer(t, listener, 2)
+
+	sink, err := NewSyslogSink(testSyslogConfig(listener.Addr().String()))
+	require.NoError(t, err)
+	defer sink.Close()
+
+	ts := time.Date(2025, 8, 2, 12, 0, 0, 250*int(time.Millisecond), time.UTC)
+	logMsg := LogMessage{ClusterID: `prod "orders"`, InstanceID: "db-1", LogType: "error"}
+	require.NoError(t, sink.WriteBatch(context.Background(), logMsg, []ParsedLogEntry{
+		{"_timestamp": ts.UnixMilli(), "level": "ERROR", "raw_line": "2025-08-02T12:00:00Z 1 [ERROR] boom"},
+		{"_timestamp": ts.UnixMilli(), "level": "Note", "message": "ready"},
+	}))
+
+	messages := <-received
+	require.Len(t, messages, 2)
+	assert.Equal(t,
+		`<131>1 2025-08-02T12:00:00.250000Z db-1 aurora-mysql - error [aurora@32473 instance_id="db-1" cluster_id="prod \"orders\"" log_type="error"] 2025-08-02T12:00:00Z 1 [ERROR] boom`,
+		messages[0])
+	assert.True(t, strings.HasPrefix(messages[1], "<133>1 "), messages[1])
+	assert.True(t, strings.HasSuffix(messages[1], "] ready"), messages[1])
+}
+
+func TestSyslogSinkTLS(t *testing.T) {
+	dir := t.TempDir()
+	cert, caFile := writeTestCertificate(t, dir)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	require.NoError(t, err)
+	defer listener.Close()
+	received := startSyslogServer(t, listener, 1)
+
+	config := testSyslogConfig(listener.Addr().String())
+	config.TLS = commontls.FileConfig{Enabled: true, CAFile: caFile}
+	config.MessageFormat = "json"
+	config.Severities = map[string]int{"WARNING": 2}
+	sink, err := NewSyslogSink(config)
+	require.NoError(t, err)
+	defer sink.Close()
+
+	require.NoError(t, sink.WriteBatch(context.Background(), LogMessage{InstanceID: "db-1", LogType: "slowquery"}, []ParsedLogEntry{
+		{"level": "WARNING", "query_time": 2.5},
+	}))
+
+	messages := <-received
+	require.Len(t, messages, 1)
+	assert.True(t, strings.HasPrefix(messages[0], "<130>1 "), messages[0])
+	assert.Contains(t, messages[0], `cluster_id=""`)
+	assert.True(t, strings.HasSuffix(messages[0], `] {"level":"WARNING","query_time":2.5}`), messages[0])
+}
+
+func TestSyslogSinkConnectionErrors(t *testing.T) {
+	sink, err := NewSyslogSink(testSyslogConfig(deadAddress(t)))
+	require.NoError(t, err)
+
+	err = sink.WriteBatch(context.Background(), LogMessage{}, []ParsedLogEntry{{"message": "m"}})
+	assert.Error(t, err)
+	assert.Error(t, sink.Health(context.Background()))
+}
+
+func TestParseSyslogFacility(t *testing.T) {
+	code, err := parseSyslogFacility("LOCAL7")
+	require.NoError(t, err)
+	assert.Equal(t, 23, code)
+
+	code, err = parseSyslogFacility("4")
+	require.NoError(t, err)
+	assert.Equal(t, 4, code)
+
+	_, err = parseSyslogFacility("24")
+	assert.Error(t, err)
+}
+
+func TestSyslogHeaderField(t *testing.T) {
+	assert.Equal(t, "-", syslogHeaderField("", 10))
+	assert.Equal(t, "dbone", syslogHeaderField("db one", 10))
+	assert.Equal(t, "abc", syslogHeaderField("abcdef", 3))
+}
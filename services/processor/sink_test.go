@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourorg/aurora-log-system/common/metrics"
+)
+
+// recordingSink is a test Sink that stores what it receives
+type recordingSink struct {
+	name     string
+	mu       sync.Mutex
+	batches  [][]ParsedLogEntry
+	failures int
+	block    chan struct{}
+	flushed  bool
+	closed   bool
+}
+
+func (s *recordingSink) Name() string { return s.name }
+
+func (s *recordingSink) WriteBatch(ctx context.Context, logMsg LogMessage, batch []ParsedLogEntry) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("sink unavailable")
+	}
+	s.batches = append(s.batches, batch)
+	return nil
+}
+
+func (s *recordingSink) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	s.flushed = true
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *recordingSink) Health(ctx context.Context) error { return nil }
+
+// bufferingSink is a recordingSink whose writes only count once flushed
+type bufferingSink struct {
+	recordingSink
+	flushErr error
+	uploaded int
+	finals   int // FlushFile calls for a file read to its end
+}
+
+func (s *bufferingSink) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.flushErr != nil {
+		return s.flushErr
+	}
+	s.uploaded = 0
+	for _, batch := range s.batches {
+		s.uploaded += len(batch)
+	}
+	return nil
+}
+
+func (s *bufferingSink) FlushFile(ctx context.Context, logMsg LogMessage, final bool) error {
+	if err := s.Flush(ctx); err != nil {
+		return err
+	}
+	if final {
+		s.mu.Lock()
+		s.finals++
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+func (s *bufferingSink) Roll(ctx context.Context) error { return nil }
+
+func (s *recordingSink) entries() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := 0
+	for _, batch := range s.batches {
+		total += len(batch)
+	}
+	return total
+}
+
+func makeEntries(levels ...string) []ParsedLogEntry {
+	entries := make([]ParsedLogEntry, 0, len(levels))
+	for _, level := range levels {
+		entries = append(entries, ParsedLogEntry{"level": level, "message": "msg"})
+	}
+	return entries
+}
+
+func TestSinkFilter(t *testing.T) {
+	filter := SinkFilter{
+		LogTypes: []string{"error"},
+		Clusters: []string{"prod-*"},
+		Levels:   []string{"ERROR", "WARNING"},
+	}
+
+	assert.True(t, filter.matchesFile(LogMessage{LogType: "error", ClusterID: "prod-orders"}))
+	assert.False(t, filter.matchesFile(LogMessage{LogType: "slowquery", ClusterID: "prod-orders"}))
+	assert.False(t, filter.matchesFile(LogMessage{LogType: "error", ClusterID: "staging-orders"}))
+
+	assert.True(t, filter.matchesEntry(ParsedLogEntry{"level": "error"}))
+	assert.False(t, filter.matchesEntry(ParsedLogEntry{"level": "INFO"}))
+	assert.True(t, filter.matchesEntry(ParsedLogEntry{"event_type": "query_sql"}), "entries without level are kept")
+
+	assert.True(t, SinkFilter{}.matchesFile(LogMessage{LogType: "general"}))
+}
+
+func TestSinkFanOutBatchingAndFilters(t *testing.T) {
+	all := &recordingSink{name: "all"}
+	errorsOnly := &recordingSink{name: "errors"}
+
+	fanOut := NewSinkFanOut(metrics.NewExporter("", "", ""))
+	fanOut.Add(all, SinkOptions{BatchSize: 2, FlushInterval: time.Hour})
+	fanOut.Add(errorsOnly, SinkOptions{BatchSize: 10, FlushInterval: time.Hour, Filter: SinkFilter{Levels: []string{"ERROR"}}})
+	fanOut.Start()
+
+	logMsg := LogMessage{LogType: "error", ClusterID: "c1", InstanceID: "i1"}
+	require.NoError(t, fanOut.WriteBatch(context.Background(), logMsg, makeEntries("ERROR", "INFO", "INFO"), nil))
+
+	// The first two entries fill a batch for "all"; the third waits for a flush
+	assert.Eventually(t, func() bool { return all.entries() == 2 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, errorsOnly.entries())
+
+	require.NoError(t, fanOut.Close(context.Background()))
+	assert.Equal(t, 3, all.entries())
+	assert.Equal(t, 1, errorsOnly.entries())
+	assert.True(t, all.flushed)
+	assert.True(t, all.closed)
+}
+
+func TestSinkFanOutIsolatesSlowSink(t *testing.T) {
+	slow := &recordingSink{name: "slow", block: make(chan struct{})}
+	fast := &recordingSink{name: "fast"}
+
+	fanOut := NewSinkFanOut(metrics.NewExporter("", "", ""))
+	fanOut.Add(slow, SinkOptions{BatchSize: 1, QueueSize: 1, EnqueueTimeout: 50 * time.Millisecond})
+	fanOut.Add(fast, SinkOptions{BatchSize: 1})
+	fanOut.Start()
+
+	logMsg := LogMessage{LogType: "error"}
+	var lastErr error
+	for i := 0; i < 5; i++ {
+		if err := fanOut.WriteBatch(context.Background(), logMsg, makeEntries("ERROR"), nil); err != nil {
+			lastErr = err
+		}
+	}
+
+	assert.ErrorContains(t, lastErr, "sink slow queue is full")
+	assert.Eventually(t, func() bool { return fast.entries() == 5 }, time.Second, 10*time.Millisecond)
+
+	close(slow.block)
+	require.NoError(t, fanOut.Close(context.Background()))
+}
+
+func TestSinkRunnerRetries(t *testing.T) {
+	sink := &recordingSink{name: "flaky", failures: 2}
+	metrics := metrics.NewExporter("", "", "")
+	runner := newSinkRunner(sink, SinkOptions{MaxRetries: 2, RetryBackoff: time.Millisecond}, metrics)
+
+	err := runner.write(context.Background(), sinkBatch{logMsg: LogMessage{LogType: "error"}, entries: makeEntries("ERROR")})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, sink.entries())
+
+	sink.failures = 5
+	err = runner.write(context.Background(), sinkBatch{logMsg: LogMessage{LogType: "error"}, entries: makeEntries("ERROR")})
+	assert.Error(t, err)
+	assert.Equal(t, int64(1), metrics.Counter("sink_flaky_failed_entries"))
+}
+
+func TestFluentBitSink(t *testing.T) {
+	addr, chunks := startAckServer(t)
+
+	pool, err := NewForwarderPool(ForwarderPoolConfig{Discovery: DiscoveryStatic, Hosts: []string{addr}}, FluentForwardOptions{AckTimeout: time.Second})
+	require.NoError(t, err)
+	require.NoError(t, pool.Start(context.Background()))
+	defer pool.Close()
+
+	sink := NewFluentBitSink(pool)
+	assert.NoError(t, sink.Health(context.Background()))
+
+	err = sink.WriteBatch(context.Background(), LogMessage{LogType: "error"}, []ParsedLogEntry{
+		{"message": "parsed", "_timestamp": time.Now().UnixMilli()},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), chunks.Load())
+}
+
+func TestBuildSinks(t *testing.T) {
+	metrics := metrics.NewExporter("", "", "")
+	httpPool := NewHTTPConnectionPool(1, time.Second)
+
+	t.Setenv("SINK_OPENOBSERVE_LOG_TYPES", "error,slowquery")
+	t.Setenv("SINK_OPENOBSERVE_BATCH_SIZE", "250")
+
+	fanOut, err := buildSinks(Config{Sinks: []string{"openobserve"}}, aws.Config{}, httpPool, nil, metrics)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"openobserve"}, fanOut.Names())
+	assert.Equal(t, 250, fanOut.runners[0].opts.BatchSize)
+	assert.Equal(t, []string{"error", "slowquery"}, fanOut.runners[0].opts.Filter.LogTypes)
+
+	_, err = buildSinks(Config{Sinks: []string{"fluentbit"}}, aws.Config{}, httpPool, nil, metrics)
+	assert.Error(t, err, "fluentbit sink needs a forwarder")
+
+	_, err = buildSinks(Config{Sinks: []string{"unknown"}}, aws.Config{}, httpPool, nil, metrics)
+	assert.Error(t, err)
+}
+
+func TestSinkDelivery(t *testing.T) {
+	ok := &recordingSink{name: "ok"}
+	failing := &recordingSink{name: "failing", failures: 100}
+
+	fanOut := NewSinkFanOut(metrics.NewExporter("", "", ""))
+	fanOut.Add(ok, SinkOptions{BatchSize: 2, FlushInterval: time.Hour})
+	fanOut.Add(failing, SinkOptions{BatchSize: 2, FlushInterval: time.Hour, MaxRetries: 0, Filter: SinkFilter{LogTypes: []string{"slowquery"}}})
+	fanOut.Start()
+	defer fanOut.Close(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Three entries span two sink batches; the delivery completes only once
+	// the flush writes the partial one
+	logMsg := LogMessage{LogType: "error", InstanceID: "i1"}
+	delivery := NewSinkDelivery()
+	require.NoError(t, fanOut.WriteBatch(ctx, logMsg, makeEntries("ERROR", "INFO", "INFO"), delivery))
+	require.NoError(t, fanOut.Flush(ctx, logMsg, delivery, true))
+	require.NoError(t, delivery.Wait(ctx))
+	assert.Equal(t, 3, ok.entries())
+
+	// A batch the sink never accepts is reported to the file's delivery
+	slowMsg := LogMessage{LogType: "slowquery", InstanceID: "i1"}
+	delivery = NewSinkDelivery()
+	require.NoError(t, fanOut.WriteBatch(ctx, slowMsg, makeEntries("INFO"), delivery))
+	require.NoError(t, fanOut.Flush(ctx, slowMsg, delivery, true))
+	assert.ErrorContains(t, delivery.Wait(ctx), "sink unavailable")
+}
+
+func TestSinkDeliveryWaitsForBufferingSinks(t *testing.T) {
+	archive := &bufferingSink{recordingSink: recordingSink{name: "archive"}}
+
+	fanOut := NewSinkFanOut(metrics.NewExporter("", "", ""))
+	fanOut.Add(archive, SinkOptions{BatchSize: 10, FlushInterval: time.Hour, MaxRetries: 0})
+	fanOut.Start()
+	defer fanOut.Close(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Buffered entries are only delivered once the flush uploaded them
+	logMsg := LogMessage{LogType: "error", InstanceID: "i1"}
+	delivery := NewSinkDelivery()
+	require.NoError(t, fanOut.WriteBatch(ctx, logMsg, makeEntries("ERROR", "INFO"), delivery))
+	require.NoError(t, fanOut.Flush(ctx, logMsg, delivery, true))
+	require.NoError(t, delivery.Wait(ctx))
+	archive.mu.Lock()
+	assert.Equal(t, 2, archive.uploaded)
+	archive.mu.Unlock()
+
+	// A failed upload fails the file even though the write was accepted
+	archive.mu.Lock()
+	archive.flushErr = errors.New("bucket unreachable")
+	archive.mu.Unlock()
+	delivery = NewSinkDelivery()
+	require.NoError(t, fanOut.WriteBatch(ctx, logMsg, makeEntries("ERROR"), delivery))
+	require.NoError(t, fanOut.Flush(ctx, logMsg, delivery, true))
+	assert.ErrorContains(t, delivery.Wait(ctx), "bucket unreachable")
+}
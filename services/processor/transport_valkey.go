@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+	"github.com/yourorg/aurora-log-system/common/env"
+	"github.com/yourorg/aurora-log-system/common/message"
+)
+
+// Transports that carry file notifications from discovery
+// (NOTIFICATION_TRANSPORT)
+const (
+	transportKafka  = "kafka"
+	transportValkey = "valkey"
+)
+
+// ValkeyStreamsConfig configures reading notifications from Valkey Streams.
+// Streams are named after the notification topics.
+type ValkeyStreamsConfig struct {
+	URL       string
+	Group     string
+	Consumer  string
+	ReadCount int
+	Block     time.Duration
+	// Entries another consumer has left pending this long are claimed. Live
+	// consumers refresh their pending entries every third of it.
+	ClaimIdle time.Duration
+}
+
+func loadValkeyStreamsConfig(group string) ValkeyStreamsConfig {
+	hostname, _ := os.Hostname()
+	return ValkeyStreamsConfig{
+		URL:       env.Get("VALKEY_STREAMS_URL", os.Getenv("VALKEY_URL")),
+		Group:     group,
+		Consumer:  env.Get("VALKEY_CONSUMER_NAME", hostname),
+		ReadCount: env.Int("VALKEY_READ_COUNT", 10),
+		Block:     time.Duration(env.Int("VALKEY_READ_BLOCK_SEC", 5)) * time.Second,
+		ClaimIdle: time.Duration(env.Int("VALKEY_CLAIM_IDLE_SEC", 60)) * time.Second,
+	}
+}
+
+// newValkeyClient connects to a redis:// or rediss:// URL; a bare host:port
+// is accepted too
+func newValkeyClient(url string) (*redis.Client, error) {
+	if url == "" {
+		return nil, errors.New("VALKEY_STREAMS_URL or VALKEY_URL is required")
+	}
+	if !strings.Contains(url, "://") {
+		url = "redis://" + url
+	}
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Valkey URL: %w", err)
+	}
+	return redis.NewClient(opts), nil
+}
+
+// valkeyStreamClient is the subset of redis.Client used for consuming
+type valkeyStreamClient interface {
+	XGroupCreateMkStream(ctx context.Context, stream, group, start string) *redis.StatusCmd
+	XReadGroup(ctx context.Context, a *redis.XReadGroupArgs) *redis.XStreamSliceCmd
+	XAutoClaim(ctx context.Context, a *redis.XAutoClaimArgs) *redis.XAutoClaimCmd
+	XClaimJustID(ctx context.Context, a *redis.XClaimArgs) *redis.StringSliceCmd
+	XAck(ctx context.Context, stream, group string, ids ...string) *redis.IntCmd
+	Close() error
+}
+
+type heldEntry struct {
+	seq int64
+	id  string
+}
+
+// ValkeyStreamSource consumes the notification streams as a member of a
+// Valkey consumer group. Entries are returned as kafka.Message so the
+// processor handles both transports alike: the topic is the stream, the
+// partition is always 0 and the offset is a sequence number local to this
+// consumer. Committing an offset acknowledges (XACK) every held entry of the
+// stream up to it.
+//
+// Entries stay in this consumer's pending list until acknowledged. Run keeps
+// them fresh; once a consumer dies, the others claim its entries with
+// XAUTOCLAIM after ClaimIdle.
+type ValkeyStreamSource struct {
+	client  valkeyStreamClient
+	config  ValkeyStreamsConfig
+	streams []string
+	now     func() time.Time
+
+	// Used only by the FetchMessage caller
+	buffered     []kafka.Message
+	lastClaim    time.Time
+	claimCursors map[string]string
+
+	mu      sync.Mutex
+	nextSeq map[string]int64
+	held    map[string][]heldEntry // per stream, in seq order
+}
+
+// NewValkeyStreamSource joins the consumer group on every stream, creating
+// streams and group as needed. A new group starts at the oldest entry.
+func NewValkeyStreamSource(ctx context.Context, client valkeyStreamClient, config ValkeyStreamsConfig, streams []string) (*ValkeyStreamSource, error) {
+	for _, stream := range streams {
+		err := client.XGroupCreateMkStream(ctx, stream, config.Group, "0").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return nil, fmt.Errorf("failed to create consumer group on stream %s: %w", stream, err)
+		}
+	}
+	return &ValkeyStreamSource{
+		client:       client,
+		config:       config,
+		streams:      streams,
+		now:          time.Now,
+		claimCursors: make(map[string]string),
+		nextSeq:      make(map[string]int64),
+		held:         make(map[string][]heldEntry),
+	}, nil
+}
+
+func (s *ValkeyStreamSource) refreshInterval() time.Duration {
+	return s.config.ClaimIdle / 3
+}
+
+// FetchMessage returns the next entry. Entries abandoned by other consumers
+// are claimed before new ones are read.
+func (s *ValkeyStreamSource) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	for len(s.buffered) == 0 {
+		if err := ctx.Err(); err != nil {
+			return kafka.Message{}, err
+		}
+		if err := s.fill(ctx); err != nil {
+			return kafka.Message{}, err
+		}
+	}
+	msg := s.buffered[0]
+	s.buffered = s.buffered[1:]
+	return msg, nil
+}
+
+func (s *ValkeyStreamSource) fill(ctx context.Context) error {
+	if s.now().Sub(s.lastClaim) >= s.refreshInterval() {
+		s.lastClaim = s.now()
+		if err := s.claimAbandoned(ctx); err != nil {
+			return err
+		}
+		if len(s.buffered) > 0 {
+			return nil
+		}
+	}
+
+	args := &redis.XReadGroupArgs{
+		Group:    s.config.Group,
+		Consumer: s.config.Consumer,
+		Count:    int64(s.config.ReadCount),
+		Block:    s.config.Block,
+	}
+	args.Streams = append(args.Streams, s.streams...)
+	for range s.streams {
+		args.Streams = append(args.Streams, ">")
+	}
+	streams, err := s.client.XReadGroup(ctx, args).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return s.backOff(ctx, fmt.Errorf("failed to read streams: %w", err))
+	}
+	for _, stream := range streams {
+		for _, entry := range stream.Messages {
+			s.buffered = append(s.buffered, s.hold(stream.Stream, entry))
+		}
+	}
+	return nil
+}
+
+// claimAbandoned takes over entries other consumers left pending for
+// ClaimIdle, continuing each stream's scan where the last pass stopped
+func (s *ValkeyStreamSource) claimAbandoned(ctx context.Context) error {
+	for _, stream := range s.streams {
+		cursor := s.claimCursors[stream]
+		if cursor == "" {
+			cursor = "0-0"
+		}
+		entries, next, err := s.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    s.config.Group,
+			Consumer: s.config.Consumer,
+			MinIdle:  s.config.ClaimIdle,
+			Start:    cursor,
+			Count:    int64(s.config.ReadCount),
+		}).Result()
+		if err != nil {
+			return s.backOff(ctx, fmt.Errorf("failed to claim entries of stream %s: %w", stream, err))
+		}
+		s.claimCursors[stream] = next
+		if len(entries) > 0 {
+			slog.Info("Claimed abandoned stream entries", "stream", stream, "count", len(entries))
+		}
+		for _, entry := range entries {
+			s.buffered = append(s.buffered, s.hold(stream, entry))
+		}
+	}
+	return nil
+}
+
+// backOff waits a moment before an error is returned, so the caller does
+// not spin while Valkey is unavailable
+func (s *ValkeyStreamSource) backOff(ctx context.Context, err error) error {
+	slog.Warn("Valkey stream error", "error", err)
+	select {
+	case <-time.After(time.Second):
+	case <-ctx.Done():
+	}
+	return err
+}
+
+// hold records a delivered entry as pending and converts it to a message
+func (s *ValkeyStreamSource) hold(stream string, entry redis.XMessage) kafka.Message {
+	s.mu.Lock()
+	seq := s.nextSeq[stream]
+	s.nextSeq[stream] = seq + 1
+	s.held[stream] = append(s.held[stream], heldEntry{seq: seq, id: entry.ID})
+	s.mu.Unlock()
+
+	msg := kafka.Message{Topic: stream, Partition: 0, Offset: seq, Time: streamEntryTime(entry.ID)}
+	key, body, headers, err := message.FromStreamValues(entry.Values)
+	if err != nil {
+		// Delivered without a body, so it fails to decode and is acknowledged
+		slog.Warn("Malformed stream entry", "stream", stream, "id", entry.ID, "error", err)
+		return msg
+	}
+	msg.Key = []byte(key)
+	msg.Value = body
+	for name, value := range headers {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: name, Value: []byte(value)})
+	}
+	sort.Slice(msg.Headers, func(i, j int) bool { return msg.Headers[i].Key < msg.Headers[j].Key })
+	return msg
+}
+
+// streamEntryTime returns when an entry was added, from its ID
+func streamEntryTime(id string) time.Time {
+	millis, _, _ := strings.Cut(id, "-")
+	if ms, err := strconv.ParseInt(millis, 10, 64); err == nil {
+		return time.UnixMilli(ms)
+	}
+	return time.Time{}
+}
+
+// CommitMessages acknowledges, per stream, every held entry up to the
+// message's offset
+func (s *ValkeyStreamSource) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	for _, msg := range msgs {
+		s.mu.Lock()
+		var ids []string
+		for _, entry := range s.held[msg.Topic] {
+			if entry.seq > msg.Offset {
+				break
+			}
+			ids = append(ids, entry.id)
+		}
+		s.mu.Unlock()
+		if len(ids) == 0 {
+			continue
+		}
+
+		if err := s.client.XAck(ctx, msg.Topic, s.config.Group, ids...).Err(); err != nil {
+			return fmt.Errorf("failed to acknowledge entries of stream %s: %w", msg.Topic, err)
+		}
+
+		s.mu.Lock()
+		held := s.held[msg.Topic]
+		for len(held) > 0 && held[0].seq <= msg.Offset {
+			held = held[1:]
+		}
+		s.held[msg.Topic] = held
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// Run refreshes the idle time of held entries so that other consumers do
+// not claim files this one is still processing
+func (s *ValkeyStreamSource) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.refreshInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.refreshHeld(ctx)
+		}
+	}
+}
+
+func (s *ValkeyStreamSource) refreshHeld(ctx context.Context) {
+	for _, stream := range s.streams {
+		s.mu.Lock()
+		ids := make([]string, 0, len(s.held[stream]))
+		for _, entry := range s.held[stream] {
+			ids = append(ids, entry.id)
+		}
+		s.mu.Unlock()
+		if len(ids) == 0 {
+			continue
+		}
+
+		// JUSTID resets the idle time without counting a delivery
+		if err := s.client.XClaimJustID(ctx, &redis.XClaimArgs{
+			Stream:   stream,
+			Group:    s.config.Group,
+			Consumer: s.config.Consumer,
+			Messages: ids,
+		}).Err(); err != nil {
+			slog.Warn("Failed to refresh pending stream entries", "stream", stream, "count", len(ids), "error", err)
+		}
+	}
+}
+
+func (s *ValkeyStreamSource) Close() error {
+	return s.client.Close()
+}
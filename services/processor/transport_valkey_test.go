@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStreamClient serves queued XREADGROUP and XAUTOCLAIM replies and
+// records acknowledgements and refreshes
+type fakeStreamClient struct {
+	groupErr error
+	groups   []string
+	reads    [][]redis.XStream
+	claims   map[string][]redis.XMessage
+	acked    map[string][]string
+	refresh  map[string][]string
+	ackErr   error
+}
+
+func newFakeStreamClient() *fakeStreamClient {
+	return &fakeStreamClient{
+		claims:  make(map[string][]redis.XMessage),
+		acked:   make(map[string][]string),
+		refresh: make(map[string][]string),
+	}
+}
+
+func (c *fakeStreamClient) XGroupCreateMkStream(ctx context.Context, stream, group, start string) *redis.StatusCmd {
+	cmd := redis.NewStatusCmd(ctx)
+	c.groups = append(c.groups, stream+"/"+group+"@"+start)
+	cmd.SetErr(c.groupErr)
+	return cmd
+}
+
+func (c *fakeStreamClient) XReadGroup(ctx context.Context, a *redis.XReadGroupArgs) *redis.XStreamSliceCmd {
+	if len(c.reads) == 0 {
+		return redis.NewXStreamSliceCmdResult(nil, redis.Nil)
+	}
+	read := c.reads[0]
+	c.reads = c.reads[1:]
+	return redis.NewXStreamSliceCmdResult(read, nil)
+}
+
+func (c *fakeStreamClient) XAutoClaim(ctx context.Context, a *redis.XAutoClaimArgs) *redis.XAutoClaimCmd {
+	cmd := redis.NewXAutoClaimCmd(ctx)
+	cmd.SetVal(c.claims[a.Stream], "0-0")
+	delete(c.claims, a.Stream)
+	return cmd
+}
+
+func (c *fakeStreamClient) XClaimJustID(ctx context.Context, a *redis.XClaimArgs) *redis.StringSliceCmd {
+	c.refresh[a.Stream] = append(c.refresh[a.Stream], a.Messages...)
+	cmd := redis.NewStringSliceCmd(ctx)
+	cmd.SetVal(a.Messages)
+	return cmd
+}
+
+func (c *fakeStreamClient) XAck(ctx context.Context, stream, group string, ids ...string) *redis.IntCmd {
+	cmd := redis.NewIntCmd(ctx)
+	if c.ackErr != nil {
+		cmd.SetErr(c.ackErr)
+		return cmd
+	}
+	c.acked[stream] = append(c.acked[stream], ids...)
+	cmd.SetVal(int64(len(ids)))
+	return cmd
+}
+
+func (c *fakeStreamClient) Close() error { return nil }
+
+func streamEntry(id string, fields ...any) redis.XMessage {
+	values := map[string]any{}
+	for i := 0; i < len(fields); i += 2 {
+		values[fields[i].(string)] = fields[i+1]
+	}
+	return redis.XMessage{ID: id, Values: values}
+}
+
+func newTestStreamSource(t *testing.T, client *fakeStreamClient) *ValkeyStreamSource {
+	source, err := NewValkeyStreamSource(context.Background(), client, ValkeyStreamsConfig{
+		Group:     "processors",
+		Consumer:  "processor-0",
+		ReadCount: 10,
+		Block:     time.Second,
+		ClaimIdle: time.Minute,
+	}, []string{"errors", "slow"})
+	require.NoError(t, err)
+	return source
+}
+
+func TestNewValkeyStreamSource(t *testing.T) {
+	client := newFakeStreamClient()
+	newTestStreamSource(t, client)
+	assert.Equal(t, []string{"errors/processors@0", "slow/processors@0"}, client.groups)
+
+	// Another processor created the group first
+	client.groupErr = errors.New("BUSYGROUP Consumer Group name already exists")
+	newTestStreamSource(t, client)
+
+	client.groupErr = errors.New("NOPERM")
+	_, err := NewValkeyStreamSource(context.Background(), client, ValkeyStreamsConfig{}, []string{"errors"})
+	assert.ErrorContains(t, err, "failed to create consumer group on stream errors")
+}
+
+func TestValkeyStreamSourceFetch(t *testing.T) {
+	client := newFakeStreamClient()
+	client.claims["slow"] = []redis.XMessage{
+		streamEntry("1754135000000-0", "key", "db-9", "body", `{"instance_id":"db-9"}`),
+	}
+	client.reads = [][]redis.XStream{{
+		{Stream: "errors", Messages: []redis.XMessage{
+			streamEntry("1754136000123-0", "key", "db-1", "body", `{"instance_id":"db-1"}`, "h:schema_version", "1", "h:scan_id", "scan-1"),
+			streamEntry("1754136000123-1", "key", "db-2"),
+		}},
+	}}
+	source := newTestStreamSource(t, client)
+	ctx := context.Background()
+
+	// Abandoned entries come first
+	claimed, err := source.FetchMessage(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "slow", claimed.Topic)
+	assert.Equal(t, "db-9", string(claimed.Key))
+
+	msg, err := source.FetchMessage(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "errors", msg.Topic)
+	assert.Equal(t, 0, msg.Partition)
+	assert.Equal(t, int64(0), msg.Offset)
+	assert.Equal(t, time.UnixMilli(1754136000123), msg.Time)
+	logMsg, err := decodeLogMessage(msg)
+	require.NoError(t, err)
+	assert.Equal(t, "db-1", logMsg.InstanceID)
+	assert.Equal(t, "scan-1", logMsg.Provenance.ScanID)
+
+	// An entry without a body still arrives, so it can be acknowledged
+	malformed, err := source.FetchMessage(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), malformed.Offset)
+	assert.Empty(t, malformed.Value)
+
+	// Nothing new: the read times out and the caller's context ends
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = source.FetchMessage(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestValkeyStreamSourceAcknowledgesContiguousWork(t *testing.T) {
+	client := newFakeStreamClient()
+	client.reads = [][]redis.XStream{{
+		{Stream: "errors", Messages: []redis.XMessage{
+			streamEntry("1-0", "body", "{}"),
+			streamEntry("2-0", "body", "{}"),
+			streamEntry("3-0", "body", "{}"),
+		}},
+	}}
+	source := newTestStreamSource(t, client)
+	source.lastClaim = time.Now()
+	tracker := NewOffsetTracker(source, 10)
+	ctx := context.Background()
+
+	var msgs []kafka.Message
+	for i := 0; i < 3; i++ {
+		msg, err := source.FetchMessage(ctx)
+		require.NoError(t, err)
+		require.NoError(t, tracker.Track(ctx, msg))
+		msgs = append(msgs, msg)
+	}
+
+	// Held entries are kept from being claimed by other consumers
+	source.refreshHeld(ctx)
+	assert.Equal(t, []string{"1-0", "2-0", "3-0"}, client.refresh["errors"])
+
+	require.NoError(t, tracker.Complete(ctx, msgs[1]))
+	assert.Empty(t, client.acked["errors"], "an earlier file is still running")
+
+	require.NoError(t, tracker.Complete(ctx, msgs[0]))
+	assert.Equal(t, []string{"1-0", "2-0"}, client.acked["errors"])
+
+	client.ackErr = assert.AnError
+	assert.ErrorIs(t, source.CommitMessages(ctx, msgs[2]), assert.AnError)
+	client.ackErr = nil
+	require.NoError(t, source.CommitMessages(ctx, msgs[2]))
+	assert.Equal(t, []string{"1-0", "2-0", "3-0"}, client.acked["errors"])
+
+	client.refresh = map[string][]string{}
+	source.refreshHeld(ctx)
+	assert.Empty(t, client.refresh, "acknowledged entries are not refreshed")
+}